@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AccessList restricts HTTP access to clients from allowed networks. Client
+// addresses are taken from X-Forwarded-For when the request comes from a
+// trusted proxy.
+type AccessList struct {
+	allowed []*net.IPNet
+	proxies []*net.IPNet
+}
+
+// newAccessList returns nil when no allowlist is configured.
+func newAccessList() *AccessList {
+	allowed, err := parseCIDRs(envString("DEX_ALLOWED_CIDRS", ""))
+	if err != nil {
+		log.Fatalf("invalid DEX_ALLOWED_CIDRS: %v", err)
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	proxies, err := parseCIDRs(envString("DEX_TRUSTED_PROXIES", ""))
+	if err != nil {
+		log.Fatalf("invalid DEX_TRUSTED_PROXIES: %v", err)
+	}
+
+	return &AccessList{allowed: allowed, proxies: proxies}
+}
+
+// parseCIDRs parses a comma separated list of networks, single addresses are
+// treated as host networks.
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range splitList(s) {
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client, or nil for unix socket clients.
+func (a *AccessList) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	if !containsIP(a.proxies, ip) {
+		return ip
+	}
+
+	// walk the proxy chain from the nearest hop, the first untrusted address is the client
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !containsIP(a.proxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// allowsAddr reports whether a client connecting from addr is allowed, for
+// the listeners other than HTTP without proxy headers. It is safe to call on a
+// nil receiver, in which case all clients are allowed.
+func (a *AccessList) allowsAddr(addr net.Addr) bool {
+	if a == nil {
+		return true
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		// unix socket clients are always allowed
+		return true
+	}
+	return containsIP(a.allowed, tcp.IP)
+}
+
+// Wrap returns a handler rejecting requests from clients outside the allowlist.
+// Requests over unix sockets are always allowed. It is safe to call on a nil
+// receiver, in which case all requests are allowed.
+func (a *AccessList) Wrap(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := a.clientIP(r)
+		if ip == nil || !containsIP(a.allowed, ip) {
+			log.Warnf("denied %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
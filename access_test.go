@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessList(t *testing.T) {
+	allowed, err := parseCIDRs("10.0.0.0/8, 192.168.1.5")
+	require.NoError(t, err)
+	proxies, err := parseCIDRs("172.16.0.1")
+	require.NoError(t, err)
+
+	handler := (&AccessList{allowed: allowed, proxies: proxies}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		expectedCode int
+	}{
+		{"allowed network", "10.1.2.3:4567", "", http.StatusOK},
+		{"allowed host", "192.168.1.5:4567", "", http.StatusOK},
+		{"denied host", "192.168.1.6:4567", "", http.StatusForbidden},
+		{"unix socket", "@", "", http.StatusOK},
+		{"untrusted proxy header ignored", "192.168.1.6:4567", "10.1.2.3", http.StatusForbidden},
+		{"trusted proxy allowed client", "172.16.0.1:4567", "10.1.2.3", http.StatusOK},
+		{"trusted proxy denied client", "172.16.0.1:4567", "8.8.8.8", http.StatusForbidden},
+		{"spoofed header before untrusted hop", "172.16.0.1:4567", "10.1.2.3, 8.8.8.8", http.StatusForbidden},
+		{"trusted proxy without header", "172.16.0.1:4567", "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+		})
+	}
+}
+
+func TestParseCIDRsInvalid(t *testing.T) {
+	_, err := parseCIDRs("10.0.0.0/8,not-a-network")
+	assert.Error(t, err)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// filterTarget is implemented by the collectors following the global filter
+// rules.
+type filterTarget interface {
+	// globalFilters returns the filters checked before changing the rules
+	globalFilters() []*containerFilter
+	setGlobalRules(rules []*FilterRule)
+}
+
+type filtersDocument struct {
+	Filters []*FilterRule `json:"filters"`
+}
+
+// FilterAdmin serves GET and PUT /api/v1/filters, which show and replace the
+// global filter rules at runtime, e.g. to drop a noisy group of containers
+// without redeploying dex. Replaced rules are written back to the
+// configuration file, so they survive restarts.
+type FilterAdmin struct {
+	token string
+	// configuration file the rules are persisted to, not persisted if empty
+	path    string
+	targets []filterTarget
+
+	// concurrent updates could persist other rules than they apply
+	mu    sync.Mutex
+	rules []*FilterRule
+}
+
+// newFilterAdmin returns nil when no admin token is configured. rules are the
+// current global rules.
+func newFilterAdmin(rules []*FilterRule) *FilterAdmin {
+	token := envString("DEX_ADMIN_TOKEN", "")
+	if token == "" {
+		return nil
+	}
+
+	path := os.Getenv("DEX_CONFIG")
+	if path == "" {
+		log.Warn("DEX_CONFIG is not set, filters changed through the admin API are lost on restart")
+	}
+
+	return &FilterAdmin{token: token, path: path, rules: rules}
+}
+
+// add registers a collector whose filters follow the global rules.
+func (a *FilterAdmin) add(t filterTarget) {
+	a.targets = append(a.targets, t)
+}
+
+// authorized checks the bearer token in constant time.
+func (a *FilterAdmin) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+func (a *FilterAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dex"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		a.mu.Lock()
+		rules := a.rules
+		a.mu.Unlock()
+		writeFilters(w, rules)
+	case http.MethodPut:
+		a.put(w, r)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *FilterAdmin) put(w http.ResponseWriter, r *http.Request) {
+	var doc filtersDocument
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&doc); err != nil {
+		http.Error(w, fmt.Sprintf("can't parse filters: %v", err), http.StatusBadRequest)
+		return
+	}
+	// no rules would fall back to DEX_FILTER_CONTAINER after a restart
+	if len(doc.Filters) == 0 {
+		http.Error(w, "at least one filter rule is required, use {\"match\": \".*\"} to collect all containers", http.StatusBadRequest)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// all filters are checked first, so the rules are never applied partially
+	for _, t := range a.targets {
+		for _, f := range t.globalFilters() {
+			if err := f.checkRules(doc.Filters); err != nil {
+				http.Error(w, fmt.Sprintf("invalid filters: %v", err), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+	}
+
+	if a.path != "" {
+		if err := persistFilters(a.path, doc.Filters); err != nil {
+			log.Errorf("can't write filters to %s: %v", a.path, err)
+			http.Error(w, fmt.Sprintf("can't write filters to the configuration file: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	for _, t := range a.targets {
+		t.setGlobalRules(doc.Filters)
+	}
+	a.rules = doc.Filters
+	log.Infof("filters replaced with %d rules by %s", len(doc.Filters), r.RemoteAddr)
+
+	writeFilters(w, doc.Filters)
+}
+
+func writeFilters(w http.ResponseWriter, rules []*FilterRule) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(filtersDocument{Filters: rules}); err != nil {
+		log.Error("can't write filters: ", err)
+	}
+}
+
+// persistFilters replaces the filters of the configuration file and keeps
+// its other content. The file is replaced by a rename, so a crash can't leave
+// it half written and its directory must be writable.
+func persistFilters(path string, rules []*FilterRule) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		// an empty file
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return errors.New("the configuration is not a mapping")
+	}
+
+	var value yaml.Node
+	if err := value.Encode(rules); err != nil {
+		return err
+	}
+	replaced := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "filters" {
+			root.Content[i+1] = &value
+			replaced = true
+		}
+	}
+	if !replaced {
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "filters"}, &value)
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(out.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+type filterTestResult struct {
+	Name    string `json:"name"`
+	Matched bool   `json:"matched"`
+	// Rule is the number of the rule deciding about the container, omitted
+	// if no rule matches
+	Rule          int               `json:"rule,omitempty"`
+	ContainerName string            `json:"container_name,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+// filterTestHandler serves GET /api/v1/filters/test, which reports how the
+// active filter rules treat the container given in the name parameter, or all
+// current containers without it. Nothing is changed, it shows the rewritten
+// name and labels of submatch templates before they end up in the metrics.
+func filterTestHandler(cli *client.Client, api *DockerAPIMetrics, filter *containerFilter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var names []string
+		if name := r.URL.Query().Get("name"); name != "" {
+			names = []string{strings.TrimPrefix(name, "/")}
+		} else {
+			ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+			defer cancel()
+
+			var containers []container.Summary
+			err := api.observe(ctx, "list", func() error {
+				var err error
+				containers, err = cli.ContainerList(ctx, container.ListOptions{All: true})
+				return err
+			})
+			if err != nil {
+				http.Error(w, fmt.Sprintf("can't list containers: %v", err), http.StatusBadGateway)
+				return
+			}
+			// matched like the collector does
+			for _, cont := range containers {
+				names = append(names, strings.TrimPrefix(strings.Join(cont.Names, ";"), "/"))
+			}
+			sort.Strings(names)
+		}
+
+		results := make([]filterTestResult, 0, len(names))
+		for _, name := range names {
+			cl, rule, ok := filter.matchRule(name)
+			result := filterTestResult{Name: name, Matched: ok, Rule: rule + 1}
+			if ok {
+				result.ContainerName = cl.values[0]
+				result.Labels = map[string]string{}
+				for i, labelName := range cl.names[1:] {
+					result.Labels[labelName] = cl.values[i+1]
+				}
+			}
+			results = append(results, result)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(map[string]any{"containers": results}); err != nil {
+			log.Error("can't write filter test results: ", err)
+		}
+	})
+}
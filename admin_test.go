@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterAdmin(t *testing.T) {
+	filter, err := newContainerFilter([]*FilterRule{{Match: `^(web)_\d+$`, Labels: map[string]string{"team": "frontend"}}})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "dex.yml")
+	require.NoError(t, os.WriteFile(path, []byte("# dex config\nport: \"9000\"\nfilters:\n  - match: ^(web)_\\d+$\n"), 0o640))
+
+	admin := &FilterAdmin{token: "secret", path: path, rules: filter.currentRules()}
+	admin.add(&DockerCollector{filter: filter})
+
+	request := func(method, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/v1/filters", strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "wrong", "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodPost, "secret", "").Code)
+
+	w := request(http.MethodGet, "secret", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"filters": [{"match": "^(web)_\\d+$", "labels": {"team": "frontend"}}]}`, w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPut, "secret", `{"filters": []}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPut, "secret", `{"rules": []}`).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, request(http.MethodPut, "secret", `{"filters": [{"match": "("}]}`).Code)
+	w = request(http.MethodPut, "secret", `{"filters": [{"match": ".*", "labels": {"env": "prod"}}]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "New labels can't be added at runtime")
+	assert.Contains(t, w.Body.String(), "label 'env' is new")
+
+	w = request(http.MethodPut, "secret", `{"filters": [{"match": "^web_1$", "drop": true}, {"match": "^(web)_\\d+$"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	_, ok := filter.match("web_1")
+	assert.False(t, ok, "Dropped container should be filtered out")
+	cl, ok := filter.match("web_2")
+	require.True(t, ok)
+	assert.Equal(t, []string{"web", ""}, cl.values)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `# dex config
+port: "9000"
+filters:
+  - match: ^web_1$
+    drop: true
+  - match: ^(web)_\d+$
+`, string(data))
+
+	cfg, err := loadConfig(path)
+	require.NoError(t, err)
+	assert.Len(t, cfg.Filters, 2)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+
+	w = request(http.MethodGet, "secret", "")
+	assert.JSONEq(t, `{"filters": [{"match": "^web_1$", "drop": true}, {"match": "^(web)_\\d+$"}]}`, w.Body.String())
+}
+
+func TestPersistFiltersEmptyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dex.yml")
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+
+	require.NoError(t, persistFilters(path, []*FilterRule{{Match: "^app_"}}))
+
+	cfg, err := loadConfig(path)
+	require.NoError(t, err)
+	require.Len(t, cfg.Filters, 1)
+	assert.Equal(t, "^app_", cfg.Filters[0].Match)
+}
+
+func TestFilterAdminDisabled(t *testing.T) {
+	assert.Nil(t, newFilterAdmin(nil))
+}
+
+func TestFilterTestHandler(t *testing.T) {
+	cli := fakeDaemon(t, []container.Summary{
+		{ID: "aaa", Names: []string{"/payments_api"}},
+		{ID: "bbb", Names: []string{"/ci_runner"}},
+		{ID: "ccc", Names: []string{"/db"}},
+	})
+	filter, err := newContainerFilter([]*FilterRule{
+		{Match: `^ci_`, Drop: true},
+		{Match: `^payments_(?P<service>.+)$`, Name: "pay-${service}", Labels: map[string]string{"team": "payments"}},
+	})
+	require.NoError(t, err)
+	handler := filterTestHandler(cli, newDockerAPIMetrics(), filter)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/filters/test?name=payments_web", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"containers": [
+		{"name": "payments_web", "matched": true, "rule": 2, "container_name": "pay-web", "labels": {"team": "payments"}}
+	]}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/filters/test", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"containers": [
+		{"name": "ci_runner", "matched": false, "rule": 1},
+		{"name": "db", "matched": false},
+		{"name": "payments_api", "matched": true, "rule": 2, "container_name": "pay-api", "labels": {"team": "payments"}}
+	]}`, w.Body.String())
+}
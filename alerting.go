@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// AlertRule is a threshold on a single dex metric, e.g.
+// "dex_memory_utilization_percent > 90" held for 5 minutes.
+type AlertRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         time.Duration     `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+
+	metric    string
+	op        string
+	threshold float64
+}
+
+type alertRulesFile struct {
+	Rules []*AlertRule `yaml:"rules"`
+}
+
+type alertmanagerAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+type activeAlert struct {
+	labels   map[string]string
+	rule     *AlertRule
+	since    time.Time
+	firing   bool
+	lastSeen time.Time
+}
+
+// AlertEvaluator periodically evaluates threshold rules against the local
+// registry and forwards firing alerts to Alertmanager.
+type AlertEvaluator struct {
+	gatherer        prometheus.Gatherer
+	rules           []*AlertRule
+	alertmanagerURL string
+	interval        time.Duration
+	httpClient      *http.Client
+
+	active map[string]*activeAlert
+}
+
+var alertOperators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// newAlertEvaluator returns nil when no rules file is configured.
+func newAlertEvaluator(gatherer prometheus.Gatherer) *AlertEvaluator {
+	rulesFile := envString("DEX_ALERT_RULES_FILE", "")
+	if rulesFile == "" {
+		return nil
+	}
+
+	rules, err := loadAlertRules(rulesFile)
+	if err != nil {
+		log.Fatalf("can't load alert rules from '%s': %v", rulesFile, err)
+	}
+
+	alertmanagerURL := envString("DEX_ALERTMANAGER_URL", "")
+	if alertmanagerURL == "" {
+		log.Fatal("DEX_ALERTMANAGER_URL is required when DEX_ALERT_RULES_FILE is set")
+	}
+
+	interval := envDuration("DEX_ALERT_EVAL_INTERVAL", 30*time.Second)
+	if interval <= 0 {
+		log.Errorf("invalid DEX_ALERT_EVAL_INTERVAL '%s', using 30s", interval)
+		interval = 30 * time.Second
+		configErrors.add(configKey("DEX_ALERT_EVAL_INTERVAL"))
+	}
+
+	return &AlertEvaluator{
+		gatherer:        gatherer,
+		rules:           rules,
+		alertmanagerURL: strings.TrimSuffix(alertmanagerURL, "/"),
+		interval:        interval,
+		httpClient:      &http.Client{Timeout: 10 * time.Second},
+		active:          map[string]*activeAlert{},
+	}
+}
+
+func loadAlertRules(path string) ([]*AlertRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file alertRulesFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	for _, rule := range file.Rules {
+		if rule.Alert == "" {
+			return nil, fmt.Errorf("rule with expr '%s' has no alert name", rule.Expr)
+		}
+		if err := rule.parseExpr(); err != nil {
+			return nil, fmt.Errorf("rule '%s': %w", rule.Alert, err)
+		}
+	}
+
+	return file.Rules, nil
+}
+
+// parseExpr parses expressions of the form "<metric> <op> <threshold>".
+// The "dex_" prefix of the metric name may be omitted.
+func (r *AlertRule) parseExpr() error {
+	for _, op := range alertOperators {
+		metric, threshold, found := strings.Cut(r.Expr, op)
+		if !found {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(threshold), 64)
+		if err != nil {
+			return fmt.Errorf("invalid threshold in '%s': %v", r.Expr, err)
+		}
+
+		r.metric = strings.TrimSpace(metric)
+		if !strings.HasPrefix(r.metric, "dex_") {
+			r.metric = "dex_" + r.metric
+		}
+		r.op = op
+		r.threshold = value
+		return nil
+	}
+
+	return fmt.Errorf("can't parse expression '%s', expected '<metric> <op> <threshold>'", r.Expr)
+}
+
+func (r *AlertRule) matches(value float64) bool {
+	switch r.op {
+	case ">":
+		return value > r.threshold
+	case ">=":
+		return value >= r.threshold
+	case "<":
+		return value < r.threshold
+	case "<=":
+		return value <= r.threshold
+	case "==":
+		return value == r.threshold
+	case "!=":
+		return value != r.threshold
+	}
+	return false
+}
+
+func (e *AlertEvaluator) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		families, err := e.gatherer.Gather()
+		if err != nil {
+			log.Error("can't gather metrics for alert evaluation: ", err)
+			continue
+		}
+
+		alerts := e.evaluate(families, time.Now())
+		if len(alerts) == 0 {
+			continue
+		}
+		if err := e.send(ctx, alerts); err != nil {
+			log.Error("can't send alerts to alertmanager: ", err)
+		}
+	}
+}
+
+// evaluate updates the state of all rules and returns the alerts that have
+// to be sent to Alertmanager: firing alerts and alerts resolved just now.
+func (e *AlertEvaluator) evaluate(families []*dto.MetricFamily, now time.Time) []alertmanagerAlert {
+	byName := map[string]*dto.MetricFamily{}
+	for _, mf := range families {
+		byName[mf.GetName()] = mf
+	}
+
+	for _, rule := range e.rules {
+		mf, ok := byName[rule.metric]
+		if !ok {
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+			if !rule.matches(metricValue(m)) {
+				continue
+			}
+
+			labels := map[string]string{"alertname": rule.Alert}
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			for k, v := range rule.Labels {
+				labels[k] = v
+			}
+
+			key := alertKey(labels)
+			alert, ok := e.active[key]
+			if !ok {
+				alert = &activeAlert{labels: labels, rule: rule, since: now}
+				e.active[key] = alert
+			}
+			alert.lastSeen = now
+			if now.Sub(alert.since) >= rule.For {
+				alert.firing = true
+			}
+		}
+	}
+
+	var alerts []alertmanagerAlert
+	for key, alert := range e.active {
+		resolved := alert.lastSeen != now
+		if resolved {
+			delete(e.active, key)
+		}
+		if !alert.firing {
+			continue
+		}
+
+		endsAt := now.Add(3 * e.interval)
+		if resolved {
+			endsAt = now
+		}
+		alerts = append(alerts, alertmanagerAlert{
+			Labels:      alert.labels,
+			Annotations: alert.rule.Annotations,
+			StartsAt:    alert.since,
+			EndsAt:      endsAt,
+		})
+	}
+
+	return alerts
+}
+
+func (e *AlertEvaluator) send(ctx context.Context, alerts []alertmanagerAlert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.alertmanagerURL+"/api/v2/alerts", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func metricValue(m *dto.Metric) float64 {
+	switch {
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	case m.Untyped != nil:
+		return m.Untyped.GetValue()
+	}
+	return 0
+}
+
+func alertKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+		b.WriteByte(';')
+	}
+	return b.String()
+}
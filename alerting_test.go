@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func memoryUtilizationFamily(values map[string]float64) []*dto.MetricFamily {
+	mf := &dto.MetricFamily{
+		Name: proto.String("dex_memory_utilization_percent"),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	for name, value := range values {
+		mf.Metric = append(mf.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{{Name: proto.String("container_name"), Value: proto.String(name)}},
+			Gauge: &dto.Gauge{Value: proto.Float64(value)},
+		})
+	}
+	return []*dto.MetricFamily{mf}
+}
+
+func TestAlertRuleParseExpr(t *testing.T) {
+	rule := &AlertRule{Alert: "HighMemory", Expr: "memory_utilization_percent >= 90.5"}
+	require.NoError(t, rule.parseExpr(), "Failed to parse expression")
+
+	assert.Equal(t, "dex_memory_utilization_percent", rule.metric, "Prefix should be added to metric name")
+	assert.Equal(t, ">=", rule.op, "Unexpected operator")
+	assert.Equal(t, 90.5, rule.threshold, "Unexpected threshold")
+
+	invalid := &AlertRule{Alert: "Broken", Expr: "dex_memory_utilization_percent ninety"}
+	assert.Error(t, invalid.parseExpr(), "Expected error for expression without operator")
+}
+
+func TestAlertEvaluatorForDuration(t *testing.T) {
+	rule := &AlertRule{
+		Alert:  "HighMemory",
+		Expr:   "dex_memory_utilization_percent > 90",
+		For:    5 * time.Minute,
+		Labels: map[string]string{"severity": "warning"},
+	}
+	require.NoError(t, rule.parseExpr())
+
+	e := &AlertEvaluator{rules: []*AlertRule{rule}, interval: 30 * time.Second, active: map[string]*activeAlert{}}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	alerts := e.evaluate(memoryUtilizationFamily(map[string]float64{"db": 95, "web": 10}), start)
+	assert.Empty(t, alerts, "Alert should be pending before the for duration elapsed")
+
+	alerts = e.evaluate(memoryUtilizationFamily(map[string]float64{"db": 96, "web": 10}), start.Add(5*time.Minute))
+	require.Len(t, alerts, 1, "Expected one firing alert")
+	assert.Equal(t, "HighMemory", alerts[0].Labels["alertname"])
+	assert.Equal(t, "db", alerts[0].Labels["container_name"])
+	assert.Equal(t, "warning", alerts[0].Labels["severity"])
+	assert.Equal(t, start, alerts[0].StartsAt, "StartsAt should be the time the condition was first met")
+	assert.True(t, alerts[0].EndsAt.After(start.Add(5*time.Minute)), "Firing alert should end in the future")
+
+	resolvedAt := start.Add(6 * time.Minute)
+	alerts = e.evaluate(memoryUtilizationFamily(map[string]float64{"db": 50, "web": 10}), resolvedAt)
+	require.Len(t, alerts, 1, "Expected one resolved alert")
+	assert.Equal(t, resolvedAt, alerts[0].EndsAt, "Resolved alert should end now")
+
+	alerts = e.evaluate(memoryUtilizationFamily(map[string]float64{"db": 50, "web": 10}), start.Add(7*time.Minute))
+	assert.Empty(t, alerts, "Resolved alert should be sent only once")
+}
+
+func TestAlertEvaluatorInvalidInterval(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.yml")
+	require.NoError(t, os.WriteFile(rulesFile, []byte("rules:\n  - alert: High\n    expr: memory_utilization_percent > 90\n"), 0o644))
+	t.Setenv("DEX_ALERT_RULES_FILE", rulesFile)
+	t.Setenv("DEX_ALERTMANAGER_URL", "http://alertmanager:9093")
+	t.Setenv("DEX_ALERT_EVAL_INTERVAL", "-1s")
+	saved := configErrors
+	configErrors = &ConfigErrors{}
+	t.Cleanup(func() { configErrors = saved })
+
+	e := newAlertEvaluator(prometheus.NewRegistry())
+	require.NotNil(t, e)
+	assert.Equal(t, 30*time.Second, e.interval)
+	assert.Equal(t, 1, testutil.CollectAndCount(configErrors))
+}
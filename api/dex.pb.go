@@ -0,0 +1,356 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: dex.proto
+
+package api
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Sample_Type int32
+
+const (
+	Sample_UNTYPED Sample_Type = 0
+	Sample_GAUGE   Sample_Type = 1
+	Sample_COUNTER Sample_Type = 2
+)
+
+// Enum value maps for Sample_Type.
+var (
+	Sample_Type_name = map[int32]string{
+		0: "UNTYPED",
+		1: "GAUGE",
+		2: "COUNTER",
+	}
+	Sample_Type_value = map[string]int32{
+		"UNTYPED": 0,
+		"GAUGE":   1,
+		"COUNTER": 2,
+	}
+)
+
+func (x Sample_Type) Enum() *Sample_Type {
+	p := new(Sample_Type)
+	*p = x
+	return p
+}
+
+func (x Sample_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Sample_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_dex_proto_enumTypes[0].Descriptor()
+}
+
+func (Sample_Type) Type() protoreflect.EnumType {
+	return &file_dex_proto_enumTypes[0]
+}
+
+func (x Sample_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Sample_Type.Descriptor instead.
+func (Sample_Type) EnumDescriptor() ([]byte, []int) {
+	return file_dex_proto_rawDescGZIP(), []int{1, 0}
+}
+
+type WatchRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Interval between updates in seconds, rounded up to a multiple of the
+	// server interval, which is used if 0.
+	IntervalSeconds uint32 `protobuf:"varint,1,opt,name=interval_seconds,json=intervalSeconds,proto3" json:"interval_seconds,omitempty"`
+	// Only send samples of these containers, all containers if empty.
+	Containers []string `protobuf:"bytes,2,rep,name=containers,proto3" json:"containers,omitempty"`
+	// Only send these metrics, all metrics if empty. The "dex_" prefix may be omitted.
+	Metrics       []string `protobuf:"bytes,3,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchRequest) Reset() {
+	*x = WatchRequest{}
+	mi := &file_dex_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchRequest) ProtoMessage() {}
+
+func (x *WatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_dex_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchRequest.ProtoReflect.Descriptor instead.
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return file_dex_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *WatchRequest) GetIntervalSeconds() uint32 {
+	if x != nil {
+		return x.IntervalSeconds
+	}
+	return 0
+}
+
+func (x *WatchRequest) GetContainers() []string {
+	if x != nil {
+		return x.Containers
+	}
+	return nil
+}
+
+func (x *WatchRequest) GetMetrics() []string {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+type Sample struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Metric    string                 `protobuf:"bytes,1,opt,name=metric,proto3" json:"metric,omitempty"`
+	Type      Sample_Type            `protobuf:"varint,2,opt,name=type,proto3,enum=dex.v1.Sample_Type" json:"type,omitempty"`
+	Container string                 `protobuf:"bytes,3,opt,name=container,proto3" json:"container,omitempty"`
+	// All labels of the sample except container_name.
+	Labels        map[string]string `protobuf:"bytes,4,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Value         float64           `protobuf:"fixed64,5,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Sample) Reset() {
+	*x = Sample{}
+	mi := &file_dex_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Sample) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Sample) ProtoMessage() {}
+
+func (x *Sample) ProtoReflect() protoreflect.Message {
+	mi := &file_dex_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Sample.ProtoReflect.Descriptor instead.
+func (*Sample) Descriptor() ([]byte, []int) {
+	return file_dex_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Sample) GetMetric() string {
+	if x != nil {
+		return x.Metric
+	}
+	return ""
+}
+
+func (x *Sample) GetType() Sample_Type {
+	if x != nil {
+		return x.Type
+	}
+	return Sample_UNTYPED
+}
+
+func (x *Sample) GetContainer() string {
+	if x != nil {
+		return x.Container
+	}
+	return ""
+}
+
+func (x *Sample) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Sample) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+type MetricsUpdate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Samples       []*Sample              `protobuf:"bytes,2,rep,name=samples,proto3" json:"samples,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MetricsUpdate) Reset() {
+	*x = MetricsUpdate{}
+	mi := &file_dex_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MetricsUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetricsUpdate) ProtoMessage() {}
+
+func (x *MetricsUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_dex_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetricsUpdate.ProtoReflect.Descriptor instead.
+func (*MetricsUpdate) Descriptor() ([]byte, []int) {
+	return file_dex_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *MetricsUpdate) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *MetricsUpdate) GetSamples() []*Sample {
+	if x != nil {
+		return x.Samples
+	}
+	return nil
+}
+
+var File_dex_proto protoreflect.FileDescriptor
+
+const file_dex_proto_rawDesc = "" +
+	"\n" +
+	"\tdex.proto\x12\x06dex.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"s\n" +
+	"\fWatchRequest\x12)\n" +
+	"\x10interval_seconds\x18\x01 \x01(\rR\x0fintervalSeconds\x12\x1e\n" +
+	"\n" +
+	"containers\x18\x02 \x03(\tR\n" +
+	"containers\x12\x18\n" +
+	"\ametrics\x18\x03 \x03(\tR\ametrics\"\x99\x02\n" +
+	"\x06Sample\x12\x16\n" +
+	"\x06metric\x18\x01 \x01(\tR\x06metric\x12'\n" +
+	"\x04type\x18\x02 \x01(\x0e2\x13.dex.v1.Sample.TypeR\x04type\x12\x1c\n" +
+	"\tcontainer\x18\x03 \x01(\tR\tcontainer\x122\n" +
+	"\x06labels\x18\x04 \x03(\v2\x1a.dex.v1.Sample.LabelsEntryR\x06labels\x12\x14\n" +
+	"\x05value\x18\x05 \x01(\x01R\x05value\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"+\n" +
+	"\x04Type\x12\v\n" +
+	"\aUNTYPED\x10\x00\x12\t\n" +
+	"\x05GAUGE\x10\x01\x12\v\n" +
+	"\aCOUNTER\x10\x02\"s\n" +
+	"\rMetricsUpdate\x128\n" +
+	"\ttimestamp\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12(\n" +
+	"\asamples\x18\x02 \x03(\v2\x0e.dex.v1.SampleR\asamples2A\n" +
+	"\aMetrics\x126\n" +
+	"\x05Watch\x12\x14.dex.v1.WatchRequest\x1a\x15.dex.v1.MetricsUpdate0\x01B\tZ\adex/apib\x06proto3"
+
+var (
+	file_dex_proto_rawDescOnce sync.Once
+	file_dex_proto_rawDescData []byte
+)
+
+func file_dex_proto_rawDescGZIP() []byte {
+	file_dex_proto_rawDescOnce.Do(func() {
+		file_dex_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_dex_proto_rawDesc), len(file_dex_proto_rawDesc)))
+	})
+	return file_dex_proto_rawDescData
+}
+
+var file_dex_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_dex_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_dex_proto_goTypes = []any{
+	(Sample_Type)(0),              // 0: dex.v1.Sample.Type
+	(*WatchRequest)(nil),          // 1: dex.v1.WatchRequest
+	(*Sample)(nil),                // 2: dex.v1.Sample
+	(*MetricsUpdate)(nil),         // 3: dex.v1.MetricsUpdate
+	nil,                           // 4: dex.v1.Sample.LabelsEntry
+	(*timestamppb.Timestamp)(nil), // 5: google.protobuf.Timestamp
+}
+var file_dex_proto_depIdxs = []int32{
+	0, // 0: dex.v1.Sample.type:type_name -> dex.v1.Sample.Type
+	4, // 1: dex.v1.Sample.labels:type_name -> dex.v1.Sample.LabelsEntry
+	5, // 2: dex.v1.MetricsUpdate.timestamp:type_name -> google.protobuf.Timestamp
+	2, // 3: dex.v1.MetricsUpdate.samples:type_name -> dex.v1.Sample
+	1, // 4: dex.v1.Metrics.Watch:input_type -> dex.v1.WatchRequest
+	3, // 5: dex.v1.Metrics.Watch:output_type -> dex.v1.MetricsUpdate
+	5, // [5:6] is the sub-list for method output_type
+	4, // [4:5] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_dex_proto_init() }
+func file_dex_proto_init() {
+	if File_dex_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_dex_proto_rawDesc), len(file_dex_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_dex_proto_goTypes,
+		DependencyIndexes: file_dex_proto_depIdxs,
+		EnumInfos:         file_dex_proto_enumTypes,
+		MessageInfos:      file_dex_proto_msgTypes,
+	}.Build()
+	File_dex_proto = out.File
+	file_dex_proto_goTypes = nil
+	file_dex_proto_depIdxs = nil
+}
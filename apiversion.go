@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// minAPIVersion is the oldest API version the docker client supports.
+const minAPIVersion = "1.24"
+
+var apiVersionPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+func validateAPIVersion(v string) error {
+	if v != "" && !apiVersionPattern.MatchString(v) {
+		return fmt.Errorf("must be an API version like 1.41")
+	}
+	return nil
+}
+
+// APIVersionCheck negotiates the Docker API version within the configured
+// range and exports it. A daemon older than the minimum fails readiness but
+// not the process, so dex recovers once the daemon is upgraded.
+type APIVersionCheck struct {
+	cli *client.Client
+	api *DockerAPIMetrics
+
+	min string
+	// max caps the negotiated version, unlimited if empty
+	max string
+
+	mu sync.Mutex
+	// daemon is the API version of the daemon, empty until it answered a ping
+	daemon string
+}
+
+func newAPIVersionCheck(cli *client.Client, api *DockerAPIMetrics) *APIVersionCheck {
+	v := &APIVersionCheck{
+		cli: cli,
+		api: api,
+		min: envString("DEX_DOCKER_API_MIN_VERSION", minAPIVersion),
+		max: envString("DEX_DOCKER_API_MAX_VERSION", ""),
+	}
+	if err := validateAPIVersion(v.min); err != nil || v.min == "" {
+		log.Errorf("invalid DEX_DOCKER_API_MIN_VERSION '%s', requiring %s", v.min, minAPIVersion)
+		v.min = minAPIVersion
+		configErrors.add(configKey("DEX_DOCKER_API_MIN_VERSION"))
+	}
+	if err := validateAPIVersion(v.max); err != nil || (v.max != "" && versions.LessThan(v.max, v.min)) {
+		log.Errorf("invalid DEX_DOCKER_API_MAX_VERSION '%s', not capping the API version", v.max)
+		v.max = ""
+		configErrors.add(configKey("DEX_DOCKER_API_MAX_VERSION"))
+	}
+	return v
+}
+
+// negotiate pings the daemon and downgrades the client to the API version of
+// the daemon within the configured range. The client only negotiates once, so
+// it must run before the other API requests.
+func (v *APIVersionCheck) negotiate(ctx context.Context) (string, error) {
+	var ping types.Ping
+	err := v.api.observe(ctx, "ping", func() error {
+		var err error
+		ping, err = v.cli.Ping(ctx)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// daemons without version negotiation report none
+	daemon := ping.APIVersion
+	if daemon == "" {
+		daemon = minAPIVersion
+	}
+	// the client never speaks less than the minimum, so it works once a
+	// daemon that is too old gets upgraded
+	ping.APIVersion = daemon
+	if v.max != "" && versions.GreaterThan(daemon, v.max) {
+		ping.APIVersion = v.max
+	} else if versions.LessThan(daemon, v.min) {
+		ping.APIVersion = v.min
+	}
+	v.cli.NegotiateAPIVersionPing(ping)
+
+	v.mu.Lock()
+	v.daemon = daemon
+	v.mu.Unlock()
+	return daemon, nil
+}
+
+// daemonVersion returns the API version of the daemon, negotiating it first
+// if unknown or too old, in case the daemon was upgraded since.
+func (v *APIVersionCheck) daemonVersion(ctx context.Context) (string, error) {
+	v.mu.Lock()
+	daemon := v.daemon
+	v.mu.Unlock()
+
+	if daemon != "" && !versions.LessThan(daemon, v.min) {
+		return daemon, nil
+	}
+	return v.negotiate(ctx)
+}
+
+// ready returns an error if the daemon is unreachable or too old.
+func (v *APIVersionCheck) ready(ctx context.Context) error {
+	daemon, err := v.daemonVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("docker daemon is unreachable: %w", err)
+	}
+	if versions.LessThan(daemon, v.min) {
+		return fmt.Errorf("docker API version %s is older than the required %s", daemon, v.min)
+	}
+	return nil
+}
+
+// check negotiates the API version at startup, before the other requests
+// make the client negotiate it without the configured range.
+func (v *APIVersionCheck) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if err := v.ready(ctx); err != nil {
+		log.Error("docker daemon is not ready: ", err)
+	}
+}
+
+func (v *APIVersionCheck) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (v *APIVersionCheck) Collect(ch chan<- prometheus.Metric) {
+	daemon, err := v.daemonVersion(context.Background())
+	if err != nil {
+		log.Error("can't negotiate docker API version: ", err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(newDesc(
+		"dex_docker_info",
+		[]string{"api_version", "daemon_api_version"},
+	), prometheus.GaugeValue, 1, v.cli.ClientVersion(), daemon)
+}
+
+// readyHandler serves /-/ready, which fails while the daemon is unreachable
+// or older than DEX_DOCKER_API_MIN_VERSION.
+func readyHandler(v *APIVersionCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.ready(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// versionedDaemon serves the ping endpoint of a daemon with the API version.
+func versionedDaemon(t *testing.T, version *atomic.Value) *client.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/_ping") {
+			w.Header().Set("Api-Version", version.Load().(string))
+			w.Write([]byte("OK"))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+	return cli
+}
+
+func TestAPIVersionCheckCapsNegotiation(t *testing.T) {
+	t.Setenv("DEX_DOCKER_API_MAX_VERSION", "1.43")
+
+	var version atomic.Value
+	version.Store("1.47")
+	v := newAPIVersionCheck(versionedDaemon(t, &version), newDockerAPIMetrics())
+
+	require.NoError(t, v.ready(context.Background()))
+	assert.Equal(t, "1.43", v.cli.ClientVersion())
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(v)
+	expected := `
+# HELP dex_docker_info Docker API versions of the daemon and the one negotiated by dex
+# TYPE dex_docker_info gauge
+dex_docker_info{api_version="1.43",daemon_api_version="1.47"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected)))
+}
+
+func TestAPIVersionCheckFailsReadiness(t *testing.T) {
+	t.Setenv("DEX_DOCKER_API_MIN_VERSION", "1.41")
+
+	var version atomic.Value
+	version.Store("1.40")
+	v := newAPIVersionCheck(versionedDaemon(t, &version), newDockerAPIMetrics())
+	h := readyHandler(v)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "docker API version 1.40 is older than the required 1.41")
+	assert.Equal(t, "1.41", v.cli.ClientVersion(), "the client must not speak less than the minimum")
+
+	// the daemon was upgraded
+	version.Store("1.45")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestInvalidAPIVersionsFallBack(t *testing.T) {
+	t.Setenv("DEX_DOCKER_API_MIN_VERSION", "1.41")
+	t.Setenv("DEX_DOCKER_API_MAX_VERSION", "1.40")
+
+	v := newAPIVersionCheck(nil, newDockerAPIMetrics())
+	assert.Equal(t, "1.41", v.min)
+	assert.Empty(t, v.max, "the maximum must not be below the minimum")
+
+	assert.Error(t, validateAPIVersion("v1.41"))
+	assert.NoError(t, validateAPIVersion("1.41"))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+type buildCacheKey struct {
+	cacheType string
+	shared    bool
+	inUse     bool
+}
+
+type buildCacheUsage struct {
+	bytes   float64
+	entries float64
+}
+
+// BuildCacheCollector periodically reads the build cache usage, the disk
+// usage API is too slow to call on every scrape.
+type BuildCacheCollector struct {
+	cli      *client.Client
+	api      *DockerAPIMetrics
+	interval time.Duration
+
+	mu    sync.Mutex
+	usage map[buildCacheKey]*buildCacheUsage
+}
+
+// newBuildCacheCollector returns nil when build cache metrics are not enabled.
+func newBuildCacheCollector(cli *client.Client, api *DockerAPIMetrics) *BuildCacheCollector {
+	interval := envDuration("DEX_BUILD_CACHE_INTERVAL", 0)
+	if interval <= 0 {
+		return nil
+	}
+
+	return &BuildCacheCollector{
+		cli:      cli,
+		api:      api,
+		interval: interval,
+		usage:    map[buildCacheKey]*buildCacheUsage{},
+	}
+}
+
+func (b *BuildCacheCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		b.update(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *BuildCacheCollector) update(ctx context.Context) {
+	var du types.DiskUsage
+	err := b.api.observe(ctx, "disk_usage", func() error {
+		var err error
+		du, err = b.cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.BuildCacheObject}})
+		return err
+	})
+	if err != nil {
+		log.Error("can't read build cache usage: ", err)
+		return
+	}
+
+	usage := buildCacheUsageOf(du.BuildCache)
+
+	b.mu.Lock()
+	b.usage = usage
+	b.mu.Unlock()
+}
+
+func buildCacheUsageOf(records []*types.BuildCache) map[buildCacheKey]*buildCacheUsage {
+	usage := map[buildCacheKey]*buildCacheUsage{}
+	for _, record := range records {
+		key := buildCacheKey{cacheType: record.Type, shared: record.Shared, inUse: record.InUse}
+		u, ok := usage[key]
+		if !ok {
+			u = &buildCacheUsage{}
+			usage[key] = u
+		}
+		u.bytes += float64(record.Size)
+		u.entries++
+	}
+	return usage
+}
+
+func (b *BuildCacheCollector) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (b *BuildCacheCollector) Collect(ch chan<- prometheus.Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	labels := []string{"type", "shared", "in_use"}
+	for key, u := range b.usage {
+		values := []string{key.cacheType, strconv.FormatBool(key.shared), strconv.FormatBool(key.inUse)}
+
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_build_cache_bytes",
+			labels,
+		), prometheus.GaugeValue, u.bytes, values...)
+
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_build_cache_entries",
+			labels,
+		), prometheus.GaugeValue, u.entries, values...)
+	}
+}
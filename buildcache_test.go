@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildCacheCollector(t *testing.T) {
+	b := &BuildCacheCollector{usage: buildCacheUsageOf([]*types.BuildCache{
+		{Type: "regular", Size: 100, InUse: false, Shared: false},
+		{Type: "regular", Size: 50, InUse: false, Shared: false},
+		{Type: "source.local", Size: 10, InUse: true, Shared: true},
+	})}
+
+	expected := `
+# HELP dex_build_cache_bytes Size of the build cache records in bytes
+# TYPE dex_build_cache_bytes gauge
+dex_build_cache_bytes{in_use="false",shared="false",type="regular"} 150
+dex_build_cache_bytes{in_use="true",shared="true",type="source.local"} 10
+# HELP dex_build_cache_entries Number of build cache records
+# TYPE dex_build_cache_entries gauge
+dex_build_cache_entries{in_use="false",shared="false",type="regular"} 2
+dex_build_cache_entries{in_use="true",shared="true",type="source.local"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(b, strings.NewReader(expected)))
+}
+
+func TestBuildCacheCollectorDisabled(t *testing.T) {
+	assert.Nil(t, newBuildCacheCollector(nil, nil))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// PutMetricData API limits
+	cloudWatchMaxDatums     = 1000
+	cloudWatchMaxDimensions = 30
+)
+
+type cloudWatchAPI interface {
+	PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+// CloudWatchPublisher periodically pushes the collected metrics to AWS
+// CloudWatch. Counters are sent as deltas since the previous push.
+type CloudWatchPublisher struct {
+	gatherer   prometheus.Gatherer
+	client     cloudWatchAPI
+	namespace  string
+	dimensions []string
+	metrics    map[string]bool
+	hostname   string
+	interval   time.Duration
+
+	// previous counter values by series, used to compute deltas
+	counters map[string]float64
+}
+
+// newCloudWatchPublisher returns nil when no namespace is configured.
+func newCloudWatchPublisher(ctx context.Context, gatherer prometheus.Gatherer) *CloudWatchPublisher {
+	namespace := envString("DEX_CLOUDWATCH_NAMESPACE", "")
+	if namespace == "" {
+		return nil
+	}
+
+	var opts []func(*awsconfig.LoadOptions) error
+	if region := envString("DEX_CLOUDWATCH_REGION", ""); region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		log.Fatalf("can't load aws config: %v", err)
+	}
+
+	interval := envDuration("DEX_CLOUDWATCH_INTERVAL", time.Minute)
+	if interval <= 0 {
+		log.Errorf("invalid DEX_CLOUDWATCH_INTERVAL '%s', using 1m", interval)
+		interval = time.Minute
+		configErrors.add(configKey("DEX_CLOUDWATCH_INTERVAL"))
+	}
+
+	p := &CloudWatchPublisher{
+		client:     cloudwatch.NewFromConfig(cfg),
+		gatherer:   gatherer,
+		namespace:  namespace,
+		dimensions: splitList(envString("DEX_CLOUDWATCH_DIMENSIONS", "container_name")),
+		interval:   interval,
+		counters:   map[string]float64{},
+	}
+
+	if metrics := splitList(envString("DEX_CLOUDWATCH_METRICS", "")); len(metrics) > 0 {
+		p.metrics = map[string]bool{}
+		for _, metric := range metrics {
+			if !strings.HasPrefix(metric, "dex_") {
+				metric = "dex_" + metric
+			}
+			p.metrics[metric] = true
+		}
+	}
+
+	if envBool("DEX_CLOUDWATCH_HOST_DIMENSION", true) {
+		if p.hostname, err = os.Hostname(); err != nil {
+			log.Fatalf("can't get hostname: %v", err)
+		}
+	}
+
+	return p
+}
+
+func (p *CloudWatchPublisher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		families, err := p.gatherer.Gather()
+		if err != nil {
+			log.Error("can't gather metrics for cloudwatch: ", err)
+		}
+
+		if err := p.publish(ctx, p.datums(families, time.Now())); err != nil {
+			log.Error("can't publish metrics to cloudwatch: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *CloudWatchPublisher) datums(families []*dto.MetricFamily, now time.Time) []types.MetricDatum {
+	var datums []types.MetricDatum
+	counters := map[string]float64{}
+
+	for _, mf := range families {
+		name := mf.GetName()
+		if !strings.HasPrefix(name, "dex_") || (p.metrics != nil && !p.metrics[name]) {
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+			var dimensions []types.Dimension
+			if p.hostname != "" {
+				dimensions = append(dimensions, types.Dimension{Name: aws.String("Host"), Value: aws.String(p.hostname)})
+			}
+			for _, lp := range m.GetLabel() {
+				if len(dimensions) == cloudWatchMaxDimensions {
+					break
+				}
+				for _, dimension := range p.dimensions {
+					if lp.GetName() == dimension && lp.GetValue() != "" {
+						dimensions = append(dimensions, types.Dimension{Name: aws.String(lp.GetName()), Value: aws.String(lp.GetValue())})
+					}
+				}
+			}
+
+			value := metricValue(m)
+			if mf.GetType() == dto.MetricType_COUNTER {
+				key := name + alertKey(labelMap(m))
+				counters[key] = value
+
+				previous, ok := p.counters[key]
+				if !ok {
+					// the first push only establishes the baseline
+					continue
+				}
+				if value >= previous {
+					value -= previous
+				}
+			}
+
+			datums = append(datums, types.MetricDatum{
+				MetricName: aws.String(strings.TrimPrefix(name, "dex_")),
+				Dimensions: dimensions,
+				Timestamp:  aws.Time(now),
+				Value:      aws.Float64(value),
+				Unit:       cloudWatchUnit(name),
+			})
+		}
+	}
+	p.counters = counters
+
+	return datums
+}
+
+func (p *CloudWatchPublisher) publish(ctx context.Context, datums []types.MetricDatum) error {
+	for start := 0; start < len(datums); start += cloudWatchMaxDatums {
+		end := min(start+cloudWatchMaxDatums, len(datums))
+
+		if _, err := p.client.PutMetricData(ctx, &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(p.namespace),
+			MetricData: datums[start:end],
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cloudWatchUnit(name string) types.StandardUnit {
+	name = strings.TrimSuffix(name, "_total")
+	switch {
+	case strings.HasSuffix(name, "_bytes"):
+		return types.StandardUnitBytes
+	case strings.HasSuffix(name, "_percent"):
+		return types.StandardUnitPercent
+	case strings.HasSuffix(name, "_seconds"):
+		return types.StandardUnitSeconds
+	}
+	return types.StandardUnitCount
+}
+
+func labelMap(m *dto.Metric) map[string]string {
+	labels := make(map[string]string, len(m.GetLabel()))
+	for _, lp := range m.GetLabel() {
+		labels[lp.GetName()] = lp.GetValue()
+	}
+	return labels
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
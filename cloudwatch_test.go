@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+type fakeCloudWatch struct {
+	inputs []*cloudwatch.PutMetricDataInput
+}
+
+func (f *fakeCloudWatch) PutMetricData(_ context.Context, params *cloudwatch.PutMetricDataInput, _ ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	f.inputs = append(f.inputs, params)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func counterFamily(name, container string, value float64) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name: proto.String(name),
+		Type: dto.MetricType_COUNTER.Enum(),
+		Metric: []*dto.Metric{{
+			Label:   []*dto.LabelPair{{Name: proto.String("container_name"), Value: proto.String(container)}},
+			Counter: &dto.Counter{Value: proto.Float64(value)},
+		}},
+	}
+}
+
+func TestCloudWatchDatums(t *testing.T) {
+	p := &CloudWatchPublisher{
+		dimensions: []string{"container_name"},
+		hostname:   "ip-10-0-0-1",
+		counters:   map[string]float64{},
+	}
+	now := time.Unix(1700000000, 0)
+
+	datums := p.datums([]*dto.MetricFamily{
+		gaugeFamily("dex_memory_usage_bytes", "web", 1024),
+		counterFamily("dex_network_rx_bytes_total", "web", 1000),
+	}, now)
+	require.Len(t, datums, 1, "Counters should be skipped on the first push")
+	assert.Equal(t, "memory_usage_bytes", *datums[0].MetricName)
+	assert.Equal(t, types.StandardUnitBytes, datums[0].Unit)
+	require.Len(t, datums[0].Dimensions, 2)
+	assert.Equal(t, "Host", *datums[0].Dimensions[0].Name)
+	assert.Equal(t, "container_name", *datums[0].Dimensions[1].Name)
+	assert.Equal(t, "web", *datums[0].Dimensions[1].Value)
+
+	datums = p.datums([]*dto.MetricFamily{counterFamily("dex_network_rx_bytes_total", "web", 1500)}, now.Add(time.Minute))
+	require.Len(t, datums, 1)
+	assert.Equal(t, 500.0, *datums[0].Value, "Counters should be sent as deltas")
+	assert.Equal(t, types.StandardUnitBytes, datums[0].Unit)
+
+	datums = p.datums([]*dto.MetricFamily{counterFamily("dex_network_rx_bytes_total", "web", 200)}, now.Add(2*time.Minute))
+	require.Len(t, datums, 1)
+	assert.Equal(t, 200.0, *datums[0].Value, "Counter resets should send the new value")
+}
+
+func TestCloudWatchPublishBatches(t *testing.T) {
+	client := &fakeCloudWatch{}
+	p := &CloudWatchPublisher{client: client, namespace: "Dex"}
+
+	datums := make([]types.MetricDatum, 2500)
+	require.NoError(t, p.publish(context.Background(), datums))
+
+	require.Len(t, client.inputs, 3, "Datums should be split into batches")
+	assert.Len(t, client.inputs[0].MetricData, 1000)
+	assert.Len(t, client.inputs[2].MetricData, 500)
+	assert.Equal(t, "Dex", *client.inputs[0].Namespace)
+}
+
+func TestCloudWatchPublisherInvalidInterval(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/nonexistent")
+	t.Setenv("DEX_CLOUDWATCH_NAMESPACE", "DEX")
+	t.Setenv("DEX_CLOUDWATCH_REGION", "eu-west-1")
+	t.Setenv("DEX_CLOUDWATCH_INTERVAL", "0")
+	saved := configErrors
+	configErrors = &ConfigErrors{}
+	t.Cleanup(func() { configErrors = saved })
+
+	p := newCloudWatchPublisher(context.Background(), prometheus.NewRegistry())
+	require.NotNil(t, p)
+	assert.Equal(t, time.Minute, p.interval)
+	assert.Equal(t, 1, testutil.CollectAndCount(configErrors))
+}
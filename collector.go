@@ -1,45 +1,384 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
-	"os"
+	"fmt"
+	"io"
+	"math"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/docker/docker/api/types/blkiodev"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
 )
 
 var labelCname = []string{"container_name"}
 
+// containerLabels are the label names and values identifying the series of a container.
+type containerLabels struct {
+	names  []string
+	values []string
+
+	// pairs caches the label pairs shared by the metrics of the container,
+	// it's filled by the first metric and not safe for concurrent use
+	pairs *[]*dto.LabelPair
+}
+
+func newContainerLabels(cName string) containerLabels {
+	return containerLabels{names: labelCname, values: []string{cName}, pairs: new([]*dto.LabelPair)}
+}
+
+// with returns a copy of the labels with an additional label.
+func (cl containerLabels) with(name, value string) containerLabels {
+	return containerLabels{
+		names:  append(append([]string(nil), cl.names...), name),
+		values: append(append([]string(nil), cl.values...), value),
+		pairs:  new([]*dto.LabelPair),
+	}
+}
+
+// labelPairs returns the labels sorted by name as in exported metrics.
+func (cl containerLabels) labelPairs() []*dto.LabelPair {
+	if cl.pairs != nil && *cl.pairs != nil {
+		return *cl.pairs
+	}
+
+	pairs := make([]*dto.LabelPair, len(cl.names))
+	for i, name := range cl.names {
+		pairs[i] = &dto.LabelPair{Name: proto.String(name), Value: proto.String(cl.values[i])}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].GetName() < pairs[j].GetName()
+	})
+
+	if cl.pairs != nil {
+		*cl.pairs = pairs
+	}
+	return pairs
+}
+
+// metric returns a sample of the container for the desc created with its
+// label names. Unlike MustNewConstMetric it doesn't allocate label pairs for
+// every sample.
+func (cl containerLabels) metric(desc *prometheus.Desc, valueType prometheus.ValueType, value float64) prometheus.Metric {
+	return &containerMetric{desc: desc, valueType: valueType, value: value, labels: cl.labelPairs()}
+}
+
+// containerMetric is a const metric sharing its label pairs with the other
+// metrics of the container.
+type containerMetric struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     float64
+	labels    []*dto.LabelPair
+}
+
+func (m *containerMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m *containerMetric) Write(out *dto.Metric) error {
+	// the pairs are allocated with their length as capacity, so labels
+	// appended by wrapping registerers don't modify the shared slice
+	out.Label = m.labels
+	// the metric isn't modified after its creation, so the value can be shared
+	switch m.valueType {
+	case prometheus.CounterValue:
+		out.Counter = &dto.Counter{Value: &m.value}
+	case prometheus.GaugeValue:
+		out.Gauge = &dto.Gauge{Value: &m.value}
+	default:
+		out.Untyped = &dto.Untyped{Value: &m.value}
+	}
+	return nil
+}
+
+// histogram returns the histogram with the labels of the container, for the
+// desc created with its label names.
+func (cl containerLabels) histogram(desc *prometheus.Desc, h prometheus.Histogram) prometheus.Metric {
+	return &containerHistogram{desc: desc, histogram: h, labels: cl.labelPairs()}
+}
+
+// containerHistogram exports a histogram kept across scrapes, e.g. by the
+// sampler, with the label pairs of its container.
+type containerHistogram struct {
+	desc      *prometheus.Desc
+	histogram prometheus.Histogram
+	labels    []*dto.LabelPair
+}
+
+func (m *containerHistogram) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m *containerHistogram) Write(out *dto.Metric) error {
+	if err := m.histogram.Write(out); err != nil {
+		return err
+	}
+	out.Label = m.labels
+	return nil
+}
+
+// key identifies the container series, e.g. for caches.
+func (cl containerLabels) key() string {
+	return strings.Join(cl.values, "\x00")
+}
+
+// descCache shares the Desc of a metric family between the samples of all
+// scrapes, creating one per sample was the main allocation of a scrape.
+type descCache struct {
+	mu sync.RWMutex
+	// descs of a family by their label names, which differ only between hosts
+	descs map[string][]labeledDesc
+}
+
+type labeledDesc struct {
+	labelNames []string
+	desc       *prometheus.Desc
+}
+
+var descs = &descCache{}
+
+// get returns the Desc of the family of metricDefs with the label names.
+func (c *descCache) get(name string, labelNames []string) *prometheus.Desc {
+	c.mu.RLock()
+	for _, d := range c.descs[name] {
+		if slices.Equal(d.labelNames, labelNames) {
+			c.mu.RUnlock()
+			return d.desc
+		}
+	}
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, d := range c.descs[name] {
+		if slices.Equal(d.labelNames, labelNames) {
+			return d.desc
+		}
+	}
+	if c.descs == nil {
+		c.descs = map[string][]labeledDesc{}
+	}
+	desc := newDesc(name, labelNames)
+	c.descs[name] = append(c.descs[name], labeledDesc{labelNames: slices.Clone(labelNames), desc: desc})
+	return desc
+}
+
 type DockerCollector struct {
-	cli         *client.Client
-	containerRe *regexp.Regexp
+	cli      *client.Client
+	filter   *containerFilter
+	api      *DockerAPIMetrics
+	counters *MonotonicCounters
+	sampler  *StatsSampler
+	lastSeen *LastSeen
+
+	containerIDLabel  bool
+	composeAggregates bool
+	hostCounters      *hostCounters
+	// export the CPU utilization of all samples instead of the last one
+	cpuHistogram bool
+	// export the network throughput between the samples
+	networkRates bool
+
+	// export full metrics only for the topN containers by CPU or memory
+	topN   int
+	topNBy string
+
+	// deadline of the API requests for a single container
+	containerTimeout time.Duration
+	// requests per second and burst of the API requests of a single scrape
+	scrapeRate  int
+	scrapeBurst int
+
+	// stream two samples instead of a single one, see readContainerStats
+	streamStats bool
+	// states of the listed containers, all if empty
+	listStates []string
+	// don't inspect exited containers for their restart count and ulimits,
+	// their exit code is read from the list instead
+	skipInspectExited bool
+	// bounds the containers processed at once by the scrapes and the sampler,
+	// and so the inspect and stats responses held at once, unlimited if nil
+	slots chan struct{}
+	// names of the environment variables whose presence is exported
+	envAudit []string
+
+	countProcesses bool
+	tmpfsMetrics   bool
+	numaMetrics    bool
+	rootfsInodes   bool
+	// procfs and cgroupfs of the docker host
+	procPath   string
+	cgroupPath string
+
+	status collectionStatus
+
+	hostInfoOnce sync.Once
+	hostMemTotal uint64
+	hostArch32   bool
 }
 
 func newDockerCollector() *DockerCollector {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	c, err := newDockerCollectorFor(dockerHost(), config.Filters)
 	if err != nil {
 		log.Fatalf("can't create docker client: %v", err)
 	}
-	container_name_regex, found := os.LookupEnv("DEX_FILTER_CONTAINER")
-	if !found {
-		container_name_regex = ".*"
+	return c
+}
+
+// globalFilters returns the filter of DEX_DOCKER_HOST, which follows the
+// global rules.
+func (c *DockerCollector) globalFilters() []*containerFilter {
+	return []*containerFilter{c.filter}
+}
+
+func (c *DockerCollector) setGlobalRules(rules []*FilterRule) {
+	c.filter.setRules(rules)
+}
+
+// closeIdleConnections closes the idle connections to the daemon, the client
+// reconnects on its next request.
+func (c *DockerCollector) closeIdleConnections() {
+	if c.cli != nil {
+		c.cli.Close()
 	}
+}
 
-	re, err := regexp.Compile(container_name_regex)
+// newDockerCollectorFor returns a collector of the docker daemon at host. The
+// filter rules replace DEX_FILTER_CONTAINER when set.
+func newDockerCollectorFor(host string, filters []*FilterRule) (*DockerCollector, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithHost(host), client.WithAPIVersionNegotiation())
 	if err != nil {
-		log.Fatalf("invalid container filter regexp '%s': %v", container_name_regex, err)
+		return nil, err
+	}
+	c := &DockerCollector{
+		cli:      cli,
+		api:      newDockerAPIMetrics(),
+		counters: newMonotonicCounters(),
+		lastSeen: newLastSeen(),
+
+		containerIDLabel:  envBool("DEX_CONTAINER_ID_LABEL", false),
+		composeAggregates: envBool("DEX_COMPOSE_AGGREGATES", false),
+		hostCounters:      newHostCounters(envBool("DEX_HOST_TOTALS", false)),
+		cpuHistogram:      envBool("DEX_CPU_HISTOGRAM", false),
+		networkRates:      envBool("DEX_NETWORK_RATES", false),
+
+		topN:   envInt("DEX_TOP_N", 0),
+		topNBy: envString("DEX_TOP_N_BY", "cpu"),
+
+		containerTimeout: envDuration("DEX_CONTAINER_TIMEOUT", 5*time.Second),
+		scrapeRate:       envInt("DEX_SCRAPE_API_RATE", 0),
+		scrapeBurst:      envInt("DEX_SCRAPE_API_BURST", 0),
+
+		streamStats:       envString("DEX_STATS_MODE", "oneshot") == "stream",
+		listStates:        splitList(envString("DEX_CONTAINER_STATES", "")),
+		skipInspectExited: !envBool("DEX_INSPECT_EXITED", true),
+		envAudit:          splitList(envString("DEX_ENV_AUDIT", "")),
+
+		countProcesses: envBool("DEX_PROCESS_METRICS", false),
+		tmpfsMetrics:   envBool("DEX_TMPFS_METRICS", false),
+		numaMetrics:    envBool("DEX_NUMA_METRICS", false),
+		rootfsInodes:   envBool("DEX_ROOTFS_INODES", false),
+		procPath:       envString("DEX_PROC_PATH", "/proc"),
+		cgroupPath:     envString("DEX_CGROUP_PATH", "/sys/fs/cgroup"),
+	}
+
+	// shared by all API calls to this daemon, the background ones included
+	c.api.limiter = newRateLimiter(envInt("DEX_DOCKER_API_RATE", 0), envInt("DEX_DOCKER_API_BURST", 0))
+
+	// an invalid filter must not leave the whole host unmonitored
+	if len(filters) > 0 {
+		c.filter, err = newContainerFilter(filters)
+		if err != nil {
+			log.Errorf("invalid container filters, collecting all containers: %v", err)
+			c.filter = matchAllFilter()
+			configErrors.add("filters")
+		}
+	} else {
+		container_name_regex := envString("DEX_FILTER_CONTAINER", ".*")
+		c.filter, err = newContainerFilter([]*FilterRule{{Match: container_name_regex}})
+		if err != nil {
+			log.Errorf("invalid container filter regexp '%s', collecting all containers: %v", container_name_regex, err)
+			c.filter = matchAllFilter()
+			configErrors.add(configKey("DEX_FILTER_CONTAINER"))
+		}
+	}
+
+	c.filter.sanitizer = newLabelSanitizer()
+
+	if err := c.filter.withLabelRules(config.ContainerLabels); err != nil {
+		log.Errorf("invalid container_labels, adding no labels: %v", err)
+		configErrors.add("container_labels")
+	}
+
+	if c.topNBy != "cpu" && c.topNBy != "memory" {
+		log.Errorf("invalid DEX_TOP_N_BY '%s', using cpu", c.topNBy)
+		c.topNBy = "cpu"
+		configErrors.add(configKey("DEX_TOP_N_BY"))
+	}
+
+	if err := validateContainerStates(strings.Join(c.listStates, ",")); err != nil {
+		log.Errorf("invalid DEX_CONTAINER_STATES, listing all containers: %v", err)
+		c.listStates = nil
+		configErrors.add(configKey("DEX_CONTAINER_STATES"))
+	}
+
+	if err := validateStatsMode(envString("DEX_STATS_MODE", "oneshot")); err != nil {
+		log.Errorf("invalid DEX_STATS_MODE, using oneshot: %v", err)
+		configErrors.add(configKey("DEX_STATS_MODE"))
 	}
 
-	return &DockerCollector{
-		cli:         cli,
-		containerRe: re,
+	if concurrency := envInt("DEX_COLLECT_CONCURRENCY", 0); concurrency > 0 {
+		c.slots = make(chan struct{}, concurrency)
 	}
+
+	c.sampler = newStatsSampler(cli, c.api, c.filter, c.topN)
+	if c.sampler != nil {
+		c.sampler.streamStats = c.streamStats
+		c.sampler.wraps32 = c.hostWraps32
+		c.sampler.slots = c.slots
+	}
+	if c.counters != nil {
+		c.counters.wraps32 = c.hostWraps32
+	}
+
+	// the histogram needs the samples between the scrapes
+	if c.cpuHistogram && c.sampler == nil {
+		log.Error("DEX_CPU_HISTOGRAM needs DEX_SAMPLE_INTERVAL, exporting the CPU utilization as gauge")
+		c.cpuHistogram = false
+		configErrors.add(configKey("DEX_CPU_HISTOGRAM"))
+	}
+	if c.cpuHistogram {
+		c.sampler.cpu = map[string]prometheus.Histogram{}
+	}
+	if c.networkRates && c.sampler == nil {
+		log.Error("DEX_NETWORK_RATES needs DEX_SAMPLE_INTERVAL, not exporting network rates")
+		c.networkRates = false
+		configErrors.add(configKey("DEX_NETWORK_RATES"))
+	}
+	if c.networkRates {
+		c.sampler.networkRates = map[string]networkRate{}
+	}
+
+	return c, nil
 }
 
 func (c *DockerCollector) Describe(_ chan<- *prometheus.Desc) {
@@ -47,35 +386,238 @@ func (c *DockerCollector) Describe(_ chan<- *prometheus.Desc) {
 }
 
 func (c *DockerCollector) Collect(ch chan<- prometheus.Metric) {
-	containers, err := c.cli.ContainerList(context.Background(), container.ListOptions{
-		All: true,
+	// API metrics are collected at the end to include this scrape
+	defer c.api.Collect(ch)
+
+	id := c.status.begin()
+	success := false
+	defer func() {
+		c.status.end(id, success)
+	}()
+
+	started := time.Now()
+	ctx, span := tracer.Start(context.Background(), "collect")
+	defer span.End()
+	ctx = withScrapeLimiter(ctx, newRateLimiter(c.scrapeRate, c.scrapeBurst))
+
+	// the daemon filters by state, so hosts with many exited containers
+	// don't have to return them all
+	options := container.ListOptions{All: true}
+	if len(c.listStates) > 0 {
+		options.Filters = filters.NewArgs()
+		for _, state := range c.listStates {
+			options.Filters.Add("status", state)
+		}
+	}
+
+	var containers []container.Summary
+	err := c.api.observe(ctx, "list", func() error {
+		var err error
+		containers, err = c.cli.ContainerList(ctx, options)
+		return err
 	})
 	if err != nil {
 		log.Error("can't list containers: ", err)
+		span.SetStatus(codes.Error, err.Error())
 		return
 	}
+	span.SetAttributes(attribute.Int("containers", len(containers)))
+
+	projects := newProjectAggregates(c.composeAggregates)
+	totals := newHostTotals(c.hostCounters)
+
+	var top map[string]bool
+	if c.topN > 0 {
+		top = c.sampler.top(c.topN, c.topNBy)
+	}
 
 	var wg sync.WaitGroup
 
 	for _, container := range containers {
+		if c.slots != nil {
+			c.slots <- struct{}{}
+		}
 		wg.Add(1)
 
-		go c.processContainer(container, ch, &wg)
+		full := top == nil || top[container.ID]
+		go func() {
+			c.processContainer(ctx, container, ch, projects, totals, full, &wg)
+			if c.slots != nil {
+				<-c.slots
+			}
+		}()
 	}
 	wg.Wait()
+
+	projects.Collect(ch)
+	totals.Collect(ch)
+	c.lastSeen.collectAbsent(ch, started)
+
+	c.counters.prune(time.Now())
+	success = true
+}
+
+// statsBuffers are reused to read the stats responses, which have about the
+// same size for all containers.
+var statsBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// decodeStats decodes a stats response. Reading it into a pooled buffer
+// allocates less than a json.Decoder growing its own buffer for every response.
+func decodeStats(r io.Reader, stats *container.StatsResponse) error {
+	buf := statsBuffers.Get().(*bytes.Buffer)
+	defer statsBuffers.Put(buf)
+
+	buf.Reset()
+	if _, err := buf.ReadFrom(r); err != nil {
+		return err
+	}
+	return json.Unmarshal(buf.Bytes(), stats)
+}
+
+// readContainerStats reads a stats sample of a container. By default the
+// daemon returns a single sample with the previous CPU stats filled from its
+// own earlier sample, which is missing or stale right after the container
+// started. With stream set two fresh samples are streamed and the CPU
+// utilization is computed between them. It's accurate right after the
+// container started but takes the second between the samples.
+func readContainerStats(ctx context.Context, cli *client.Client, api *DockerAPIMetrics, id string, stream bool) (container.StatsResponse, error) {
+	var containerStats container.StatsResponse
+	err := api.observe(ctx, "stats", func() error {
+		stats, err := cli.ContainerStats(ctx, id, stream)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := stats.Body.Close(); err != nil {
+				log.Error("can't close body: ", err)
+			}
+		}()
+
+		if !stream {
+			return decodeStats(stats.Body, &containerStats)
+		}
+
+		decoder := json.NewDecoder(stats.Body)
+		if err := decoder.Decode(&containerStats); err != nil {
+			return err
+		}
+		// the stream ends when the container stops, the first sample is all there is
+		var second container.StatsResponse
+		if err := decoder.Decode(&second); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		second.PreCPUStats = containerStats.CPUStats
+		second.PreRead = containerStats.Read
+		containerStats = second
+		return nil
+	})
+	return containerStats, err
+}
+
+// ping checks that the Docker daemon is reachable.
+func (c *DockerCollector) ping(ctx context.Context, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := c.api.observe(ctx, "ping", func() error {
+		_, err := c.cli.Ping(ctx)
+		return err
+	})
+	return err == nil
+}
+
+// healthy reports whether collections are working: no collection is stuck for
+// longer than timeout, and either a collection succeeded within timeout or the
+// daemon answers a ping.
+func (c *DockerCollector) healthy(ctx context.Context, timeout time.Duration) bool {
+	now := time.Now()
+	if c.status.stalled(now, timeout) {
+		return false
+	}
+	if c.status.succeededSince(now.Add(-timeout)) {
+		return true
+	}
+	return c.ping(ctx, timeout/2)
+}
+
+// succeededSince reports whether a collection succeeded after t.
+func (c *DockerCollector) succeededSince(t time.Time) bool {
+	return c.status.succeededSince(t)
+}
+
+// collectionStatus tracks running and successful collections.
+type collectionStatus struct {
+	mu          sync.Mutex
+	seq         int
+	running     map[int]time.Time
+	lastSuccess time.Time
+}
+
+func (s *collectionStatus) begin() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running == nil {
+		s.running = map[int]time.Time{}
+	}
+	s.seq++
+	s.running[s.seq] = time.Now()
+	return s.seq
+}
+
+func (s *collectionStatus) end(id int, success bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.running, id)
+	if success {
+		s.lastSuccess = time.Now()
+	}
+}
+
+// stalled reports whether a collection has been running for longer than timeout.
+func (s *collectionStatus) stalled(now time.Time, timeout time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, started := range s.running {
+		if now.Sub(started) > timeout {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *collectionStatus) succeededSince(t time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastSuccess.After(t)
 }
 
-func (c *DockerCollector) processContainer(cont container.Summary, ch chan<- prometheus.Metric, wg *sync.WaitGroup) {
+func (c *DockerCollector) processContainer(ctx context.Context, cont container.Summary, ch chan<- prometheus.Metric, projects *projectAggregates, totals *hostTotals, full bool, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	cName := strings.TrimPrefix(strings.Join(cont.Names, ";"), "/")
-	submatches := c.containerRe.FindStringSubmatch(cName)
-	if len(submatches) == 0 {
+	filterLabels, ok := c.filter.match(strings.TrimPrefix(strings.Join(cont.Names, ";"), "/"))
+	if !ok {
 		return
 	}
-	cName = submatches[len(submatches)-1]
+	cName := filterLabels.values[0]
+
+	ctx, span := tracer.Start(ctx, "container", trace.WithAttributes(attribute.String("container.name", cName), attribute.String("container.id", cont.ID)))
+	defer span.End()
+
+	cl := filterLabels
+	if c.containerIDLabel {
+		cl = cl.with("container_id", shortID(cont.ID))
+	}
+
+	project := c.filter.sanitizer.sanitize(containerProject(cont.Labels))
+	projects.addContainer(project, cont.State, upAndHealthy(cont))
 
-	var isRunning, isRestarting, isExited float64
+	var isRunning, isRestarting, isExited, isPaused float64
 
 	if cont.State == "running" {
 		isRunning = 1
@@ -89,163 +631,821 @@ func (c *DockerCollector) processContainer(cont container.Summary, ch chan<- pro
 		isExited = 1
 	}
 
+	if cont.State == "paused" {
+		isPaused = 1
+	}
+
 	// container state metric for all containers
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+	ch <- cl.metric(descs.get(
 		"dex_container_running",
-		"1 if docker container is running, 0 otherwise",
-		labelCname,
-		nil,
-	), prometheus.GaugeValue, isRunning, cName)
+		cl.names,
+	), prometheus.GaugeValue, isRunning)
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+	ch <- cl.metric(descs.get(
 		"dex_container_restarting",
-		"1 if docker container is restarting, 0 otherwise",
-		labelCname,
-		nil,
-	), prometheus.GaugeValue, isRestarting, cName)
+		cl.names,
+	), prometheus.GaugeValue, isRestarting)
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+	ch <- cl.metric(descs.get(
 		"dex_container_exited",
-		"1 if docker container exited, 0 otherwise",
-		labelCname,
-		nil,
-	), prometheus.GaugeValue, isExited, cName)
+		cl.names,
+	), prometheus.GaugeValue, isExited)
+
+	ch <- cl.metric(descs.get(
+		"dex_container_paused",
+		cl.names,
+	), prometheus.GaugeValue, isPaused)
+
+	now := time.Now()
+	ch <- cl.metric(descs.get(
+		"dex_container_last_seen_timestamp_seconds",
+		cl.names,
+	), prometheus.GaugeValue, float64(now.UnixNano())/1e9)
+
+	if c.lastSeen != nil {
+		c.lastSeen.see(cl, now)
+		ch <- cl.metric(descs.get(
+			"dex_container_absent",
+			cl.names,
+		), prometheus.GaugeValue, 0)
+	}
+
+	info := filterLabels.with("container_id", shortID(cont.ID)).with("image", cont.Image)
+	ch <- info.metric(descs.get(
+		"dex_container_info",
+		info.names,
+	), prometheus.GaugeValue, 1)
+
+	if !full {
+		// outside the top-N only the aggregates use the sampled stats
+		if containerStats, ok := c.sampler.get(cont.ID); ok {
+			totals.addStats(cont.ID, containerStats)
+			projects.addStats(project, float64(containerStats.CPUStats.CPUUsage.TotalUsage)/1e9, float64(memoryUsageBytes(containerStats)))
+			// the CPU limit is only known from the inspection
+			if headroom, ok := c.memoryHeadroom(containerStats); ok {
+				projects.addMemoryHeadroom(project, headroom)
+			}
+		}
+		return
+	}
+
+	// on hosts with thousands of exited CI containers their inspection
+	// dominates the scrape time
+	if isExited == 1 && c.skipInspectExited {
+		if exitCode, ok := statusExitCode(cont.Status); ok {
+			exitCodeMetrics(ch, exitCode, cl)
+		}
+		return
+	}
+
+	// a wedged container must not delay the metrics of all others
+	if c.containerTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.containerTimeout)
+		defer cancel()
+	}
+
+	// CPU limit of the container in cores, 0 if unlimited
+	var cpuCores float64
 
-	if inspect, err := c.cli.ContainerInspect(context.Background(), cont.ID); err != nil {
-		log.Fatal(err)
+	var inspect container.InspectResponse
+	err := c.api.observe(ctx, "inspect", func() error {
+		var err error
+		inspect, err = c.cli.ContainerInspect(ctx, cont.ID)
+		return err
+	})
+	if err != nil {
+		log.Errorf("can't inspect container '%s': %v", cName, err)
 	} else {
-		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+		ch <- cl.metric(descs.get(
 			"dex_container_restarts_total",
-			"Number of times the container has restarted",
-			labelCname,
-			nil,
-		), prometheus.CounterValue, float64(inspect.RestartCount), cName)
+			cl.names,
+		), prometheus.CounterValue, float64(inspect.RestartCount))
+
+		// health metric only for containers with a healthcheck
+		if inspect.State != nil && inspect.State.Health != nil {
+			var isHealthy float64
+			if inspect.State.Health.Status == "healthy" {
+				isHealthy = 1
+			}
+
+			ch <- cl.metric(descs.get(
+				"dex_container_healthy",
+				cl.names,
+			), prometheus.GaugeValue, isHealthy)
+		}
+
+		mountMetrics(ch, inspect.Mounts, cl)
+		if inspect.Config != nil {
+			userMetrics(ch, inspect.Config.User, cl)
+			if len(c.envAudit) > 0 {
+				envAuditMetrics(ch, c.envAudit, inspect.Config.Env, cl)
+			}
+		}
+		if inspect.HostConfig != nil {
+			ulimitMetrics(ch, inspect.HostConfig.Ulimits, cl)
+			cpusetMetrics(ch, inspect.HostConfig, cl)
+			blkioThrottleMetrics(ch, inspect.HostConfig, cl)
+			logDriverMetrics(ch, inspect.HostConfig.LogConfig, cl)
+		}
+		cpuCores, _ = cpuLimit(inspect.HostConfig)
+
+		if isExited == 1 && inspect.State != nil {
+			exitCodeMetrics(ch, inspect.State.ExitCode, cl)
+		}
+
+		if changed, ok := stateChangedAt(inspect); ok {
+			ch <- cl.metric(descs.get(
+				"dex_container_state_changed_timestamp_seconds",
+				cl.names,
+			), prometheus.GaugeValue, float64(changed.UnixNano())/1e9)
+		}
+
+		if inspect.State != nil && inspect.State.Pid > 0 {
+			if c.countProcesses {
+				c.processMetrics(ch, inspect.State.Pid, cl)
+			}
+			if c.tmpfsMetrics {
+				c.tmpfsUsageMetrics(ch, inspect.State.Pid, cl)
+			}
+			// NUMA locality matters for containers pinned to CPUs or memory nodes
+			if c.numaMetrics && inspect.HostConfig != nil && (inspect.HostConfig.CpusetCpus != "" || inspect.HostConfig.CpusetMems != "") {
+				c.numaMemoryMetrics(ch, inspect.State.Pid, cl)
+			}
+		}
+
+		// only overlay2 exposes the writable layer, not the containerd snapshotter
+		if upperDir := inspect.GraphDriver.Data["UpperDir"]; c.rootfsInodes && isRunning == 1 && upperDir != "" {
+			c.rootfsMetrics(ch, upperDir, cl)
+		}
 	}
 
 	// stats metrics only for running containers
 	if isRunning == 1 {
-
-		if stats, err := c.cli.ContainerStats(context.Background(), cont.ID, false); err != nil {
-			log.Fatal(err)
-		} else {
-			var containerStats container.StatsResponse
-			err := json.NewDecoder(stats.Body).Decode(&containerStats)
-			if err != nil {
-				log.Error("can't read api stats: ", err)
-			}
-			if err := stats.Body.Close(); err != nil {
-				log.Error("can't close body: ", err)
+		var timedOut float64
+		containerStats, ok := c.sampler.get(cont.ID)
+		if !ok {
+			stats, err := readContainerStats(ctx, c.cli, c.api, cont.ID, c.streamStats)
+			if ctx.Err() == context.DeadlineExceeded {
+				log.Warnf("reading stats of container '%s' timed out after %s", cName, c.containerTimeout)
+				timedOut = 1
+			} else if err != nil {
+				log.Errorf("can't read stats of container '%s': %v", cName, err)
 			}
+			normalizeStats(&stats)
+			containerStats, ok = &stats, err == nil
+		}
+
+		ch <- cl.metric(descs.get(
+			"dex_container_stats_timeout",
+			cl.names,
+		), prometheus.GaugeValue, timedOut)
+		if ok {
+
+			c.blockIoMetrics(ch, containerStats, cl)
 
-			c.blockIoMetrics(ch, &containerStats, cName)
+			c.memoryMetrics(ch, containerStats, cl)
 
-			c.memoryMetrics(ch, &containerStats, cName)
+			c.networkMetrics(ch, containerStats, cl)
 
-			c.networkMetrics(ch, &containerStats, cName)
+			c.CPUMetrics(ch, containerStats, cl)
 
-			c.CPUMetrics(ch, &containerStats, cName)
+			c.pidsMetrics(ch, containerStats, cl)
 
-			c.pidsMetrics(ch, &containerStats, cName)
+			c.headroomMetrics(ch, containerStats, cpuCores, cl, projects, project)
+
+			totals.addStats(cont.ID, containerStats)
+
+			projects.addStats(project, float64(containerStats.CPUStats.CPUUsage.TotalUsage)/1e9, float64(memoryUsageBytes(containerStats)))
 		}
 	}
 }
 
-func (c *DockerCollector) CPUMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cName string) {
+func (c *DockerCollector) CPUMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cl containerLabels) {
 	totalUsage := containerStats.CPUStats.CPUUsage.TotalUsage
-	cpuDelta := totalUsage - containerStats.PreCPUStats.CPUUsage.TotalUsage
-	sysemDelta := containerStats.CPUStats.SystemUsage - containerStats.PreCPUStats.SystemUsage
-
-	cpuUtilization := float64(cpuDelta) / float64(sysemDelta) * 100.0
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_cpu_utilization_percent",
-		"CPU utilization in percent",
-		labelCname,
-		nil,
-	), prometheus.GaugeValue, cpuUtilization, cName)
+	if c.cpuHistogram {
+		if h, ok := c.sampler.cpuHistogram(containerStats.ID); ok {
+			ch <- cl.histogram(descs.get(
+				"dex_cpu_utilization_percent",
+				cl.names,
+			), h)
+		}
+	} else if cpuUtilization, ok := cpuPercent(containerStats); ok {
+		ch <- cl.metric(descs.get(
+			"dex_cpu_utilization_percent",
+			cl.names,
+		), prometheus.GaugeValue, cpuUtilization)
+	}
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+	ch <- cl.metric(descs.get(
 		"dex_cpu_utilization_seconds_total",
-		"Cumulative CPU utilization in seconds",
-		labelCname,
-		nil,
-	), prometheus.CounterValue, float64(totalUsage)/1e9, cName)
+		cl.names,
+	), prometheus.CounterValue, c.counters.value(cl.key(), "cpu_seconds", float64(totalUsage)/1e9))
+
+	// not reported by all daemons
+	usage := containerStats.CPUStats.CPUUsage
+	if usage.UsageInUsermode == 0 && usage.UsageInKernelmode == 0 {
+		return
+	}
+	for mode, nanoseconds := range map[string]uint64{"user": usage.UsageInUsermode, "kernel": usage.UsageInKernelmode} {
+		mcl := cl.with("mode", mode)
+		ch <- mcl.metric(descs.get(
+			"dex_cpu_mode_seconds_total",
+			mcl.names,
+		), prometheus.CounterValue, c.counters.value(cl.key(), "cpu_"+mode+"_seconds", float64(nanoseconds)/1e9))
+	}
+}
+
+// shortID returns the 12 character container ID used by the docker CLI.
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
 }
 
-func (c *DockerCollector) networkMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cName string) {
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+// cpuPercent calculates CPU utilization the same way as docker stats does:
+// the share of the host CPU time used by the container, multiplied by the
+// number of online CPUs. It returns false when the sample can't be used, e.g.
+// for a fresh container without previous CPU stats.
+func cpuPercent(containerStats *container.StatsResponse) (float64, bool) {
+	if isWindowsStats(containerStats) {
+		return cpuPercentWindows(containerStats)
+	}
+
+	cpu, preCPU := containerStats.CPUStats, containerStats.PreCPUStats
+
+	if preCPU.SystemUsage == 0 || cpu.SystemUsage <= preCPU.SystemUsage || cpu.CPUUsage.TotalUsage < preCPU.CPUUsage.TotalUsage {
+		return 0, false
+	}
+
+	onlineCPUs := float64(cpu.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(cpu.CPUUsage.PercpuUsage))
+	}
+	if onlineCPUs == 0 {
+		onlineCPUs = 1
+	}
+
+	cpuDelta := float64(cpu.CPUUsage.TotalUsage - preCPU.CPUUsage.TotalUsage)
+	systemDelta := float64(cpu.SystemUsage - preCPU.SystemUsage)
+
+	percent := cpuDelta / systemDelta * onlineCPUs * 100.0
+
+	return min(percent, onlineCPUs*100.0), true
+}
+
+// cpuPercentWindows calculates the CPU utilization of normalized Windows
+// stats, which have no host CPU time, from the CPU time used between the
+// reads of the samples. As on Linux, 100% is one processor.
+func cpuPercentWindows(containerStats *container.StatsResponse) (float64, bool) {
+	cpu, preCPU := containerStats.CPUStats, containerStats.PreCPUStats
+	elapsed := containerStats.Read.Sub(containerStats.PreRead)
+
+	if containerStats.PreRead.IsZero() || elapsed <= 0 || cpu.CPUUsage.TotalUsage < preCPU.CPUUsage.TotalUsage {
+		return 0, false
+	}
+
+	percent := float64(cpu.CPUUsage.TotalUsage-preCPU.CPUUsage.TotalUsage) / float64(elapsed.Nanoseconds()) * 100.0
+	return min(percent, float64(containerStats.NumProcs)*100.0), true
+}
+
+func (c *DockerCollector) networkMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cl containerLabels) {
+	ch <- cl.metric(descs.get(
 		"dex_network_rx_bytes_total",
-		"Network received bytes total",
-		labelCname,
-		nil,
-	), prometheus.CounterValue, float64(containerStats.Networks["eth0"].RxBytes), cName)
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+		cl.names,
+	), prometheus.CounterValue, c.counters.value(cl.key(), "network_rx", float64(containerStats.Networks["eth0"].RxBytes)))
+	ch <- cl.metric(descs.get(
 		"dex_network_tx_bytes_total",
-		"Network sent bytes total",
-		labelCname,
-		nil,
-	), prometheus.CounterValue, float64(containerStats.Networks["eth0"].TxBytes), cName)
+		cl.names,
+	), prometheus.CounterValue, c.counters.value(cl.key(), "network_tx", float64(containerStats.Networks["eth0"].TxBytes)))
+
+	// for sinks which can't compute rate(), e.g. MQTT
+	if rate, ok := c.sampler.networkRate(containerStats.ID); ok {
+		ch <- cl.metric(descs.get(
+			"dex_network_rx_bytes_per_second",
+			cl.names,
+		), prometheus.GaugeValue, rate.rx)
+		ch <- cl.metric(descs.get(
+			"dex_network_tx_bytes_per_second",
+			cl.names,
+		), prometheus.GaugeValue, rate.tx)
+	}
 }
 
-func (c *DockerCollector) memoryMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cName string) {
+// memoryUsageBytes returns the memory usage without the page cache.
+func memoryUsageBytes(containerStats *container.StatsResponse) uint64 {
 	// From official documentation
 	//Note: On Linux, the Docker CLI reports memory usage by subtracting page cache usage from the total memory usage.
 	//The API does not perform such a calculation but rather provides the total memory usage and the amount from the page cache so that clients can use the data as needed.
-	memoryUsage := containerStats.MemoryStats.Usage - containerStats.MemoryStats.Stats["cache"]
-	memoryTotal := containerStats.MemoryStats.Limit
+	usage := containerStats.MemoryStats.Usage
+	if cache := containerStats.MemoryStats.Stats["cache"]; cache <= usage {
+		usage -= cache
+	}
+	return usage
+}
+
+func (c *DockerCollector) memoryMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cl containerLabels) {
+	if !hasMemoryStats(containerStats) {
+		return
+	}
 
-	memoryUtilization := float64(memoryUsage) / float64(memoryTotal) * 100.0
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+	memoryUsage := memoryUsageBytes(containerStats)
+	memoryTotal, limited := c.memoryLimit(containerStats)
+
+	var limitSet float64
+	if limited {
+		limitSet = 1
+	}
+
+	ch <- cl.metric(descs.get(
 		"dex_memory_usage_bytes",
-		"Total memory usage bytes",
-		labelCname,
-		nil,
-	), prometheus.CounterValue, float64(memoryUsage), cName)
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+		cl.names,
+	), prometheus.CounterValue, float64(memoryUsage))
+	ch <- cl.metric(descs.get(
 		"dex_memory_total_bytes",
-		"Total memory bytes",
-		labelCname,
-		nil,
-	), prometheus.GaugeValue, float64(memoryTotal), cName)
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_memory_utilization_percent",
-		"Memory utilization percent",
-		labelCname,
-		nil,
-	), prometheus.GaugeValue, memoryUtilization, cName)
-}
-
-func (c *DockerCollector) blockIoMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cName string) {
-	var readTotal, writeTotal uint64
+		cl.names,
+	), prometheus.GaugeValue, float64(memoryTotal))
+	ch <- cl.metric(descs.get(
+		"dex_memory_limit_set",
+		cl.names,
+	), prometheus.GaugeValue, limitSet)
+
+	// utilization only makes sense against a real limit
+	if limitSet == 1 {
+		memoryUtilization := float64(memoryUsage) / float64(memoryTotal) * 100.0
+		ch <- cl.metric(descs.get(
+			"dex_memory_utilization_percent",
+			cl.names,
+		), prometheus.GaugeValue, memoryUtilization)
+		ch <- cl.metric(descs.get(
+			"dex_memory_headroom_bytes",
+			cl.names,
+		), prometheus.GaugeValue, float64(memoryTotal)-float64(memoryUsage))
+	}
+}
+
+// memoryLimit returns the memory limit of a container and whether it has one.
+// Without a memory limit the daemon reports the host memory as limit.
+func (c *DockerCollector) memoryLimit(containerStats *container.StatsResponse) (uint64, bool) {
+	limit := containerStats.MemoryStats.Limit
+	return limit, limit > 0 && !unlimitedMemory(limit) && (c.hostMemory() == 0 || limit < c.hostMemory())
+}
+
+// memoryHeadroom returns the memory limit minus the usage of a container, false
+// if it has no memory limit.
+func (c *DockerCollector) memoryHeadroom(containerStats *container.StatsResponse) (float64, bool) {
+	if !hasMemoryStats(containerStats) {
+		return 0, false
+	}
+	limit, ok := c.memoryLimit(containerStats)
+	if !ok {
+		return 0, false
+	}
+	return float64(limit) - float64(memoryUsageBytes(containerStats)), true
+}
+
+// cpuLimit returns the number of CPUs a container may use from --cpus or
+// --cpu-quota, false if it is not limited.
+func cpuLimit(hostConfig *container.HostConfig) (float64, bool) {
+	if hostConfig == nil {
+		return 0, false
+	}
+	if hostConfig.NanoCPUs > 0 {
+		return float64(hostConfig.NanoCPUs) / 1e9, true
+	}
+	if hostConfig.CPUQuota > 0 {
+		// the kernel default period
+		period := hostConfig.CPUPeriod
+		if period <= 0 {
+			period = 100000
+		}
+		return float64(hostConfig.CPUQuota) / float64(period), true
+	}
+	return 0, false
+}
+
+// headroomMetrics exports the unused CPU cores and memory of a container with
+// limits and adds them to its compose project. cpuCores is the CPU limit, 0 if
+// it is not limited.
+func (c *DockerCollector) headroomMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cpuCores float64, cl containerLabels, projects *projectAggregates, project string) {
+	if cpuUtilization, ok := cpuPercent(containerStats); ok && cpuCores > 0 {
+		headroom := cpuCores - cpuUtilization/100
+		ch <- cl.metric(descs.get(
+			"dex_cpu_headroom_cores",
+			cl.names,
+		), prometheus.GaugeValue, headroom)
+		projects.addCPUHeadroom(project, headroom)
+	}
+
+	// the per-container memory headroom is exported with the memory metrics
+	if headroom, ok := c.memoryHeadroom(containerStats); ok {
+		projects.addMemoryHeadroom(project, headroom)
+	}
+}
+
+// hostMemory returns the total memory of the docker host, or 0 if unknown.
+func (c *DockerCollector) hostMemory() uint64 {
+	c.readHostInfo()
+	return c.hostMemTotal
+}
+
+// hostWraps32 reports whether the docker host is 32-bit, its counters may wrap
+// at 32 bits.
+func (c *DockerCollector) hostWraps32() bool {
+	c.readHostInfo()
+	return c.hostArch32
+}
+
+// readHostInfo reads the memory and architecture of the docker host once.
+func (c *DockerCollector) readHostInfo() {
+	c.hostInfoOnce.Do(func() {
+		if c.cli == nil {
+			return
+		}
+
+		ctx := context.Background()
+		err := c.api.observe(ctx, "info", func() error {
+			info, err := c.cli.Info(ctx)
+			if err != nil {
+				return err
+			}
+			c.hostMemTotal = uint64(info.MemTotal)
+			c.hostArch32 = isArch32(info.Architecture)
+			return nil
+		})
+		if err != nil {
+			log.Error("can't get docker host info: ", err)
+		}
+	})
+}
+
+func (c *DockerCollector) blockIoMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cl containerLabels) {
+	readTotal, writeTotal := blockIoBytes(containerStats)
+
+	ch <- cl.metric(descs.get(
+		"dex_block_io_read_bytes_total",
+		cl.names,
+	), prometheus.CounterValue, c.counters.value(cl.key(), "block_io_read", float64(readTotal)))
+
+	ch <- cl.metric(descs.get(
+		"dex_block_io_write_bytes_total",
+		cl.names,
+	), prometheus.CounterValue, c.counters.value(cl.key(), "block_io_write", float64(writeTotal)))
+
+	// only cgroup v1 with the CFQ scheduler reports wait times and queues
+	if waitTime, ok := blkioTotal(containerStats.BlkioStats.IoWaitTimeRecursive); ok {
+		ch <- cl.metric(descs.get(
+			"dex_block_io_wait_seconds_total",
+			cl.names,
+		), prometheus.CounterValue, c.counters.value(cl.key(), "block_io_wait", waitTime/1e9))
+	}
+
+	if queued, ok := blkioTotal(containerStats.BlkioStats.IoQueuedRecursive); ok {
+		ch <- cl.metric(descs.get(
+			"dex_block_io_queued_operations",
+			cl.names,
+		), prometheus.GaugeValue, queued)
+	}
+}
+
+// blockIoBytes returns the bytes read and written by the container on all
+// devices.
+func blockIoBytes(containerStats *container.StatsResponse) (read, write uint64) {
 	for _, b := range containerStats.BlkioStats.IoServiceBytesRecursive {
 		if strings.EqualFold(b.Op, "read") {
-			readTotal += b.Value
+			read += b.Value
 		}
 		if strings.EqualFold(b.Op, "write") {
-			writeTotal += b.Value
+			write += b.Value
 		}
 	}
+	return read, write
+}
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_block_io_read_bytes_total",
-		"Block I/O read bytes",
-		labelCname,
-		nil,
-	), prometheus.CounterValue, float64(readTotal), cName)
+// blkioTotal sums the "Total" entries of all devices, false if there are none.
+func blkioTotal(entries []container.BlkioStatEntry) (float64, bool) {
+	var total float64
+	found := false
+	for _, e := range entries {
+		if strings.EqualFold(e.Op, "total") {
+			total += float64(e.Value)
+			found = true
+		}
+	}
+	return total, found
+}
 
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
-		"dex_block_io_write_bytes_total",
-		"Block I/O write bytes",
-		labelCname,
-		nil,
-	), prometheus.CounterValue, float64(writeTotal), cName)
+func (c *DockerCollector) processMetrics(ch chan<- prometheus.Metric, pid int, cl containerLabels) {
+	counts, err := readProcessCounts(c.procPath, c.cgroupPath, pid)
+	if err != nil {
+		log.Errorf("can't count processes of container '%s': %v", cl.values[0], err)
+		return
+	}
+
+	ch <- cl.metric(descs.get(
+		"dex_container_zombie_processes",
+		cl.names,
+	), prometheus.GaugeValue, counts.zombies)
+
+	ch <- cl.metric(descs.get(
+		"dex_container_threads",
+		cl.names,
+	), prometheus.GaugeValue, counts.threads)
+}
+
+// ulimitMetrics exports the ulimits configured for the container, the
+// defaults of the daemon aren't visible in the API.
+func ulimitMetrics(ch chan<- prometheus.Metric, ulimits []*container.Ulimit, cl containerLabels) {
+	for _, ulimit := range ulimits {
+		for limitType, value := range map[string]int64{"soft": ulimit.Soft, "hard": ulimit.Hard} {
+			limit := float64(value)
+			if value < 0 {
+				limit = math.Inf(1)
+			}
+
+			ucl := cl.with("name", ulimit.Name).with("type", limitType)
+			ch <- ucl.metric(descs.get(
+				"dex_container_ulimit",
+				ucl.names,
+			), prometheus.GaugeValue, limit)
+		}
+	}
+}
+
+// stateChangedAt returns when the container last started or stopped, or its
+// creation time if it never ran. Unlike the for clause of alert rules it
+// survives restarts of dex.
+func stateChangedAt(inspect container.InspectResponse) (time.Time, bool) {
+	var changed time.Time
+	if inspect.ContainerJSONBase == nil {
+		return changed, false
+	}
+	if created, err := time.Parse(time.RFC3339Nano, inspect.Created); err == nil {
+		changed = created
+	}
+	if inspect.State != nil {
+		// never started or stopped containers have 0001-01-01T00:00:00Z
+		for _, timestamp := range []string{inspect.State.StartedAt, inspect.State.FinishedAt} {
+			if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil && t.After(changed) {
+				changed = t
+			}
+		}
+	}
+	return changed, !changed.IsZero()
+}
+
+// cpusetMetrics exports the CPUs and memory nodes a container is pinned to,
+// nothing for containers without pinning.
+func cpusetMetrics(ch chan<- prometheus.Metric, hostConfig *container.HostConfig, cl containerLabels) {
+	if hostConfig.CpusetCpus == "" && hostConfig.CpusetMems == "" {
+		return
+	}
+
+	if hostConfig.CpusetCpus != "" {
+		if cpus, err := countCPUList(hostConfig.CpusetCpus); err != nil {
+			log.Warnf("can't parse cpuset '%s' of container '%s': %v", hostConfig.CpusetCpus, cl.values[0], err)
+		} else {
+			ch <- cl.metric(descs.get(
+				"dex_container_cpuset_cpus",
+				cl.names,
+			), prometheus.GaugeValue, float64(cpus))
+		}
+	}
+
+	info := cl.with("cpus", hostConfig.CpusetCpus).with("mems", hostConfig.CpusetMems)
+	ch <- info.metric(descs.get(
+		"dex_container_cpuset_info",
+		info.names,
+	), prometheus.GaugeValue, 1)
+}
+
+// blkioThrottleMetrics exports the configured I/O limits of the container by
+// device, nothing for devices without limits.
+func blkioThrottleMetrics(ch chan<- prometheus.Metric, hostConfig *container.HostConfig, cl containerLabels) {
+	for _, limit := range []struct {
+		name    string
+		devices []*blkiodev.ThrottleDevice
+	}{
+		{"dex_block_io_read_bytes_per_second_limit", hostConfig.BlkioDeviceReadBps},
+		{"dex_block_io_write_bytes_per_second_limit", hostConfig.BlkioDeviceWriteBps},
+		{"dex_block_io_read_iops_limit", hostConfig.BlkioDeviceReadIOps},
+		{"dex_block_io_write_iops_limit", hostConfig.BlkioDeviceWriteIOps},
+	} {
+		for _, device := range limit.devices {
+			dcl := cl.with("device", device.Path)
+			ch <- dcl.metric(descs.get(limit.name, dcl.names), prometheus.GaugeValue, float64(device.Rate))
+		}
+	}
+}
+
+// logDriverMetrics exports the logging driver of the container with its
+// rotation options, empty if the driver has none or uses its defaults.
+func logDriverMetrics(ch chan<- prometheus.Metric, logConfig container.LogConfig, cl containerLabels) {
+	info := cl.with("driver", logConfig.Type).
+		with("max_size", logConfig.Config["max-size"]).
+		with("max_file", logConfig.Config["max-file"])
+	ch <- info.metric(descs.get(
+		"dex_container_log_driver",
+		info.names,
+	), prometheus.GaugeValue, 1)
+}
+
+// mountTypes are always counted, so missing series mean the container wasn't
+// inspected.
+var mountTypes = []mount.Type{mount.TypeBind, mount.TypeVolume, mount.TypeTmpfs}
+
+// mountMetrics exports the number of mounts by type and a series per mount, to
+// alert on sensitive host paths like /var/run/docker.sock.
+func mountMetrics(ch chan<- prometheus.Metric, mounts []container.MountPoint, cl containerLabels) {
+	counts := map[mount.Type]int{}
+	for _, mountType := range mountTypes {
+		counts[mountType] = 0
+	}
+
+	for _, m := range mounts {
+		counts[m.Type]++
+
+		info := cl.with("type", string(m.Type)).
+			with("source", m.Source).
+			with("destination", m.Destination).
+			with("rw", strconv.FormatBool(m.RW))
+		ch <- info.metric(descs.get(
+			"dex_container_mount_info",
+			info.names,
+		), prometheus.GaugeValue, 1)
+	}
+
+	for mountType, count := range counts {
+		tcl := cl.with("type", string(mountType))
+		ch <- tcl.metric(descs.get(
+			"dex_container_mounts",
+			tcl.names,
+		), prometheus.GaugeValue, float64(count))
+	}
+}
+
+// userMetrics exports the user the container runs as, set by --user or the
+// USER of the image.
+func userMetrics(ch chan<- prometheus.Metric, user string, cl containerLabels) {
+	var root float64
+	if isRootUser(user) {
+		root = 1
+	}
+	ch <- cl.metric(descs.get(
+		"dex_container_running_as_root",
+		cl.names,
+	), prometheus.GaugeValue, root)
+
+	info := cl.with("user", user)
+	ch <- info.metric(descs.get(
+		"dex_container_user_info",
+		info.names,
+	), prometheus.GaugeValue, 1)
+}
+
+// isRootUser returns true for a user like "", "root" or "0:1000". Other named
+// users can't be resolved without the passwd of the image and count as
+// unprivileged. User namespace remapping is not considered.
+func isRootUser(user string) bool {
+	name, _, _ := strings.Cut(user, ":")
+	return name == "" || name == "root" || name == "0"
+}
+
+// envAuditMetrics exports whether each of the names is set in the environment
+// of the container. The values are never read.
+func envAuditMetrics(ch chan<- prometheus.Metric, names []string, env []string, cl containerLabels) {
+	set := map[string]bool{}
+	for _, variable := range env {
+		name, _, _ := strings.Cut(variable, "=")
+		set[name] = true
+	}
+
+	for _, name := range names {
+		var present float64
+		if set[name] {
+			present = 1
+		}
+		vcl := cl.with("var", name)
+		ch <- vcl.metric(descs.get(
+			"dex_container_env_present",
+			vcl.names,
+		), prometheus.GaugeValue, present)
+	}
+}
+
+// countCPUList returns the number of CPUs in a list like "0-3,8".
+func countCPUList(list string) (int, error) {
+	var count int
+	for _, part := range strings.Split(list, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			return 0, fmt.Errorf("invalid CPU '%s'", part)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(last); err != nil || to < from {
+				return 0, fmt.Errorf("invalid CPU range '%s'", part)
+			}
+		}
+		count += to - from + 1
+	}
+	return count, nil
+}
+
+var exitedStatusRe = regexp.MustCompile(`^Exited \((-?\d+)\)`)
+
+// statusExitCode returns the exit code of an exited container from its listed
+// status, e.g. "Exited (137) 5 minutes ago".
+func statusExitCode(status string) (int, bool) {
+	m := exitedStatusRe.FindStringSubmatch(status)
+	if m == nil {
+		return 0, false
+	}
+	exitCode, err := strconv.Atoi(m[1])
+	return exitCode, err == nil
+}
+
+// exitCodeMetrics exports the exit code of an exited container, so abnormal
+// exits can be alerted on without events.
+func exitCodeMetrics(ch chan<- prometheus.Metric, exitCode int, cl containerLabels) {
+	var failed float64
+	if exitCode != 0 {
+		failed = 1
+	}
+
+	ch <- cl.metric(descs.get(
+		"dex_container_exit_code",
+		cl.names,
+	), prometheus.GaugeValue, float64(exitCode))
+
+	ch <- cl.metric(descs.get(
+		"dex_container_failed",
+		cl.names,
+	), prometheus.GaugeValue, failed)
+}
+
+func (c *DockerCollector) numaMemoryMetrics(ch chan<- prometheus.Metric, pid int, cl containerLabels) {
+	memory, err := readNUMAMemory(c.procPath, c.cgroupPath, pid)
+	if err != nil {
+		log.Errorf("can't read NUMA memory of container '%s': %v", cl.values[0], err)
+		return
+	}
+
+	for _, m := range memory {
+		ncl := cl.with("node", m.node).with("type", m.memType)
+		ch <- ncl.metric(descs.get(
+			"dex_container_memory_numa_bytes",
+			ncl.names,
+		), prometheus.GaugeValue, m.bytes)
+	}
+}
+
+func (c *DockerCollector) tmpfsUsageMetrics(ch chan<- prometheus.Metric, pid int, cl containerLabels) {
+	usages, err := readTmpfsUsage(c.procPath, pid)
+	if err != nil {
+		log.Errorf("can't read tmpfs mounts of container '%s': %v", cl.values[0], err)
+		return
+	}
+
+	for _, usage := range usages {
+		mcl := cl.with("mountpoint", usage.mountpoint)
+
+		ch <- mcl.metric(descs.get(
+			"dex_container_tmpfs_used_bytes",
+			mcl.names,
+		), prometheus.GaugeValue, usage.used)
+
+		ch <- mcl.metric(descs.get(
+			"dex_container_tmpfs_size_bytes",
+			mcl.names,
+		), prometheus.GaugeValue, usage.size)
+	}
+}
+
+func (c *DockerCollector) rootfsMetrics(ch chan<- prometheus.Metric, upperDir string, cl containerLabels) {
+	used, free, err := rootfsInodes(upperDir)
+	if err != nil {
+		log.Errorf("can't count inodes of container '%s': %v", cl.values[0], err)
+		return
+	}
+
+	ch <- cl.metric(descs.get(
+		"dex_container_rootfs_inodes_used",
+		cl.names,
+	), prometheus.GaugeValue, used)
+
+	ch <- cl.metric(descs.get(
+		"dex_container_rootfs_inodes_free",
+		cl.names,
+	), prometheus.GaugeValue, free)
 }
 
-func (c *DockerCollector) pidsMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cName string) {
-	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+func (c *DockerCollector) pidsMetrics(ch chan<- prometheus.Metric, containerStats *container.StatsResponse, cl containerLabels) {
+	ch <- cl.metric(descs.get(
 		"dex_pids_current",
-		"Current number of pids in the cgroup",
-		labelCname,
-		nil,
-	), prometheus.CounterValue, float64(containerStats.PidsStats.Current), cName)
+		cl.names,
+	), prometheus.CounterValue, float64(containerStats.PidsStats.Current))
 }
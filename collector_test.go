@@ -1,11 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/docker/docker/api/types/blkiodev"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -32,7 +45,7 @@ func TestCPUMetrics(t *testing.T) {
 
 	ch := make(chan prometheus.Metric, 2) // Expecting 2 metrics
 
-	c.CPUMetrics(ch, stats, containerName)
+	c.CPUMetrics(ch, stats, newContainerLabels(containerName))
 	close(ch)
 
 	var metrics []prometheus.Metric
@@ -86,7 +99,7 @@ func TestNetworkMetrics(t *testing.T) {
 	}
 
 	ch := make(chan prometheus.Metric, 2)
-	c.networkMetrics(ch, stats, containerName)
+	c.networkMetrics(ch, stats, newContainerLabels(containerName))
 	close(ch)
 
 	var metrics []prometheus.Metric
@@ -140,8 +153,8 @@ func TestMemoryMetrics(t *testing.T) {
 		},
 	}
 
-	ch := make(chan prometheus.Metric, 3)
-	c.memoryMetrics(ch, stats, containerName)
+	ch := make(chan prometheus.Metric, 5)
+	c.memoryMetrics(ch, stats, newContainerLabels(containerName))
 	close(ch)
 
 	var metrics []prometheus.Metric
@@ -149,7 +162,7 @@ func TestMemoryMetrics(t *testing.T) {
 		metrics = append(metrics, metric)
 	}
 
-	assert.Len(t, metrics, 3, "Expected 3 memory metrics")
+	assert.Len(t, metrics, 5, "Expected 5 memory metrics")
 
 	expectedMemoryUsageBytes := float64(600 * 1024 * 1024)
 	expectedMemoryTotalBytes := float64(1024 * 1024 * 1024)
@@ -157,7 +170,9 @@ func TestMemoryMetrics(t *testing.T) {
 
 	foundUsageBytes := false
 	foundTotalBytes := false
+	foundLimitSet := false
 	foundUtilizationPercent := false
+	foundHeadroom := false
 
 	for _, m := range metrics {
 		desc := m.Desc().String()
@@ -177,17 +192,144 @@ func TestMemoryMetrics(t *testing.T) {
 			val := *pbMetric.Gauge.Value
 			assert.Equal(t, expectedMemoryTotalBytes, val, "Unexpected dex_memory_total_bytes value")
 		}
+		if strings.Contains(desc, "dex_memory_limit_set") {
+			foundLimitSet = true
+			require.NotNil(t, pbMetric.Gauge, "Gauge should not be nil for dex_memory_limit_set")
+			assert.Equal(t, 1.0, *pbMetric.Gauge.Value, "Unexpected dex_memory_limit_set value")
+		}
 		if strings.Contains(desc, "dex_memory_utilization_percent") {
 			foundUtilizationPercent = true
 			require.NotNil(t, pbMetric.Gauge, "Gauge should not be nil for dex_memory_utilization_percent")
 			val := *pbMetric.Gauge.Value
 			assert.InDelta(t, expectedMemoryUtilizationPercent, val, 0.001, "Unexpected dex_memory_utilization_percent value")
 		}
+		if strings.Contains(desc, "dex_memory_headroom_bytes") {
+			foundHeadroom = true
+			require.NotNil(t, pbMetric.Gauge, "Gauge should not be nil for dex_memory_headroom_bytes")
+			assert.Equal(t, expectedMemoryTotalBytes-expectedMemoryUsageBytes, *pbMetric.Gauge.Value, "Unexpected dex_memory_headroom_bytes value")
+		}
 	}
 
 	assert.True(t, foundUsageBytes, "Metric dex_memory_usage_bytes not found")
 	assert.True(t, foundTotalBytes, "Metric dex_memory_total_bytes not found")
+	assert.True(t, foundLimitSet, "Metric dex_memory_limit_set not found")
 	assert.True(t, foundUtilizationPercent, "Metric dex_memory_utilization_percent not found")
+	assert.True(t, foundHeadroom, "Metric dex_memory_headroom_bytes not found")
+}
+
+func TestMemoryMetricsWithoutLimit(t *testing.T) {
+	c := &DockerCollector{hostMemTotal: 8 * 1024 * 1024 * 1024}
+
+	stats := &container.StatsResponse{
+		MemoryStats: container.MemoryStats{
+			Usage: 100 * 1024 * 1024,
+			Limit: 8 * 1024 * 1024 * 1024, // host memory, no limit configured
+			Stats: map[string]uint64{
+				"cache": 200 * 1024 * 1024, // larger than usage, must not underflow
+			},
+		},
+	}
+
+	ch := make(chan prometheus.Metric, 4)
+	c.memoryMetrics(ch, stats, newContainerLabels("unlimited"))
+	close(ch)
+
+	var metrics []prometheus.Metric
+	for metric := range ch {
+		metrics = append(metrics, metric)
+	}
+
+	assert.Len(t, metrics, 3, "Utilization should not be exported without a limit")
+
+	for _, m := range metrics {
+		desc := m.Desc().String()
+		pbMetric := &dto.Metric{}
+		err := m.Write(pbMetric)
+		require.NoError(t, err, "Failed to write metric to protobuf")
+
+		assert.NotContains(t, desc, "dex_memory_utilization_percent", "Utilization should not be exported without a limit")
+		assert.NotContains(t, desc, "dex_memory_headroom_bytes", "Headroom should not be exported without a limit")
+		if strings.Contains(desc, "dex_memory_limit_set") {
+			assert.Equal(t, 0.0, *pbMetric.Gauge.Value, "Limit equal to host memory means no limit")
+		}
+		if strings.Contains(desc, "dex_memory_usage_bytes") {
+			assert.Equal(t, float64(100*1024*1024), *pbMetric.Counter.Value, "Usage should not underflow")
+		}
+	}
+}
+
+func TestCPULimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		hostConfig *container.HostConfig
+		cores      float64
+		ok         bool
+	}{
+		{"nil", nil, 0, false},
+		{"unlimited", &container.HostConfig{}, 0, false},
+		{"cpus", &container.HostConfig{Resources: container.Resources{NanoCPUs: 1500000000}}, 1.5, true},
+		{"quota", &container.HostConfig{Resources: container.Resources{CPUQuota: 50000, CPUPeriod: 100000}}, 0.5, true},
+		{"quota with default period", &container.HostConfig{Resources: container.Resources{CPUQuota: 200000}}, 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cores, ok := cpuLimit(tt.hostConfig)
+			assert.Equal(t, tt.ok, ok)
+			assert.InDelta(t, tt.cores, cores, 0.001)
+		})
+	}
+}
+
+func TestHeadroomMetrics(t *testing.T) {
+	c := &DockerCollector{hostMemTotal: 8 * 1024 * 1024 * 1024}
+	projects := newProjectAggregates(true)
+
+	// 50% of a CPU and 300 MiB of a 1 GiB limit
+	stats := &container.StatsResponse{
+		CPUStats: container.CPUStats{
+			CPUUsage:    container.CPUUsage{TotalUsage: 1000000000},
+			SystemUsage: 20000000000,
+			OnlineCPUs:  2,
+		},
+		PreCPUStats: container.CPUStats{
+			CPUUsage:    container.CPUUsage{TotalUsage: 500000000},
+			SystemUsage: 18000000000,
+		},
+		MemoryStats: container.MemoryStats{
+			Usage: 300 * 1024 * 1024,
+			Limit: 1024 * 1024 * 1024,
+		},
+	}
+
+	ch := make(chan prometheus.Metric, 1)
+	c.headroomMetrics(ch, stats, 2, newContainerLabels("limited"), projects, "shop")
+	close(ch)
+
+	metric := <-ch
+	assert.Contains(t, metric.Desc().String(), "dex_cpu_headroom_cores")
+	pbMetric := &dto.Metric{}
+	require.NoError(t, metric.Write(pbMetric))
+	assert.InDelta(t, 1.5, pbMetric.GetGauge().GetValue(), 0.001)
+
+	expected := `
+# HELP dex_compose_project_cpu_headroom_cores CPU cores the running containers of the compose project with a CPU limit don't use
+# TYPE dex_compose_project_cpu_headroom_cores gauge
+dex_compose_project_cpu_headroom_cores{compose_project="shop"} 1.5
+# HELP dex_compose_project_memory_headroom_bytes Memory bytes the running containers of the compose project with a memory limit don't use
+# TYPE dex_compose_project_memory_headroom_bytes gauge
+dex_compose_project_memory_headroom_bytes{compose_project="shop"} 7.59169024e+08
+`
+	assert.NoError(t, testutil.CollectAndCompare(projects, strings.NewReader(expected),
+		"dex_compose_project_cpu_headroom_cores", "dex_compose_project_memory_headroom_bytes"))
+
+	// without limits nothing is exported
+	ch = make(chan prometheus.Metric, 1)
+	stats.MemoryStats.Limit = c.hostMemTotal
+	c.headroomMetrics(ch, stats, 0, newContainerLabels("unlimited"), projects, "blog")
+	close(ch)
+	assert.Empty(t, ch)
+	assert.Equal(t, 2, testutil.CollectAndCount(projects, "dex_compose_project_cpu_headroom_cores", "dex_compose_project_memory_headroom_bytes"))
 }
 
 func TestBlockIoMetrics(t *testing.T) {
@@ -207,7 +349,7 @@ func TestBlockIoMetrics(t *testing.T) {
 	}
 
 	ch := make(chan prometheus.Metric, 2)
-	c.blockIoMetrics(ch, stats, containerName)
+	c.blockIoMetrics(ch, stats, newContainerLabels(containerName))
 	close(ch)
 
 	var metrics []prometheus.Metric
@@ -247,6 +389,44 @@ func TestBlockIoMetrics(t *testing.T) {
 	assert.True(t, foundWriteBytes, "Metric dex_block_io_write_bytes_total not found")
 }
 
+func TestBlockIoWaitMetrics(t *testing.T) {
+	c := &DockerCollector{}
+
+	stats := &container.StatsResponse{
+		BlkioStats: container.BlkioStats{
+			IoWaitTimeRecursive: []container.BlkioStatEntry{
+				{Major: 8, Op: "Read", Value: 1_000_000_000},
+				{Major: 8, Op: "Total", Value: 1_500_000_000},
+				{Major: 9, Op: "Total", Value: 500_000_000},
+			},
+			IoQueuedRecursive: []container.BlkioStatEntry{
+				{Major: 8, Op: "Total", Value: 3},
+				{Major: 9, Op: "Total", Value: 1},
+			},
+		},
+	}
+
+	ch := make(chan prometheus.Metric, 4)
+	c.blockIoMetrics(ch, stats, newContainerLabels("db"))
+	close(ch)
+
+	values := map[string]float64{}
+	for m := range ch {
+		pbMetric := &dto.Metric{}
+		require.NoError(t, m.Write(pbMetric))
+		name := strings.Split(strings.Split(m.Desc().String(), `fqName: "`)[1], `"`)[0]
+		values[name] = pbMetric.GetCounter().GetValue() + pbMetric.GetGauge().GetValue()
+	}
+	assert.Equal(t, 2.0, values["dex_block_io_wait_seconds_total"])
+	assert.Equal(t, 4.0, values["dex_block_io_queued_operations"])
+
+	// cgroup v2 reports neither
+	ch = make(chan prometheus.Metric, 4)
+	c.blockIoMetrics(ch, &container.StatsResponse{}, newContainerLabels("db"))
+	close(ch)
+	assert.Len(t, ch, 2)
+}
+
 func TestPidsMetrics(t *testing.T) {
 	c := &DockerCollector{}
 	containerName := "test-pids-container"
@@ -258,7 +438,7 @@ func TestPidsMetrics(t *testing.T) {
 	}
 
 	ch := make(chan prometheus.Metric, 1) // Expecting 1 metric
-	c.pidsMetrics(ch, stats, containerName)
+	c.pidsMetrics(ch, stats, newContainerLabels(containerName))
 	close(ch)
 
 	var metrics []prometheus.Metric
@@ -287,3 +467,634 @@ func TestPidsMetrics(t *testing.T) {
 
 	assert.True(t, foundPidsCurrent, "Metric dex_pids_current not found")
 }
+
+func TestDescCache(t *testing.T) {
+	cache := &descCache{}
+	desc := cache.get("dex_container_running", []string{"container_name"})
+
+	assert.Same(t, desc, cache.get("dex_container_running", []string{"container_name"}), "Samples of a family should share the Desc")
+	assert.NotSame(t, desc, cache.get("dex_container_running", []string{"container_name", "container_id"}))
+}
+
+type metricsCollector []prometheus.Metric
+
+func (c metricsCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c {
+		ch <- m
+	}
+}
+
+func TestContainerMetric(t *testing.T) {
+	cl := newContainerLabels("web").with("container_id", "3f1e2d4c5b6a")
+	running := cl.metric(descs.get("dex_container_running", cl.names), prometheus.GaugeValue, 1)
+	restarts := cl.metric(descs.get("dex_container_restarts_total", cl.names), prometheus.CounterValue, 3)
+
+	reg := prometheus.NewPedanticRegistry()
+	prometheus.WrapRegistererWith(prometheus.Labels{"docker_host": "tcp://10.0.0.2:2375"}, reg).MustRegister(metricsCollector{running, restarts})
+
+	expected := `
+# HELP dex_container_restarts_total Number of times the container has restarted
+# TYPE dex_container_restarts_total counter
+dex_container_restarts_total{container_id="3f1e2d4c5b6a",container_name="web",docker_host="tcp://10.0.0.2:2375"} 3
+# HELP dex_container_running 1 if docker container is running, 0 otherwise
+# TYPE dex_container_running gauge
+dex_container_running{container_id="3f1e2d4c5b6a",container_name="web",docker_host="tcp://10.0.0.2:2375"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected)))
+	assert.Len(t, cl.labelPairs(), 2, "Wrapping labels must not modify the shared label pairs")
+}
+
+func TestUlimitMetrics(t *testing.T) {
+	ulimits := []*container.Ulimit{
+		{Name: "nofile", Soft: 1024, Hard: 65536},
+		{Name: "memlock", Soft: -1, Hard: -1},
+	}
+
+	ch := make(chan prometheus.Metric, 4)
+	ulimitMetrics(ch, ulimits, newContainerLabels("web"))
+	assert.ElementsMatch(t, []map[string]string{
+		{"container_name": "web", "name": "nofile", "type": "soft", "value": "1024"},
+		{"container_name": "web", "name": "nofile", "type": "hard", "value": "65536"},
+		{"container_name": "web", "name": "memlock", "type": "soft", "value": "+Inf"},
+		{"container_name": "web", "name": "memlock", "type": "hard", "value": "+Inf"},
+	}, collectLabels(t, ch))
+}
+
+func TestCountCPUList(t *testing.T) {
+	for _, tt := range []struct {
+		list  string
+		count int
+		err   bool
+	}{
+		{list: "0", count: 1},
+		{list: "0-3", count: 4},
+		{list: "0-3,8,10-11", count: 7},
+		{list: "3-1", err: true},
+		{list: "a", err: true},
+		{list: "1,", err: true},
+	} {
+		count, err := countCPUList(tt.list)
+		assert.Equal(t, tt.err, err != nil, tt.list)
+		assert.Equal(t, tt.count, count, tt.list)
+	}
+}
+
+func TestStateChangedAt(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		inspect  container.InspectResponse
+		expected string
+	}{
+		{"created", container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{
+			Created: "2024-05-01T10:00:00Z",
+			State:   &container.State{Status: "created", StartedAt: "0001-01-01T00:00:00Z", FinishedAt: "0001-01-01T00:00:00Z"},
+		}}, "2024-05-01T10:00:00Z"},
+		{"running after a restart", container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{
+			Created: "2024-05-01T10:00:00Z",
+			State:   &container.State{Status: "running", StartedAt: "2024-05-01T12:00:00.5Z", FinishedAt: "2024-05-01T11:59:59Z"},
+		}}, "2024-05-01T12:00:00.5Z"},
+		{"exited", container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{
+			Created: "2024-05-01T10:00:00Z",
+			State:   &container.State{Status: "exited", StartedAt: "2024-05-01T12:00:00Z", FinishedAt: "2024-05-01T13:00:00Z"},
+		}}, "2024-05-01T13:00:00Z"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, ok := stateChangedAt(tt.inspect)
+			assert.True(t, ok)
+			assert.Equal(t, tt.expected, changed.UTC().Format(time.RFC3339Nano))
+		})
+	}
+
+	_, ok := stateChangedAt(container.InspectResponse{})
+	assert.False(t, ok)
+}
+
+func TestCpusetMetrics(t *testing.T) {
+	ch := make(chan prometheus.Metric, 2)
+	cpusetMetrics(ch, &container.HostConfig{Resources: container.Resources{CpusetCpus: "0-3", CpusetMems: "0"}}, newContainerLabels("db"))
+	assert.ElementsMatch(t, []map[string]string{
+		{"container_name": "db", "value": "4"},
+		{"container_name": "db", "cpus": "0-3", "mems": "0", "value": "1"},
+	}, collectLabels(t, ch))
+
+	// memory pinning only
+	ch = make(chan prometheus.Metric, 2)
+	cpusetMetrics(ch, &container.HostConfig{Resources: container.Resources{CpusetMems: "1"}}, newContainerLabels("db"))
+	assert.ElementsMatch(t, []map[string]string{
+		{"container_name": "db", "cpus": "", "mems": "1", "value": "1"},
+	}, collectLabels(t, ch))
+
+	ch = make(chan prometheus.Metric, 2)
+	cpusetMetrics(ch, &container.HostConfig{}, newContainerLabels("web"))
+	assert.Empty(t, collectLabels(t, ch))
+}
+
+func TestBlkioThrottleMetrics(t *testing.T) {
+	hostConfig := &container.HostConfig{Resources: container.Resources{
+		BlkioDeviceReadBps:   []*blkiodev.ThrottleDevice{{Path: "/dev/sda", Rate: 10485760}},
+		BlkioDeviceWriteBps:  []*blkiodev.ThrottleDevice{{Path: "/dev/sda", Rate: 5242880}, {Path: "/dev/sdb", Rate: 1048576}},
+		BlkioDeviceWriteIOps: []*blkiodev.ThrottleDevice{{Path: "/dev/sda", Rate: 100}},
+	}}
+
+	ch := make(chan prometheus.Metric, 4)
+	blkioThrottleMetrics(ch, hostConfig, newContainerLabels("db"))
+	assert.ElementsMatch(t, []map[string]string{
+		{"container_name": "db", "device": "/dev/sda", "value": "1.048576e+07"},
+		{"container_name": "db", "device": "/dev/sda", "value": "5.24288e+06"},
+		{"container_name": "db", "device": "/dev/sdb", "value": "1.048576e+06"},
+		{"container_name": "db", "device": "/dev/sda", "value": "100"},
+	}, collectLabels(t, ch))
+
+	ch = make(chan prometheus.Metric)
+	blkioThrottleMetrics(ch, &container.HostConfig{}, newContainerLabels("web"))
+	assert.Empty(t, collectLabels(t, ch))
+}
+
+func TestLogDriverMetrics(t *testing.T) {
+	ch := make(chan prometheus.Metric, 2)
+	logDriverMetrics(ch, container.LogConfig{Type: "json-file", Config: map[string]string{"max-size": "10m", "max-file": "3", "compress": "true"}}, newContainerLabels("web"))
+	logDriverMetrics(ch, container.LogConfig{Type: "journald"}, newContainerLabels("db"))
+	assert.ElementsMatch(t, []map[string]string{
+		{"container_name": "web", "driver": "json-file", "max_size": "10m", "max_file": "3", "value": "1"},
+		{"container_name": "db", "driver": "journald", "max_size": "", "max_file": "", "value": "1"},
+	}, collectLabels(t, ch))
+}
+
+func TestMountMetrics(t *testing.T) {
+	mounts := []container.MountPoint{
+		{Type: mount.TypeBind, Source: "/var/run/docker.sock", Destination: "/var/run/docker.sock", RW: false},
+		{Type: mount.TypeVolume, Name: "data", Source: "/var/lib/docker/volumes/data/_data", Destination: "/data", RW: true},
+	}
+
+	ch := make(chan prometheus.Metric, 5)
+	mountMetrics(ch, mounts, newContainerLabels("agent"))
+	assert.ElementsMatch(t, []map[string]string{
+		{"container_name": "agent", "type": "bind", "source": "/var/run/docker.sock", "destination": "/var/run/docker.sock", "rw": "false", "value": "1"},
+		{"container_name": "agent", "type": "volume", "source": "/var/lib/docker/volumes/data/_data", "destination": "/data", "rw": "true", "value": "1"},
+		{"container_name": "agent", "type": "bind", "value": "1"},
+		{"container_name": "agent", "type": "volume", "value": "1"},
+		{"container_name": "agent", "type": "tmpfs", "value": "0"},
+	}, collectLabels(t, ch))
+}
+
+func TestUserMetrics(t *testing.T) {
+	for _, tt := range []struct {
+		user string
+		root string
+	}{
+		{user: "", root: "1"},
+		{user: "root", root: "1"},
+		{user: "0:0", root: "1"},
+		{user: "1000", root: "0"},
+		{user: "nobody:nogroup", root: "0"},
+	} {
+		ch := make(chan prometheus.Metric, 2)
+		userMetrics(ch, tt.user, newContainerLabels("app"))
+		assert.ElementsMatch(t, []map[string]string{
+			{"container_name": "app", "value": tt.root},
+			{"container_name": "app", "user": tt.user, "value": "1"},
+		}, collectLabels(t, ch), tt.user)
+	}
+}
+
+func TestEnvAuditMetrics(t *testing.T) {
+	ch := make(chan prometheus.Metric, 3)
+	envAuditMetrics(ch, []string{"JAVA_OPTS", "TZ", "PATH"}, []string{"PATH=/usr/bin", "JAVA_OPTS=-Xmx512m", "EMPTY="}, newContainerLabels("app"))
+	assert.ElementsMatch(t, []map[string]string{
+		{"container_name": "app", "var": "JAVA_OPTS", "value": "1"},
+		{"container_name": "app", "var": "TZ", "value": "0"},
+		{"container_name": "app", "var": "PATH", "value": "1"},
+	}, collectLabels(t, ch))
+}
+
+func TestExitCodeMetrics(t *testing.T) {
+	for _, tt := range []struct {
+		exitCode int
+		failed   float64
+	}{
+		{exitCode: 0, failed: 0},
+		{exitCode: 137, failed: 1},
+	} {
+		ch := make(chan prometheus.Metric, 2)
+		exitCodeMetrics(ch, tt.exitCode, newContainerLabels("job"))
+		close(ch)
+
+		values := map[string]float64{}
+		for m := range ch {
+			pbMetric := &dto.Metric{}
+			require.NoError(t, m.Write(pbMetric), "Failed to write metric to protobuf")
+			if strings.Contains(m.Desc().String(), `"dex_container_failed"`) {
+				values["failed"] = pbMetric.GetGauge().GetValue()
+			} else {
+				values["exit_code"] = pbMetric.GetGauge().GetValue()
+			}
+		}
+		assert.Equal(t, map[string]float64{"exit_code": float64(tt.exitCode), "failed": tt.failed}, values)
+	}
+}
+
+func loadStatsFixture(t testing.TB, name string) *container.StatsResponse {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "stats", name))
+	require.NoError(t, err, "Failed to read stats fixture")
+
+	var stats container.StatsResponse
+	require.NoError(t, json.Unmarshal(data, &stats), "Failed to decode stats fixture")
+
+	return &stats
+}
+
+func TestCPUPercentFixtures(t *testing.T) {
+	tests := []struct {
+		fixture         string
+		expectedPercent float64
+		expectedOk      bool
+	}{
+		{"cgroupv2_running.json", 100.0, true}, // 1s of CPU time in 1s on 4 online CPUs
+		{"cgroupv1_percpu.json", 50.0, true},   // online CPUs taken from percpu_usage length
+		{"fresh_container.json", 0, false},     // no previous CPU stats
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			percent, ok := cpuPercent(loadStatsFixture(t, tt.fixture))
+			assert.Equal(t, tt.expectedOk, ok, "Unexpected sample validity")
+			assert.InDelta(t, tt.expectedPercent, percent, 0.001, "Unexpected CPU percent")
+		})
+	}
+}
+
+func TestCPUPercentInvalidSamples(t *testing.T) {
+	stats := &container.StatsResponse{
+		CPUStats:    container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 100}, SystemUsage: 1000, OnlineCPUs: 2},
+		PreCPUStats: container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 50}, SystemUsage: 1000, OnlineCPUs: 2},
+	}
+	_, ok := cpuPercent(stats)
+	assert.False(t, ok, "Zero system delta should be skipped")
+
+	stats.CPUStats.SystemUsage = 1100
+	stats.CPUStats.CPUUsage.TotalUsage = 10
+	_, ok = cpuPercent(stats)
+	assert.False(t, ok, "Decreasing CPU usage should be skipped")
+
+	stats.CPUStats.CPUUsage.TotalUsage = 1000
+	percent, ok := cpuPercent(stats)
+	assert.True(t, ok)
+	assert.Equal(t, 200.0, percent, "CPU percent should be clamped to online CPUs * 100")
+}
+
+func TestCPUMetricsSkipsInvalidPercent(t *testing.T) {
+	c := &DockerCollector{}
+
+	ch := make(chan prometheus.Metric, 3)
+	c.CPUMetrics(ch, loadStatsFixture(t, "fresh_container.json"), newContainerLabels("fresh"))
+	close(ch)
+
+	var names []string
+	for m := range ch {
+		names = append(names, m.Desc().String())
+	}
+
+	require.Len(t, names, 3, "Only the CPU seconds counters should be exported")
+	assert.Contains(t, names[0], "dex_cpu_utilization_seconds_total")
+	assert.Contains(t, names[1], "dex_cpu_mode_seconds_total")
+	assert.Contains(t, names[2], "dex_cpu_mode_seconds_total")
+}
+
+func TestCPUModeMetrics(t *testing.T) {
+	c := &DockerCollector{}
+
+	ch := make(chan prometheus.Metric, 3)
+	c.CPUMetrics(ch, &container.StatsResponse{CPUStats: container.CPUStats{CPUUsage: container.CPUUsage{
+		TotalUsage:        3000000000,
+		UsageInUsermode:   2500000000,
+		UsageInKernelmode: 500000000,
+	}}}, newContainerLabels("web"))
+	close(ch)
+
+	modes := map[string]float64{}
+	for m := range ch {
+		var pb dto.Metric
+		require.NoError(t, m.Write(&pb))
+		for _, label := range pb.Label {
+			if label.GetName() == "mode" {
+				modes[label.GetValue()] = pb.Counter.GetValue()
+			}
+		}
+	}
+	assert.Equal(t, map[string]float64{"user": 2.5, "kernel": 0.5}, modes)
+
+	// without the split only the total is exported
+	ch = make(chan prometheus.Metric, 3)
+	c.CPUMetrics(ch, &container.StatsResponse{CPUStats: container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 3000000000}}}, newContainerLabels("web"))
+	assert.Len(t, collectLabels(t, ch), 1)
+}
+
+func TestContainerIDLabel(t *testing.T) {
+	c := &DockerCollector{}
+	cl := newContainerLabels("web").with("container_id", shortID("3f1e2d4c5b6a79880123456789abcdef"))
+
+	ch := make(chan prometheus.Metric, 1)
+	c.pidsMetrics(ch, &container.StatsResponse{PidsStats: container.PidsStats{Current: 1}}, cl)
+	close(ch)
+
+	pbMetric := &dto.Metric{}
+	require.NoError(t, (<-ch).Write(pbMetric), "Failed to write metric to protobuf")
+
+	labels := map[string]string{}
+	for _, lp := range pbMetric.Label {
+		labels[lp.GetName()] = lp.GetValue()
+	}
+	assert.Equal(t, map[string]string{"container_name": "web", "container_id": "3f1e2d4c5b6a"}, labels)
+	assert.Equal(t, []string{"container_name"}, newContainerLabels("web").names, "with should not modify the original labels")
+}
+
+func TestInvalidFilterFallsBack(t *testing.T) {
+	t.Setenv("DEX_FILTER_CONTAINER", "app_(")
+	saved := configErrors
+	configErrors = &ConfigErrors{}
+	t.Cleanup(func() { configErrors = saved })
+
+	c := newDockerCollector()
+	_, ok := c.filter.match("anything")
+	assert.True(t, ok, "Invalid filter should match all containers")
+
+	ch := make(chan prometheus.Metric, 1)
+	configErrors.Collect(ch)
+	close(ch)
+
+	pbMetric := &dto.Metric{}
+	require.NoError(t, (<-ch).Write(pbMetric), "Failed to write metric to protobuf")
+	assert.Equal(t, "filter_container", pbMetric.Label[0].GetValue())
+	assert.Equal(t, 1.0, pbMetric.GetGauge().GetValue())
+}
+
+func TestContainerStatsTimeout(t *testing.T) {
+	// the stats of the wedged container never arrive
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			json.NewEncoder(w).Encode([]container.Summary{
+				{ID: "aaa", Names: []string{"/web"}, State: "running"},
+				{ID: "bbb", Names: []string{"/wedged"}, State: "running"},
+			})
+		case strings.HasSuffix(r.URL.Path, "/bbb/stats"):
+			<-r.Context().Done()
+		case strings.HasSuffix(r.URL.Path, "/stats"):
+			json.NewEncoder(w).Encode(sampleStats(100, 200))
+		default:
+			json.NewEncoder(w).Encode(container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{}})
+		}
+	}))
+	t.Cleanup(server.Close)
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.45"))
+	require.NoError(t, err)
+
+	c := &DockerCollector{
+		cli:              cli,
+		api:              newDockerAPIMetrics(),
+		counters:         newMonotonicCounters(),
+		filter:           matchAllFilter(),
+		containerTimeout: 100 * time.Millisecond,
+	}
+
+	ch := make(chan prometheus.Metric, 1000)
+	c.Collect(ch)
+	close(ch)
+
+	timeouts := map[string]float64{}
+	running := map[string]bool{}
+	memory := map[string]bool{}
+	for m := range ch {
+		desc := m.Desc().String()
+		if !strings.Contains(desc, "container_name") {
+			continue
+		}
+		pbMetric := &dto.Metric{}
+		require.NoError(t, m.Write(pbMetric))
+		name := pbMetric.Label[0].GetValue()
+		switch {
+		case strings.Contains(desc, `"dex_container_stats_timeout"`):
+			timeouts[name] = pbMetric.GetGauge().GetValue()
+		case strings.Contains(desc, `"dex_container_running"`):
+			running[name] = true
+		case strings.Contains(desc, `"dex_memory_usage_bytes"`):
+			memory[name] = true
+		}
+	}
+
+	assert.Equal(t, map[string]float64{"web": 0, "wedged": 1}, timeouts)
+	assert.Equal(t, map[string]bool{"web": true, "wedged": true}, running, "State metrics should be exported for all containers")
+	assert.Equal(t, map[string]bool{"web": true}, memory)
+}
+
+func TestCollectContainerStatesAndConcurrency(t *testing.T) {
+	var listFilters string
+	var inflight, maxInflight atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/containers/json") {
+			listFilters = r.URL.Query().Get("filters")
+			var containers []container.Summary
+			for _, name := range []string{"a", "b", "c", "d", "e"} {
+				containers = append(containers, container.Summary{ID: name, Names: []string{"/" + name}, State: "exited"})
+			}
+			json.NewEncoder(w).Encode(containers)
+			return
+		}
+
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			m := maxInflight.Load()
+			if n <= m || maxInflight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		json.NewEncoder(w).Encode(container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{}})
+	}))
+	t.Cleanup(server.Close)
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.45"))
+	require.NoError(t, err)
+
+	c := &DockerCollector{
+		cli:        cli,
+		api:        newDockerAPIMetrics(),
+		counters:   newMonotonicCounters(),
+		filter:     matchAllFilter(),
+		listStates: []string{"running", "exited"},
+		slots:      make(chan struct{}, 2),
+	}
+
+	ch := make(chan prometheus.Metric, 1000)
+	c.Collect(ch)
+	close(ch)
+
+	assert.JSONEq(t, `{"status":{"running":true,"exited":true}}`, listFilters, "States should be filtered by the daemon")
+	assert.Equal(t, int32(2), maxInflight.Load(), "Containers should be processed two at a time")
+}
+
+func TestInvalidContainerStatesFallBack(t *testing.T) {
+	t.Setenv("DEX_CONTAINER_STATES", "running,stopped")
+	saved := configErrors
+	configErrors = &ConfigErrors{}
+	t.Cleanup(func() { configErrors = saved })
+
+	c := newDockerCollector()
+	assert.Empty(t, c.listStates, "Invalid states should list all containers")
+	assert.Equal(t, 1, testutil.CollectAndCount(configErrors))
+}
+
+// BenchmarkContainerStatsMetrics measures exporting the metrics of the stats
+// of a running container, which happens for every container in every scrape.
+func BenchmarkContainerStatsMetrics(b *testing.B) {
+	c := &DockerCollector{counters: newMonotonicCounters(), hostMemTotal: 8 * 1024 * 1024 * 1024}
+	stats := loadStatsFixture(b, "cgroupv2_running.json")
+	cl := newContainerLabels("web")
+	ch := make(chan prometheus.Metric, 100)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.blockIoMetrics(ch, stats, cl)
+		c.memoryMetrics(ch, stats, cl)
+		c.networkMetrics(ch, stats, cl)
+		c.CPUMetrics(ch, stats, cl)
+		c.pidsMetrics(ch, stats, cl)
+		// the registry writes every metric during a scrape
+		for len(ch) > 0 {
+			if err := (<-ch).Write(&dto.Metric{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkDecodeStats(b *testing.B) {
+	data, err := os.ReadFile(filepath.Join("testdata", "stats", "cgroupv2_running.json"))
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var stats container.StatsResponse
+		if err := decodeStats(bytes.NewReader(data), &stats); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStatusExitCode(t *testing.T) {
+	for status, expected := range map[string]int{
+		"Exited (0) 3 hours ago":     0,
+		"Exited (137) 5 minutes ago": 137,
+		"Exited (-1) 2 days ago":     -1,
+	} {
+		exitCode, ok := statusExitCode(status)
+		assert.True(t, ok, status)
+		assert.Equal(t, expected, exitCode, status)
+	}
+
+	_, ok := statusExitCode("Up 2 minutes (healthy)")
+	assert.False(t, ok)
+}
+
+func TestSkipInspectExited(t *testing.T) {
+	api := newDockerAPIMetrics()
+	c := &DockerCollector{
+		cli: fakeDaemon(t, []container.Summary{
+			{ID: "aaa", Names: []string{"/ci_1"}, State: "exited", Status: "Exited (2) 1 hour ago"},
+			{ID: "bbb", Names: []string{"/ci_2"}, State: "exited", Status: "Exited (0) 1 hour ago"},
+		}),
+		api:               api,
+		counters:          newMonotonicCounters(),
+		filter:            matchAllFilter(),
+		skipInspectExited: true,
+	}
+
+	ch := make(chan prometheus.Metric, 1000)
+	c.Collect(ch)
+	close(ch)
+
+	values := map[string]float64{}
+	for m := range ch {
+		pbMetric := &dto.Metric{}
+		require.NoError(t, m.Write(pbMetric))
+		if strings.Contains(m.Desc().String(), `"dex_container_exit_code"`) {
+			values[pbMetric.Label[0].GetValue()] = pbMetric.GetGauge().GetValue()
+		}
+		assert.NotContains(t, m.Desc().String(), `"dex_container_restarts_total"`)
+	}
+	assert.Equal(t, map[string]float64{"ci_1": 2, "ci_2": 0}, values, "Exit codes are read from the status")
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(api.duration)
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		for _, m := range family.Metric {
+			assert.NotEqual(t, "inspect", m.Label[0].GetValue(), "Exited containers shouldn't be inspected")
+		}
+	}
+}
+
+func TestReadContainerStatsStream(t *testing.T) {
+	frame := func(i int) *container.StatsResponse {
+		stats := &container.StatsResponse{}
+		stats.Read = time.Unix(int64(i), 0)
+		stats.CPUStats.CPUUsage.TotalUsage = uint64(i * 100)
+		stats.CPUStats.SystemUsage = uint64(i * 1000)
+		stats.CPUStats.OnlineCPUs = 1
+		return stats
+	}
+
+	samples := 2
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stream") != "1" {
+			// the daemon fills the previous CPU stats of a single sample
+			assert.Empty(t, r.URL.Query().Get("one-shot"))
+			stats := frame(2)
+			stats.PreCPUStats = frame(1).CPUStats
+			stats.PreRead = frame(1).Read
+			json.NewEncoder(w).Encode(stats)
+			return
+		}
+		// the first streamed sample has no previous CPU stats, the later ones
+		// have the stats of the sample before
+		for i := 1; i <= samples; i++ {
+			stats := frame(i)
+			if i > 1 {
+				stats.PreCPUStats = frame(i - 1).CPUStats
+				stats.PreRead = frame(i - 1).Read
+			}
+			json.NewEncoder(w).Encode(stats)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.45"))
+	require.NoError(t, err)
+
+	stats, err := readContainerStats(context.Background(), cli, newDockerAPIMetrics(), "single", false)
+	require.NoError(t, err)
+	percent, ok := cpuPercent(&stats)
+	assert.True(t, ok, "The daemon should fill the previous CPU stats")
+	assert.Equal(t, 10.0, percent)
+
+	stats, err = readContainerStats(context.Background(), cli, newDockerAPIMetrics(), "stream", true)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(200), stats.CPUStats.CPUUsage.TotalUsage, "The second sample should be used")
+	assert.Equal(t, uint64(100), stats.PreCPUStats.CPUUsage.TotalUsage, "The first sample should be the previous one")
+	percent, ok = cpuPercent(&stats)
+	assert.True(t, ok)
+	assert.Equal(t, 10.0, percent)
+
+	// a container stopping after the first sample
+	samples = 1
+	stats, err = readContainerStats(context.Background(), cli, newDockerAPIMetrics(), "stream", true)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(100), stats.CPUStats.CPUUsage.TotalUsage)
+	_, ok = cpuPercent(&stats)
+	assert.False(t, ok)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// labels identifying the project of a container, compose takes precedence
+// over swarm stacks
+var projectLabels = []string{"com.docker.compose.project", "com.docker.stack.namespace"}
+
+func containerProject(labels map[string]string) string {
+	for _, name := range projectLabels {
+		if project := labels[name]; project != "" {
+			return project
+		}
+	}
+	return ""
+}
+
+// upAndHealthy reports whether a listed container is running and, if it has
+// a healthcheck, healthy. The health is read from the status, e.g.
+// "Up 2 minutes (health: starting)", so no inspect is needed.
+func upAndHealthy(cont container.Summary) bool {
+	return cont.State == "running" && !strings.Contains(cont.Status, "(unhealthy)") && !strings.Contains(cont.Status, "(health: starting)")
+}
+
+type projectAggregate struct {
+	cpuSeconds  float64
+	memoryBytes float64
+	states      map[string]float64
+	// number of containers not running or not healthy
+	down float64
+	// headroom of the containers with a limit, exported only if any has one
+	cpuHeadroom, memoryHeadroom float64
+	cpuLimited, memoryLimited   bool
+}
+
+// projectAggregates sums the metrics of the containers of each compose project
+// or stack during a collection, so fleet dashboards don't need to sum over
+// per-container series.
+type projectAggregates struct {
+	mu       sync.Mutex
+	projects map[string]*projectAggregate
+}
+
+// newProjectAggregates returns nil when aggregation is disabled.
+func newProjectAggregates(enabled bool) *projectAggregates {
+	if !enabled {
+		return nil
+	}
+	return &projectAggregates{projects: map[string]*projectAggregate{}}
+}
+
+func (a *projectAggregates) get(project string) *projectAggregate {
+	aggregate, ok := a.projects[project]
+	if !ok {
+		aggregate = &projectAggregate{states: map[string]float64{}}
+		a.projects[project] = aggregate
+	}
+	return aggregate
+}
+
+// addContainer counts a container. It is safe to call on a nil receiver.
+func (a *projectAggregates) addContainer(project, state string, healthy bool) {
+	if a == nil || project == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	aggregate := a.get(project)
+	aggregate.states[state]++
+	if !healthy {
+		aggregate.down++
+	}
+}
+
+// addStats adds the resource usage of a running container. It is safe to
+// call on a nil receiver.
+func (a *projectAggregates) addStats(project string, cpuSeconds, memoryBytes float64) {
+	if a == nil || project == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	aggregate := a.get(project)
+	aggregate.cpuSeconds += cpuSeconds
+	aggregate.memoryBytes += memoryBytes
+}
+
+// addCPUHeadroom adds the unused CPU cores of a container with a CPU limit. It
+// is safe to call on a nil receiver.
+func (a *projectAggregates) addCPUHeadroom(project string, cores float64) {
+	if a == nil || project == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	aggregate := a.get(project)
+	aggregate.cpuHeadroom += cores
+	aggregate.cpuLimited = true
+}
+
+// addMemoryHeadroom adds the unused memory of a container with a memory
+// limit. It is safe to call on a nil receiver.
+func (a *projectAggregates) addMemoryHeadroom(project string, bytes float64) {
+	if a == nil || project == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	aggregate := a.get(project)
+	aggregate.memoryHeadroom += bytes
+	aggregate.memoryLimited = true
+}
+
+func (a *projectAggregates) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+// Collect is safe to call on a nil receiver.
+func (a *projectAggregates) Collect(ch chan<- prometheus.Metric) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for project, aggregate := range a.projects {
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_compose_project_cpu_utilization_seconds_total",
+			[]string{"compose_project"},
+		), prometheus.CounterValue, aggregate.cpuSeconds, project)
+
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_compose_project_memory_usage_bytes",
+			[]string{"compose_project"},
+		), prometheus.GaugeValue, aggregate.memoryBytes, project)
+
+		if aggregate.cpuLimited {
+			ch <- prometheus.MustNewConstMetric(newDesc(
+				"dex_compose_project_cpu_headroom_cores",
+				[]string{"compose_project"},
+			), prometheus.GaugeValue, aggregate.cpuHeadroom, project)
+		}
+
+		if aggregate.memoryLimited {
+			ch <- prometheus.MustNewConstMetric(newDesc(
+				"dex_compose_project_memory_headroom_bytes",
+				[]string{"compose_project"},
+			), prometheus.GaugeValue, aggregate.memoryHeadroom, project)
+		}
+
+		var healthy float64
+		if aggregate.down == 0 {
+			healthy = 1
+		}
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_compose_project_healthy",
+			[]string{"compose_project"},
+		), prometheus.GaugeValue, healthy, project)
+
+		for state, count := range aggregate.states {
+			ch <- prometheus.MustNewConstMetric(newDesc(
+				"dex_compose_project_containers",
+				[]string{"compose_project", "state"},
+			), prometheus.GaugeValue, count, project, state)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerProject(t *testing.T) {
+	assert.Equal(t, "shop", containerProject(map[string]string{"com.docker.compose.project": "shop"}))
+	assert.Equal(t, "stack", containerProject(map[string]string{"com.docker.stack.namespace": "stack"}))
+	assert.Equal(t, "", containerProject(nil))
+}
+
+func TestProjectAggregates(t *testing.T) {
+	projects := newProjectAggregates(true)
+
+	projects.addContainer("shop", "running", true)
+	projects.addStats("shop", 1.5, 100)
+	projects.addContainer("shop", "running", true)
+	projects.addStats("shop", 2.5, 200)
+	projects.addContainer("shop", "exited", false)
+	projects.addContainer("blog", "running", true)
+	projects.addContainer("", "running", true)
+	projects.addStats("", 10, 1000)
+
+	expected := `
+# HELP dex_compose_project_containers Number of containers of the compose project by state
+# TYPE dex_compose_project_containers gauge
+dex_compose_project_containers{compose_project="blog",state="running"} 1
+dex_compose_project_containers{compose_project="shop",state="exited"} 1
+dex_compose_project_containers{compose_project="shop",state="running"} 2
+# HELP dex_compose_project_cpu_utilization_seconds_total Cumulative CPU utilization in seconds of the running containers of the compose project
+# TYPE dex_compose_project_cpu_utilization_seconds_total counter
+dex_compose_project_cpu_utilization_seconds_total{compose_project="blog"} 0
+dex_compose_project_cpu_utilization_seconds_total{compose_project="shop"} 4
+# HELP dex_compose_project_healthy 1 if all containers of the compose project are running and healthy, 0 otherwise
+# TYPE dex_compose_project_healthy gauge
+dex_compose_project_healthy{compose_project="blog"} 1
+dex_compose_project_healthy{compose_project="shop"} 0
+# HELP dex_compose_project_memory_usage_bytes Memory usage bytes of the running containers of the compose project
+# TYPE dex_compose_project_memory_usage_bytes gauge
+dex_compose_project_memory_usage_bytes{compose_project="blog"} 0
+dex_compose_project_memory_usage_bytes{compose_project="shop"} 300
+`
+	assert.NoError(t, testutil.CollectAndCompare(projects, strings.NewReader(expected)))
+}
+
+func TestProjectAggregatesDisabled(t *testing.T) {
+	projects := newProjectAggregates(false)
+	assert.Nil(t, projects)
+
+	// nil aggregates are no-ops
+	projects.addContainer("shop", "running", true)
+	projects.addStats("shop", 1, 1)
+	projects.addCPUHeadroom("shop", 1)
+	projects.addMemoryHeadroom("shop", 1)
+}
+
+func TestUpAndHealthy(t *testing.T) {
+	assert.True(t, upAndHealthy(container.Summary{State: "running", Status: "Up 2 minutes"}))
+	assert.True(t, upAndHealthy(container.Summary{State: "running", Status: "Up 2 minutes (healthy)"}))
+	assert.False(t, upAndHealthy(container.Summary{State: "running", Status: "Up 2 minutes (unhealthy)"}))
+	assert.False(t, upAndHealthy(container.Summary{State: "running", Status: "Up 3 seconds (health: starting)"}))
+	assert.False(t, upAndHealthy(container.Summary{State: "exited", Status: "Exited (0) 5 minutes ago"}))
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the optional YAML configuration file given in DEX_CONFIG. Options
+// are named like the environment variables in lower case without the DEX_
+// prefix, e.g. `filter_container: ^app_`. Environment variables take
+// precedence over the file.
+type Config struct {
+	// Filters replace DEX_FILTER_CONTAINER when set
+	Filters []*FilterRule `yaml:"filters"`
+	// ContainerLabels add static labels to the matching containers
+	ContainerLabels []*LabelRule `yaml:"container_labels"`
+	// Hosts set filters and labels per host in multi-host mode
+	Hosts []*HostConfig `yaml:"hosts"`
+	// ExpectedContainers must exist on DEX_DOCKER_HOST
+	ExpectedContainers []*ExpectedContainer `yaml:"expected_containers"`
+
+	MetricRelabelConfigs []*RelabelConfig `yaml:"metric_relabel_configs"`
+
+	// Labels are added to all metrics
+	Labels map[string]string `yaml:"labels"`
+
+	Options map[string]string `yaml:",inline"`
+}
+
+// config is the loaded configuration file, options are read through the env helpers.
+var config = &Config{}
+
+// configErrors are the options dex started with despite being invalid.
+var configErrors = &ConfigErrors{}
+
+// ConfigErrors exports dex_config_error for invalid options which are replaced
+// by their defaults, so a bad configuration doesn't go unnoticed.
+type ConfigErrors struct {
+	mu      sync.Mutex
+	options []string
+}
+
+func (e *ConfigErrors) add(option string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// every collector of a multi-host setup reports the same options
+	if !slices.Contains(e.options, option) {
+		e.options = append(e.options, option)
+	}
+}
+
+func (e *ConfigErrors) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (e *ConfigErrors) Collect(ch chan<- prometheus.Metric) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, option := range e.options {
+		ch <- prometheus.MustNewConstMetric(
+			newDesc("dex_config_error", []string{"option"}),
+			prometheus.GaugeValue,
+			1,
+			option,
+		)
+	}
+}
+
+// configOptions lists all options with a validator of their value.
+var configOptions = map[string]func(string) error{
+	"DEX_PORT":                         validateInt,
+	"DEX_LISTEN_UNIX":                  validateString,
+	"DEX_LISTEN_UNIX_MODE":             validateFileMode,
+	"DEX_ALLOWED_CIDRS":                validateCIDRs,
+	"DEX_TRUSTED_PROXIES":              validateCIDRs,
+	"DEX_SCRAPE_TIMEOUT":               validateDuration,
+	"DEX_MAX_REQUESTS_IN_FLIGHT":       validateInt,
+	"DEX_SCRAPE_OVERLAP":               validateOverlapMode,
+	"DEX_DISABLE_COMPRESSION":          validateBool,
+	"DEX_NATIVE_HISTOGRAMS":            validateBool,
+	"DEX_DOCKER_HOST":                  validateDockerHost,
+	"DEX_DOCKER_HOSTS":                 validateDockerHosts,
+	"DEX_DOCKER_HOSTS_DNS":             validateString,
+	"DEX_DOCKER_HOSTS_DNS_PORT":        validateInt,
+	"DEX_DOCKER_HOSTS_DNS_INTERVAL":    validateDuration,
+	"DEX_FILTER_CONTAINER":             validateRegexp,
+	"DEX_CONTAINER_ID_LABEL":           validateBool,
+	"DEX_LABEL_INVALID_CHARS":          validateRegexp,
+	"DEX_LABEL_MAX_LENGTH":             validateLabelMaxLength,
+	"DEX_COMPOSE_AGGREGATES":           validateBool,
+	"DEX_HOST_TOTALS":                  validateBool,
+	"DEX_COLLECT_INTERVAL":             validateDuration,
+	"DEX_SAMPLE_INTERVAL":              validateDuration,
+	"DEX_CPU_HISTOGRAM":                validateBool,
+	"DEX_NETWORK_RATES":                validateBool,
+	"DEX_TOP_N":                        validateInt,
+	"DEX_TOP_N_BY":                     validateTopNBy,
+	"DEX_CONTAINER_TIMEOUT":            validateDuration,
+	"DEX_DOCKER_API_RATE":              validateInt,
+	"DEX_DOCKER_API_BURST":             validateInt,
+	"DEX_SCRAPE_API_RATE":              validateInt,
+	"DEX_SCRAPE_API_BURST":             validateInt,
+	"DEX_STATS_MODE":                   validateStatsMode,
+	"DEX_CONTAINER_STATES":             validateContainerStates,
+	"DEX_INSPECT_EXITED":               validateBool,
+	"DEX_COLLECT_CONCURRENCY":          validateInt,
+	"DEX_DOCKER_API_MIN_VERSION":       validateAPIVersion,
+	"DEX_DOCKER_API_MAX_VERSION":       validateAPIVersion,
+	"DEX_ROOTFS_INODES":                validateBool,
+	"DEX_ENV_AUDIT":                    validateString,
+	"DEX_PROCESS_METRICS":              validateBool,
+	"DEX_TMPFS_METRICS":                validateBool,
+	"DEX_NUMA_METRICS":                 validateBool,
+	"DEX_PROC_PATH":                    validateString,
+	"DEX_CGROUP_PATH":                  validateString,
+	"DEX_MONOTONIC_COUNTERS":           validateBool,
+	"DEX_MONOTONIC_COUNTERS_TTL":       validateDuration,
+	"DEX_ABSENT_CONTAINERS_TTL":        validateDuration,
+	"DEX_DAEMON_METRICS":               validateBool,
+	"DEX_NETWORK_METRICS":              validateBool,
+	"DEX_HOST_LABELS":                  validateHostLabels,
+	"DEX_SWARM_NODE_LABELS":            validateBool,
+	"DEX_SWARM_CLUSTER_METRICS":        validateBool,
+	"DEX_BUILD_CACHE_INTERVAL":         validateDuration,
+	"DEX_DANGLING_INTERVAL":            validateDuration,
+	"DEX_LAYER_SIZE_INTERVAL":          validateDuration,
+	"DEX_TIME_OFFSET_INTERVAL":         validateDuration,
+	"DEX_START_DURATION_ENABLED":       validateBool,
+	"DEX_LIFETIME_ENABLED":             validateBool,
+	"DEX_OOM_KILLS_ENABLED":            validateBool,
+	"DEX_EVENT_LOG":                    validateString,
+	"DEX_EVENT_LOG_MAX_BYTES":          validateInt,
+	"DEX_EVENT_LOG_MAX_FILES":          validateInt,
+	"DEX_PAUSE_EVENTS_ENABLED":         validateBool,
+	"DEX_KMSG_PATH":                    validateString,
+	"DEX_TRIVY_ENABLED":                validateBool,
+	"DEX_TRIVY_BIN":                    validateString,
+	"DEX_TRIVY_SERVER":                 validateString,
+	"DEX_TRIVY_INTERVAL":               validateDuration,
+	"DEX_TRIVY_TIMEOUT":                validateDuration,
+	"DEX_DOCKER_CONFIG":                validateString,
+	"DEX_UI_REFRESH":                   validateDuration,
+	"DEX_HISTORY_RETENTION":            validateDuration,
+	"DEX_HISTORY_INTERVAL":             validateDuration,
+	"DEX_ALERT_RULES_FILE":             validateAlertRules,
+	"DEX_ALERTMANAGER_URL":             validateString,
+	"DEX_ALERT_EVAL_INTERVAL":          validateDuration,
+	"DEX_MQTT_BROKER":                  validateString,
+	"DEX_MQTT_USERNAME":                validateString,
+	"DEX_MQTT_USERNAME_FILE":           validateSecretFile,
+	"DEX_MQTT_PASSWORD":                validateString,
+	"DEX_MQTT_PASSWORD_FILE":           validateSecretFile,
+	"DEX_MQTT_CLIENT_ID":               validateString,
+	"DEX_MQTT_TOPIC_PREFIX":            validateString,
+	"DEX_MQTT_DISCOVERY_PREFIX":        validateString,
+	"DEX_MQTT_INTERVAL":                validateDuration,
+	"DEX_ZABBIX_SERVER":                validateString,
+	"DEX_ZABBIX_HOST":                  validateTemplate,
+	"DEX_ZABBIX_KEY":                   validateTemplate,
+	"DEX_ZABBIX_DISCOVERY_KEY":         validateString,
+	"DEX_ZABBIX_INTERVAL":              validateDuration,
+	"DEX_CLOUDWATCH_NAMESPACE":         validateString,
+	"DEX_CLOUDWATCH_REGION":            validateString,
+	"DEX_CLOUDWATCH_DIMENSIONS":        validateString,
+	"DEX_CLOUDWATCH_METRICS":           validateString,
+	"DEX_CLOUDWATCH_HOST_DIMENSION":    validateBool,
+	"DEX_CLOUDWATCH_INTERVAL":          validateDuration,
+	"DEX_GRPC_LISTEN":                  validateString,
+	"DEX_GRPC_INTERVAL":                validateDuration,
+	"DEX_PROXY_ENABLED":                validateBool,
+	"DEX_PROXY_TIMEOUT":                validateDuration,
+	"DEX_PROXY_PREFIX":                 validateString,
+	"DEX_PROXY_PUBLISHED_HOST":         validateString,
+	"DEX_DEBUG_ENDPOINTS":              validateBool,
+	"DEX_ADMIN_TOKEN":                  validateString,
+	"DEX_ADMIN_TOKEN_FILE":             validateSecretFile,
+	"DEX_LEADER_LOCK_FILE":             validateString,
+	"DEX_LEADER_ID":                    validateString,
+	"DEX_LEADER_LEASE":                 validateDuration,
+	"DEX_OTLP_ENDPOINT":                validateString,
+	"DEX_OTLP_SERVICE_NAME":            validateString,
+	"DEX_READ_ONLY":                    validateBool,
+	"DEX_UID":                          validateInt,
+	"DEX_GID":                          validateInt,
+	"DEX_SOCKET_PROXY":                 validateBool,
+	"DEX_HEARTBEAT_URL":                validateString,
+	"DEX_HEARTBEAT_URL_FILE":           validateSecretFile,
+	"DEX_HEARTBEAT_INTERVAL":           validateDuration,
+	"DEX_SLOW_METRICS_PATH":            validateMetricsPath,
+	"DEX_PLUGIN_DIR":                   validateString,
+	"DEX_PLUGIN_TIMEOUT":               validateDuration,
+	"DEX_CONTAINER_SCRIPT_DIR":         validateString,
+	"DEX_CONTAINER_SCRIPT_TIMEOUT":     validateDuration,
+	"DEX_CONTAINER_SCRIPT_CONCURRENCY": validateInt,
+	"DEX_RECEIVE_ENABLED":              validateBool,
+	"DEX_RECEIVE_TTL":                  validateDuration,
+	"DEX_RECEIVE_MAX_SERIES":           validateInt,
+}
+
+// secretOptions aren't printed by check-config and can be read from the file
+// given in the option with the _FILE suffix. Passwords in the URLs of the
+// other options are redacted by check-config, see redactURLs.
+var secretOptions = map[string]bool{
+	"DEX_MQTT_USERNAME": true,
+	"DEX_MQTT_PASSWORD": true,
+	"DEX_ADMIN_TOKEN":   true,
+	"DEX_HEARTBEAT_URL": true,
+}
+
+// redactURLs hides the passwords of the comma separated URLs in an option
+// value, e.g. the basic auth of DEX_ALERTMANAGER_URL or DEX_MQTT_BROKER.
+func redactURLs(v string) string {
+	parts := strings.Split(v, ",")
+	for i, part := range parts {
+		u, err := url.Parse(strings.TrimSpace(part))
+		if err != nil || u.User == nil {
+			continue
+		}
+		if _, hasPassword := u.User.Password(); hasPassword {
+			parts[i] = u.Redacted()
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// configKey returns the configuration file key of an environment variable.
+func configKey(name string) string {
+	return strings.ToLower(strings.TrimPrefix(name, "DEX_"))
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// lookupOption returns the value of an option from the environment or the
+// configuration file. Secrets can also be read from the file given in the
+// option with the _FILE suffix, e.g. DEX_MQTT_PASSWORD_FILE for Docker secrets.
+// The file is read on every lookup, so a reload picks up a rotated secret.
+func lookupOption(name string) (string, bool) {
+	if v, isSet := lookupPlainOption(name); isSet {
+		return v, true
+	}
+
+	if secretOptions[name] {
+		if path, isSet := lookupPlainOption(name + "_FILE"); isSet {
+			secret, err := readSecretFile(path)
+			if err != nil {
+				log.Errorf("can't read %s_FILE: %v", name, err)
+				return "", false
+			}
+			return secret, true
+		}
+	}
+
+	return "", false
+}
+
+func lookupPlainOption(name string) (string, bool) {
+	if v, isSet := os.LookupEnv(name); isSet {
+		return v, true
+	}
+	v, isSet := config.Options[configKey(name)]
+	return v, isSet
+}
+
+// readSecretFile reads a secret without the trailing newline most editors add.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// unknownOptions returns the keys of the configuration file which aren't options.
+func (cfg *Config) unknownOptions() []string {
+	var unknown []string
+	for key := range cfg.Options {
+		if _, found := configOptions["DEX_"+strings.ToUpper(key)]; !found || key != strings.ToLower(key) {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// effectiveOptions returns the names of all options set in the environment or
+// the configuration file.
+func effectiveOptions() []string {
+	var names []string
+	for name := range configOptions {
+		if _, isSet := lookupOption(name); isSet {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dockerHost returns the Docker endpoint the collector connects to.
+func dockerHost() string {
+	if host := envString("DEX_DOCKER_HOST", ""); host != "" {
+		return host
+	}
+	if host := os.Getenv(client.EnvOverrideHost); host != "" {
+		return host
+	}
+	return client.DefaultDockerHost
+}
+
+// checkConfig implements `dex check-config [-ping] <file>`. It validates all
+// options of the file and the environment, prints the effective configuration
+// and returns the exit code. With -ping DEX_DOCKER_HOST and every static host
+// of DEX_DOCKER_HOSTS must respond.
+func checkConfig(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	ping := flags.Bool("ping", false, "ping the Docker endpoints")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: dex check-config [-ping] <file>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := loadConfig(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	config = cfg
+
+	failed := false
+	for _, key := range cfg.unknownOptions() {
+		fmt.Fprintf(stderr, "%s: unknown option\n", key)
+		failed = true
+	}
+
+	if len(cfg.Filters) > 0 {
+		if _, err := newContainerFilter(cfg.Filters); err != nil {
+			fmt.Fprintf(stderr, "filters: %v\n", err)
+			failed = true
+		}
+	}
+
+	if err := matchAllFilter().withLabelRules(cfg.ContainerLabels); err != nil {
+		fmt.Fprintf(stderr, "container_labels: %v\n", err)
+		failed = true
+	}
+
+	if err := compileHostConfigs(cfg.Hosts); err != nil {
+		fmt.Fprintf(stderr, "hosts: %v\n", err)
+		failed = true
+	}
+
+	if err := validateExpectedContainers(cfg.ExpectedContainers); err != nil {
+		fmt.Fprintf(stderr, "expected_containers: %v\n", err)
+		failed = true
+	}
+
+	if err := compileRelabelConfigs(cfg.MetricRelabelConfigs); err != nil {
+		fmt.Fprintf(stderr, "metric_relabel_configs: %v\n", err)
+		failed = true
+	}
+
+	if err := validateLabels(cfg.Labels); err != nil {
+		fmt.Fprintf(stderr, "labels: %v\n", err)
+		failed = true
+	}
+
+	names := effectiveOptions()
+	for _, name := range names {
+		v, _ := lookupOption(name)
+		if err := configOptions[name](v); err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", configKey(name), err)
+			failed = true
+		}
+	}
+
+	host := dockerHost()
+	if *ping && !failed {
+		// in multi-host mode the other collectors still use DEX_DOCKER_HOST
+		endpoints := []string{host}
+		for _, endpoint := range splitList(envString("DEX_DOCKER_HOSTS", "")) {
+			if !slices.Contains(endpoints, endpoint) {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+		for _, endpoint := range endpoints {
+			if err := pingDockerHost(endpoint); err != nil {
+				fmt.Fprintf(stderr, "docker host %s: %v\n", redactURLs(endpoint), err)
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		return 1
+	}
+
+	effective := map[string]any{configKey("DEX_DOCKER_HOST"): redactURLs(host)}
+	if len(cfg.Filters) > 0 {
+		effective["filters"] = cfg.Filters
+	}
+	if len(cfg.ContainerLabels) > 0 {
+		effective["container_labels"] = cfg.ContainerLabels
+	}
+	if len(cfg.Hosts) > 0 {
+		effective["hosts"] = cfg.Hosts
+	}
+	if len(cfg.ExpectedContainers) > 0 {
+		effective["expected_containers"] = cfg.ExpectedContainers
+	}
+	if len(cfg.MetricRelabelConfigs) > 0 {
+		effective["metric_relabel_configs"] = cfg.MetricRelabelConfigs
+	}
+	if len(cfg.Labels) > 0 {
+		effective["labels"] = cfg.Labels
+	}
+	for _, name := range names {
+		v, _ := lookupOption(name)
+		if secretOptions[name] {
+			v = "<secret>"
+		} else {
+			v = redactURLs(v)
+		}
+		if name != "DEX_DOCKER_HOST" {
+			effective[configKey(name)] = v
+		}
+	}
+
+	if err := yaml.NewEncoder(stdout).Encode(effective); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func pingDockerHost(host string) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithHost(host), client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = cli.Ping(ctx)
+	return err
+}
+
+func validateString(string) error {
+	return nil
+}
+
+func validateSecretFile(v string) error {
+	_, err := readSecretFile(v)
+	return err
+}
+
+func validateBool(v string) error {
+	_, err := strconv.ParseBool(v)
+	return err
+}
+
+func validateInt(v string) error {
+	_, err := strconv.Atoi(v)
+	return err
+}
+
+func validateDuration(v string) error {
+	_, err := time.ParseDuration(v)
+	return err
+}
+
+func validateMetricsPath(v string) error {
+	if v == "" {
+		return nil
+	}
+	if !strings.HasPrefix(v, "/") || v == "/" || v == "/metrics" || strings.ContainsAny(v, "{} \t") {
+		return fmt.Errorf("must be a path other than / and /metrics, e.g. /metrics/slow")
+	}
+	return nil
+}
+
+func validateFileMode(v string) error {
+	_, err := strconv.ParseUint(v, 8, 32)
+	return err
+}
+
+func validateRegexp(v string) error {
+	_, err := regexp.Compile(v)
+	return err
+}
+
+func validateCIDRs(v string) error {
+	_, err := parseCIDRs(v)
+	return err
+}
+
+func validateTemplate(v string) error {
+	_, err := template.New("").Parse(v)
+	return err
+}
+
+func validateDockerHost(v string) error {
+	if v == "" {
+		return nil
+	}
+	_, err := client.ParseHostURL(v)
+	return err
+}
+
+func validateDockerHosts(v string) error {
+	for _, host := range splitList(v) {
+		if _, err := client.ParseHostURL(host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateHostLabels(v string) error {
+	for _, name := range splitList(v) {
+		if !hostLabelNames[name] {
+			return fmt.Errorf("unknown host label '%s'", name)
+		}
+	}
+	return nil
+}
+
+func validateTopNBy(v string) error {
+	if v != "cpu" && v != "memory" {
+		return fmt.Errorf("must be cpu or memory")
+	}
+	return nil
+}
+
+func validateStatsMode(v string) error {
+	if v != "oneshot" && v != "stream" {
+		return fmt.Errorf("must be oneshot or stream")
+	}
+	return nil
+}
+
+// containerStates are the states the daemon can filter containers by.
+var containerStates = []string{"created", "restarting", "running", "removing", "paused", "exited", "dead"}
+
+func validateContainerStates(v string) error {
+	for _, state := range splitList(v) {
+		if !slices.Contains(containerStates, state) {
+			return fmt.Errorf("unknown container state '%s', must be one of %s", state, strings.Join(containerStates, ", "))
+		}
+	}
+	return nil
+}
+
+func validateAlertRules(v string) error {
+	if v == "" {
+		return nil
+	}
+	_, err := loadAlertRules(v)
+	return err
+}
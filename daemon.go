@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// DaemonCollector exports the configuration of the Docker daemon, e.g. to
+// alert when a required plugin is disabled.
+type DaemonCollector struct {
+	cli *client.Client
+	api *DockerAPIMetrics
+}
+
+// newDaemonCollector returns nil when daemon metrics are disabled.
+func newDaemonCollector(cli *client.Client, api *DockerAPIMetrics) *DaemonCollector {
+	if !envBool("DEX_DAEMON_METRICS", true) {
+		return nil
+	}
+	return &DaemonCollector{cli: cli, api: api}
+}
+
+func (d *DaemonCollector) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (d *DaemonCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+
+	var plugins types.PluginsListResponse
+	err := d.api.observe(ctx, "plugins", func() error {
+		var err error
+		plugins, err = d.cli.PluginList(ctx, filters.Args{})
+		return err
+	})
+	if err != nil {
+		log.Error("can't list plugins: ", err)
+	} else {
+		pluginMetrics(ch, plugins)
+	}
+
+	var info system.Info
+	err = d.api.observe(ctx, "info", func() error {
+		var err error
+		info, err = d.cli.Info(ctx)
+		return err
+	})
+	if err != nil {
+		log.Error("can't get docker info: ", err)
+	} else {
+		runtimeMetrics(ch, info)
+	}
+}
+
+func pluginMetrics(ch chan<- prometheus.Metric, plugins types.PluginsListResponse) {
+	for _, plugin := range plugins {
+		var enabled float64
+		if plugin.Enabled {
+			enabled = 1
+		}
+
+		var capabilities []string
+		for _, t := range plugin.Config.Interface.Types {
+			capabilities = append(capabilities, t.Capability)
+		}
+		sort.Strings(capabilities)
+
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_docker_plugin_enabled",
+			[]string{"plugin", "type"},
+		), prometheus.GaugeValue, enabled, plugin.Name, strings.Join(capabilities, ","))
+	}
+}
+
+func runtimeMetrics(ch chan<- prometheus.Metric, info system.Info) {
+	for name := range info.Runtimes {
+		isDefault := "false"
+		if name == info.DefaultRuntime {
+			isDefault = "true"
+		}
+
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_docker_runtime_info",
+			[]string{"runtime", "default"},
+		), prometheus.GaugeValue, 1, name, isDefault)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/system"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func collectLabels(t *testing.T, ch chan prometheus.Metric) []map[string]string {
+	close(ch)
+
+	var result []map[string]string
+	for m := range ch {
+		pbMetric := &dto.Metric{}
+		require.NoError(t, m.Write(pbMetric), "Failed to write metric to protobuf")
+
+		labels := map[string]string{"value": strconv.FormatFloat(pbMetric.GetGauge().GetValue(), 'g', -1, 64)}
+		for _, lp := range pbMetric.Label {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		result = append(result, labels)
+	}
+	return result
+}
+
+func TestPluginMetrics(t *testing.T) {
+	plugins := types.PluginsListResponse{
+		{Name: "loki:latest", Enabled: true, Config: types.PluginConfig{Interface: types.PluginConfigInterface{
+			Types: []types.PluginInterfaceType{{Capability: "logdriver", Prefix: "docker", Version: "1.0"}},
+		}}},
+		{Name: "rexray/ebs:latest", Enabled: false, Config: types.PluginConfig{Interface: types.PluginConfigInterface{
+			Types: []types.PluginInterfaceType{{Capability: "volumedriver"}},
+		}}},
+	}
+
+	ch := make(chan prometheus.Metric, 2)
+	pluginMetrics(ch, plugins)
+
+	assert.ElementsMatch(t, []map[string]string{
+		{"plugin": "loki:latest", "type": "logdriver", "value": "1"},
+		{"plugin": "rexray/ebs:latest", "type": "volumedriver", "value": "0"},
+	}, collectLabels(t, ch))
+}
+
+func TestRuntimeMetrics(t *testing.T) {
+	info := system.Info{
+		DefaultRuntime: "runc",
+		Runtimes: map[string]system.RuntimeWithStatus{
+			"runc":   {},
+			"nvidia": {},
+		},
+	}
+
+	ch := make(chan prometheus.Metric, 2)
+	runtimeMetrics(ch, info)
+
+	assert.ElementsMatch(t, []map[string]string{
+		{"runtime": "runc", "default": "true", "value": "1"},
+		{"runtime": "nvidia", "default": "false", "value": "1"},
+	}, collectLabels(t, ch))
+}
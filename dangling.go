@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// danglingUsage is what `docker system prune` would remove.
+type danglingUsage struct {
+	images, imageBytes         float64
+	volumes, volumeBytes       float64
+	containers, containerBytes float64
+}
+
+// DanglingCollector periodically reads the disk usage of resources which
+// aren't used anymore, to drive automated prune decisions.
+type DanglingCollector struct {
+	cli      *client.Client
+	api      *DockerAPIMetrics
+	interval time.Duration
+
+	mu    sync.Mutex
+	usage *danglingUsage
+}
+
+// newDanglingCollector returns nil when dangling resource metrics are not enabled.
+func newDanglingCollector(cli *client.Client, api *DockerAPIMetrics) *DanglingCollector {
+	interval := envDuration("DEX_DANGLING_INTERVAL", 0)
+	if interval <= 0 {
+		return nil
+	}
+
+	return &DanglingCollector{
+		cli:      cli,
+		api:      api,
+		interval: interval,
+	}
+}
+
+func (d *DanglingCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		d.update(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *DanglingCollector) update(ctx context.Context) {
+	var du types.DiskUsage
+	err := d.api.observe(ctx, "disk_usage", func() error {
+		var err error
+		du, err = d.cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{
+			types.ImageObject, types.VolumeObject, types.ContainerObject,
+		}})
+		return err
+	})
+	if err != nil {
+		log.Error("can't read disk usage: ", err)
+		return
+	}
+
+	usage := danglingUsageOf(du)
+
+	d.mu.Lock()
+	d.usage = usage
+	d.mu.Unlock()
+}
+
+func danglingUsageOf(du types.DiskUsage) *danglingUsage {
+	usage := &danglingUsage{}
+
+	for _, image := range du.Images {
+		if len(image.RepoTags) > 0 && image.RepoTags[0] != "<none>:<none>" {
+			continue
+		}
+		usage.images++
+		// layers shared with other images aren't freed
+		size := image.Size
+		if image.SharedSize > 0 {
+			size -= image.SharedSize
+		}
+		usage.imageBytes += float64(size)
+	}
+
+	for _, volume := range du.Volumes {
+		if volume.UsageData == nil || volume.UsageData.RefCount > 0 {
+			continue
+		}
+		usage.volumes++
+		// the size is -1 if the volume driver doesn't report it
+		if volume.UsageData.Size > 0 {
+			usage.volumeBytes += float64(volume.UsageData.Size)
+		}
+	}
+
+	for _, cont := range du.Containers {
+		if cont.State == "running" || cont.State == "paused" || cont.State == "restarting" {
+			continue
+		}
+		usage.containers++
+		usage.containerBytes += float64(cont.SizeRw)
+	}
+
+	return usage
+}
+
+func (d *DanglingCollector) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (d *DanglingCollector) Collect(ch chan<- prometheus.Metric) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.usage == nil {
+		return
+	}
+
+	for _, m := range []struct {
+		name  string
+		value float64
+	}{
+		{"dex_dangling_images_total", d.usage.images},
+		{"dex_dangling_image_bytes", d.usage.imageBytes},
+		{"dex_unused_volumes_total", d.usage.volumes},
+		{"dex_unused_volume_bytes", d.usage.volumeBytes},
+		{"dex_stopped_containers_total", d.usage.containers},
+		{"dex_stopped_container_bytes", d.usage.containerBytes},
+	} {
+		ch <- prometheus.MustNewConstMetric(newDesc(m.name, nil), prometheus.GaugeValue, m.value)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDanglingCollector(t *testing.T) {
+	d := &DanglingCollector{usage: danglingUsageOf(types.DiskUsage{
+		Images: []*image.Summary{
+			{RepoTags: []string{"nginx:latest"}, Size: 1000},
+			{RepoTags: nil, Size: 300, SharedSize: 100},
+			{RepoTags: []string{"<none>:<none>"}, Size: 50, SharedSize: -1},
+		},
+		Volumes: []*volume.Volume{
+			{Name: "used", UsageData: &volume.UsageData{RefCount: 1, Size: 10}},
+			{Name: "unused", UsageData: &volume.UsageData{RefCount: 0, Size: 20}},
+			{Name: "unknown-size", UsageData: &volume.UsageData{RefCount: 0, Size: -1}},
+		},
+		Containers: []*container.Summary{
+			{State: "running", SizeRw: 5},
+			{State: "exited", SizeRw: 7},
+			{State: "created", SizeRw: 0},
+		},
+	})}
+
+	expected := `
+# HELP dex_dangling_image_bytes Size of untagged images without layers shared with other images
+# TYPE dex_dangling_image_bytes gauge
+dex_dangling_image_bytes 250
+# HELP dex_dangling_images_total Number of untagged images
+# TYPE dex_dangling_images_total gauge
+dex_dangling_images_total 2
+# HELP dex_stopped_container_bytes Size of the writable layers of stopped containers
+# TYPE dex_stopped_container_bytes gauge
+dex_stopped_container_bytes 7
+# HELP dex_stopped_containers_total Number of stopped containers
+# TYPE dex_stopped_containers_total gauge
+dex_stopped_containers_total 2
+# HELP dex_unused_volume_bytes Size of volumes not used by any container
+# TYPE dex_unused_volume_bytes gauge
+dex_unused_volume_bytes 20
+# HELP dex_unused_volumes_total Number of volumes not used by any container
+# TYPE dex_unused_volumes_total gauge
+dex_unused_volumes_total 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(d, strings.NewReader(expected)))
+}
+
+func TestDanglingCollectorBeforeFirstUpdate(t *testing.T) {
+	assert.Equal(t, 0, testutil.CollectAndCount(&DanglingCollector{}))
+}
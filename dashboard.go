@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+type dashboardTarget struct {
+	// name of the metric without the namespace, which is detected at runtime
+	suffix string
+	expr   string
+	legend string
+}
+
+type dashboardPanel struct {
+	title   string
+	unit    string
+	targets []dashboardTarget
+}
+
+// dashboardPanels lists all panels the generator knows about, a panel is
+// included only when at least one of its metrics is currently exposed.
+var dashboardPanels = []dashboardPanel{
+	{"Running containers", "short", []dashboardTarget{
+		{"container_running", "%s", "{{container_name}}"},
+	}},
+	{"Container health", "short", []dashboardTarget{
+		{"container_healthy", "%s", "{{container_name}}"},
+	}},
+	{"Restarts", "short", []dashboardTarget{
+		{"container_restarts_total", "increase(%s[$__rate_interval])", "{{container_name}}"},
+	}},
+	{"CPU usage percent", "percent", []dashboardTarget{
+		{"cpu_utilization_percent", "%s", "{{container_name}}"},
+	}},
+	{"Memory usage", "bytes", []dashboardTarget{
+		{"memory_usage_bytes", "%s", "{{container_name}}"},
+	}},
+	{"Memory usage percent", "percent", []dashboardTarget{
+		{"memory_utilization_percent", "%s", "{{container_name}}"},
+	}},
+	{"Network read / write bytes", "Bps", []dashboardTarget{
+		{"network_rx_bytes_total", "rate(%s[$__rate_interval])", "{{container_name}} rx"},
+		{"network_tx_bytes_total", "-rate(%s[$__rate_interval])", "{{container_name}} tx"},
+	}},
+	{"Storage read / write bytes", "Bps", []dashboardTarget{
+		{"block_io_read_bytes_total", "rate(%s[$__rate_interval])", "{{container_name}} read"},
+		{"block_io_write_bytes_total", "-rate(%s[$__rate_interval])", "{{container_name}} write"},
+	}},
+	{"Processes", "short", []dashboardTarget{
+		{"pids_current", "%s", "{{container_name}}"},
+	}},
+	{"Image vulnerabilities", "short", []dashboardTarget{
+		{"image_vulnerabilities", "sum by (image, severity) (%s)", "{{image}} {{severity}}"},
+	}},
+}
+
+// dashboardHandler generates a Grafana dashboard for the metrics currently
+// exposed by the gatherer, which applies the metric relabeling so the
+// dashboard uses the names Prometheus scrapes.
+func dashboardHandler(gatherer prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			log.Error("can't gather metrics for dashboard: ", err)
+		}
+
+		var names []string
+		for _, mf := range families {
+			names = append(names, mf.GetName())
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(grafanaDashboard(names)); err != nil {
+			log.Error("can't write dashboard: ", err)
+		}
+	})
+}
+
+// dashboardNamespaceMetric is exported by every collection. The namespace,
+// e.g. renamed by metric_relabel_configs, is detected from its name rather
+// than from the suffixes of all metrics, which families like
+// dex_host_block_io_read_bytes_total also end with.
+const dashboardNamespaceMetric = "container_running"
+
+func grafanaDashboard(metricNames []string) map[string]any {
+	// the default namespace when no container is running
+	namespace := "dex_"
+	for _, name := range metricNames {
+		if name == dashboardNamespaceMetric || strings.HasSuffix(name, "_"+dashboardNamespaceMetric) {
+			namespace = strings.TrimSuffix(name, dashboardNamespaceMetric)
+			break
+		}
+	}
+
+	// map of known metric suffix to the full exposed metric name
+	exposed := map[string]string{}
+	for _, name := range metricNames {
+		suffix, ok := strings.CutPrefix(name, namespace)
+		if !ok {
+			continue
+		}
+		for _, panel := range dashboardPanels {
+			for _, target := range panel.targets {
+				if suffix == target.suffix {
+					exposed[target.suffix] = name
+				}
+			}
+		}
+	}
+
+	selector := `{job=~"$job", container_name=~"$container"}`
+
+	var panels []map[string]any
+	var variableMetric string
+	for _, panel := range dashboardPanels {
+		var targets []map[string]any
+		for _, target := range panel.targets {
+			name, ok := exposed[target.suffix]
+			if !ok {
+				continue
+			}
+			if variableMetric == "" {
+				variableMetric = name
+			}
+			targets = append(targets, map[string]any{
+				"datasource":   map[string]string{"type": "prometheus", "uid": "${datasource}"},
+				"expr":         fmt.Sprintf(target.expr, name+selector),
+				"legendFormat": target.legend,
+				"refId":        string(rune('A' + len(targets))),
+			})
+		}
+		if len(targets) == 0 {
+			continue
+		}
+
+		i := len(panels)
+		panels = append(panels, map[string]any{
+			"id":         i + 1,
+			"type":       "timeseries",
+			"title":      panel.title,
+			"datasource": map[string]string{"type": "prometheus", "uid": "${datasource}"},
+			"gridPos":    map[string]int{"h": 8, "w": 12, "x": (i % 2) * 12, "y": (i / 2) * 8},
+			"fieldConfig": map[string]any{
+				"defaults":  map[string]any{"unit": panel.unit},
+				"overrides": []any{},
+			},
+			"targets": targets,
+		})
+	}
+
+	variables := []map[string]any{
+		{
+			"name":  "datasource",
+			"label": "Data source",
+			"type":  "datasource",
+			"query": "prometheus",
+		},
+	}
+	if variableMetric != "" {
+		for _, v := range []struct{ name, label, query string }{
+			{"job", "Job", fmt.Sprintf("label_values(%s, job)", variableMetric)},
+			{"container", "Container", fmt.Sprintf(`label_values(%s{job=~"$job"}, container_name)`, variableMetric)},
+		} {
+			variables = append(variables, map[string]any{
+				"name":       v.name,
+				"label":      v.label,
+				"type":       "query",
+				"datasource": map[string]string{"type": "prometheus", "uid": "${datasource}"},
+				"query":      v.query,
+				"refresh":    2,
+				"includeAll": true,
+				"multi":      true,
+				"allValue":   ".*",
+				"current":    map[string]any{"text": "All", "value": "$__all"},
+			})
+		}
+	}
+
+	return map[string]any{
+		"title":         "Dex Metrics",
+		"uid":           "dex-generated",
+		"schemaVersion": 39,
+		"editable":      true,
+		"refresh":       "30s",
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"tags":          []string{"docker", "dex"},
+		"templating":    map[string]any{"list": variables},
+		"panels":        panels,
+	}
+}
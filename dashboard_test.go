@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrafanaDashboard(t *testing.T) {
+	// the names of metrics renamed by metric_relabel_configs
+	dashboard := grafanaDashboard([]string{
+		"myns_container_running",
+		"myns_cpu_utilization_percent",
+		"myns_host_block_io_read_bytes_total",
+		"myns_network_rx_bytes_total",
+		"myns_network_tx_bytes_total",
+		"go_goroutines",
+	})
+
+	data, err := json.Marshal(dashboard)
+	require.NoError(t, err, "Dashboard should be serializable")
+
+	panels := dashboard["panels"].([]map[string]any)
+	require.Len(t, panels, 3, "Only panels for exposed metrics should be generated")
+	assert.Equal(t, "Running containers", panels[0]["title"])
+	assert.Equal(t, "CPU usage percent", panels[1]["title"])
+	assert.Equal(t, "Network read / write bytes", panels[2]["title"])
+	assert.Len(t, panels[2]["targets"], 2, "Network panel should have rx and tx targets")
+
+	assert.True(t, strings.Contains(string(data), `myns_cpu_utilization_percent{job=~\"$job\", container_name=~\"$container\"}`),
+		"Expressions should use the exposed metric names")
+	assert.False(t, strings.Contains(string(data), "dex_"), "Renamed namespace should not reference dex_ metrics")
+	assert.False(t, strings.Contains(string(data), "host_block_io"), "Host totals without container names should not be used")
+}
+
+func TestDashboardHandlerRelabeled(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	for _, name := range []string{"dex_container_running", "dex_cpu_utilization_percent"} {
+		gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: name}, []string{"container_name"})
+		gauge.WithLabelValues("web").Set(1)
+		reg.MustRegister(gauge)
+	}
+	rules := parseRelabelConfigs(t, `
+- source_labels: [__name__]
+  regex: dex_(.*)
+  target_label: __name__
+  replacement: docker_$1
+`)
+
+	w := httptest.NewRecorder()
+	dashboardHandler(newMetricRelabeler(reg, rules)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dashboard/grafana.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "docker_cpu_utilization_percent{", "The dashboard should use the relabeled names")
+	assert.NotContains(t, w.Body.String(), "dex_")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	log "github.com/sirupsen/logrus"
+)
+
+// debugStatsHandler serves GET /debug/containers/{name}/stats with the stats
+// of a container as decoded from the daemon, so metric mapping bugs can be
+// reported with the exact payload.
+func debugStatsHandler(cli *client.Client, api *DockerAPIMetrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+
+		name := r.PathValue("name")
+
+		var inspect container.InspectResponse
+		err := api.observe(ctx, "inspect", func() error {
+			var err error
+			inspect, err = cli.ContainerInspect(ctx, name)
+			return err
+		})
+		if errdefs.IsNotFound(err) {
+			http.Error(w, fmt.Sprintf("container '%s' not found", name), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("can't inspect container '%s': %v", name, err), http.StatusBadGateway)
+			return
+		}
+
+		stats, err := readContainerStats(ctx, cli, api, inspect.ID, false)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("can't read stats of container '%s': %v", name, err), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(stats); err != nil {
+			log.Error("can't write container stats: ", err)
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugStatsHandler(t *testing.T) {
+	cli := fakeDaemon(t, []container.Summary{{ID: "aaa", Names: []string{"/web"}}})
+
+	router := http.NewServeMux()
+	router.Handle("GET /debug/containers/{name}/stats", debugStatsHandler(cli, newDockerAPIMetrics()))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/containers/web/stats", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var stats container.StatsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, sampleStats(100, 200).MemoryStats.Usage, stats.MemoryStats.Usage)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/containers/db/stats", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
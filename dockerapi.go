@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// DockerAPIMetrics instruments calls to the Docker API, so slow scrapes can be
+// attributed to the daemon. It also limits the rate of the calls, so the
+// daemon isn't starved on hosts with many containers.
+type DockerAPIMetrics struct {
+	duration   *prometheus.HistogramVec
+	errors     *prometheus.CounterVec
+	throttled  prometheus.Counter
+	reconnects *prometheus.CounterVec
+
+	// limiter limits all calls, nil if unlimited
+	limiter *rate.Limiter
+}
+
+func newDockerAPIMetrics() *DockerAPIMetrics {
+	return &DockerAPIMetrics{
+		duration: prometheus.NewHistogramVec(withNativeHistogram(prometheus.HistogramOpts{
+			Name:    "dex_docker_api_request_duration_seconds",
+			Help:    metricHelp("dex_docker_api_request_duration_seconds"),
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}), []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dex_docker_api_errors_total",
+			Help: metricHelp("dex_docker_api_errors_total"),
+		}, []string{"operation"}),
+		throttled: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dex_docker_api_throttled_seconds_total",
+			Help: metricHelp("dex_docker_api_throttled_seconds_total"),
+		}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dex_docker_api_stream_reconnects_total",
+			Help: metricHelp("dex_docker_api_stream_reconnects_total"),
+		}, []string{"operation"}),
+	}
+}
+
+// withNativeHistogram adds a native histogram to the classic buckets. Scrapers
+// negotiating the protobuf format get both, text format clients only the
+// classic buckets.
+func withNativeHistogram(opts prometheus.HistogramOpts) prometheus.HistogramOpts {
+	if !envBool("DEX_NATIVE_HISTOGRAMS", true) {
+		return opts
+	}
+
+	// about 10% resolution, halved whenever there are more buckets
+	opts.NativeHistogramBucketFactor = 1.1
+	opts.NativeHistogramMaxBucketNumber = 100
+	opts.NativeHistogramMinResetDuration = time.Hour
+	return opts
+}
+
+// newRateLimiter returns a token bucket limiter allowing r requests per
+// second, or nil if r isn't positive. The burst defaults to r.
+func newRateLimiter(r, burst int) *rate.Limiter {
+	if r <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = r
+	}
+	return rate.NewLimiter(rate.Limit(r), burst)
+}
+
+type scrapeLimiterKey struct{}
+
+// withScrapeLimiter returns a context limiting the calls made with it in
+// addition to the limiter of the metrics, e.g. the calls of one scrape.
+func withScrapeLimiter(ctx context.Context, limiter *rate.Limiter) context.Context {
+	if limiter == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, scrapeLimiterKey{}, limiter)
+}
+
+// wait blocks until the rate limiters allow another call.
+func (m *DockerAPIMetrics) wait(ctx context.Context) error {
+	start := time.Now()
+	defer func() {
+		if d := time.Since(start); d > time.Millisecond {
+			m.throttled.Add(d.Seconds())
+		}
+	}()
+
+	if limiter, ok := ctx.Value(scrapeLimiterKey{}).(*rate.Limiter); ok {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if m.limiter != nil {
+		return m.limiter.Wait(ctx)
+	}
+	return nil
+}
+
+// observe runs the API call fn once the rate limiters allow it and records
+// its duration and result. It is safe to call on a nil receiver.
+func (m *DockerAPIMetrics) observe(ctx context.Context, operation string, fn func() error) error {
+	if m == nil {
+		return fn()
+	}
+
+	if err := m.wait(ctx); err != nil {
+		m.errors.WithLabelValues(operation).Inc()
+		return err
+	}
+
+	start := time.Now()
+	err := fn()
+	m.duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.errors.WithLabelValues(operation).Inc()
+	}
+	return err
+}
+
+// streamFailed counts a stream of the operation failing after it was opened
+// by observe. It is safe to call on a nil receiver.
+func (m *DockerAPIMetrics) streamFailed(operation string) {
+	if m != nil {
+		m.errors.WithLabelValues(operation).Inc()
+	}
+}
+
+// streamReconnected counts reopening a failed stream of the operation. It is
+// safe to call on a nil receiver.
+func (m *DockerAPIMetrics) streamReconnected(operation string) {
+	if m != nil {
+		m.reconnects.WithLabelValues(operation).Inc()
+	}
+}
+
+func (m *DockerAPIMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.duration.Describe(ch)
+	m.errors.Describe(ch)
+	m.throttled.Describe(ch)
+	m.reconnects.Describe(ch)
+}
+
+func (m *DockerAPIMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.duration.Collect(ch)
+	m.errors.Collect(ch)
+	m.throttled.Collect(ch)
+	m.reconnects.Collect(ch)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerAPIMetricsObserve(t *testing.T) {
+	m := newDockerAPIMetrics()
+
+	assert.NoError(t, m.observe(context.Background(), "inspect", func() error { return nil }))
+	assert.Error(t, m.observe(context.Background(), "inspect", func() error { return errors.New("no such container") }))
+	assert.NoError(t, m.observe(context.Background(), "list", func() error { return nil }))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(m.duration), "Expected one histogram per operation")
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.errors.WithLabelValues("inspect")))
+
+	var nilMetrics *DockerAPIMetrics
+	assert.NoError(t, nilMetrics.observe(context.Background(), "list", func() error { return nil }), "Nil metrics should still run the call")
+}
+
+func TestDockerAPIRateLimit(t *testing.T) {
+	assert.Nil(t, newRateLimiter(0, 5), "Rate limiting should be disabled by default")
+	assert.Equal(t, 20, newRateLimiter(20, 0).Burst(), "Burst should default to the rate")
+
+	m := newDockerAPIMetrics()
+	m.limiter = newRateLimiter(20, 1)
+	call := func() error { return nil }
+
+	start := time.Now()
+	for range 3 {
+		require.NoError(t, m.observe(context.Background(), "list", call))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond, "Calls should wait for the global limiter")
+	assert.Positive(t, testutil.ToFloat64(m.throttled))
+
+	// the scrape limiter applies only to the calls with its context
+	ctx, cancel := context.WithTimeout(withScrapeLimiter(context.Background(), newRateLimiter(1, 1)), 100*time.Millisecond)
+	defer cancel()
+	m.limiter = nil
+	require.NoError(t, m.observe(ctx, "inspect", call))
+	assert.Error(t, m.observe(ctx, "inspect", call), "The call can't be made before the deadline")
+	assert.NoError(t, m.observe(context.Background(), "inspect", call))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.errors.WithLabelValues("inspect")))
+}
+
+func TestNativeHistogramNegotiation(t *testing.T) {
+	api := newDockerAPIMetrics()
+	require.NoError(t, api.observe(context.Background(), "list", func() error { return nil }))
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(api)
+	handler := newMetricsHandler(reg, reg)
+
+	scrape := func(accept string) (*dto.MetricFamily, expfmt.Format) {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		format := expfmt.ResponseFormat(w.Result().Header)
+		decoder := expfmt.NewDecoder(w.Body, format)
+		for {
+			var family dto.MetricFamily
+			require.NoError(t, decoder.Decode(&family))
+			if family.GetName() == "dex_docker_api_request_duration_seconds" {
+				return &family, format
+			}
+		}
+	}
+
+	family, format := scrape(`application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited`)
+	assert.Equal(t, expfmt.TypeProtoDelim, format.FormatType())
+	histogram := family.Metric[0].GetHistogram()
+	assert.NotNil(t, histogram.Schema, "protobuf scrapes must get the native histogram")
+	assert.NotEmpty(t, histogram.Bucket, "the classic buckets are kept")
+
+	family, format = scrape("text/plain")
+	assert.Equal(t, expfmt.TypeTextPlain, format.FormatType())
+	histogram = family.Metric[0].GetHistogram()
+	assert.Nil(t, histogram.Schema, "the text format has no native histograms")
+	assert.NotEmpty(t, histogram.Bucket)
+}
+
+func TestMetricsHandlerGatherer(t *testing.T) {
+	reg, slow := prometheus.NewRegistry(), prometheus.NewRegistry()
+	slow.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "dex_slow"}, func() float64 { return 1 }))
+
+	scrape := func(handler http.Handler) string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	// both handlers count their scrapes in the main registry
+	assert.NotContains(t, scrape(newMetricsHandler(reg, reg)), "dex_slow")
+	assert.Contains(t, scrape(newMetricsHandler(reg, slow)), "dex_slow 1")
+	assert.Contains(t, scrape(newMetricsHandler(reg, reg)), `promhttp_metric_handler_requests_total{code="200"} 2`)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// envString returns an option from the environment or the configuration file.
+// The other env helpers also parse the value, invalid values are logged and
+// the default is used.
+func envString(name, def string) string {
+	if v, isSet := lookupOption(name); isSet {
+		return v
+	}
+	return def
+}
+
+func envBool(name string, def bool) bool {
+	v, isSet := lookupOption(name)
+	if !isSet {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Warnf("invalid boolean in %s '%s', using %v", name, v, def)
+		return def
+	}
+	return b
+}
+
+func envInt(name string, def int) int {
+	v, isSet := lookupOption(name)
+	if !isSet {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		log.Warnf("invalid integer in %s '%s', using %v", name, v, def)
+		return def
+	}
+	return i
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	v, isSet := lookupOption(name)
+	if !isSet {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Warnf("invalid duration in %s '%s', using %v", name, v, def)
+		return def
+	}
+	return d
+}
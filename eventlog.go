@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// eventLogActions are the container lifecycle events written to the event log.
+var eventLogActions = []events.Action{
+	events.ActionCreate,
+	events.ActionStart,
+	events.ActionRestart,
+	events.ActionStop,
+	events.ActionKill,
+	events.ActionDie,
+	events.ActionOOM,
+	events.ActionPause,
+	events.ActionUnPause,
+	events.ActionHealthStatus,
+	events.ActionDestroy,
+}
+
+// eventLogEntry is a line of the event log.
+type eventLogEntry struct {
+	Time          string            `json:"time"`
+	Action        string            `json:"action"`
+	ContainerID   string            `json:"container_id"`
+	ContainerName string            `json:"container_name"`
+	Image         string            `json:"image,omitempty"`
+	ExitCode      *int              `json:"exit_code,omitempty"`
+	Signal        string            `json:"signal,omitempty"`
+	Health        string            `json:"health,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+// EventLog writes the lifecycle events of the containers as JSON lines, an
+// audit trail for hosts without central logging which can be correlated with
+// the metrics by time and container name.
+type EventLog struct {
+	filter *containerFilter
+
+	mu  sync.Mutex
+	out io.WriteCloser
+}
+
+// newEventLog returns nil when the event log is not enabled. With "-" the
+// events are written to stdout, otherwise to the file at DEX_EVENT_LOG.
+func newEventLog(filter *containerFilter) *EventLog {
+	path := envString("DEX_EVENT_LOG", "")
+	if path == "" {
+		return nil
+	}
+
+	var out io.WriteCloser = nopCloser{os.Stdout}
+	if path != "-" {
+		file, err := openRotatingFile(path, envInt("DEX_EVENT_LOG_MAX_BYTES", 10<<20), envInt("DEX_EVENT_LOG_MAX_FILES", 5))
+		if err != nil {
+			log.Errorf("can't open event log, not writing events: %v", err)
+			configErrors.add(configKey("DEX_EVENT_LOG"))
+			return nil
+		}
+		out = file
+	}
+
+	return &EventLog{filter: filter, out: out}
+}
+
+// handle writes an event of a container matching the filters.
+func (l *EventLog) handle(_ context.Context, msg events.Message) {
+	cl, ok := l.filter.match(strings.TrimPrefix(msg.Actor.Attributes["name"], "/"))
+	if !ok {
+		return
+	}
+
+	entry := eventLogEntry{
+		Time:          time.Unix(0, msg.TimeNano).UTC().Format(time.RFC3339Nano),
+		Action:        string(msg.Action),
+		ContainerID:   msg.Actor.ID,
+		ContainerName: cl.values[0],
+		Image:         msg.Actor.Attributes["image"],
+		Signal:        msg.Actor.Attributes["signal"],
+	}
+	// e.g. "health_status: unhealthy"
+	if action, health, ok := strings.Cut(string(msg.Action), ":"); ok {
+		entry.Action, entry.Health = action, strings.TrimSpace(health)
+	}
+	if code, err := strconv.Atoi(msg.Actor.Attributes["exitCode"]); err == nil {
+		entry.ExitCode = &code
+	}
+	if len(cl.names) > 1 {
+		entry.Labels = map[string]string{}
+		for i, name := range cl.names[1:] {
+			entry.Labels[name] = cl.values[i+1]
+		}
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Error("can't encode event: ", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.out.Write(append(line, '\n')); err != nil {
+		log.Error("can't write event log: ", err)
+	}
+}
+
+func (l *EventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.out.Close()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// rotatingFile is a file which is renamed to path.1 once it would exceed
+// maxBytes, the older files move up to path.maxFiles and are then removed.
+type rotatingFile struct {
+	path     string
+	maxBytes int
+	maxFiles int
+
+	file *os.File
+	size int
+}
+
+func openRotatingFile(path string, maxBytes, maxFiles int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, int(info.Size())
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	// a single line larger than the limit still goes to an empty file
+	if f.maxBytes > 0 && f.size > 0 && f.size+len(p) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += n
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	// the file is reopened even if it couldn't be moved, so the log goes on
+	err := f.shift()
+	if openErr := f.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// shift moves the file to path.1 and the older files up by one.
+func (f *rotatingFile) shift() error {
+	if f.maxFiles <= 0 {
+		return os.Remove(f.path)
+	}
+
+	for i := f.maxFiles - 1; i > 0; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(f.path, f.path+".1")
+}
+
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
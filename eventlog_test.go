@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	t.Setenv("DEX_EVENT_LOG", path)
+	filter, err := newContainerFilter([]*FilterRule{{Match: `^app_.*`, Labels: map[string]string{"team": "shop"}}})
+	require.NoError(t, err)
+	l := newEventLog(filter)
+	require.NotNil(t, l)
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	event := func(action events.Action, attributes map[string]string) events.Message {
+		return events.Message{Action: action, TimeNano: at.UnixNano(), Actor: events.Actor{ID: "abc123", Attributes: attributes}}
+	}
+	l.handle(context.Background(), event(events.ActionStart, map[string]string{"name": "app_web", "image": "nginx:1.27"}))
+	l.handle(context.Background(), event("health_status: unhealthy", map[string]string{"name": "app_web"}))
+	l.handle(context.Background(), event(events.ActionDie, map[string]string{"name": "app_web", "exitCode": "137"}))
+	l.handle(context.Background(), event(events.ActionStart, map[string]string{"name": "other"}))
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `{"time":"2024-05-01T12:00:00Z","action":"start","container_id":"abc123","container_name":"app_web","image":"nginx:1.27","labels":{"team":"shop"}}
+{"time":"2024-05-01T12:00:00Z","action":"health_status","container_id":"abc123","container_name":"app_web","health":"unhealthy","labels":{"team":"shop"}}
+{"time":"2024-05-01T12:00:00Z","action":"die","container_id":"abc123","container_name":"app_web","exit_code":137,"labels":{"team":"shop"}}
+`, string(data), "containers not matching the filters are left out")
+}
+
+func TestEventLogDisabled(t *testing.T) {
+	assert.Nil(t, newEventLog(matchAllFilter()))
+
+	// the log isn't written if its directory doesn't exist
+	t.Setenv("DEX_EVENT_LOG", filepath.Join(t.TempDir(), "missing", "events.log"))
+	assert.Nil(t, newEventLog(matchAllFilter()))
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	f, err := openRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, "fourth\n", read(path))
+	assert.Equal(t, "third\n", read(path+".1"))
+	assert.Equal(t, "second\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3", "only maxFiles rotated files are kept")
+
+	// appends to an existing file
+	f, err = openRotatingFile(path, 100, 2)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("fifth\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.True(t, strings.HasPrefix(read(path), "fourth\n"))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// EventWatcher follows the container events of the Docker daemon and
+// dispatches them to the handlers registered for their action.
+type EventWatcher struct {
+	cli      *client.Client
+	api      *DockerAPIMetrics
+	handlers map[events.Action][]func(context.Context, events.Message)
+	// delay before reopening a failed stream
+	retry time.Duration
+}
+
+func newEventWatcher(cli *client.Client, api *DockerAPIMetrics) *EventWatcher {
+	return &EventWatcher{
+		cli:      cli,
+		api:      api,
+		handlers: map[events.Action][]func(context.Context, events.Message){},
+		retry:    5 * time.Second,
+	}
+}
+
+// handle registers a handler for container events with the action.
+func (w *EventWatcher) handle(action events.Action, handler func(context.Context, events.Message)) {
+	w.handlers[action] = append(w.handlers[action], handler)
+}
+
+// Run watches events until the context is done, reconnecting when the stream
+// fails. Only opening the stream is observed as an "events" API request, its
+// failures and reconnects are counted separately. It returns immediately when
+// no handlers are registered.
+func (w *EventWatcher) Run(ctx context.Context) {
+	if len(w.handlers) == 0 {
+		return
+	}
+
+	args := filters.NewArgs(filters.Arg("type", string(events.ContainerEventType)))
+	for action := range w.handlers {
+		args.Add("event", string(action))
+	}
+
+	for reconnect := false; ; reconnect = true {
+		if reconnect {
+			w.api.streamReconnected("events")
+		}
+
+		var messages <-chan events.Message
+		var errs <-chan error
+		err := w.api.observe(ctx, "events", func() error {
+			// Events returns once the stream is open or failed to open
+			messages, errs = w.cli.Events(ctx, events.ListOptions{Filters: args})
+			select {
+			case err := <-errs:
+				return err
+			default:
+				return nil
+			}
+		})
+		if err == nil {
+			err = w.watch(ctx, messages, errs)
+			if ctx.Err() == nil {
+				w.api.streamFailed("events")
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Error("docker event stream failed: ", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.retry):
+		}
+	}
+}
+
+// watch dispatches the events of an open stream until it fails or the
+// context is done.
+func (w *EventWatcher) watch(ctx context.Context, messages <-chan events.Message, errs <-chan error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			return err
+		case msg := <-messages:
+			w.dispatch(ctx, msg)
+		}
+	}
+}
+
+func (w *EventWatcher) dispatch(ctx context.Context, msg events.Message) {
+	// some actions carry a detail, e.g. "health_status: healthy"
+	action, _, _ := strings.Cut(string(msg.Action), ":")
+	for _, handler := range w.handlers[events.Action(action)] {
+		handler(ctx, msg)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventWatcherDispatch(t *testing.T) {
+	w := newEventWatcher(nil, nil)
+
+	var started, died []string
+	w.handle(events.ActionStart, func(_ context.Context, msg events.Message) {
+		started = append(started, msg.Actor.ID)
+	})
+	w.handle(events.ActionDie, func(_ context.Context, msg events.Message) {
+		died = append(died, msg.Actor.ID)
+	})
+
+	w.dispatch(context.Background(), events.Message{Action: events.ActionStart, Actor: events.Actor{ID: "a"}})
+	w.dispatch(context.Background(), events.Message{Action: events.ActionDie, Actor: events.Actor{ID: "b"}})
+	w.dispatch(context.Background(), events.Message{Action: events.ActionDestroy, Actor: events.Actor{ID: "c"}})
+
+	assert.Equal(t, []string{"a"}, started)
+	assert.Equal(t, []string{"b"}, died)
+}
+
+func TestEventWatcherDispatchWithDetail(t *testing.T) {
+	w := newEventWatcher(nil, nil)
+
+	var health []string
+	w.handle(events.ActionHealthStatus, func(_ context.Context, msg events.Message) {
+		health = append(health, string(msg.Action))
+	})
+
+	w.dispatch(context.Background(), events.Message{Action: "health_status: healthy"})
+	assert.Equal(t, []string{"health_status: healthy"}, health)
+}
+
+func TestEventWatcherWithoutHandlers(t *testing.T) {
+	// returns without connecting to the daemon
+	newEventWatcher(nil, nil).Run(context.Background())
+}
+
+func TestEventWatcherObservesStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/events") {
+			http.NotFound(w, r)
+			return
+		}
+		// the stream ends after an event, like a restarting daemon
+		json.NewEncoder(w).Encode(events.Message{Type: events.ContainerEventType, Action: events.ActionStart, Actor: events.Actor{ID: "a"}})
+	}))
+	defer server.Close()
+
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.45"))
+	require.NoError(t, err)
+
+	api := newDockerAPIMetrics()
+	w := newEventWatcher(cli, api)
+	w.retry = 10 * time.Millisecond
+	started := make(chan string, 1)
+	w.handle(events.ActionStart, func(_ context.Context, msg events.Message) {
+		started <- msg.Actor.ID
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	assert.Equal(t, "a", <-started)
+	assert.Equal(t, "a", <-started, "The stream should be reopened")
+	cancel()
+	<-done
+
+	// the streams lasted until they failed, only opening them is observed
+	var m dto.Metric
+	require.NoError(t, api.duration.WithLabelValues("events").(prometheus.Histogram).Write(&m))
+	opened := m.GetHistogram().GetSampleCount()
+	assert.GreaterOrEqual(t, opened, uint64(2))
+	assert.GreaterOrEqual(t, testutil.ToFloat64(api.reconnects.WithLabelValues("events")), float64(opened-1))
+	assert.GreaterOrEqual(t, testutil.ToFloat64(api.errors.WithLabelValues("events")), float64(opened-1),
+		"The ended streams should be counted as failed")
+}
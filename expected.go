@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// ExpectedContainer is a container which must exist on the host in any state.
+type ExpectedContainer struct {
+	// Name is the container name, or only the name it is exported as when
+	// Labels is set
+	Name string `yaml:"name"`
+	// Labels select the container by its docker labels instead of the name,
+	// an empty value matches any value of the label
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+func (e *ExpectedContainer) matches(cont container.Summary) bool {
+	if len(e.Labels) == 0 {
+		for _, name := range cont.Names {
+			if strings.TrimPrefix(name, "/") == e.Name {
+				return true
+			}
+		}
+		return false
+	}
+
+	for name, value := range e.Labels {
+		v, ok := cont.Labels[name]
+		if !ok || (value != "" && v != value) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateExpectedContainers(expected []*ExpectedContainer) error {
+	seen := map[string]bool{}
+	for i, e := range expected {
+		if e.Name == "" {
+			return fmt.Errorf("container %d: missing name", i+1)
+		}
+		if seen[e.Name] {
+			return fmt.Errorf("container %d: duplicate name '%s'", i+1, e.Name)
+		}
+		seen[e.Name] = true
+		for name := range e.Labels {
+			if name == "" {
+				return fmt.Errorf("container %d: empty label name", i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// ExpectedContainers exports whether the containers declared in the
+// expected_containers configuration exist. A removed container has no state
+// metrics anymore, so only an explicit list can tell it is missing.
+type ExpectedContainers struct {
+	cli      *client.Client
+	api      *DockerAPIMetrics
+	expected []*ExpectedContainer
+}
+
+// newExpectedContainers returns nil when no containers are expected.
+func newExpectedContainers(cli *client.Client, api *DockerAPIMetrics) *ExpectedContainers {
+	if len(config.ExpectedContainers) == 0 {
+		return nil
+	}
+	if err := validateExpectedContainers(config.ExpectedContainers); err != nil {
+		log.Errorf("invalid expected_containers, not checking them: %v", err)
+		configErrors.add("expected_containers")
+		return nil
+	}
+	return &ExpectedContainers{cli: cli, api: api, expected: config.ExpectedContainers}
+}
+
+func (e *ExpectedContainers) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (e *ExpectedContainers) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+
+	// stopped containers exist too, whatever DEX_CONTAINER_STATES lists
+	var containers []container.Summary
+	err := e.api.observe(ctx, "list", func() error {
+		var err error
+		containers, err = e.cli.ContainerList(ctx, container.ListOptions{All: true})
+		return err
+	})
+	if err != nil {
+		log.Error("can't list containers for expected containers: ", err)
+		return
+	}
+
+	for _, expected := range e.expected {
+		missing := 1.0
+		for _, cont := range containers {
+			if expected.matches(cont) {
+				missing = 0
+				break
+			}
+		}
+
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_expected_container_missing",
+			[]string{"name"},
+		), prometheus.GaugeValue, missing, expected.Name)
+	}
+}
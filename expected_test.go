@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpectedContainers(t *testing.T) {
+	cli := fakeDaemon(t, []container.Summary{
+		{ID: "aaa", Names: []string{"/web"}, State: "running"},
+		{ID: "bbb", Names: []string{"/db"}, State: "exited", Labels: map[string]string{"com.docker.compose.service": "db"}},
+	})
+	e := &ExpectedContainers{cli: cli, api: newDockerAPIMetrics(), expected: []*ExpectedContainer{
+		{Name: "web"},
+		{Name: "backup-agent"},
+		{Name: "database", Labels: map[string]string{"com.docker.compose.service": "db"}},
+		{Name: "compose", Labels: map[string]string{"com.docker.compose.service": ""}},
+		{Name: "cache", Labels: map[string]string{"com.docker.compose.service": "redis"}},
+	}}
+
+	expected := `
+# HELP dex_expected_container_missing 1 if no container matches the expected container, 0 otherwise
+# TYPE dex_expected_container_missing gauge
+dex_expected_container_missing{name="backup-agent"} 1
+dex_expected_container_missing{name="cache"} 1
+dex_expected_container_missing{name="compose"} 0
+dex_expected_container_missing{name="database"} 0
+dex_expected_container_missing{name="web"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(e, strings.NewReader(expected)))
+}
+
+func TestValidateExpectedContainers(t *testing.T) {
+	assert.NoError(t, validateExpectedContainers([]*ExpectedContainer{{Name: "web"}, {Name: "db", Labels: map[string]string{"role": "db"}}}))
+	assert.ErrorContains(t, validateExpectedContainers([]*ExpectedContainer{{Labels: map[string]string{"role": "db"}}}), "missing name")
+	assert.ErrorContains(t, validateExpectedContainers([]*ExpectedContainer{{Name: "web"}, {Name: "web"}}), "duplicate name 'web'")
+}
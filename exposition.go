@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	// offers zstd to scrapers accepting it, next to gzip
+	_ "github.com/prometheus/client_golang/prometheus/promhttp/zstd"
+)
+
+// ExpositionMetrics measures the size and serialization time of the metrics
+// responses, to size scrape bandwidth on constrained links and to decide
+// between gzip and zstd for large expositions.
+type ExpositionMetrics struct {
+	size          *prometheus.HistogramVec
+	serialization *prometheus.HistogramVec
+}
+
+func newExpositionMetrics() *ExpositionMetrics {
+	return &ExpositionMetrics{
+		size: prometheus.NewHistogramVec(withNativeHistogram(prometheus.HistogramOpts{
+			Name:    "dex_metrics_response_size_bytes",
+			Help:    metricHelp("dex_metrics_response_size_bytes"),
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+		}), []string{"path", "encoding"}),
+		serialization: prometheus.NewHistogramVec(withNativeHistogram(prometheus.HistogramOpts{
+			Name:    "dex_metrics_serialization_duration_seconds",
+			Help:    metricHelp("dex_metrics_serialization_duration_seconds"),
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}), []string{"path", "encoding"}),
+	}
+}
+
+func (m *ExpositionMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.size.Describe(ch)
+	m.serialization.Describe(ch)
+}
+
+func (m *ExpositionMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.size.Collect(ch)
+	m.serialization.Collect(ch)
+}
+
+// Wrap returns a handler measuring the responses of next. The metrics are
+// gathered before the first byte is written, so the time from the first write
+// to the end of the response is the serialization.
+func (m *ExpositionMetrics) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &countingWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+		if cw.firstWrite.IsZero() {
+			return
+		}
+
+		encoding := w.Header().Get("Content-Encoding")
+		if encoding == "" {
+			encoding = "identity"
+		}
+		m.size.WithLabelValues(r.URL.Path, encoding).Observe(float64(cw.bytes))
+		m.serialization.WithLabelValues(r.URL.Path, encoding).Observe(time.Since(cw.firstWrite).Seconds())
+	})
+}
+
+// countingWriter counts the bytes written to the response.
+type countingWriter struct {
+	http.ResponseWriter
+	bytes      int
+	firstWrite time.Time
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.firstWrite.IsZero() {
+		w.firstWrite = time.Now()
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += n
+	return n, err
+}
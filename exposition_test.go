@@ -0,0 +1,59 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpositionMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "dex_test", Help: "Test gauge"}, func() float64 { return 1 }))
+	exposition := newExpositionMetrics()
+	handler := exposition.Wrap(newMetricsHandler(reg, reg))
+
+	scrape := func(acceptEncoding string) (string, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Header().Get("Content-Encoding"), w
+	}
+
+	encoding, w := scrape("zstd")
+	assert.Equal(t, "zstd", encoding)
+	decoder, err := zstd.NewReader(w.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(decoder)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "dex_test 1")
+
+	encoding, w = scrape("gzip")
+	assert.Equal(t, "gzip", encoding)
+	reader, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	body, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "dex_test 1")
+
+	encoding, w = scrape("")
+	assert.Empty(t, encoding)
+	size := w.Body.Len()
+
+	assert.Equal(t, 3, testutil.CollectAndCount(exposition, "dex_metrics_response_size_bytes"))
+	assert.Equal(t, 3, testutil.CollectAndCount(exposition, "dex_metrics_serialization_duration_seconds"))
+
+	var pb dto.Metric
+	require.NoError(t, exposition.size.WithLabelValues("/metrics", "identity").(prometheus.Metric).Write(&pb))
+	assert.Equal(t, float64(size), pb.GetHistogram().GetSampleSum())
+}
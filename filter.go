@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+)
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// FilterRule selects containers by name, optionally renames them and attaches
+// static labels to their metrics.
+type FilterRule struct {
+	// Match is a regexp matched against the container name
+	Match string `yaml:"match" json:"match"`
+	// Name is the template of container_name with $1 or ${name} referencing
+	// submatches, the last submatch is used if empty
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	// Labels are templates like Name, e.g. to move a replica number from
+	// the name into a label
+	Labels map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	// Drop skips the matching containers, e.g. to exclude some of them
+	// before a catch-all rule
+	Drop bool `yaml:"drop,omitempty" json:"drop,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// LabelRule attaches static labels to the metrics of the containers matching
+// it, independently of the filter rules.
+type LabelRule struct {
+	// Match is a regexp matched against the container name
+	Match string `yaml:"match"`
+	// Labels can reference submatches of Match like FilterRule.Labels
+	Labels map[string]string `yaml:"labels"`
+
+	re *regexp.Regexp
+}
+
+// containerFilter evaluates the rules in order, the first matching rule
+// decides the labels of a container. Containers matching no rule are skipped.
+// The rules can be replaced at runtime, the label names are fixed.
+type containerFilter struct {
+	mu    sync.RWMutex
+	rules []*FilterRule
+	// all matching label rules apply, later ones override earlier ones
+	labelRules []*LabelRule
+	// union of the static label names of all rules, so all containers have
+	// the same label names
+	labelNames []string
+	// sanitizer is applied to the name and label values of containers
+	sanitizer *labelSanitizer
+}
+
+// reservedLabelNames are the labels DEX adds itself, a static label with one
+// of these names would duplicate a label of some metrics and fail the scrape.
+var reservedLabelNames = func() map[string]bool {
+	names := map[string]bool{
+		"container_name": true,
+		"container_id":   true,
+		"image":          true,
+		"docker_host":    true,
+		// the scripts name their metrics themselves
+		"script": true,
+	}
+	for _, def := range metricDefs {
+		for _, name := range def.labels {
+			names[name] = true
+		}
+	}
+	return names
+}()
+
+// checkLabelName returns an error if name can't be a static container label.
+func checkLabelName(name string) error {
+	if !labelNameRe.MatchString(name) {
+		return fmt.Errorf("invalid label name '%s'", name)
+	}
+	if reservedLabelNames[name] {
+		return fmt.Errorf("label '%s' is reserved", name)
+	}
+	return nil
+}
+
+func newContainerFilter(rules []*FilterRule) (*containerFilter, error) {
+	f := &containerFilter{rules: rules}
+
+	seen := map[string]bool{}
+	for i, rule := range rules {
+		re, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		rule.re = re
+
+		for name := range rule.Labels {
+			if err := checkLabelName(name); err != nil {
+				return nil, fmt.Errorf("rule %d: %v", i+1, err)
+			}
+			if !seen[name] {
+				seen[name] = true
+				f.labelNames = append(f.labelNames, name)
+			}
+		}
+	}
+	sort.Strings(f.labelNames)
+
+	return f, nil
+}
+
+// withLabelRules adds the label rules to the filter. The filter is left
+// unchanged if a rule is invalid.
+func (f *containerFilter) withLabelRules(rules []*LabelRule) error {
+	names := slices.Clone(f.labelNames)
+	for i, rule := range rules {
+		re, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		rule.re = re
+
+		for name := range rule.Labels {
+			if err := checkLabelName(name); err != nil {
+				return fmt.Errorf("rule %d: %v", i+1, err)
+			}
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	f.labelRules = append(f.labelRules, rules...)
+	f.labelNames = names
+	return nil
+}
+
+// currentRules returns the rules the filter evaluates.
+func (f *containerFilter) currentRules() []*FilterRule {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.rules
+}
+
+// checkRules returns an error if the rules are invalid or need label names
+// the filter doesn't have. The event counters are created with the label
+// names, so adding one requires a restart.
+func (f *containerFilter) checkRules(rules []*FilterRule) error {
+	compiled, err := newContainerFilter(rules)
+	if err != nil {
+		return err
+	}
+	for _, name := range compiled.labelNames {
+		if !slices.Contains(f.labelNames, name) {
+			return fmt.Errorf("label '%s' is new, adding labels requires a restart", name)
+		}
+	}
+	return nil
+}
+
+// setRules replaces the rules with ones passing checkRules.
+func (f *containerFilter) setRules(rules []*FilterRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = rules
+}
+
+// matchAllFilter collects all containers under their names.
+func matchAllFilter() *containerFilter {
+	f, _ := newContainerFilter([]*FilterRule{{Match: ".*"}})
+	return f
+}
+
+// match returns the labels of a container, or false if it is filtered out.
+func (f *containerFilter) match(cName string) (containerLabels, bool) {
+	cl, _, ok := f.matchRule(cName)
+	return cl, ok
+}
+
+// matchRule is match also returning the index of the rule deciding about the
+// container, -1 if no rule matches.
+func (f *containerFilter) matchRule(cName string) (containerLabels, int, bool) {
+	for i, rule := range f.currentRules() {
+		submatches := rule.re.FindStringSubmatchIndex(cName)
+		if submatches == nil {
+			continue
+		}
+		if rule.Drop {
+			return containerLabels{}, i, false
+		}
+
+		var name string
+		if rule.Name != "" {
+			name = string(rule.re.ExpandString(nil, rule.Name, cName, submatches))
+		} else {
+			// like FindStringSubmatch, a submatch which didn't participate is empty
+			if last := len(submatches) - 2; submatches[last] >= 0 {
+				name = cName[submatches[last]:submatches[last+1]]
+			}
+		}
+
+		labels := map[string]string{}
+		for labelName, value := range rule.Labels {
+			labels[labelName] = string(rule.re.ExpandString(nil, value, cName, submatches))
+		}
+		for _, labelRule := range f.labelRules {
+			if m := labelRule.re.FindStringSubmatchIndex(cName); m != nil {
+				for labelName, value := range labelRule.Labels {
+					labels[labelName] = string(labelRule.re.ExpandString(nil, value, cName, m))
+				}
+			}
+		}
+
+		cl := newContainerLabels(f.sanitizer.sanitize(name))
+		for _, labelName := range f.labelNames {
+			cl = cl.with(labelName, f.sanitizer.sanitize(labels[labelName]))
+		}
+		return cl, i, true
+	}
+	return containerLabels{}, -1, false
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContainerFilter(t *testing.T) {
+	f, err := newContainerFilter([]*FilterRule{
+		{Match: `^payments_(?P<service>.+)$`, Name: "pay-${service}", Labels: map[string]string{"team": "payments"}},
+		{Match: `^(monitoring)_.*$`, Labels: map[string]string{"team": "sre", "tier": "infra"}},
+		{Match: `^web_(\d+)$`},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team", "tier"}, f.labelNames)
+
+	tests := []struct {
+		cName    string
+		expected map[string]string
+	}{
+		{"payments_api", map[string]string{"container_name": "pay-api", "team": "payments", "tier": ""}},
+		{"monitoring_grafana", map[string]string{"container_name": "monitoring", "team": "sre", "tier": "infra"}},
+		{"web_1", map[string]string{"container_name": "1", "team": "", "tier": ""}},
+		{"db", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cName, func(t *testing.T) {
+			cl, ok := f.match(tt.cName)
+			if tt.expected == nil {
+				assert.False(t, ok, "Container should be filtered out")
+				return
+			}
+			require.True(t, ok)
+
+			labels := map[string]string{}
+			for i, name := range cl.names {
+				labels[name] = cl.values[i]
+			}
+			assert.Equal(t, tt.expected, labels)
+		})
+	}
+}
+
+func TestContainerFilterFirstRuleWins(t *testing.T) {
+	f, err := newContainerFilter([]*FilterRule{
+		{Match: `^app_`, Labels: map[string]string{"team": "a"}},
+		{Match: `.*`, Labels: map[string]string{"team": "b"}},
+	})
+	require.NoError(t, err)
+
+	cl, ok := f.match("app_1")
+	require.True(t, ok)
+	assert.Equal(t, []string{"app_", "a"}, cl.values)
+}
+
+func TestContainerFilterInvalid(t *testing.T) {
+	_, err := newContainerFilter([]*FilterRule{{Match: "("}})
+	assert.ErrorContains(t, err, "rule 1")
+
+	_, err = newContainerFilter([]*FilterRule{{Match: ".*", Labels: map[string]string{"bad-name": "x"}}})
+	assert.ErrorContains(t, err, "invalid label name")
+
+	_, err = newContainerFilter([]*FilterRule{{Match: ".*", Labels: map[string]string{"container_id": "x"}}})
+	assert.ErrorContains(t, err, "reserved")
+
+	// dex_container_mounts has a type label, a second one fails the scrape
+	_, err = newContainerFilter([]*FilterRule{{Match: ".*", Labels: map[string]string{"type": "web"}}})
+	assert.ErrorContains(t, err, "label 'type' is reserved")
+
+	_, err = newContainerFilter([]*FilterRule{{Match: ".*", Labels: map[string]string{"script": "x"}}})
+	assert.ErrorContains(t, err, "reserved")
+}
+
+func TestContainerFilterLabelRules(t *testing.T) {
+	f, err := newContainerFilter([]*FilterRule{
+		{Match: `^payments_(.*)$`, Labels: map[string]string{"team": "payments"}},
+		{Match: `.*`},
+	})
+	require.NoError(t, err)
+	require.NoError(t, f.withLabelRules([]*LabelRule{
+		{Match: `.*`, Labels: map[string]string{"env": "prod"}},
+		{Match: `db$`, Labels: map[string]string{"tier": "db", "team": "dba"}},
+	}))
+	assert.Equal(t, []string{"env", "team", "tier"}, f.labelNames)
+
+	cl, ok := f.match("payments_api")
+	require.True(t, ok)
+	assert.Equal(t, []string{"api", "prod", "payments", ""}, cl.values)
+
+	cl, ok = f.match("payments_db")
+	require.True(t, ok)
+	assert.Equal(t, []string{"db", "prod", "dba", "db"}, cl.values, "Label rules should override the filter rule")
+
+	cl, ok = f.match("cache")
+	require.True(t, ok)
+	assert.Equal(t, []string{"cache", "prod", "", ""}, cl.values)
+}
+
+func TestContainerFilterInvalidLabelRules(t *testing.T) {
+	f := matchAllFilter()
+
+	assert.ErrorContains(t, f.withLabelRules([]*LabelRule{
+		{Match: `.*`, Labels: map[string]string{"env": "prod"}},
+		{Match: `(`},
+	}), "rule 2")
+	assert.ErrorContains(t, f.withLabelRules([]*LabelRule{{Match: `.*`, Labels: map[string]string{"image": "x"}}}), "reserved")
+	for _, name := range []string{"type", "mode", "name"} {
+		assert.ErrorContains(t, f.withLabelRules([]*LabelRule{{Match: `.*`, Labels: map[string]string{name: "x"}}}), "reserved", name)
+	}
+
+	cl, ok := f.match("web")
+	require.True(t, ok)
+	assert.Equal(t, []string{"web"}, cl.values, "Invalid rules should leave the filter unchanged")
+}
+
+func TestContainerFilterLabelTemplates(t *testing.T) {
+	f, err := newContainerFilter([]*FilterRule{
+		// compose v2 names scaled containers <project>-<service>-<n>
+		{Match: `^(?P<project>[^-]+)-(?P<service>.+)-(?P<replica>\d+)$`, Name: "${service}", Labels: map[string]string{"replica": "${replica}", "project": "$project"}},
+		{Match: `.*`},
+	})
+	require.NoError(t, err)
+	require.NoError(t, f.withLabelRules([]*LabelRule{{Match: `^(\w+)-`, Labels: map[string]string{"stack": "${1}-stack"}}}))
+
+	for _, tt := range []struct {
+		cName    string
+		expected []string
+	}{
+		{"shop-web-1", []string{"web", "shop", "1", "shop-stack"}},
+		{"shop-web-2", []string{"web", "shop", "2", "shop-stack"}},
+		{"shop-pg-replica-12", []string{"pg-replica", "shop", "12", "shop-stack"}},
+		{"standalone", []string{"standalone", "", "", ""}},
+	} {
+		cl, ok := f.match(tt.cName)
+		require.True(t, ok)
+		assert.Equal(t, tt.expected, cl.values, tt.cName)
+	}
+}
+
+func TestContainerFilterDrop(t *testing.T) {
+	f, err := newContainerFilter([]*FilterRule{
+		{Match: `^ci_`, Drop: true},
+		{Match: `.*`},
+	})
+	require.NoError(t, err)
+
+	_, ok := f.match("ci_runner")
+	assert.False(t, ok)
+	_, ok = f.match("web")
+	assert.True(t, ok)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"dex/api"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const grpcMinInterval = time.Second
+
+// MetricsServer streams collected metrics to gRPC clients, see api/dex.proto.
+// The metrics are gathered once per interval and sent to all streams, so the
+// number of clients doesn't multiply the requests to the Docker daemon.
+type MetricsServer struct {
+	api.UnimplementedMetricsServer
+
+	gatherer prometheus.Gatherer
+	interval time.Duration
+
+	mu       sync.Mutex
+	watchers int
+	latest   *metricsSnapshot
+	// wakes the gather loop when a stream starts without a snapshot
+	wake chan struct{}
+}
+
+// metricsSnapshot is a gathering of the metrics shared by all streams.
+type metricsSnapshot struct {
+	families []*dto.MetricFamily
+	time     time.Time
+	// closed when the next snapshot is gathered
+	next chan struct{}
+}
+
+func newMetricsServer(gatherer prometheus.Gatherer, interval time.Duration) *MetricsServer {
+	return &MetricsServer{
+		gatherer: gatherer,
+		interval: max(interval, grpcMinInterval),
+		latest:   &metricsSnapshot{next: make(chan struct{})},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// startGRPCServer returns nil when the gRPC API is not enabled. The streams
+// are restricted like the HTTP endpoints by the access list and
+// DEX_MAX_REQUESTS_IN_FLIGHT.
+func startGRPCServer(ctx context.Context, gatherer prometheus.Gatherer, access *AccessList) *grpc.Server {
+	addr := envString("DEX_GRPC_LISTEN", "")
+	if addr == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("can't listen for grpc on %s: %v", addr, err)
+	}
+
+	metrics := newMetricsServer(gatherer, envDuration("DEX_GRPC_INTERVAL", 15*time.Second))
+	go metrics.run(ctx)
+
+	server := grpc.NewServer(grpc.ChainStreamInterceptor(
+		allowStreams(access),
+		limitStreams(envInt("DEX_MAX_REQUESTS_IN_FLIGHT", 0)),
+	))
+	api.RegisterMetricsServer(server, metrics)
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	go func() {
+		log.Info("gRPC server is ready to handle requests at ", addr)
+		if err := server.Serve(listener); err != nil {
+			log.Error("grpc server failed: ", err)
+		}
+	}()
+
+	return server
+}
+
+// allowStreams rejects the streams of clients outside the access list.
+func allowStreams(access *AccessList) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if p, ok := peer.FromContext(stream.Context()); ok && !access.allowsAddr(p.Addr) {
+			log.Warnf("denied %s from %s", info.FullMethod, p.Addr)
+			return status.Error(codes.PermissionDenied, "forbidden")
+		}
+		return handler(srv, stream)
+	}
+}
+
+// limitStreams rejects streams when limit streams are already open,
+// unlimited if 0.
+func limitStreams(limit int) grpc.StreamServerInterceptor {
+	var slots chan struct{}
+	if limit > 0 {
+		slots = make(chan struct{}, limit)
+	}
+	return func(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if slots == nil {
+			return handler(srv, stream)
+		}
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			return handler(srv, stream)
+		default:
+			return status.Error(codes.ResourceExhausted, "too many streams")
+		}
+	}
+}
+
+// run gathers the metrics every interval while streams are open.
+func (s *MetricsServer) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+
+		s.mu.Lock()
+		watched := s.watchers > 0
+		s.mu.Unlock()
+		if watched {
+			s.gather()
+		}
+	}
+}
+
+func (s *MetricsServer) gather() {
+	families, err := s.gatherer.Gather()
+	if err != nil {
+		log.Error("can't gather metrics for grpc: ", err)
+	}
+
+	s.mu.Lock()
+	last := s.latest
+	s.latest = &metricsSnapshot{families: families, time: time.Now(), next: make(chan struct{})}
+	s.mu.Unlock()
+	close(last.next)
+}
+
+// subscribe returns the latest snapshot for a new stream. Without one the
+// loop is woken to gather it right away.
+func (s *MetricsServer) subscribe() *metricsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.watchers++
+	if s.latest.time.IsZero() {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	return s.latest
+}
+
+func (s *MetricsServer) unsubscribe() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.watchers--
+	if s.watchers == 0 {
+		// the next stream must not start with a stale snapshot
+		s.latest = &metricsSnapshot{next: make(chan struct{})}
+	}
+}
+
+func (s *MetricsServer) current() *metricsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest
+}
+
+// Watch sends the snapshots of the gather loop. A stream asking for a longer
+// interval than the server gets every nth snapshot.
+func (s *MetricsServer) Watch(req *api.WatchRequest, stream api.Metrics_WatchServer) error {
+	every := 1
+	if req.GetIntervalSeconds() > 0 {
+		interval := time.Duration(req.GetIntervalSeconds()) * time.Second
+		every = max(int((interval+s.interval-1)/s.interval), 1)
+	}
+
+	snapshot := s.subscribe()
+	defer s.unsubscribe()
+
+	// the snapshots gathered since the last update sent
+	gathered := every
+	for {
+		if !snapshot.time.IsZero() && gathered >= every {
+			if err := stream.Send(metricsUpdate(snapshot.families, req, snapshot.time)); err != nil {
+				return err
+			}
+			gathered = 0
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-snapshot.next:
+		}
+		snapshot = s.current()
+		gathered++
+	}
+}
+
+func metricsUpdate(families []*dto.MetricFamily, req *api.WatchRequest, now time.Time) *api.MetricsUpdate {
+	containers := map[string]bool{}
+	for _, container := range req.GetContainers() {
+		containers[container] = true
+	}
+	metrics := map[string]bool{}
+	for _, metric := range req.GetMetrics() {
+		if !strings.HasPrefix(metric, "dex_") {
+			metric = "dex_" + metric
+		}
+		metrics[metric] = true
+	}
+
+	update := &api.MetricsUpdate{Timestamp: timestamppb.New(now)}
+	for _, mf := range families {
+		if len(metrics) > 0 && !metrics[mf.GetName()] {
+			continue
+		}
+
+		sampleType := api.Sample_UNTYPED
+		switch mf.GetType() {
+		case dto.MetricType_GAUGE:
+			sampleType = api.Sample_GAUGE
+		case dto.MetricType_COUNTER:
+			sampleType = api.Sample_COUNTER
+		}
+
+		for _, m := range mf.GetMetric() {
+			sample := &api.Sample{
+				Metric: mf.GetName(),
+				Type:   sampleType,
+				Value:  metricValue(m),
+			}
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == "container_name" {
+					sample.Container = lp.GetValue()
+					continue
+				}
+				if sample.Labels == nil {
+					sample.Labels = map[string]string{}
+				}
+				sample.Labels[lp.GetName()] = lp.GetValue()
+			}
+
+			if len(containers) > 0 && !containers[sample.Container] {
+				continue
+			}
+			update.Samples = append(update.Samples, sample)
+		}
+	}
+
+	return update
+}
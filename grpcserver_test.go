@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dex/api"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestMetricsUpdateFilters(t *testing.T) {
+	families := []*dto.MetricFamily{
+		gaugeFamily("dex_cpu_utilization_percent", "web", 12.5),
+		gaugeFamily("dex_cpu_utilization_percent", "db", 50),
+		counterFamily("dex_network_rx_bytes_total", "web", 1024),
+	}
+
+	update := metricsUpdate(families, &api.WatchRequest{Containers: []string{"web"}, Metrics: []string{"cpu_utilization_percent"}}, time.Unix(1700000000, 0))
+	require.Len(t, update.Samples, 1)
+	assert.Equal(t, "dex_cpu_utilization_percent", update.Samples[0].Metric)
+	assert.Equal(t, "web", update.Samples[0].Container)
+	assert.Equal(t, api.Sample_GAUGE, update.Samples[0].Type)
+	assert.Equal(t, 12.5, update.Samples[0].Value)
+	assert.Equal(t, int64(1700000000), update.Timestamp.GetSeconds())
+
+	update = metricsUpdate(families, &api.WatchRequest{}, time.Now())
+	assert.Len(t, update.Samples, 3, "Empty filters should return all samples")
+}
+
+// watchBufconn serves the metrics server over an in-memory listener and
+// returns a client of it.
+func watchBufconn(t *testing.T, metrics *MetricsServer, opts ...grpc.ServerOption) api.MetricsClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(opts...)
+	api.RegisterMetricsServer(server, metrics)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return api.NewMetricsClient(conn)
+}
+
+func TestMetricsServerWatch(t *testing.T) {
+	var gathers atomic.Int32
+	metrics := newMetricsServer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		gathers.Add(1)
+		return []*dto.MetricFamily{gaugeFamily("dex_container_running", "web", 1)}, nil
+	}), time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go metrics.run(ctx)
+	client := watchBufconn(t, metrics)
+
+	stream, err := client.Watch(ctx, &api.WatchRequest{})
+	require.NoError(t, err)
+	update, err := stream.Recv()
+	require.NoError(t, err, "Expected the first update immediately")
+	require.Len(t, update.Samples, 1)
+	assert.Equal(t, "dex_container_running", update.Samples[0].Metric)
+
+	// the streams share the gathers of the server
+	other, err := client.Watch(ctx, &api.WatchRequest{})
+	require.NoError(t, err)
+	second, err := other.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, update.Timestamp.AsTime(), second.Timestamp.AsTime(), "A new stream should get the latest gather")
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	_, err = other.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), gathers.Load(), "Streams shouldn't gather on their own")
+}
+
+func TestMetricsServerWatchInterval(t *testing.T) {
+	metrics := newMetricsServer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return nil, nil
+	}), time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go metrics.run(ctx)
+	client := watchBufconn(t, metrics)
+
+	stream, err := client.Watch(ctx, &api.WatchRequest{IntervalSeconds: 2})
+	require.NoError(t, err)
+	first, err := stream.Recv()
+	require.NoError(t, err)
+	second, err := stream.Recv()
+	require.NoError(t, err)
+	assert.InDelta(t, 2*time.Second, second.Timestamp.AsTime().Sub(first.Timestamp.AsTime()), float64(500*time.Millisecond),
+		"Every second gather should be sent")
+}
+
+func TestMetricsServerLimits(t *testing.T) {
+	metrics := newMetricsServer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return nil, nil
+	}), time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go metrics.run(ctx)
+
+	// bufconn clients have no TCP address and are allowed like unix socket clients
+	allowed, err := parseCIDRs("10.0.0.0/8")
+	require.NoError(t, err)
+	access := &AccessList{allowed: allowed}
+	assert.True(t, access.allowsAddr(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}))
+	assert.False(t, access.allowsAddr(&net.TCPAddr{IP: net.ParseIP("192.168.1.6")}))
+	assert.True(t, (*AccessList)(nil).allowsAddr(&net.TCPAddr{IP: net.ParseIP("192.168.1.6")}))
+
+	client := watchBufconn(t, metrics, grpc.ChainStreamInterceptor(allowStreams(access), limitStreams(1)))
+	stream, err := client.Watch(ctx, &api.WatchRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	other, err := client.Watch(ctx, &api.WatchRequest{})
+	require.NoError(t, err)
+	_, err = other.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "Streams over the limit should be rejected")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// collectionChecker is implemented by the collectors tracking their
+// successful collections.
+type collectionChecker interface {
+	succeededSince(t time.Time) bool
+}
+
+// Heartbeat posts to a dead man's switch like healthchecks.io while the
+// containers are collected successfully, so a dead dex or Docker daemon is
+// noticed even when Prometheus can't reach the host. When no collection
+// succeeded within the interval, e.g. because nothing scrapes dex, the
+// containers are collected for the heartbeat.
+type Heartbeat struct {
+	url        string
+	interval   time.Duration
+	collector  prometheus.Collector
+	checker    collectionChecker
+	httpClient *http.Client
+}
+
+// newHeartbeat returns nil when no heartbeat URL is configured.
+func newHeartbeat(collector prometheus.Collector, checker collectionChecker) *Heartbeat {
+	heartbeatURL := envString("DEX_HEARTBEAT_URL", "")
+	if heartbeatURL == "" {
+		return nil
+	}
+
+	interval := envDuration("DEX_HEARTBEAT_INTERVAL", time.Minute)
+	if interval <= 0 {
+		log.Errorf("invalid DEX_HEARTBEAT_INTERVAL '%s', using 1m", interval)
+		interval = time.Minute
+		configErrors.add(configKey("DEX_HEARTBEAT_INTERVAL"))
+	}
+
+	return &Heartbeat{
+		url:        heartbeatURL,
+		interval:   interval,
+		collector:  collector,
+		checker:    checker,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (h *Heartbeat) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := h.beat(ctx); err != nil {
+			log.Warn("heartbeat failed: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// beat sends a heartbeat if a collection succeeded within the interval.
+func (h *Heartbeat) beat(ctx context.Context) error {
+	since := time.Now().Add(-h.interval)
+	if !h.checker.succeededSince(since) && h.collector != nil {
+		collected := make(chan prometheus.Metric)
+		go func() {
+			h.collector.Collect(collected)
+			close(collected)
+		}()
+		for range collected {
+		}
+	}
+	if !h.checker.succeededSince(since) {
+		return fmt.Errorf("no collection succeeded in the last %s", h.interval)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		// the URL is a secret, don't log it
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("heartbeat URL returned %s", resp.Status)
+	}
+	return nil
+}
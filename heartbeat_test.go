@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCollection succeeds when collected while ok is set.
+type fakeCollection struct {
+	ok        bool
+	collected int
+	succeeded time.Time
+}
+
+func (f *fakeCollection) Describe(_ chan<- *prometheus.Desc) {}
+
+func (f *fakeCollection) Collect(_ chan<- prometheus.Metric) {
+	f.collected++
+	if f.ok {
+		f.succeeded = time.Now()
+	}
+}
+
+func (f *fakeCollection) succeededSince(t time.Time) bool {
+	return f.succeeded.After(t)
+}
+
+func heartbeatServer(t *testing.T, status int) (string, *atomic.Int32) {
+	var beats atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		beats.Add(1)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, &beats
+}
+
+func TestHeartbeatDisabled(t *testing.T) {
+	assert.Nil(t, newHeartbeat(nil, &fakeCollection{}))
+}
+
+func TestHeartbeat(t *testing.T) {
+	url, beats := heartbeatServer(t, http.StatusOK)
+	t.Setenv("DEX_HEARTBEAT_URL", url)
+	collection := &fakeCollection{ok: true}
+	h := newHeartbeat(collection, collection)
+
+	// nothing scraped dex, so the heartbeat collects
+	assert.NoError(t, h.beat(context.Background()))
+	assert.Equal(t, 1, collection.collected)
+	assert.EqualValues(t, 1, beats.Load())
+
+	// a recent collection is enough
+	assert.NoError(t, h.beat(context.Background()))
+	assert.Equal(t, 1, collection.collected)
+	assert.EqualValues(t, 2, beats.Load())
+}
+
+func TestHeartbeatFailedCollection(t *testing.T) {
+	url, beats := heartbeatServer(t, http.StatusOK)
+	t.Setenv("DEX_HEARTBEAT_URL", url)
+	collection := &fakeCollection{}
+	h := newHeartbeat(collection, collection)
+
+	assert.EqualError(t, h.beat(context.Background()), "no collection succeeded in the last 1m0s")
+	assert.EqualValues(t, 0, beats.Load())
+}
+
+func TestHeartbeatURLError(t *testing.T) {
+	url, _ := heartbeatServer(t, http.StatusNotFound)
+	t.Setenv("DEX_HEARTBEAT_URL", url+"/secret-uuid")
+	collection := &fakeCollection{ok: true}
+	h := newHeartbeat(collection, collection)
+
+	assert.EqualError(t, h.beat(context.Background()), "heartbeat URL returned 404 Not Found")
+
+	t.Setenv("DEX_HEARTBEAT_URL", "http://127.0.0.1:1/secret-uuid")
+	err := newHeartbeat(collection, collection).beat(context.Background())
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret-uuid")
+}
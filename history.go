@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+type historySample struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+func (s historySample) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{float64(s.Timestamp.UnixMilli()) / 1000, s.Value})
+}
+
+type historySeries struct {
+	Metric  string            `json:"metric"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Samples []historySample   `json:"samples"`
+}
+
+// History keeps recently collected per-container samples in memory, so they
+// can be inspected on hosts without Prometheus.
+type History struct {
+	gatherer  prometheus.Gatherer
+	retention time.Duration
+	interval  time.Duration
+
+	mu sync.Mutex
+	// container name -> series key -> series
+	series map[string]map[string]*historySeries
+}
+
+// newHistory returns nil when history recording is not enabled.
+func newHistory(gatherer prometheus.Gatherer) *History {
+	retention := envDuration("DEX_HISTORY_RETENTION", 0)
+	if retention <= 0 {
+		return nil
+	}
+
+	interval := envDuration("DEX_HISTORY_INTERVAL", 30*time.Second)
+	if interval <= 0 {
+		log.Errorf("invalid DEX_HISTORY_INTERVAL '%s', using 30s", interval)
+		interval = 30 * time.Second
+		configErrors.add(configKey("DEX_HISTORY_INTERVAL"))
+	}
+
+	return &History{
+		gatherer:  gatherer,
+		retention: retention,
+		interval:  interval,
+		series:    map[string]map[string]*historySeries{},
+	}
+}
+
+func (h *History) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		families, err := h.gatherer.Gather()
+		if err != nil {
+			log.Error("can't gather metrics for history: ", err)
+		}
+		h.record(families, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *History) record(families []*dto.MetricFamily, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			var container string
+			labels := map[string]string{}
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == "container_name" {
+					container = lp.GetValue()
+				} else {
+					labels[lp.GetName()] = lp.GetValue()
+				}
+			}
+			if container == "" {
+				continue
+			}
+
+			key := mf.GetName() + alertKey(labels)
+			containerSeries, ok := h.series[container]
+			if !ok {
+				containerSeries = map[string]*historySeries{}
+				h.series[container] = containerSeries
+			}
+			series, ok := containerSeries[key]
+			if !ok {
+				series = &historySeries{Metric: mf.GetName()}
+				if len(labels) > 0 {
+					series.Labels = labels
+				}
+				containerSeries[key] = series
+			}
+			series.Samples = append(series.Samples, historySample{Timestamp: now, Value: metricValue(m)})
+		}
+	}
+
+	// drop samples and series older than the retention
+	cutoff := now.Add(-h.retention)
+	for container, containerSeries := range h.series {
+		for key, series := range containerSeries {
+			i := sort.Search(len(series.Samples), func(i int) bool {
+				return !series.Samples[i].Timestamp.Before(cutoff)
+			})
+			series.Samples = series.Samples[i:]
+			if len(series.Samples) == 0 {
+				delete(containerSeries, key)
+			}
+		}
+		if len(containerSeries) == 0 {
+			delete(h.series, container)
+		}
+	}
+}
+
+// query returns copies of the series of a container, optionally limited to
+// one metric. The "dex_" prefix of the metric name may be omitted.
+func (h *History) query(container, metric string) []historySeries {
+	if metric != "" && !strings.HasPrefix(metric, "dex_") {
+		metric = "dex_" + metric
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var result []historySeries
+	for _, series := range h.series[container] {
+		if metric != "" && series.Metric != metric {
+			continue
+		}
+		result = append(result, historySeries{
+			Metric:  series.Metric,
+			Labels:  series.Labels,
+			Samples: append([]historySample(nil), series.Samples...),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Metric < result[j].Metric
+	})
+
+	return result
+}
+
+// ServeHTTP handles /api/v1/history?container=...&metric=...
+func (h *History) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	container := r.URL.Query().Get("container")
+	if container == "" {
+		http.Error(w, "container parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	series := h.query(container, r.URL.Query().Get("metric"))
+	if series == nil {
+		series = []historySeries{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"container": container,
+		"series":    series,
+	}); err != nil {
+		log.Error("can't write history response: ", err)
+	}
+}
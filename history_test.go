@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryRetention(t *testing.T) {
+	h := &History{retention: time.Minute, series: map[string]map[string]*historySeries{}}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	h.record([]*dto.MetricFamily{
+		gaugeFamily("dex_cpu_utilization_percent", "web", 10),
+		gaugeFamily("dex_cpu_utilization_percent", "batch", 99),
+	}, start)
+	h.record([]*dto.MetricFamily{gaugeFamily("dex_cpu_utilization_percent", "web", 20)}, start.Add(30*time.Second))
+	h.record([]*dto.MetricFamily{gaugeFamily("dex_cpu_utilization_percent", "web", 30)}, start.Add(90*time.Second))
+
+	series := h.query("web", "cpu_utilization_percent")
+	require.Len(t, series, 1, "Expected one series for web")
+	require.Len(t, series[0].Samples, 2, "Samples older than the retention should be dropped")
+	assert.Equal(t, 20.0, series[0].Samples[0].Value)
+	assert.Equal(t, 30.0, series[0].Samples[1].Value)
+
+	assert.Empty(t, h.query("batch", ""), "Containers without recent samples should be dropped")
+}
+
+func TestHistoryHandler(t *testing.T) {
+	h := &History{retention: time.Hour, series: map[string]map[string]*historySeries{}}
+	ts := time.Unix(1700000000, 0)
+	h.record([]*dto.MetricFamily{
+		gaugeFamily("dex_cpu_utilization_percent", "web", 12.5),
+		gaugeFamily("dex_memory_usage_bytes", "web", 1024),
+	}, ts)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/history?container=web&metric=dex_memory_usage_bytes", nil))
+	require.Equal(t, 200, rec.Code)
+
+	var resp struct {
+		Container string `json:"container"`
+		Series    []struct {
+			Metric  string       `json:"metric"`
+			Samples [][2]float64 `json:"samples"`
+		} `json:"series"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "web", resp.Container)
+	require.Len(t, resp.Series, 1)
+	assert.Equal(t, "dex_memory_usage_bytes", resp.Series[0].Metric)
+	assert.Equal(t, [][2]float64{{1700000000, 1024}}, resp.Series[0].Samples)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/history", nil))
+	assert.Equal(t, 400, rec.Code, "Container parameter should be required")
+}
+
+func TestHistoryInvalidInterval(t *testing.T) {
+	t.Setenv("DEX_HISTORY_RETENTION", "1h")
+	t.Setenv("DEX_HISTORY_INTERVAL", "0")
+	saved := configErrors
+	configErrors = &ConfigErrors{}
+	t.Cleanup(func() { configErrors = saved })
+
+	h := newHistory(prometheus.NewRegistry())
+	require.NotNil(t, h)
+	assert.Equal(t, 30*time.Second, h.interval)
+	assert.Equal(t, 1, testutil.CollectAndCount(configErrors))
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// hostLabelNames are the labels DEX_HOST_LABELS can detect.
+var hostLabelNames = map[string]bool{
+	"hostname":          true,
+	"instance_id":       true,
+	"availability_zone": true,
+}
+
+// staticLabels returns the labels section of the config, or nil if a label
+// name is invalid.
+func staticLabels() prometheus.Labels {
+	if err := validateLabels(config.Labels); err != nil {
+		log.Errorf("invalid labels, no labels are added: %v", err)
+		configErrors.add("labels")
+		return nil
+	}
+	return config.Labels
+}
+
+func validateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelNameRe.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name '%s'", name)
+		}
+	}
+	return nil
+}
+
+// newHostLabels returns the labels listed in DEX_HOST_LABELS detected on the
+// host of the local daemon. Labels which can't be detected are left out.
+func newHostLabels(cli *client.Client) prometheus.Labels {
+	labels := prometheus.Labels{}
+	names := splitList(envString("DEX_HOST_LABELS", ""))
+	if len(names) == 0 {
+		return labels
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var metadata *instanceMetadata
+	for _, name := range names {
+		switch name {
+		case "hostname":
+			// the hostname of a container is its ID
+			if info, err := cli.Info(ctx); err == nil {
+				labels[name] = info.Name
+			} else if hostname, err := os.Hostname(); err == nil {
+				labels[name] = hostname
+			}
+		case "instance_id", "availability_zone":
+			if metadata == nil {
+				var err error
+				metadata, err = newCloudMetadata().detect(ctx)
+				if err != nil {
+					log.Warn("can't read cloud instance metadata: ", err)
+					metadata = &instanceMetadata{}
+				}
+			}
+			if name == "instance_id" && metadata.instanceID != "" {
+				labels[name] = metadata.instanceID
+			}
+			if name == "availability_zone" && metadata.zone != "" {
+				labels[name] = metadata.zone
+			}
+		default:
+			log.Errorf("unknown host label '%s'", name)
+			configErrors.add(configKey("DEX_HOST_LABELS"))
+		}
+	}
+	return labels
+}
+
+// mergeLabels returns the union of the labels, later ones take precedence.
+func mergeLabels(labels ...prometheus.Labels) prometheus.Labels {
+	merged := prometheus.Labels{}
+	for _, l := range labels {
+		for name, value := range l {
+			merged[name] = value
+		}
+	}
+	return merged
+}
+
+type instanceMetadata struct {
+	instanceID string
+	zone       string
+}
+
+// cloudMetadata reads the instance metadata services of AWS, GCP and Azure.
+type cloudMetadata struct {
+	client   *http.Client
+	awsURL   string
+	gcpURL   string
+	azureURL string
+}
+
+func newCloudMetadata() *cloudMetadata {
+	return &cloudMetadata{
+		client:   &http.Client{Timeout: 2 * time.Second},
+		awsURL:   "http://169.254.169.254/latest",
+		gcpURL:   "http://metadata.google.internal/computeMetadata/v1/instance",
+		azureURL: "http://169.254.169.254/metadata/instance/compute?api-version=2021-02-01",
+	}
+}
+
+// detect returns the metadata of the first cloud whose service responds.
+func (m *cloudMetadata) detect(ctx context.Context) (*instanceMetadata, error) {
+	var errs []string
+	for _, provider := range []struct {
+		name string
+		read func(context.Context) (*instanceMetadata, error)
+	}{
+		{"aws", m.aws},
+		{"gcp", m.gcp},
+		{"azure", m.azure},
+	} {
+		metadata, err := provider.read(ctx)
+		if err == nil {
+			return metadata, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", provider.name, err))
+	}
+	return nil, fmt.Errorf("no metadata service found (%s)", strings.Join(errs, ", "))
+}
+
+// aws uses IMDSv2, which requires a session token.
+func (m *cloudMetadata) aws(ctx context.Context) (*instanceMetadata, error) {
+	token, err := m.get(ctx, http.MethodPut, m.awsURL+"/api/token", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+	if err != nil {
+		return nil, err
+	}
+
+	header := map[string]string{"X-aws-ec2-metadata-token": token}
+	id, err := m.get(ctx, http.MethodGet, m.awsURL+"/meta-data/instance-id", header)
+	if err != nil {
+		return nil, err
+	}
+	zone, err := m.get(ctx, http.MethodGet, m.awsURL+"/meta-data/placement/availability-zone", header)
+	if err != nil {
+		return nil, err
+	}
+	return &instanceMetadata{instanceID: id, zone: zone}, nil
+}
+
+func (m *cloudMetadata) gcp(ctx context.Context) (*instanceMetadata, error) {
+	header := map[string]string{"Metadata-Flavor": "Google"}
+	id, err := m.get(ctx, http.MethodGet, m.gcpURL+"/id", header)
+	if err != nil {
+		return nil, err
+	}
+	// the zone is returned as projects/<number>/zones/<zone>
+	zone, err := m.get(ctx, http.MethodGet, m.gcpURL+"/zone", header)
+	if err != nil {
+		return nil, err
+	}
+	return &instanceMetadata{instanceID: id, zone: path.Base(zone)}, nil
+}
+
+func (m *cloudMetadata) azure(ctx context.Context) (*instanceMetadata, error) {
+	body, err := m.get(ctx, http.MethodGet, m.azureURL, map[string]string{"Metadata": "true"})
+	if err != nil {
+		return nil, err
+	}
+
+	var compute struct {
+		VMID     string `json:"vmId"`
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+	}
+	if err := json.Unmarshal([]byte(body), &compute); err != nil {
+		return nil, err
+	}
+
+	// like Kubernetes, zones are named <location>-<zone>
+	zone := compute.Location
+	if compute.Zone != "" {
+		zone += "-" + compute.Zone
+	}
+	return &instanceMetadata{instanceID: compute.VMID, zone: zone}, nil
+}
+
+func (m *cloudMetadata) get(ctx context.Context, method, url string, header map[string]string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticLabels(t *testing.T) {
+	cfg, err := loadConfig(writeConfig(t, "labels:\n  env: prod\n  region: eu\nport: 9100\n"))
+	require.NoError(t, err)
+	config = cfg
+
+	assert.Equal(t, prometheus.Labels{"env": "prod", "region": "eu"}, staticLabels())
+	assert.Empty(t, cfg.unknownOptions())
+
+	config = &Config{Labels: map[string]string{"bad-name": "x"}}
+	assert.Nil(t, staticLabels())
+	assert.Error(t, validateLabels(map[string]string{"__reserved": "x"}))
+}
+
+func TestMergeLabels(t *testing.T) {
+	assert.Equal(t, prometheus.Labels{"hostname": "node-1", "env": "prod", "instance_id": "configured"}, mergeLabels(
+		prometheus.Labels{"hostname": "node-1", "instance_id": "i-123"},
+		nil,
+		prometheus.Labels{"env": "prod", "instance_id": "configured"},
+	))
+}
+
+func TestValidateHostLabels(t *testing.T) {
+	assert.NoError(t, validateHostLabels("hostname, instance_id,availability_zone"))
+	assert.Error(t, validateHostLabels("hostname,region"))
+}
+
+func TestCloudMetadataAWS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/token" {
+			assert.Equal(t, http.MethodPut, r.Method)
+			w.Write([]byte("token"))
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/meta-data/instance-id":
+			w.Write([]byte("i-0123456789"))
+		case "/meta-data/placement/availability-zone":
+			w.Write([]byte("eu-west-1a"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	m := &cloudMetadata{client: server.Client(), awsURL: server.URL}
+	metadata, err := m.detect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &instanceMetadata{instanceID: "i-0123456789", zone: "eu-west-1a"}, metadata)
+}
+
+func TestCloudMetadataGCP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/instance/id":
+			w.Write([]byte("1234567890"))
+		case "/instance/zone":
+			w.Write([]byte("projects/42/zones/us-central1-a"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	m := &cloudMetadata{client: server.Client(), awsURL: server.URL, gcpURL: server.URL + "/instance"}
+	metadata, err := m.detect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &instanceMetadata{instanceID: "1234567890", zone: "us-central1-a"}, metadata)
+}
+
+func TestCloudMetadataAzure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metadata/instance/compute" || r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"vmId":"02aab8a4-74ef-476e-8182-f6d2ba4166a6","location":"westeurope","zone":"2"}`))
+	}))
+	defer server.Close()
+
+	m := &cloudMetadata{
+		client:   server.Client(),
+		awsURL:   server.URL,
+		gcpURL:   server.URL,
+		azureURL: server.URL + "/metadata/instance/compute",
+	}
+	metadata, err := m.detect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &instanceMetadata{instanceID: "02aab8a4-74ef-476e-8182-f6d2ba4166a6", zone: "westeurope-2"}, metadata)
+}
+
+func TestCloudMetadataNotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	m := &cloudMetadata{client: server.Client(), awsURL: server.URL, gcpURL: server.URL, azureURL: server.URL}
+	_, err := m.detect(context.Background())
+	assert.Error(t, err)
+}
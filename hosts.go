@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// DockerHosts collects the containers of several docker hosts. Every host
+// has its own DockerCollector whose metrics get the docker_host label. The
+// hosts are listed statically or discovered by periodically resolving a DNS
+// name, so autoscaled fleets don't need config changes.
+type DockerHosts struct {
+	static   []string
+	configs  []*HostConfig
+	dnsName  string
+	dnsPort  int
+	interval time.Duration
+
+	lookupSRV  func(ctx context.Context, name string) ([]*net.SRV, error)
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	mu sync.Mutex
+	// rules are the global filter rules of hosts without their own
+	rules []*FilterRule
+	hosts map[string]*remoteHost
+}
+
+// HostConfig sets the filters and labels of the docker hosts in multi-host
+// mode whose endpoint matches it, e.g. to filter build and production hosts
+// differently.
+type HostConfig struct {
+	// Match is a regexp matched against the endpoint, e.g. tcp://10.0.0.2:2375
+	Match string `yaml:"match"`
+	// Filters replace the global filters for the host when set
+	Filters []*FilterRule `yaml:"filters,omitempty"`
+	// Labels are added to all metrics of the host
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Interval collects the host in the background and serves the cached
+	// metrics, e.g. for daemons behind a WAN link. Collected on scrape if 0
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// compileHostConfigs checks the host configs and compiles their regexps.
+func compileHostConfigs(configs []*HostConfig) error {
+	for i, cfg := range configs {
+		re, err := regexp.Compile(cfg.Match)
+		if err != nil {
+			return fmt.Errorf("host %d: %v", i+1, err)
+		}
+		cfg.re = re
+
+		if len(cfg.Filters) > 0 {
+			if _, err := newContainerFilter(cfg.Filters); err != nil {
+				return fmt.Errorf("host %d: filters: %v", i+1, err)
+			}
+		}
+		if err := validateLabels(cfg.Labels); err != nil {
+			return fmt.Errorf("host %d: %v", i+1, err)
+		}
+		// the labels are added to all metrics of the host like the static
+		// labels of containers
+		for name := range cfg.Labels {
+			if reservedLabelNames[name] {
+				return fmt.Errorf("host %d: label '%s' is reserved", i+1, name)
+			}
+		}
+		if cfg.Interval < 0 {
+			return fmt.Errorf("host %d: negative interval", i+1)
+		}
+	}
+	return nil
+}
+
+// hostConfig returns the first config matching the endpoint, or nil.
+func (d *DockerHosts) hostConfig(endpoint string) *HostConfig {
+	for _, cfg := range d.configs {
+		if cfg.re.MatchString(endpoint) {
+			return cfg
+		}
+	}
+	return nil
+}
+
+type remoteHost struct {
+	collector *DockerCollector
+	// the collector wrapped with the docker_host label
+	labeled prometheus.Collector
+	cancel  context.CancelFunc
+	// global is set when the host follows the global filter rules
+	global bool
+}
+
+// newDockerHosts returns nil when multi-host mode is not enabled.
+func newDockerHosts() *DockerHosts {
+	static := splitList(envString("DEX_DOCKER_HOSTS", ""))
+	dnsName := envString("DEX_DOCKER_HOSTS_DNS", "")
+	if len(static) == 0 && dnsName == "" {
+		return nil
+	}
+
+	// an invalid host config must not leave the hosts unmonitored
+	configs := config.Hosts
+	if err := compileHostConfigs(configs); err != nil {
+		log.Errorf("invalid hosts, using the global filters for all hosts: %v", err)
+		configs = nil
+		configErrors.add("hosts")
+	}
+
+	interval := envDuration("DEX_DOCKER_HOSTS_DNS_INTERVAL", 30*time.Second)
+	if interval <= 0 {
+		log.Errorf("invalid DEX_DOCKER_HOSTS_DNS_INTERVAL '%s', using 30s", interval)
+		interval = 30 * time.Second
+		configErrors.add(configKey("DEX_DOCKER_HOSTS_DNS_INTERVAL"))
+	}
+
+	return &DockerHosts{
+		static:     static,
+		configs:    configs,
+		dnsName:    dnsName,
+		dnsPort:    envInt("DEX_DOCKER_HOSTS_DNS_PORT", 2375),
+		interval:   interval,
+		lookupSRV:  lookupSRV,
+		lookupHost: net.DefaultResolver.LookupHost,
+		rules:      config.Filters,
+		hosts:      map[string]*remoteHost{},
+	}
+}
+
+func lookupSRV(ctx context.Context, name string) ([]*net.SRV, error) {
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	return records, err
+}
+
+func (d *DockerHosts) Run(ctx context.Context) {
+	defer d.update(ctx, nil)
+
+	// static hosts are never re-resolved
+	var tick <-chan time.Time
+	if d.dnsName != "" {
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		if endpoints, err := d.endpoints(ctx); err != nil {
+			// keep the known hosts until the name resolves again
+			log.Errorf("can't resolve docker hosts '%s': %v", d.dnsName, err)
+		} else {
+			d.update(ctx, endpoints)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		}
+	}
+}
+
+// endpoints returns the sorted docker endpoints of the static hosts and the
+// resolved DNS name. A name starting with an underscore is resolved as SRV
+// record, e.g. _docker._tcp.example.com, otherwise as A and AAAA records.
+func (d *DockerHosts) endpoints(ctx context.Context) ([]string, error) {
+	endpoints := slices.Clone(d.static)
+
+	if strings.HasPrefix(d.dnsName, "_") {
+		records, err := d.lookupSRV(ctx, d.dnsName)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range records {
+			endpoints = append(endpoints, "tcp://"+net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+		}
+	} else if d.dnsName != "" {
+		addrs, err := d.lookupHost(ctx, d.dnsName)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			endpoints = append(endpoints, "tcp://"+net.JoinHostPort(addr, strconv.Itoa(d.dnsPort)))
+		}
+	}
+
+	slices.Sort(endpoints)
+	return slices.Compact(endpoints), nil
+}
+
+// update starts collectors of new endpoints and stops those of the gone ones.
+func (d *DockerHosts) update(ctx context.Context, endpoints []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for endpoint, host := range d.hosts {
+		if slices.Contains(endpoints, endpoint) {
+			continue
+		}
+		log.Infof("removing docker host %s", endpoint)
+		host.cancel()
+		host.collector.cli.Close()
+		delete(d.hosts, endpoint)
+	}
+
+	for _, endpoint := range endpoints {
+		if _, ok := d.hosts[endpoint]; ok {
+			continue
+		}
+		host, err := newRemoteHost(ctx, endpoint, d.hostConfig(endpoint), d.rules)
+		if err != nil {
+			log.Errorf("can't add docker host %s: %v", endpoint, err)
+			continue
+		}
+		log.Infof("adding docker host %s", endpoint)
+		d.hosts[endpoint] = host
+	}
+}
+
+// newRemoteHost starts collecting the host with its config, nil for the
+// global filter rules and no labels.
+func newRemoteHost(ctx context.Context, endpoint string, cfg *HostConfig, rules []*FilterRule) (*remoteHost, error) {
+	filters, labels := rules, prometheus.Labels{}
+	global := true
+	var interval time.Duration
+	if cfg != nil {
+		if len(cfg.Filters) > 0 {
+			filters = cfg.Filters
+			global = false
+		}
+		labels = mergeLabels(cfg.Labels)
+		interval = cfg.Interval
+	}
+	labels["docker_host"] = endpoint
+
+	collector, err := newDockerCollectorFor(endpoint, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	if collector.sampler != nil {
+		go collector.sampler.Run(ctx)
+	}
+
+	var served prometheus.Collector = collector
+	if interval > 0 {
+		cached := &cachedCollector{collector: collector, interval: interval}
+		go cached.Run(ctx)
+		served = cached
+	}
+
+	// the wrapping registerer is the only way to get a labeled collector
+	var labeled collectorRef
+	prometheus.WrapRegistererWith(labels, &labeled).MustRegister(served)
+
+	return &remoteHost{collector: collector, labeled: labeled.collector, cancel: cancel, global: global}, nil
+}
+
+// globalFilters returns the filters of the hosts following the global rules.
+func (d *DockerHosts) globalFilters() []*containerFilter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var filters []*containerFilter
+	for _, host := range d.hosts {
+		if host.global {
+			filters = append(filters, host.collector.filter)
+		}
+	}
+	return filters
+}
+
+// setGlobalRules replaces the global filter rules of the current and future
+// hosts.
+func (d *DockerHosts) setGlobalRules(rules []*FilterRule) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.rules = rules
+	for _, host := range d.hosts {
+		if host.global {
+			host.collector.filter.setRules(rules)
+		}
+	}
+}
+
+// samplers returns the stats samplers of the current hosts.
+func (d *DockerHosts) samplers() []*StatsSampler {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	samplers := make([]*StatsSampler, 0, len(d.hosts))
+	for _, host := range d.hosts {
+		samplers = append(samplers, host.collector.sampler)
+	}
+	return samplers
+}
+
+// closeIdleConnections closes the idle connections to all hosts.
+func (d *DockerHosts) closeIdleConnections() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, host := range d.hosts {
+		host.collector.closeIdleConnections()
+	}
+}
+
+// succeededSince reports whether the collections of all hosts succeeded after
+// t, false without hosts.
+func (d *DockerHosts) succeededSince(t time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, host := range d.hosts {
+		if !host.collector.succeededSince(t) {
+			return false
+		}
+	}
+	return len(d.hosts) > 0
+}
+
+func (d *DockerHosts) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (d *DockerHosts) Collect(ch chan<- prometheus.Metric) {
+	d.mu.Lock()
+	hosts := make([]*remoteHost, 0, len(d.hosts))
+	for _, host := range d.hosts {
+		hosts = append(hosts, host)
+	}
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			host.labeled.Collect(ch)
+		}()
+	}
+	wg.Wait()
+
+	ch <- prometheus.MustNewConstMetric(newDesc("dex_docker_hosts", nil), prometheus.GaugeValue, float64(len(hosts)))
+}
+
+// cachedCollector collects a host every interval in the background and
+// serves the metrics of the last collection, so a slow host doesn't slow down
+// the scrapes.
+type cachedCollector struct {
+	collector prometheus.Collector
+	interval  time.Duration
+
+	mu      sync.Mutex
+	metrics []prometheus.Metric
+}
+
+func (c *cachedCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.update()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *cachedCollector) update() {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.collector.Collect(ch)
+		close(ch)
+	}()
+
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	metrics = append(metrics, prometheus.MustNewConstMetric(newDesc("dex_docker_host_collected_timestamp_seconds", nil), prometheus.GaugeValue, float64(time.Now().UnixNano())/1e9))
+
+	c.mu.Lock()
+	c.metrics = metrics
+	c.mu.Unlock()
+}
+
+func (c *cachedCollector) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (c *cachedCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	metrics := c.metrics
+	c.mu.Unlock()
+
+	for _, m := range metrics {
+		ch <- m
+	}
+}
+
+// collectorRef is a Registerer keeping the registered collector.
+type collectorRef struct {
+	collector prometheus.Collector
+}
+
+func (r *collectorRef) Register(c prometheus.Collector) error {
+	if r.collector != nil {
+		return fmt.Errorf("collector already registered")
+	}
+	r.collector = c
+	return nil
+}
+
+func (r *collectorRef) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (r *collectorRef) Unregister(c prometheus.Collector) bool {
+	return false
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerHostsEndpoints(t *testing.T) {
+	d := &DockerHosts{
+		static:  []string{"tcp://static:2376", "tcp://10.0.0.1:2375"},
+		dnsName: "docker.example.com",
+		dnsPort: 2375,
+		lookupHost: func(_ context.Context, host string) ([]string, error) {
+			assert.Equal(t, "docker.example.com", host)
+			return []string{"10.0.0.2", "10.0.0.1", "fd00::1"}, nil
+		},
+	}
+
+	endpoints, err := d.endpoints(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"tcp://10.0.0.1:2375",
+		"tcp://10.0.0.2:2375",
+		"tcp://[fd00::1]:2375",
+		"tcp://static:2376",
+	}, endpoints)
+}
+
+func TestDockerHostsEndpointsSRV(t *testing.T) {
+	d := &DockerHosts{
+		dnsName: "_docker._tcp.example.com",
+		lookupSRV: func(_ context.Context, name string) ([]*net.SRV, error) {
+			assert.Equal(t, "_docker._tcp.example.com", name)
+			return []*net.SRV{
+				{Target: "node-b.example.com.", Port: 2376},
+				{Target: "node-a.example.com.", Port: 2375},
+			}, nil
+		},
+	}
+
+	endpoints, err := d.endpoints(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tcp://node-a.example.com:2375", "tcp://node-b.example.com:2376"}, endpoints)
+}
+
+func TestDockerHostsEndpointsError(t *testing.T) {
+	d := &DockerHosts{
+		dnsName: "docker.example.com",
+		lookupHost: func(context.Context, string) ([]string, error) {
+			return nil, errors.New("no such host")
+		},
+	}
+
+	_, err := d.endpoints(context.Background())
+	assert.Error(t, err)
+}
+
+func TestDockerHostsUpdate(t *testing.T) {
+	d := &DockerHosts{hosts: map[string]*remoteHost{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d.update(ctx, []string{"tcp://10.0.0.1:2375", "tcp://10.0.0.2:2375"})
+	require.Len(t, d.hosts, 2)
+	first := d.hosts["tcp://10.0.0.1:2375"]
+	assert.Equal(t, "tcp://10.0.0.1:2375", first.collector.cli.DaemonHost())
+
+	d.update(ctx, []string{"tcp://10.0.0.1:2375", "tcp://10.0.0.3:2375"})
+	assert.Len(t, d.hosts, 2)
+	assert.Same(t, first, d.hosts["tcp://10.0.0.1:2375"], "known hosts are kept")
+	assert.Contains(t, d.hosts, "tcp://10.0.0.3:2375")
+	assert.NotContains(t, d.hosts, "tcp://10.0.0.2:2375")
+
+	d.update(ctx, nil)
+	assert.Empty(t, d.hosts)
+}
+
+func TestDockerHostsConfig(t *testing.T) {
+	build := fakeDaemon(t, []container.Summary{
+		{ID: "aaa", Names: []string{"/web"}, State: "running"},
+		{ID: "bbb", Names: []string{"/ci_runner"}, State: "running"},
+	})
+	prod := fakeDaemon(t, []container.Summary{
+		{ID: "ccc", Names: []string{"/ci_runner"}, State: "running"},
+	})
+
+	configs := []*HostConfig{
+		{
+			Match:   "^" + regexp.QuoteMeta(build.DaemonHost()) + "$",
+			Filters: []*FilterRule{{Match: `^ci_`, Drop: true}, {Match: `.*`}},
+			Labels:  map[string]string{"env": "build"},
+		},
+	}
+	require.NoError(t, compileHostConfigs(configs))
+
+	d := &DockerHosts{configs: configs, hosts: map[string]*remoteHost{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.update(ctx, []string{build.DaemonHost(), prod.DaemonHost()})
+	defer d.update(ctx, nil)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(d)
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	var running []string
+	for _, family := range families {
+		if family.GetName() != "dex_container_running" {
+			continue
+		}
+		for _, m := range family.Metric {
+			labels := map[string]string{}
+			for _, pair := range m.Label {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			running = append(running, labels["docker_host"]+" "+labels["container_name"]+" "+labels["env"])
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		build.DaemonHost() + " web build",
+		prod.DaemonHost() + " ci_runner ",
+	}, running)
+}
+
+func TestInvalidHostConfigs(t *testing.T) {
+	assert.Error(t, compileHostConfigs([]*HostConfig{{Match: "build-("}}))
+	assert.Error(t, compileHostConfigs([]*HostConfig{{Match: ".*", Filters: []*FilterRule{{Match: "("}}}}))
+	assert.Error(t, compileHostConfigs([]*HostConfig{{Match: ".*", Labels: map[string]string{"bad-name": "x"}}}))
+	for _, name := range []string{"docker_host", "container_name", "image", "type"} {
+		assert.ErrorContains(t, compileHostConfigs([]*HostConfig{{Match: ".*", Labels: map[string]string{name: "x"}}}), "reserved", name)
+	}
+}
+
+type countingCollector struct {
+	collections atomic.Int32
+}
+
+func (c *countingCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *countingCollector) Collect(ch chan<- prometheus.Metric) {
+	n := c.collections.Add(1)
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("dex_test_collections", "collections", nil, nil), prometheus.GaugeValue, float64(n))
+}
+
+func TestCachedCollector(t *testing.T) {
+	inner := &countingCollector{}
+	cached := &cachedCollector{collector: inner, interval: time.Hour}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(cached)
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	assert.Empty(t, families, "nothing is served before the first collection")
+
+	cached.update()
+	for range 3 {
+		families, err = reg.Gather()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), inner.collections.Load(), "scrapes must be served from the cache")
+
+	require.Len(t, families, 2)
+	assert.Equal(t, "dex_docker_host_collected_timestamp_seconds", families[0].GetName())
+	assert.InDelta(t, float64(time.Now().Unix()), families[0].Metric[0].GetGauge().GetValue(), 5)
+	assert.Equal(t, 1.0, families[1].Metric[0].GetGauge().GetValue())
+}
+
+func TestDockerHostsInvalidDNSInterval(t *testing.T) {
+	t.Setenv("DEX_DOCKER_HOSTS_DNS", "docker.example.com")
+	t.Setenv("DEX_DOCKER_HOSTS_DNS_INTERVAL", "0")
+	saved := configErrors
+	configErrors = &ConfigErrors{}
+	t.Cleanup(func() { configErrors = saved })
+
+	d := newDockerHosts()
+	require.NotNil(t, d)
+	assert.Equal(t, 30*time.Second, d.interval)
+	assert.Equal(t, 1, testutil.CollectAndCount(configErrors))
+}
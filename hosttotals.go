@@ -0,0 +1,160 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// hostCounter indexes the counters summed in the host totals.
+type hostCounter int
+
+const (
+	hostCPUSeconds hostCounter = iota
+	hostRxBytes
+	hostTxBytes
+	hostReadBytes
+	hostWriteBytes
+	hostCounterCount
+)
+
+type hostCounterValues [hostCounterCount]float64
+
+// hostTotals sums the resource usage of all running containers during a
+// collection, so hosts can be monitored with a few series even when the
+// per-container metrics are dropped for their cardinality.
+type hostTotals struct {
+	counters *hostCounters
+
+	mu          sync.Mutex
+	containers  float64
+	memoryBytes float64
+	// counters of the containers by ID
+	values map[string]hostCounterValues
+}
+
+// hostCounters keeps the host counters monotonic across collections. The
+// last counters of every container stay in the totals when it stops, and are
+// carried forward when the container restarts and its counters start over.
+type hostCounters struct {
+	mu       sync.Mutex
+	last     map[string]hostCounterValues
+	lastSeen map[string]time.Time
+	// counters of restarted and forgotten containers
+	carried hostCounterValues
+}
+
+// hostCountersTTL is how long the counters of a container not seen anymore
+// are kept by ID before they are only part of the carried totals.
+const hostCountersTTL = time.Hour
+
+// newHostCounters returns nil when the totals are disabled.
+func newHostCounters(enabled bool) *hostCounters {
+	if !enabled {
+		return nil
+	}
+	return &hostCounters{last: map[string]hostCounterValues{}, lastSeen: map[string]time.Time{}}
+}
+
+// newHostTotals returns nil when the totals are disabled.
+func newHostTotals(counters *hostCounters) *hostTotals {
+	if counters == nil {
+		return nil
+	}
+	return &hostTotals{counters: counters, values: map[string]hostCounterValues{}}
+}
+
+// addStats adds the stats of the running container id. It is safe to call on
+// a nil receiver.
+func (h *hostTotals) addStats(id string, containerStats *container.StatsResponse) {
+	if h == nil {
+		return
+	}
+	read, write := blockIoBytes(containerStats)
+
+	var values hostCounterValues
+	values[hostCPUSeconds] = float64(containerStats.CPUStats.CPUUsage.TotalUsage) / 1e9
+	for _, network := range containerStats.Networks {
+		values[hostRxBytes] += float64(network.RxBytes)
+		values[hostTxBytes] += float64(network.TxBytes)
+	}
+	values[hostReadBytes] = float64(read)
+	values[hostWriteBytes] = float64(write)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.containers++
+	if hasMemoryStats(containerStats) {
+		h.memoryBytes += float64(memoryUsageBytes(containerStats))
+	}
+	h.values[id] = values
+}
+
+// update records the counters of the containers of a collection and returns
+// the host totals.
+func (c *hostCounters) update(values map[string]hostCounterValues, now time.Time) hostCounterValues {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, current := range values {
+		last := c.last[id]
+		for i := range current {
+			if current[i] < last[i] {
+				// the container restarted, its counters start over
+				c.carried[i] += last[i]
+			}
+		}
+		c.last[id] = current
+		c.lastSeen[id] = now
+	}
+
+	totals := c.carried
+	for id, last := range c.last {
+		if now.Sub(c.lastSeen[id]) > hostCountersTTL {
+			for i := range last {
+				c.carried[i] += last[i]
+				totals[i] += last[i]
+			}
+			delete(c.last, id)
+			delete(c.lastSeen, id)
+			continue
+		}
+		for i := range last {
+			totals[i] += last[i]
+		}
+	}
+	return totals
+}
+
+func (h *hostTotals) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+// Collect is safe to call on a nil receiver.
+func (h *hostTotals) Collect(ch chan<- prometheus.Metric) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	counters := h.counters.update(h.values, time.Now())
+
+	for _, total := range []struct {
+		name      string
+		valueType prometheus.ValueType
+		value     float64
+	}{
+		{"dex_host_containers_measured", prometheus.GaugeValue, h.containers},
+		{"dex_host_cpu_utilization_seconds_total", prometheus.CounterValue, counters[hostCPUSeconds]},
+		{"dex_host_memory_usage_bytes", prometheus.GaugeValue, h.memoryBytes},
+		{"dex_host_network_rx_bytes_total", prometheus.CounterValue, counters[hostRxBytes]},
+		{"dex_host_network_tx_bytes_total", prometheus.CounterValue, counters[hostTxBytes]},
+		{"dex_host_block_io_read_bytes_total", prometheus.CounterValue, counters[hostReadBytes]},
+		{"dex_host_block_io_write_bytes_total", prometheus.CounterValue, counters[hostWriteBytes]},
+	} {
+		ch <- prometheus.MustNewConstMetric(newDesc(total.name, nil), total.valueType, total.value)
+	}
+}
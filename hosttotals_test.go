@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostTotals(t *testing.T) {
+	totals := newHostTotals(newHostCounters(true))
+
+	totals.addStats("aaa", &container.StatsResponse{
+		CPUStats:    container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 1500000000}},
+		MemoryStats: container.MemoryStats{Usage: 300, Limit: 1000, Stats: map[string]uint64{"cache": 100}},
+		Networks:    map[string]container.NetworkStats{"eth0": {RxBytes: 6, TxBytes: 15}, "eth1": {RxBytes: 4, TxBytes: 5}},
+		BlkioStats: container.BlkioStats{IoServiceBytesRecursive: []container.BlkioStatEntry{
+			{Op: "Read", Value: 5},
+			{Op: "Write", Value: 7},
+		}},
+	})
+	// without the memory controller, e.g. rootless
+	totals.addStats("bbb", &container.StatsResponse{
+		CPUStats: container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 2500000000}},
+		Networks: map[string]container.NetworkStats{"eth0": {RxBytes: 1, TxBytes: 2}},
+	})
+
+	expected := `
+# HELP dex_host_block_io_read_bytes_total Block I/O read bytes of the containers including stopped ones
+# TYPE dex_host_block_io_read_bytes_total counter
+dex_host_block_io_read_bytes_total 5
+# HELP dex_host_block_io_write_bytes_total Block I/O write bytes of the containers including stopped ones
+# TYPE dex_host_block_io_write_bytes_total counter
+dex_host_block_io_write_bytes_total 7
+# HELP dex_host_containers_measured Number of running containers whose stats are summed in the dex_host_ totals
+# TYPE dex_host_containers_measured gauge
+dex_host_containers_measured 2
+# HELP dex_host_cpu_utilization_seconds_total Cumulative CPU utilization in seconds of the containers including stopped ones
+# TYPE dex_host_cpu_utilization_seconds_total counter
+dex_host_cpu_utilization_seconds_total 4
+# HELP dex_host_memory_usage_bytes Memory usage bytes of the running containers
+# TYPE dex_host_memory_usage_bytes gauge
+dex_host_memory_usage_bytes 200
+# HELP dex_host_network_rx_bytes_total Network received bytes of the containers including stopped ones
+# TYPE dex_host_network_rx_bytes_total counter
+dex_host_network_rx_bytes_total 11
+# HELP dex_host_network_tx_bytes_total Network sent bytes of the containers including stopped ones
+# TYPE dex_host_network_tx_bytes_total counter
+dex_host_network_tx_bytes_total 22
+`
+	assert.NoError(t, testutil.CollectAndCompare(totals, strings.NewReader(expected)))
+}
+
+func TestHostTotalsDisabled(t *testing.T) {
+	totals := newHostTotals(newHostCounters(false))
+	assert.Nil(t, totals)
+
+	// nil totals are no-ops
+	totals.addStats("aaa", &container.StatsResponse{})
+	assert.Equal(t, 0, testutil.CollectAndCount(totals))
+}
+
+func TestHostCounters(t *testing.T) {
+	counters := newHostCounters(true)
+	now := time.Now()
+	cpu := func(seconds float64) hostCounterValues {
+		return hostCounterValues{hostCPUSeconds: seconds}
+	}
+
+	assert.Equal(t, cpu(30), counters.update(map[string]hostCounterValues{"a": cpu(10), "b": cpu(20)}, now))
+	// b stopped, its counters stay in the totals
+	assert.Equal(t, cpu(35), counters.update(map[string]hostCounterValues{"a": cpu(15)}, now.Add(time.Minute)))
+	// a restarted and its counters start over
+	assert.Equal(t, cpu(37), counters.update(map[string]hostCounterValues{"a": cpu(2)}, now.Add(2*time.Minute)))
+	// b is forgotten but stays carried
+	assert.Equal(t, cpu(38), counters.update(map[string]hostCounterValues{"a": cpu(3)}, now.Add(2*hostCountersTTL)))
+	assert.NotContains(t, counters.last, "b")
+}
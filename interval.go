@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// idleCloser is implemented by the collectors holding connections to docker
+// daemons.
+type idleCloser interface {
+	closeIdleConnections()
+}
+
+// IntervalCollector collects the containers at a fixed interval in the
+// background and serves scrapes from the last collection, for Raspberry Pi
+// class hosts where dex should use next to no CPU between the collections.
+// The connections to the daemon are closed after each collection, so it
+// isn't kept busy by an idle client either.
+type IntervalCollector struct {
+	collector prometheus.Collector
+	interval  time.Duration
+
+	// concurrent updates, e.g. by /-/refresh, would only load the daemon
+	updateMu sync.Mutex
+
+	mu        sync.Mutex
+	cached    []prometheus.Metric
+	collected time.Time
+}
+
+// newIntervalCollector returns nil when the containers are collected on
+// scrape.
+func newIntervalCollector(collector prometheus.Collector) *IntervalCollector {
+	interval := envDuration("DEX_COLLECT_INTERVAL", 0)
+	if interval <= 0 {
+		return nil
+	}
+
+	return &IntervalCollector{
+		collector: collector,
+		interval:  interval,
+	}
+}
+
+func (i *IntervalCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(i.interval)
+	defer ticker.Stop()
+
+	for {
+		i.update(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (i *IntervalCollector) update(_ context.Context) {
+	i.updateMu.Lock()
+	defer i.updateMu.Unlock()
+
+	collected := make(chan prometheus.Metric)
+	go func() {
+		i.collector.Collect(collected)
+		close(collected)
+	}()
+
+	var metrics []prometheus.Metric
+	for m := range collected {
+		metrics = append(metrics, m)
+	}
+
+	if closer, ok := i.collector.(idleCloser); ok {
+		closer.closeIdleConnections()
+	}
+
+	i.mu.Lock()
+	i.cached = metrics
+	i.collected = time.Now()
+	i.mu.Unlock()
+}
+
+func (i *IntervalCollector) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (i *IntervalCollector) Collect(ch chan<- prometheus.Metric) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// nothing is exported until the first collection finished
+	if i.collected.IsZero() {
+		return
+	}
+
+	for _, m := range i.cached {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(newDesc("dex_last_collection_timestamp_seconds", nil), prometheus.GaugeValue, float64(i.collected.UnixNano())/1e9)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// idleCountingCollector counts its collections and the closing of its idle
+// connections.
+type idleCountingCollector struct {
+	collections int
+	closed      int
+}
+
+func (c *idleCountingCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *idleCountingCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collections++
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("dex_test_collections", "collections", nil, nil), prometheus.GaugeValue, float64(c.collections))
+}
+
+func (c *idleCountingCollector) closeIdleConnections() {
+	c.closed++
+}
+
+// collectInterval collects i and returns the metrics by name.
+func collectInterval(t *testing.T, i *IntervalCollector) map[string]float64 {
+	ch := make(chan prometheus.Metric, 10)
+	i.Collect(ch)
+	close(ch)
+
+	values := map[string]float64{}
+	for m := range ch {
+		var metric dto.Metric
+		require.NoError(t, m.Write(&metric))
+		name := strings.Split(strings.Split(m.Desc().String(), `fqName: "`)[1], `"`)[0]
+		values[name] = metric.GetGauge().GetValue()
+	}
+	return values
+}
+
+func TestIntervalCollector(t *testing.T) {
+	t.Setenv("DEX_COLLECT_INTERVAL", "1m")
+	c := &idleCountingCollector{}
+	i := newIntervalCollector(c)
+	require.NotNil(t, i)
+
+	assert.Empty(t, collectInterval(t, i), "nothing is exported before the first collection")
+
+	i.update(context.Background())
+	assert.Equal(t, 1, c.closed, "idle connections are closed after a collection")
+
+	// scrapes are served from the cache
+	for range 3 {
+		values := collectInterval(t, i)
+		assert.Len(t, values, 2)
+		assert.Equal(t, 1.0, values["dex_test_collections"])
+		assert.Greater(t, values["dex_last_collection_timestamp_seconds"], 0.0)
+	}
+	assert.Equal(t, 1, c.collections)
+
+	i.update(context.Background())
+	assert.Equal(t, 2, c.collections)
+	assert.Equal(t, 2, c.closed)
+}
+
+func TestIntervalCollectorDisabled(t *testing.T) {
+	assert.Nil(t, newIntervalCollector(&idleCountingCollector{}))
+
+	t.Setenv("DEX_COLLECT_INTERVAL", "0s")
+	assert.Nil(t, newIntervalCollector(&idleCountingCollector{}))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type seenContainer struct {
+	cl containerLabels
+	at time.Time
+}
+
+// LastSeen remembers the containers of the previous collections, so a
+// removed container keeps its series with dex_container_absent for a while
+// and alerts on its absence have something to fire on.
+type LastSeen struct {
+	ttl time.Duration
+
+	mu         sync.Mutex
+	containers map[string]*seenContainer
+}
+
+// newLastSeen returns nil when removed containers are not kept.
+func newLastSeen() *LastSeen {
+	ttl := envDuration("DEX_ABSENT_CONTAINERS_TTL", 0)
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &LastSeen{
+		ttl:        ttl,
+		containers: map[string]*seenContainer{},
+	}
+}
+
+// see records a listed container. It is safe to call on a nil receiver.
+func (l *LastSeen) see(cl containerLabels, at time.Time) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.containers[cl.key()] = &seenContainer{cl: cl, at: at}
+}
+
+// collectAbsent exports the containers not seen since the collection started
+// and forgets those not seen for longer than the TTL. It is safe to call on a
+// nil receiver.
+func (l *LastSeen) collectAbsent(ch chan<- prometheus.Metric, started time.Time) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, seen := range l.containers {
+		if !seen.at.Before(started) {
+			continue
+		}
+		if started.Sub(seen.at) > l.ttl {
+			delete(l.containers, key)
+			continue
+		}
+
+		ch <- seen.cl.metric(descs.get(
+			"dex_container_last_seen_timestamp_seconds",
+			seen.cl.names,
+		), prometheus.GaugeValue, float64(seen.at.UnixNano())/1e9)
+		ch <- seen.cl.metric(descs.get(
+			"dex_container_absent",
+			seen.cl.names,
+		), prometheus.GaugeValue, 1)
+	}
+}
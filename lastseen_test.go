@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastSeen(t *testing.T) {
+	l := &LastSeen{ttl: 10 * time.Minute, containers: map[string]*seenContainer{}}
+	first := time.Unix(1700000000, 0)
+
+	l.see(newContainerLabels("web"), first)
+	l.see(newContainerLabels("backup"), first)
+
+	// backup was removed before the next collection
+	second := first.Add(time.Minute)
+	l.see(newContainerLabels("web"), second)
+
+	collect := func(started time.Time) metricsCollector {
+		ch := make(chan prometheus.Metric, 10)
+		l.collectAbsent(ch, started)
+		close(ch)
+
+		var metrics metricsCollector
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		return metrics
+	}
+
+	expected := `
+# HELP dex_container_absent 1 if the container was removed within DEX_ABSENT_CONTAINERS_TTL, 0 otherwise
+# TYPE dex_container_absent gauge
+dex_container_absent{container_name="backup"} 1
+# HELP dex_container_last_seen_timestamp_seconds Time the container was last listed
+# TYPE dex_container_last_seen_timestamp_seconds gauge
+dex_container_last_seen_timestamp_seconds{container_name="backup"} 1.7e+09
+`
+	assert.NoError(t, testutil.CollectAndCompare(collect(second), strings.NewReader(expected)))
+
+	later := first.Add(11 * time.Minute)
+	l.see(newContainerLabels("web"), later)
+	assert.Empty(t, collect(later), "absent containers are forgotten after the TTL")
+	assert.NotContains(t, l.containers, newContainerLabels("backup").key())
+}
+
+func TestLastSeenDisabled(t *testing.T) {
+	assert.Nil(t, newLastSeen())
+
+	// a nil LastSeen is a no-op
+	var l *LastSeen
+	l.see(newContainerLabels("web"), time.Now())
+	l.collectAbsent(nil, time.Now())
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+type layerSize struct {
+	cl          containerLabels
+	imageLayers float64
+	rwLayer     float64
+}
+
+// LayerSizeCollector periodically reads the sizes of the image layers and
+// the writable layer of the running containers, computing them is too slow
+// to do on every scrape.
+type LayerSizeCollector struct {
+	cli      *client.Client
+	api      *DockerAPIMetrics
+	filter   *containerFilter
+	interval time.Duration
+
+	mu    sync.Mutex
+	sizes []layerSize
+}
+
+// newLayerSizeCollector returns nil when layer size metrics are not enabled.
+func newLayerSizeCollector(cli *client.Client, api *DockerAPIMetrics, filter *containerFilter) *LayerSizeCollector {
+	interval := envDuration("DEX_LAYER_SIZE_INTERVAL", 0)
+	if interval <= 0 {
+		return nil
+	}
+
+	return &LayerSizeCollector{
+		cli:      cli,
+		api:      api,
+		filter:   filter,
+		interval: interval,
+	}
+}
+
+func (l *LayerSizeCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		l.update(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *LayerSizeCollector) update(ctx context.Context) {
+	var containers []container.Summary
+	err := l.api.observe(ctx, "list", func() error {
+		var err error
+		containers, err = l.cli.ContainerList(ctx, container.ListOptions{Size: true})
+		return err
+	})
+	if err != nil {
+		log.Error("can't list container sizes: ", err)
+		return
+	}
+
+	sizes := layerSizesOf(containers, l.filter)
+
+	l.mu.Lock()
+	l.sizes = sizes
+	l.mu.Unlock()
+}
+
+func layerSizesOf(containers []container.Summary, filter *containerFilter) []layerSize {
+	var sizes []layerSize
+	for _, cont := range containers {
+		cl, ok := filter.match(strings.TrimPrefix(strings.Join(cont.Names, ";"), "/"))
+		if !ok {
+			continue
+		}
+
+		// the root filesystem size includes the writable layer
+		sizes = append(sizes, layerSize{
+			cl:          cl,
+			imageLayers: float64(cont.SizeRootFs - cont.SizeRw),
+			rwLayer:     float64(cont.SizeRw),
+		})
+	}
+	return sizes
+}
+
+func (l *LayerSizeCollector) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (l *LayerSizeCollector) Collect(ch chan<- prometheus.Metric) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, size := range l.sizes {
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_container_image_layers_bytes",
+			size.cl.names,
+		), prometheus.GaugeValue, size.imageLayers, size.cl.values...)
+
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_container_rw_layer_bytes",
+			size.cl.names,
+		), prometheus.GaugeValue, size.rwLayer, size.cl.values...)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayerSizeCollector(t *testing.T) {
+	filter, err := newContainerFilter([]*FilterRule{{Match: "^app_(.*)$"}})
+	require.NoError(t, err)
+
+	c := &LayerSizeCollector{sizes: layerSizesOf([]container.Summary{
+		{Names: []string{"/app_web"}, SizeRw: 1000, SizeRootFs: 51000},
+		{Names: []string{"/other"}, SizeRw: 1, SizeRootFs: 2},
+	}, filter)}
+
+	expected := `
+# HELP dex_container_image_layers_bytes Size of the image layers of the container, shared with other containers of the image
+# TYPE dex_container_image_layers_bytes gauge
+dex_container_image_layers_bytes{container_name="web"} 50000
+# HELP dex_container_rw_layer_bytes Size of the writable layer of the container
+# TYPE dex_container_rw_layer_bytes gauge
+dex_container_rw_layer_bytes{container_name="web"} 1000
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected)))
+}
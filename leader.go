@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// leaderLease is the content of the lock file.
+type leaderLease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// LeaderElection lets only one of several dex instances monitoring the same
+// hosts run the push outputs, so samples aren't sent twice. The leader holds
+// a lease in a file on storage shared by the instances and renews it
+// periodically, another instance takes over when the lease expires.
+type LeaderElection struct {
+	path string
+	id   string
+	ttl  time.Duration
+
+	leader atomic.Bool
+}
+
+// newLeaderElection returns nil when leader election is not enabled, every
+// instance is the leader then.
+func newLeaderElection() *LeaderElection {
+	path := envString("DEX_LEADER_LOCK_FILE", "")
+	if path == "" {
+		return nil
+	}
+
+	// the lease is renewed every third of it
+	ttl := envDuration("DEX_LEADER_LEASE", 30*time.Second)
+	if ttl/3 <= 0 {
+		log.Errorf("invalid DEX_LEADER_LEASE '%s', using 30s", ttl)
+		ttl = 30 * time.Second
+		configErrors.add(configKey("DEX_LEADER_LEASE"))
+	}
+
+	hostname, _ := os.Hostname()
+	return &LeaderElection{
+		path: path,
+		id:   envString("DEX_LEADER_ID", fmt.Sprintf("%s-%d", hostname, os.Getpid())),
+		ttl:  ttl,
+	}
+}
+
+// isLeader is safe to call on a nil receiver.
+func (l *LeaderElection) isLeader() bool {
+	return l == nil || l.leader.Load()
+}
+
+func (l *LeaderElection) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		leader, err := l.acquire(time.Now())
+		if err != nil {
+			log.Error("can't acquire leader lease: ", err)
+		}
+		if leader != l.leader.Swap(leader) {
+			log.Infof("leader election: leader=%v", leader)
+		}
+
+		select {
+		case <-ctx.Done():
+			if l.leader.Load() {
+				l.release()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// acquire takes or renews the lease and reports whether this instance holds it.
+func (l *LeaderElection) acquire(now time.Time) (bool, error) {
+	lease, err := l.read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	if err == nil && lease.Holder != l.id && now.Before(lease.Expires) {
+		return false, nil
+	}
+
+	if err := l.write(leaderLease{Holder: l.id, Expires: now.Add(l.ttl)}); err != nil {
+		return false, err
+	}
+
+	// another instance may have written the lease concurrently, the last write wins
+	lease, err = l.read()
+	if err != nil {
+		return false, err
+	}
+	return lease.Holder == l.id, nil
+}
+
+// release expires the lease, so another instance takes over without waiting.
+func (l *LeaderElection) release() {
+	if err := l.write(leaderLease{Holder: l.id}); err != nil {
+		log.Error("can't release leader lease: ", err)
+	}
+}
+
+func (l *LeaderElection) read() (leaderLease, error) {
+	var lease leaderLease
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return lease, err
+	}
+	if err := json.Unmarshal(data, &lease); err != nil {
+		// a corrupt lease is treated as expired
+		log.Warnf("invalid leader lease in %s: %v", l.path, err)
+		return leaderLease{}, nil
+	}
+	return lease, nil
+}
+
+// write replaces the lease atomically.
+func (l *LeaderElection) write(lease leaderLease) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), ".dex-leader-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.path)
+}
+
+// runWhileLeader runs fn while this instance is the leader and cancels it
+// when the leadership is lost. It is safe to call on a nil receiver, fn
+// always runs then.
+func (l *LeaderElection) runWhileLeader(ctx context.Context, fn func(context.Context)) {
+	if l == nil {
+		fn(ctx)
+		return
+	}
+
+	const poll = time.Second
+	for {
+		for !l.isLeader() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(poll):
+			}
+		}
+
+		leaderCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			fn(leaderCtx)
+		}()
+
+	watch:
+		for l.isLeader() {
+			select {
+			case <-ctx.Done():
+				break watch
+			case <-done:
+				// fn stopped by itself, don't restart it
+				cancel()
+				return
+			case <-time.After(poll):
+			}
+		}
+		cancel()
+		<-done
+
+		if ctx.Err() != nil {
+			return
+		}
+		log.Info("lost leadership, stopping push output")
+	}
+}
+
+func (l *LeaderElection) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (l *LeaderElection) Collect(ch chan<- prometheus.Metric) {
+	var leader float64
+	if l.isLeader() {
+		leader = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(newDesc("dex_leader", nil), prometheus.GaugeValue, leader)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaderElection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.json")
+	a := &LeaderElection{path: path, id: "a", ttl: 30 * time.Second}
+	b := &LeaderElection{path: path, id: "b", ttl: 30 * time.Second}
+	now := time.Now()
+
+	leader, err := a.acquire(now)
+	require.NoError(t, err)
+	assert.True(t, leader, "a takes the free lease")
+
+	leader, err = b.acquire(now.Add(10 * time.Second))
+	require.NoError(t, err)
+	assert.False(t, leader, "b waits while the lease is valid")
+
+	leader, err = a.acquire(now.Add(20 * time.Second))
+	require.NoError(t, err)
+	assert.True(t, leader, "a renews its lease")
+
+	leader, err = b.acquire(now.Add(40 * time.Second))
+	require.NoError(t, err)
+	assert.False(t, leader, "the renewed lease is still valid")
+
+	leader, err = b.acquire(now.Add(51 * time.Second))
+	require.NoError(t, err)
+	assert.True(t, leader, "b takes over the expired lease")
+
+	leader, err = a.acquire(now.Add(52 * time.Second))
+	require.NoError(t, err)
+	assert.False(t, leader)
+
+	b.release()
+	leader, err = a.acquire(now.Add(53 * time.Second))
+	require.NoError(t, err)
+	assert.True(t, leader, "a takes over the released lease")
+}
+
+func TestLeaderElectionCorruptLease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.json")
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0o644))
+
+	l := &LeaderElection{path: path, id: "a", ttl: 30 * time.Second}
+	leader, err := l.acquire(time.Now())
+	require.NoError(t, err)
+	assert.True(t, leader)
+}
+
+func TestLeaderElectionDisabled(t *testing.T) {
+	var l *LeaderElection
+	assert.True(t, l.isLeader())
+
+	ran := false
+	l.runWhileLeader(context.Background(), func(context.Context) { ran = true })
+	assert.True(t, ran)
+}
+
+func TestRunWhileLeader(t *testing.T) {
+	l := &LeaderElection{}
+	l.leader.Store(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{}, 2)
+	stopped := make(chan struct{}, 2)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.runWhileLeader(ctx, func(ctx context.Context) {
+			started <- struct{}{}
+			<-ctx.Done()
+			stopped <- struct{}{}
+		})
+	}()
+
+	<-started
+	l.leader.Store(false)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not stopped after losing leadership")
+	}
+
+	l.leader.Store(true)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not restarted after regaining leadership")
+	}
+
+	cancel()
+	<-stopped
+	<-done
+}
+
+func TestLeaderElectionInvalidLease(t *testing.T) {
+	t.Setenv("DEX_LEADER_LOCK_FILE", filepath.Join(t.TempDir(), "leader.json"))
+	saved := configErrors
+	t.Cleanup(func() { configErrors = saved })
+
+	for _, lease := range []string{"0", "-30s", "2ns"} {
+		t.Setenv("DEX_LEADER_LEASE", lease)
+		configErrors = &ConfigErrors{}
+
+		l := newLeaderElection()
+		require.NotNil(t, l)
+		assert.Equal(t, 30*time.Second, l.ttl, lease)
+		assert.Equal(t, 1, testutil.CollectAndCount(configErrors), lease)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// ContainerLifetimes exports how long containers ran until they stopped by
+// image, so crash-looping deployments show up as many short lifetimes.
+type ContainerLifetimes struct {
+	cli    *client.Client
+	api    *DockerAPIMetrics
+	filter *containerFilter
+
+	lifetimes *prometheus.HistogramVec
+}
+
+// newContainerLifetimes returns nil when lifetimes are not enabled.
+func newContainerLifetimes(cli *client.Client, api *DockerAPIMetrics, filter *containerFilter) *ContainerLifetimes {
+	if !envBool("DEX_LIFETIME_ENABLED", false) {
+		return nil
+	}
+
+	return &ContainerLifetimes{
+		cli:    cli,
+		api:    api,
+		filter: filter,
+		lifetimes: prometheus.NewHistogramVec(withNativeHistogram(prometheus.HistogramOpts{
+			Name:    "dex_container_lifetime_seconds",
+			Help:    metricHelp("dex_container_lifetime_seconds"),
+			Buckets: []float64{1, 5, 10, 30, 60, 300, 900, 3600, 6 * 3600, 24 * 3600, 7 * 24 * 3600},
+		}), []string{"image"}),
+	}
+}
+
+func (l *ContainerLifetimes) Describe(ch chan<- *prometheus.Desc) {
+	l.lifetimes.Describe(ch)
+}
+
+func (l *ContainerLifetimes) Collect(ch chan<- prometheus.Metric) {
+	l.lifetimes.Collect(ch)
+}
+
+// handleDie observes the lifetime of a container on its die event.
+func (l *ContainerLifetimes) handleDie(ctx context.Context, msg events.Message) {
+	if _, ok := l.filter.match(msg.Actor.Attributes["name"]); !ok {
+		return
+	}
+
+	var inspect container.InspectResponse
+	err := l.api.observe(ctx, "inspect", func() error {
+		var err error
+		inspect, err = l.cli.ContainerInspect(ctx, msg.Actor.ID)
+		return err
+	})
+	if err != nil {
+		// containers run with --rm may already be gone
+		log.Debugf("can't inspect stopped container '%s': %v", shortID(msg.Actor.ID), err)
+		return
+	}
+
+	if lifetime, ok := containerLifetime(inspect); ok {
+		image := msg.Actor.Attributes["image"]
+		if image == "" && inspect.Config != nil {
+			image = inspect.Config.Image
+		}
+		l.lifetimes.WithLabelValues(image).Observe(lifetime.Seconds())
+	}
+}
+
+// containerLifetime returns the time from the last start to the stop of a
+// container.
+func containerLifetime(inspect container.InspectResponse) (time.Duration, bool) {
+	if inspect.ContainerJSONBase == nil || inspect.State == nil {
+		return 0, false
+	}
+
+	started, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+	if err != nil || started.IsZero() {
+		return 0, false
+	}
+	finished, err := time.Parse(time.RFC3339Nano, inspect.State.FinishedAt)
+	if err != nil || finished.Before(started) {
+		return 0, false
+	}
+
+	return finished.Sub(started), true
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerLifetime(t *testing.T) {
+	lifetime, ok := containerLifetime(inspectWithTimes("2024-05-01T10:00:00Z", "2024-05-01T10:00:01Z", "2024-05-01T10:00:03.500000000Z"))
+	assert.True(t, ok)
+	assert.Equal(t, 2500*time.Millisecond, lifetime)
+}
+
+func TestContainerLifetimeInvalid(t *testing.T) {
+	_, ok := containerLifetime(container.InspectResponse{})
+	assert.False(t, ok)
+
+	_, ok = containerLifetime(inspectWithTimes("2024-05-01T10:00:00Z", "0001-01-01T00:00:00Z", "2024-05-01T10:00:03Z"))
+	assert.False(t, ok, "Containers which never started should be skipped")
+
+	// the die event of a restarting container may come after the next start
+	_, ok = containerLifetime(inspectWithTimes("2024-05-01T10:00:00Z", "2024-05-01T10:00:05Z", "2024-05-01T10:00:03Z"))
+	assert.False(t, ok)
+}
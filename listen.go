@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// httpListeners returns the listeners the HTTP server is served on: sockets
+// passed by systemd socket activation, a unix socket, or the TCP port.
+func httpListeners(port int) ([]net.Listener, error) {
+	listeners, err := systemdListeners()
+	if err != nil || len(listeners) > 0 {
+		return listeners, err
+	}
+
+	if path := envString("DEX_LISTEN_UNIX", ""); path != "" {
+		listener, err := unixListener(path)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%v", port))
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{listener}, nil
+}
+
+func unixListener(path string) (net.Listener, error) {
+	// remove a stale socket left behind by a previous run
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := strconv.ParseUint(envString("DEX_LISTEN_UNIX_MODE", "0660"), 8, 32)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("invalid DEX_LISTEN_UNIX_MODE: %v", err)
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
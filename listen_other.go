@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// systemdListeners returns no listeners, socket activation is only supported
+// on linux.
+func systemdListeners() ([]net.Listener, error) {
+	return nil, nil
+}
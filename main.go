@@ -2,38 +2,316 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/docker/docker/api/types/events"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
 
 	log "github.com/sirupsen/logrus"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		os.Exit(checkConfig(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "generate-rules" {
+		os.Exit(generateRules(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	if path := os.Getenv("DEX_CONFIG"); path != "" {
+		cfg, err := loadConfig(path)
+		if err != nil {
+			log.Fatalf("can't load config: %v", err)
+		}
+		for _, key := range cfg.unknownOptions() {
+			log.Warnf("unknown option %s in %s", key, path)
+		}
+		config = cfg
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	// set before the docker clients are created, they trace their requests
+	if tp := newTracerProvider(ctx); tp != nil {
+		otel.SetTracerProvider(tp)
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := tp.Shutdown(shutdownCtx); err != nil {
+				log.Error("can't flush traces: ", err)
+			}
+		}()
+	}
+
+	privileges := newPrivilegedFeatures()
+	if envBool("DEX_READ_ONLY", false) {
+		if err := privileges.checkReadOnly(); err != nil {
+			log.Fatalf("can't run read-only: %v", err)
+		}
+	}
+
 	reg := prometheus.NewRegistry()
-	reg.MustRegister(newDockerCollector())
+	collector := newDockerCollector()
+	versions := newAPIVersionCheck(collector.cli, collector.api)
+	versions.check(ctx)
+	endpoints := newSocketProxyCheck(ctx, collector.cli, collector.api)
 
-	router := http.NewServeMux()
-	router.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{
-		Registry: reg,
-	}))
+	// labels added to all metrics of this instance, the configured ones take precedence
+	labels := staticLabels()
+	hostLabels := mergeLabels(newHostLabels(collector.cli), newSwarmNodeLabels(collector.cli), labels)
+	registerer := prometheus.WrapRegistererWith(hostLabels, reg)
 
-	serverPort := 8080
+	// the expensive collectors of images and disk usage can be scraped less
+	// often at their own path, the outputs and the UI still get all metrics
+	var gatherer prometheus.Gatherer = reg
+	slowRegisterer := registerer
+	slowPath := slowMetricsPath()
+	var slow *prometheus.Registry
+	if slowPath != "" {
+		slow = prometheus.NewRegistry()
+		slowRegisterer = prometheus.WrapRegistererWith(hostLabels, slow)
+		gatherer = prometheus.Gatherers{reg, slow}
+	}
+	registerer.MustRegister(configErrors, versions, privileges)
+	if endpoints != nil {
+		registerer.MustRegister(endpoints)
+	}
 
-	if strPort, isSet := os.LookupEnv("DEX_PORT"); isSet {
-		if intPort, err := strconv.Atoi(strPort); err == nil {
-			serverPort = intPort
+	// the filters of DEX_DOCKER_HOST follow the global rules in both modes
+	admin := newFilterAdmin(collector.filter.currentRules())
+	if admin != nil {
+		admin.add(collector)
+	}
+
+	// in multi-host mode the other collectors still use DEX_DOCKER_HOST
+	var containers prometheus.Collector
+	var refresh *RefreshHandler
+	var checker collectionChecker = collector
+	if hosts := newDockerHosts(); hosts != nil {
+		containers = hosts
+		checker = hosts
+		go hosts.Run(ctx)
+		refresh = newRefreshHandler(hosts.samplers)
+		if admin != nil {
+			admin.add(hosts)
+		}
+	} else if endpoints.require("containers", endpointContainers) {
+		containers = collector
+		if collector.sampler != nil {
+			go collector.sampler.Run(ctx)
 		}
+		refresh = newRefreshHandler(func() []*StatsSampler { return []*StatsSampler{collector.sampler} })
+	} else {
+		refresh = newRefreshHandler(func() []*StatsSampler { return nil })
+	}
+
+	var guard *ScrapeGuard
+	if containers != nil {
+		// scrapes are served from the background collections on low-power hosts
+		if interval := newIntervalCollector(containers); interval != nil {
+			containers = interval
+			go interval.Run(ctx)
+			refresh.add(interval)
+		}
+
+		// the guard serializes the collections of the containers
+		guard = newScrapeGuard(containers)
+		if guard != nil {
+			registerer.MustRegister(guard)
+			containers = guard
+		} else {
+			registerer.MustRegister(containers)
+		}
+	}
+
+	if scanner := newVulnerabilityScanner(collector.cli, collector.api); scanner != nil && endpoints.require("vulnerability_scan", endpointContainers) {
+		slowRegisterer.MustRegister(scanner)
+		go scanner.Run(ctx)
 	}
 
+	if daemon := newDaemonCollector(collector.cli, collector.api); daemon != nil && endpoints.require("daemon", endpointInfo) {
+		registerer.MustRegister(daemon)
+	}
+
+	// the leader exports the cluster metrics, they get only the configured labels
+	// so their series don't change when the leadership moves
+	if swarm := newSwarmCollector(collector.cli, collector.api); swarm != nil && endpoints.require("swarm", endpointInfo) {
+		prometheus.WrapRegistererWith(labels, reg).MustRegister(swarm)
+	}
+
+	if expected := newExpectedContainers(collector.cli, collector.api); expected != nil && endpoints.require("expected", endpointContainers) {
+		registerer.MustRegister(expected)
+	}
+
+	if proxy := newMetricsProxy(collector.cli, collector.api, collector.filter, hostLabels); proxy != nil && endpoints.require("proxy", endpointContainers) {
+		registerer.MustRegister(proxy)
+	}
+
+	if networks := newNetworkCollector(collector.cli, collector.api); networks != nil {
+		registerer.MustRegister(networks)
+	}
+
+	if buildCache := newBuildCacheCollector(collector.cli, collector.api); buildCache != nil {
+		slowRegisterer.MustRegister(buildCache)
+		go buildCache.Run(ctx)
+		refresh.add(buildCache)
+	}
+
+	if dangling := newDanglingCollector(collector.cli, collector.api); dangling != nil {
+		slowRegisterer.MustRegister(dangling)
+		go dangling.Run(ctx)
+		refresh.add(dangling)
+	}
+
+	if layers := newLayerSizeCollector(collector.cli, collector.api, collector.filter); layers != nil && endpoints.require("layer_size", endpointContainers) {
+		slowRegisterer.MustRegister(layers)
+		go layers.Run(ctx)
+		refresh.add(layers)
+	}
+
+	if offsets := newTimeOffsetCollector(collector.cli, collector.api, collector.filter); offsets != nil && endpoints.require("time_offset", endpointContainers) {
+		registerer.MustRegister(offsets)
+		go offsets.Run(ctx)
+		refresh.add(offsets)
+	}
+
+	for _, plugin := range newCollectorPlugins(collector.cli) {
+		registerer.MustRegister(plugin)
+	}
+
+	if plugins := newExecPlugins(hostLabels); plugins != nil {
+		registerer.MustRegister(plugins)
+	}
+
+	// served at /receive below
+	receiver := newRemoteWriteReceiver(collector.cli, collector.api, collector.filter, hostLabels)
+	if receiver != nil && !endpoints.require("receive", endpointContainers) {
+		receiver = nil
+	}
+	if receiver != nil {
+		registerer.MustRegister(receiver)
+	}
+
+	// the scripts run only for the scrapes of /metrics, not again for every
+	// output and internal consumer gathering the registry
+	scraped := prometheus.NewRegistry()
+	if scripts := newContainerScripts(collector.cli, collector.api, collector.filter, hostLabels); scripts != nil && endpoints.require("container_scripts", endpointContainers) {
+		prometheus.WrapRegistererWith(hostLabels, scraped).MustRegister(scripts)
+	}
+
+	watcher := newEventWatcher(collector.cli, collector.api)
+
+	if durations := newStartDurations(collector.cli, collector.api, collector.filter); durations != nil && endpoints.require("start_durations", endpointContainers, endpointEvents) {
+		registerer.MustRegister(durations)
+		watcher.handle(events.ActionStart, durations.handleStart)
+	}
+
+	if lifetimes := newContainerLifetimes(collector.cli, collector.api, collector.filter); lifetimes != nil && endpoints.require("lifetimes", endpointContainers, endpointEvents) {
+		registerer.MustRegister(lifetimes)
+		watcher.handle(events.ActionDie, lifetimes.handleDie)
+	}
+
+	if kills := newOOMKills(collector.filter); kills != nil && endpoints.require("oom_kills", endpointEvents) {
+		registerer.MustRegister(kills)
+		watcher.handle(events.ActionOOM, kills.handleOOM)
+		go kills.Run(ctx)
+	}
+
+	if pauses := newPauseEvents(collector.filter); pauses != nil && endpoints.require("pauses", endpointEvents) {
+		registerer.MustRegister(pauses)
+		watcher.handle(events.ActionPause, pauses.handlePause)
+		watcher.handle(events.ActionUnPause, pauses.handleUnpause)
+	}
+
+	if eventLog := newEventLog(collector.filter); eventLog != nil {
+		defer eventLog.Close()
+		if endpoints.require("event_log", endpointEvents) {
+			for _, action := range eventLogActions {
+				watcher.handle(action, eventLog.handle)
+			}
+		}
+	}
+
+	go watcher.Run(ctx)
+
+	// push outputs run only on the leader, so samples aren't sent twice
+	leader := newLeaderElection()
+	if leader != nil {
+		registerer.MustRegister(leader)
+		go leader.Run(ctx)
+	}
+
+	if evaluator := newAlertEvaluator(gatherer); evaluator != nil {
+		go leader.runWhileLeader(ctx, evaluator.Run)
+	}
+
+	if publisher := newMQTTPublisher(gatherer); publisher != nil {
+		go leader.runWhileLeader(ctx, publisher.Run)
+	}
+
+	if sender := newZabbixSender(gatherer); sender != nil {
+		go leader.runWhileLeader(ctx, sender.Run)
+	}
+
+	if publisher := newCloudWatchPublisher(ctx, gatherer); publisher != nil {
+		go leader.runWhileLeader(ctx, publisher.Run)
+	}
+
+	// every instance sends its own heartbeat, not only the leader
+	if heartbeat := newHeartbeat(containers, checker); heartbeat != nil {
+		go heartbeat.Run(ctx)
+	}
+
+	access := newAccessList()
+
+	startGRPCServer(ctx, gatherer, access)
+
+	exposition := newExpositionMetrics()
+	registerer.MustRegister(exposition)
+
+	// the status page shows the containers of the last scrape
+	status := newStatusRecorder(prometheus.Gatherers{reg, scraped})
+
+	router := http.NewServeMux()
+	router.Handle("/metrics", access.Wrap(guard.Wrap(exposition.Wrap(newMetricsHandler(reg, status)))))
+	if slow != nil {
+		router.Handle(slowPath, access.Wrap(exposition.Wrap(newMetricsHandler(reg, slow))))
+	}
+	router.Handle("/", access.Wrap(statusHandler(status)))
+	// the dashboard queries the metrics as scraped, renamed by the relabeling
+	router.Handle("/dashboard/grafana.json", access.Wrap(guard.Wrap(dashboardHandler(newMetricRelabeler(gatherer, config.MetricRelabelConfigs)))))
+	router.Handle("/-/refresh", access.Wrap(refresh))
+	router.Handle("/-/ready", readyHandler(versions))
+	if receiver != nil {
+		router.Handle("/receive", access.Wrap(receiver))
+	}
+
+	if envBool("DEX_DEBUG_ENDPOINTS", false) {
+		router.Handle("GET /debug/containers/{name}/stats", access.Wrap(debugStatsHandler(collector.cli, collector.api)))
+	}
+
+	router.Handle("GET /api/v1/metadata", access.Wrap(metadataHandler()))
+	router.Handle("GET /api/v1/filters/test", access.Wrap(filterTestHandler(collector.cli, collector.api, collector.filter)))
+	if admin != nil {
+		router.Handle("/api/v1/filters", access.Wrap(admin))
+	}
+
+	if history := newHistory(gatherer); history != nil {
+		router.Handle("/api/v1/history", access.Wrap(history))
+		go history.Run(ctx)
+	}
+
+	serverPort := envInt("DEX_PORT", 8080)
+
 	server := &http.Server{
-		Addr:         fmt.Sprintf(":%v", serverPort),
 		Handler:      router,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 120 * time.Second,
@@ -43,26 +321,69 @@ func main() {
 	done := make(chan bool)
 
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
 		<-quit
 		log.Info("Server is shutting down...")
+		stop()
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		if err := server.Shutdown(ctx); err != nil {
+		if err := server.Shutdown(shutdownCtx); err != nil {
 			log.Fatalf("Could not gracefully shutdown the server: %v\n", err)
 		}
 		close(done)
 	}()
 
-	log.Info("Server is ready to handle requests at :", serverPort)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Could not listen on %d: %v\n", serverPort, err)
+	listeners, err := httpListeners(serverPort)
+	if err != nil {
+		log.Fatalf("Could not listen: %v\n", err)
+	}
+	if err := privileges.dropPrivileges(); err != nil {
+		log.Fatalf("can't drop privileges: %v", err)
 	}
 
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			log.Info("Server is ready to handle requests at ", listener.Addr())
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Could not serve on %s: %v\n", listener.Addr(), err)
+			}
+		}(listener)
+	}
+
+	go runSystemdNotify(ctx, collector)
+
 	<-done
 	log.Info("Server stopped")
 }
+
+// newMetricsHandler limits concurrent and slow scrapes, so misbehaving
+// scrapers can't overload the Docker daemon through dex. Metric relabeling
+// applies to the scrapes of /metrics and the slow metrics path, not to the
+// alerting, push outputs and other internal consumers of the registry.
+func newMetricsHandler(reg *prometheus.Registry, gatherer prometheus.Gatherer) http.Handler {
+	gatherer = newMetricRelabeler(gatherer, config.MetricRelabelConfigs)
+
+	return promhttp.InstrumentMetricHandler(reg, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		ErrorLog:            log.StandardLogger(),
+		Registry:            reg,
+		Timeout:             envDuration("DEX_SCRAPE_TIMEOUT", 0),
+		MaxRequestsInFlight: envInt("DEX_MAX_REQUESTS_IN_FLIGHT", 0),
+		DisableCompression:  envBool("DEX_DISABLE_COMPRESSION", false),
+	}))
+}
+
+// slowMetricsPath returns the path the slow collectors are served at, empty if
+// they are served at /metrics with the others.
+func slowMetricsPath() string {
+	path := envString("DEX_SLOW_METRICS_PATH", "")
+	if err := validateMetricsPath(path); err != nil {
+		log.Errorf("invalid DEX_SLOW_METRICS_PATH, serving all metrics at /metrics: %v", err)
+		configErrors.add(configKey("DEX_SLOW_METRICS_PATH"))
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// types of the metrics, named like in the metadata API of Prometheus
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+)
+
+// metricDef describes a metric of DEX. The emitted metrics take their help
+// from here and /api/v1/metadata serves the definitions, so integrations and
+// the docs can't drift from the code.
+type metricDef struct {
+	name string
+	typ  string
+	help string
+	// labels of the metric, without the host labels and, for container
+	// metrics, after the container labels
+	labels    []string
+	container bool
+}
+
+// metricDefs are all metrics of DEX by name. The metrics of plugins, scripts,
+// the proxy and the remote write receiver are named by their sources.
+var metricDefs = []metricDef{
+	{"dex_block_io_queued_operations", metricGauge, "Number of block I/O operations of the container queued in the scheduler", nil, true},
+	{"dex_block_io_read_bytes_per_second_limit", metricGauge, "Configured read rate limit of the device in bytes per second", []string{"device"}, true},
+	{"dex_block_io_read_bytes_total", metricCounter, "Block I/O read bytes", nil, true},
+	{"dex_block_io_read_iops_limit", metricGauge, "Configured read rate limit of the device in operations per second", []string{"device"}, true},
+	{"dex_block_io_wait_seconds_total", metricCounter, "Time block I/O operations of the container spent waiting in the scheduler queues", nil, true},
+	{"dex_block_io_write_bytes_per_second_limit", metricGauge, "Configured write rate limit of the device in bytes per second", []string{"device"}, true},
+	{"dex_block_io_write_bytes_total", metricCounter, "Block I/O write bytes", nil, true},
+	{"dex_block_io_write_iops_limit", metricGauge, "Configured write rate limit of the device in operations per second", []string{"device"}, true},
+	{"dex_build_cache_bytes", metricGauge, "Size of the build cache records in bytes", []string{"type", "shared", "in_use"}, false},
+	{"dex_build_cache_entries", metricGauge, "Number of build cache records", []string{"type", "shared", "in_use"}, false},
+	{"dex_collector_enabled", metricGauge, "1 if the collector is enabled, 0 if it is disabled because the socket proxy denies an endpoint it uses", []string{"collector"}, false},
+	{"dex_compose_project_containers", metricGauge, "Number of containers of the compose project by state", []string{"compose_project", "state"}, false},
+	{"dex_compose_project_cpu_headroom_cores", metricGauge, "CPU cores the running containers of the compose project with a CPU limit don't use", []string{"compose_project"}, false},
+	{"dex_compose_project_cpu_utilization_seconds_total", metricCounter, "Cumulative CPU utilization in seconds of the running containers of the compose project", []string{"compose_project"}, false},
+	{"dex_compose_project_healthy", metricGauge, "1 if all containers of the compose project are running and healthy, 0 otherwise", []string{"compose_project"}, false},
+	{"dex_compose_project_memory_headroom_bytes", metricGauge, "Memory bytes the running containers of the compose project with a memory limit don't use", []string{"compose_project"}, false},
+	{"dex_compose_project_memory_usage_bytes", metricGauge, "Memory usage bytes of the running containers of the compose project", []string{"compose_project"}, false},
+	{"dex_config_error", metricGauge, "Whether an option is invalid and its default is used instead", []string{"option"}, false},
+	{"dex_container_absent", metricGauge, "1 if the container was removed within DEX_ABSENT_CONTAINERS_TTL, 0 otherwise", nil, true},
+	{"dex_container_cpuset_cpus", metricGauge, "Number of CPUs the container is pinned to", nil, true},
+	{"dex_container_cpuset_info", metricGauge, "CPUs and NUMA memory nodes the container is pinned to, empty if not pinned", []string{"cpus", "mems"}, true},
+	{"dex_container_env_present", metricGauge, "1 if the environment variable is set in the container, 0 otherwise", []string{"var"}, true},
+	{"dex_container_exit_code", metricGauge, "Exit code of the exited container", nil, true},
+	{"dex_container_exited", metricGauge, "1 if docker container exited, 0 otherwise", nil, true},
+	{"dex_container_failed", metricGauge, "1 if the exited container returned a non-zero exit code, 0 otherwise", nil, true},
+	{"dex_container_healthy", metricGauge, "1 if docker container healthcheck reports healthy, 0 otherwise", nil, true},
+	{"dex_container_image_layers_bytes", metricGauge, "Size of the image layers of the container, shared with other containers of the image", nil, true},
+	{"dex_container_info", metricGauge, "Information about the docker container", []string{"container_id", "image"}, true},
+	{"dex_container_last_seen_timestamp_seconds", metricGauge, "Time the container was last listed", nil, true},
+	{"dex_container_lifetime_seconds", metricHistogram, "Time containers ran from their start until they stopped", []string{"image"}, false},
+	{"dex_container_log_driver", metricGauge, "Always 1, labeled with the logging driver of the container and its rotation options", []string{"driver", "max_size", "max_file"}, true},
+	{"dex_container_memory_numa_bytes", metricGauge, "Memory of the container on the NUMA node by type anon or file", []string{"node", "type"}, true},
+	{"dex_container_mount_info", metricGauge, "Always 1, labeled with the type, source, destination and writability of a mount of the container", []string{"type", "source", "destination", "rw"}, true},
+	{"dex_container_mounts", metricGauge, "Number of mounts of the container by type", []string{"type"}, true},
+	{"dex_container_paused", metricGauge, "1 if docker container is paused, 0 otherwise", nil, true},
+	{"dex_container_pauses_total", metricCounter, "Number of times the container was paused", nil, true},
+	{"dex_container_restarting", metricGauge, "1 if docker container is restarting, 0 otherwise", nil, true},
+	{"dex_container_restarts_total", metricCounter, "Number of times the container has restarted", nil, true},
+	{"dex_container_rootfs_inodes_free", metricGauge, "Number of free inodes of the filesystem of the writable layer of the container", nil, true},
+	{"dex_container_rootfs_inodes_used", metricGauge, "Number of inodes used by the writable layer of the container", nil, true},
+	{"dex_container_running", metricGauge, "1 if docker container is running, 0 otherwise", nil, true},
+	{"dex_container_running_as_root", metricGauge, "1 if the container runs as root, 0 otherwise", nil, true},
+	{"dex_container_rw_layer_bytes", metricGauge, "Size of the writable layer of the container", nil, true},
+	{"dex_container_script_up", metricGauge, "1 if the script ran for the container and its output was parsed, 0 otherwise", []string{"script"}, true},
+	{"dex_container_start_duration_seconds", metricHistogram, "Time from creating a container to running it", []string{"image"}, false},
+	{"dex_container_state_changed_timestamp_seconds", metricGauge, "Time the container was last started or stopped, its creation time if it never ran", nil, true},
+	{"dex_container_stats_timeout", metricGauge, "1 if reading the stats of the container timed out in this scrape, 0 otherwise", nil, true},
+	{"dex_container_threads", metricGauge, "Number of threads of the processes in the container", nil, true},
+	{"dex_container_time_offset_seconds", metricGauge, "Offset of the container clock from the clock of dex, accurate to the duration of an exec", nil, true},
+	{"dex_container_tmpfs_size_bytes", metricGauge, "Size limit of the tmpfs mount of the container", []string{"mountpoint"}, true},
+	{"dex_container_tmpfs_used_bytes", metricGauge, "Used bytes of the tmpfs mount of the container, including /dev/shm", []string{"mountpoint"}, true},
+	{"dex_container_ulimit", metricGauge, "Configured ulimit of the container, +Inf if unlimited", []string{"name", "type"}, true},
+	{"dex_container_unpauses_total", metricCounter, "Number of times the container was unpaused", nil, true},
+	{"dex_container_user_info", metricGauge, "Always 1, labeled with the configured user of the container, empty for the default root", []string{"user"}, true},
+	{"dex_container_utc_offset_seconds", metricGauge, "UTC offset of the timezone of the container", nil, true},
+	{"dex_container_zombie_processes", metricGauge, "Number of zombie processes in the container", nil, true},
+	{"dex_cpu_headroom_cores", metricGauge, "CPU limit minus the CPU utilization in cores", nil, true},
+	{"dex_cpu_mode_seconds_total", metricCounter, "Cumulative CPU time in seconds spent in user or kernel mode", []string{"mode"}, true},
+	{"dex_cpu_utilization_percent", metricGauge, "CPU utilization in percent", nil, true},
+	{"dex_cpu_utilization_seconds_total", metricCounter, "Cumulative CPU utilization in seconds", nil, true},
+	{"dex_dangling_image_bytes", metricGauge, "Size of untagged images without layers shared with other images", nil, false},
+	{"dex_dangling_images_total", metricGauge, "Number of untagged images", nil, false},
+	{"dex_docker_api_errors_total", metricCounter, "Number of failed Docker API requests", []string{"operation"}, false},
+	{"dex_docker_api_request_duration_seconds", metricHistogram, "Duration of Docker API requests", []string{"operation"}, false},
+	{"dex_docker_api_stream_reconnects_total", metricCounter, "Number of reopened Docker API streams after they failed", []string{"operation"}, false},
+	{"dex_docker_api_throttled_seconds_total", metricCounter, "Time Docker API requests waited for the rate limiters", nil, false},
+	{"dex_docker_host_collected_timestamp_seconds", metricGauge, "Time of the last background collection of the docker host, see the interval of the hosts configuration", nil, false},
+	{"dex_docker_hosts", metricGauge, "Number of docker hosts collected in multi-host mode", nil, false},
+	{"dex_docker_info", metricGauge, "Docker API versions of the daemon and the one negotiated by dex", []string{"api_version", "daemon_api_version"}, false},
+	{"dex_docker_plugin_enabled", metricGauge, "1 if the docker plugin is enabled, 0 otherwise", []string{"plugin", "type"}, false},
+	{"dex_docker_runtime_info", metricGauge, "Container runtimes configured in the docker daemon", []string{"runtime", "default"}, false},
+	{"dex_expected_container_missing", metricGauge, "1 if no container matches the expected container, 0 otherwise", []string{"name"}, false},
+	{"dex_host_block_io_read_bytes_total", metricCounter, "Block I/O read bytes of the containers including stopped ones", nil, false},
+	{"dex_host_block_io_write_bytes_total", metricCounter, "Block I/O write bytes of the containers including stopped ones", nil, false},
+	{"dex_host_containers_measured", metricGauge, "Number of running containers whose stats are summed in the dex_host_ totals", nil, false},
+	{"dex_host_cpu_utilization_seconds_total", metricCounter, "Cumulative CPU utilization in seconds of the containers including stopped ones", nil, false},
+	{"dex_host_memory_usage_bytes", metricGauge, "Memory usage bytes of the running containers", nil, false},
+	{"dex_host_network_rx_bytes_total", metricCounter, "Network received bytes of the containers including stopped ones", nil, false},
+	{"dex_host_network_tx_bytes_total", metricCounter, "Network sent bytes of the containers including stopped ones", nil, false},
+	{"dex_image_vulnerabilities", metricGauge, "Number of known vulnerabilities in the image of running containers", []string{"image", "severity"}, false},
+	{"dex_image_vulnerability_scan_errors_total", metricCounter, "Number of failed image vulnerability scans", nil, false},
+	{"dex_last_collection_timestamp_seconds", metricGauge, "Time the containers were last collected in the background, see DEX_COLLECT_INTERVAL", nil, false},
+	{"dex_leader", metricGauge, "1 if this instance is the leader running the push outputs, 0 otherwise", nil, false},
+	{"dex_memory_headroom_bytes", metricGauge, "Memory limit minus the memory usage in bytes", nil, true},
+	{"dex_memory_limit_set", metricGauge, "1 if docker container has a memory limit, 0 otherwise", nil, true},
+	{"dex_memory_total_bytes", metricGauge, "Total memory bytes", nil, true},
+	{"dex_memory_usage_bytes", metricCounter, "Total memory usage bytes", nil, true},
+	{"dex_memory_utilization_percent", metricGauge, "Memory utilization percent", nil, true},
+	{"dex_metrics_response_size_bytes", metricHistogram, "Size of the metrics responses after compression", []string{"path", "encoding"}, false},
+	{"dex_metrics_serialization_duration_seconds", metricHistogram, "Time spent encoding and compressing the metrics responses, after the collection", []string{"path", "encoding"}, false},
+	{"dex_network_containers", metricGauge, "Number of containers connected to the docker network", []string{"network"}, false},
+	{"dex_network_info", metricGauge, "Information about the docker network", []string{"network", "driver", "scope", "internal"}, false},
+	{"dex_network_rx_bytes_per_second", metricGauge, "Network received bytes per second between the last two samples", nil, true},
+	{"dex_network_rx_bytes_total", metricCounter, "Network received bytes total", nil, true},
+	{"dex_network_subnet_addresses", metricGauge, "Number of addresses containers can be assigned in the subnet", []string{"network", "subnet"}, false},
+	{"dex_network_subnet_allocated_addresses", metricGauge, "Number of allocated addresses in the subnet, including the gateway", []string{"network", "subnet"}, false},
+	{"dex_network_tx_bytes_per_second", metricGauge, "Network sent bytes per second between the last two samples", nil, true},
+	{"dex_network_tx_bytes_total", metricCounter, "Network sent bytes total", nil, true},
+	{"dex_oom_kills_total", metricCounter, "Number of processes killed by the OOM killer in the container", []string{"process"}, true},
+	{"dex_pids_current", metricCounter, "Current number of pids in the cgroup", nil, true},
+	{"dex_plugin_duration_seconds", metricGauge, "Time the plugin ran", []string{"plugin"}, false},
+	{"dex_plugin_up", metricGauge, "1 if the plugin ran and its output was parsed, 0 otherwise", []string{"plugin"}, false},
+	{"dex_privileged_features_enabled", metricGauge, "1 if the feature needing more than read access to the Docker API is enabled, 0 otherwise", []string{"feature"}, false},
+	{"dex_proxy_up", metricGauge, "1 if the metrics of the container were scraped, 0 otherwise", nil, true},
+	{"dex_receive_requests_total", metricCounter, "Number of remote write requests received by status code", []string{"code"}, false},
+	{"dex_receive_series", metricGauge, "Number of series received by remote write and exported", nil, false},
+	{"dex_receive_series_dropped_total", metricCounter, "Number of received series dropped because of DEX_RECEIVE_MAX_SERIES", nil, false},
+	{"dex_scrape_overlaps_total", metricCounter, "Number of scrapes which arrived while a collection was running", []string{"mode"}, false},
+	{"dex_stopped_container_bytes", metricGauge, "Size of the writable layers of stopped containers", nil, false},
+	{"dex_stopped_containers_total", metricGauge, "Number of stopped containers", nil, false},
+	{"dex_swarm_node_info", metricGauge, "Information about the swarm node", []string{"swarm_node_id", "swarm_node", "swarm_node_role", "availability", "state"}, false},
+	{"dex_swarm_service_desired_tasks", metricGauge, "Number of tasks the swarm service should run", []string{"service", "mode"}, false},
+	{"dex_swarm_service_running_tasks", metricGauge, "Number of running tasks of the swarm service", []string{"service", "mode"}, false},
+	{"dex_unused_volume_bytes", metricGauge, "Size of volumes not used by any container", nil, false},
+	{"dex_unused_volumes_total", metricGauge, "Number of volumes not used by any container", nil, false},
+}
+
+var metricDefsByName = func() map[string]*metricDef {
+	defs := map[string]*metricDef{}
+	for i := range metricDefs {
+		defs[metricDefs[i].name] = &metricDefs[i]
+	}
+	return defs
+}()
+
+// metricHelp returns the help of a metric of metricDefs. It panics for
+// unknown metrics, so none is emitted without a definition.
+func metricHelp(name string) string {
+	def, ok := metricDefsByName[name]
+	if !ok {
+		panic(fmt.Sprintf("metric %s is not defined in metricDefs", name))
+	}
+	return def.help
+}
+
+// matchesLabels reports whether the label names are those of the metric. The
+// labels of container metrics start with the labels of the container.
+func (def *metricDef) matchesLabels(labelNames []string) bool {
+	sorted := slices.Clone(labelNames)
+	slices.Sort(sorted)
+	if len(slices.Compact(sorted)) != len(labelNames) {
+		return false
+	}
+	if !def.container {
+		return slices.Equal(labelNames, def.labels)
+	}
+	return len(labelNames) > len(def.labels) && labelNames[0] == "container_name" &&
+		slices.Equal(labelNames[len(labelNames)-len(def.labels):], def.labels)
+}
+
+// newDesc returns a Desc of a metric of metricDefs with the label names. For
+// an unknown metric or label names not matching the definition the Desc is
+// invalid, so the scrape fails instead of the metadata API drifting from what
+// is emitted.
+func newDesc(name string, labelNames []string) *prometheus.Desc {
+	def, ok := metricDefsByName[name]
+	if !ok {
+		return prometheus.NewInvalidDesc(fmt.Errorf("metric %s is not defined in metricDefs", name))
+	}
+	if !def.matchesLabels(labelNames) {
+		return prometheus.NewInvalidDesc(fmt.Errorf("labels %v of metric %s don't match metricDefs %v", labelNames, name, def.labels))
+	}
+	return prometheus.NewDesc(name, def.help, labelNames, nil)
+}
+
+// metricMetadata is a metric in the format of the metadata API of Prometheus,
+// with the labels of the metric.
+type metricMetadata struct {
+	Type   string   `json:"type"`
+	Help   string   `json:"help"`
+	Unit   string   `json:"unit"`
+	Labels []string `json:"labels"`
+	// the metric has the labels of the container before its own labels
+	Container bool `json:"container"`
+}
+
+// metadataHandler serves GET /api/v1/metadata like Prometheus does for its
+// targets, optionally only the metric of the metric parameter.
+func metadataHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("metric")
+
+		data := map[string][]metricMetadata{}
+		for _, def := range metricDefs {
+			if name != "" && def.name != name {
+				continue
+			}
+			typ := def.typ
+			// sampled in the background the CPU utilization is a histogram
+			if def.name == "dex_cpu_utilization_percent" && envBool("DEX_CPU_HISTOGRAM", false) {
+				typ = metricHistogram
+			}
+			data[def.name] = []metricMetadata{{
+				Type:      typ,
+				Help:      def.help,
+				Labels:    append([]string{}, def.labels...),
+				Container: def.container,
+			}}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]any{"status": "success", "data": data}); err != nil {
+			log.Error("can't write metadata response: ", err)
+		}
+	})
+}
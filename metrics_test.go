@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricDefs(t *testing.T) {
+	names := make([]string, 0, len(metricDefs))
+	for _, def := range metricDefs {
+		assert.Regexp(t, `^dex_[a-z0-9_]+$`, def.name)
+		assert.Contains(t, []string{metricCounter, metricGauge, metricHistogram}, def.typ, def.name)
+		assert.NotEmpty(t, def.help, def.name)
+		for _, label := range def.labels {
+			assert.Regexp(t, labelNameRe, label, def.name)
+		}
+		names = append(names, def.name)
+	}
+	assert.True(t, sort.StringsAreSorted(names), "metricDefs must be sorted by name")
+	assert.Len(t, metricDefsByName, len(metricDefs), "metric names must be unique")
+
+	assert.Panics(t, func() { metricHelp("dex_undefined") })
+
+	invalid := func(desc *prometheus.Desc, labelValues ...string) error {
+		_, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, 1, labelValues...)
+		return err
+	}
+	assert.NoError(t, invalid(newDesc("dex_container_mounts", []string{"container_name", "type"}), "web", "bind"))
+	assert.ErrorContains(t, invalid(newDesc("dex_undefined", nil)), "not defined")
+	assert.ErrorContains(t, invalid(newDesc("dex_container_running", []string{"image"}), "x"), "don't match")
+	assert.ErrorContains(t, invalid(newDesc("dex_plugin_up", nil)), "don't match")
+	assert.ErrorContains(t, invalid(newDesc("dex_container_mounts", []string{"container_name", "type", "type"}), "web", "web", "bind"),
+		"don't match", "A static label named like a label of the metric must not be emitted")
+}
+
+// TestMetricDefsCallSites walks the sources for every Desc and vector created
+// from metricDefs and checks the names, the static label names and the types
+// against the definitions.
+func TestMetricDefsCallSites(t *testing.T) {
+	fset := token.NewFileSet()
+	files, err := filepath.Glob("*.go")
+	require.NoError(t, err)
+
+	valueTypes := map[string]string{
+		"CounterValue": metricCounter,
+		"GaugeValue":   metricGauge,
+	}
+	vecTypes := map[string]string{
+		"NewCounter":      metricCounter,
+		"NewCounterVec":   metricCounter,
+		"NewGauge":        metricGauge,
+		"NewGaugeVec":     metricGauge,
+		"NewHistogram":    metricHistogram,
+		"NewHistogramVec": metricHistogram,
+	}
+	metricName := regexp.MustCompile(`^dex_[a-z0-9_]+$`)
+	// the type of the metric when DEX_CPU_HISTOGRAM is set
+	histograms := map[string]bool{"dex_cpu_utilization_percent": true}
+	literal := func(expr ast.Expr) (string, bool) {
+		lit, ok := expr.(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return "", false
+		}
+		value, err := strconv.Unquote(lit.Value)
+		return value, err == nil
+	}
+	// descName returns the metric name of a newDesc or descs.get call
+	descName := func(expr ast.Expr) (*ast.CallExpr, string, bool) {
+		call, ok := expr.(*ast.CallExpr)
+		if !ok || len(call.Args) != 2 {
+			return nil, "", false
+		}
+		switch fun := call.Fun.(type) {
+		case *ast.Ident:
+			if fun.Name != "newDesc" {
+				return nil, "", false
+			}
+		case *ast.SelectorExpr:
+			if recv, ok := fun.X.(*ast.Ident); !ok || recv.Name != "descs" || fun.Sel.Name != "get" {
+				return nil, "", false
+			}
+		default:
+			return nil, "", false
+		}
+		name, ok := literal(call.Args[0])
+		return call, name, ok
+	}
+
+	sites := 0
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		require.NoError(t, err)
+
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.BasicLit:
+				// names taken from tables like the host totals
+				if name, ok := literal(n); ok && metricName.MatchString(name) {
+					assert.NotNil(t, metricDefsByName[name], "%s: %s is not defined", fset.Position(n.Pos()), name)
+				}
+			case *ast.CallExpr:
+				pos := fset.Position(n.Pos()).String()
+				if call, name, ok := descName(n); ok {
+					sites++
+					def := metricDefsByName[name]
+					if def == nil {
+						return true
+					}
+					switch labels := call.Args[1].(type) {
+					case *ast.Ident:
+						if labels.Name == "nil" {
+							assert.False(t, def.container, "%s: %s needs the container labels", pos, name)
+							assert.Empty(t, def.labels, "%s: %s", pos, name)
+						}
+					case *ast.CompositeLit:
+						var names []string
+						for _, elt := range labels.Elts {
+							label, ok := literal(elt)
+							require.True(t, ok, pos)
+							names = append(names, label)
+						}
+						assert.False(t, def.container, "%s: %s needs the container labels", pos, name)
+						assert.Equal(t, def.labels, names, "%s: %s", pos, name)
+					case *ast.SelectorExpr:
+						assert.True(t, def.container, "%s: %s has no container labels", pos, name)
+					}
+					return true
+				}
+
+				// the type of a metric is the value type or the kind of vector
+				if len(n.Args) > 0 {
+					if _, name, ok := descName(n.Args[0]); ok && metricDefsByName[name] != nil {
+						typ := ""
+						if sel, ok := n.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "histogram" {
+							typ = metricHistogram
+						} else if len(n.Args) > 1 {
+							if sel, ok := n.Args[1].(*ast.SelectorExpr); ok {
+								typ = valueTypes[sel.Sel.Name]
+							}
+						}
+						if typ != "" && !(typ == metricHistogram && histograms[name]) {
+							assert.Equal(t, metricDefsByName[name].typ, typ, "%s: %s", pos, name)
+						}
+					}
+				}
+				sel, ok := n.Fun.(*ast.SelectorExpr)
+				if !ok || vecTypes[sel.Sel.Name] == "" || len(n.Args) == 0 {
+					return true
+				}
+				ast.Inspect(n.Args[0], func(m ast.Node) bool {
+					help, ok := m.(*ast.CallExpr)
+					if !ok {
+						return true
+					}
+					if ident, ok := help.Fun.(*ast.Ident); ok && ident.Name == "metricHelp" && len(help.Args) == 1 {
+						if name, ok := literal(help.Args[0]); ok && metricDefsByName[name] != nil {
+							sites++
+							if typ := vecTypes[sel.Sel.Name]; typ != metricHistogram || !histograms[name] {
+								assert.Equal(t, metricDefsByName[name].typ, typ, "%s: %s", pos, name)
+							}
+						}
+					}
+					return true
+				})
+			}
+			return true
+		})
+	}
+	assert.Greater(t, sites, 100)
+}
+
+// TestMetricDefsEmitted checks the type and labels of the metrics emitted
+// by the collectors against metricDefs, so the metadata API describes what is
+// scraped.
+func TestMetricDefsEmitted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			json.NewEncoder(w).Encode([]container.Summary{
+				{ID: "aaa", Names: []string{"/web"}, State: "running", Labels: map[string]string{"com.docker.compose.project": "shop"}},
+				{ID: "bbb", Names: []string{"/batch"}, State: "exited", Status: "Exited (1) 2 minutes ago"},
+			})
+		case strings.HasSuffix(r.URL.Path, "/stats"):
+			stats := sampleStats(100, 200)
+			stats.MemoryStats.Limit = 1000
+			stats.Networks = map[string]container.NetworkStats{"eth0": {RxBytes: 1, TxBytes: 2}}
+			stats.BlkioStats.IoServiceBytesRecursive = []container.BlkioStatEntry{{Op: "Read", Value: 3}}
+			json.NewEncoder(w).Encode(stats)
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			json.NewEncoder(w).Encode(container.InspectResponse{
+				ContainerJSONBase: &container.ContainerJSONBase{
+					ID:           "aaa",
+					RestartCount: 2,
+					State:        &container.State{Status: "running", Running: true, StartedAt: "2024-01-01T00:00:00Z"},
+					HostConfig:   &container.HostConfig{Resources: container.Resources{Memory: 1000, NanoCPUs: 1e9}},
+				},
+				Config: &container.Config{Image: "nginx"},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	plugins := t.TempDir()
+	writePlugin(t, plugins, "ok", "true\n", 0o755)
+	t.Setenv("DEX_PLUGIN_DIR", plugins)
+	t.Setenv("DEX_COMPOSE_AGGREGATES", "true")
+	t.Setenv("DEX_HOST_TOTALS", "true")
+	c, err := newDockerCollectorFor("tcp://"+server.Listener.Addr().String(), nil)
+	require.NoError(t, err)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c, newExecPlugins(nil))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.NotEmpty(t, families)
+
+	for _, family := range families {
+		name := family.GetName()
+		def, ok := metricDefsByName[name]
+		if !assert.True(t, ok, "%s is not defined", name) {
+			continue
+		}
+		assert.Equal(t, def.typ, strings.ToLower(family.GetType().String()), name)
+		for _, m := range family.Metric {
+			var labelNames []string
+			for _, pair := range m.Label {
+				labelNames = append(labelNames, pair.GetName())
+			}
+			if !def.container {
+				assert.Equal(t, def.labels, labelNames, name)
+				continue
+			}
+			assert.Contains(t, labelNames, "container_name", name)
+			for _, label := range def.labels {
+				assert.Contains(t, labelNames, label, name)
+			}
+		}
+	}
+}
+
+// TestMetricDefsDocumented keeps the table of docs/README.md in sync with
+// metricDefs.
+func TestMetricDefsDocumented(t *testing.T) {
+	f, err := os.Open("docs/README.md")
+	require.NoError(t, err)
+	defer f.Close()
+
+	row := regexp.MustCompile(`^\| (dex_\w+) \| (\w+) \|`)
+	documented := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m := row.FindStringSubmatch(scanner.Text()); m != nil {
+			documented[m[1]] = strings.ToLower(m[2])
+		}
+	}
+	require.NoError(t, scanner.Err())
+
+	defined := map[string]string{}
+	for _, def := range metricDefs {
+		defined[def.name] = def.typ
+	}
+	assert.Equal(t, defined, documented)
+}
+
+func TestMetadataHandler(t *testing.T) {
+	get := func(query string) map[string][]metricMetadata {
+		w := httptest.NewRecorder()
+		metadataHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/metadata"+query, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Status string                      `json:"status"`
+			Data   map[string][]metricMetadata `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.Equal(t, "success", resp.Status)
+		return resp.Data
+	}
+
+	assert.Len(t, get(""), len(metricDefs))
+	assert.Equal(t, map[string][]metricMetadata{
+		"dex_container_info": {{
+			Type:      "gauge",
+			Help:      "Information about the docker container",
+			Labels:    []string{"container_id", "image"},
+			Container: true,
+		}},
+	}, get("?metric=dex_container_info"))
+	assert.Empty(t, get("?metric=dex_undefined"))
+
+	t.Setenv("DEX_CPU_HISTOGRAM", "true")
+	assert.Equal(t, "histogram", get("?metric=dex_cpu_utilization_percent")["dex_cpu_utilization_percent"][0].Type)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type monotonicCounter struct {
+	last     float64
+	offset   float64
+	lastSeen time.Time
+}
+
+// MonotonicCounters carries counter totals across container restarts, so a
+// recreated container with the same name doesn't reset its series.
+type MonotonicCounters struct {
+	ttl time.Duration
+	// wraps32 reports whether the counters of the daemon wrap at 32 bits, they
+	// never do if nil
+	wraps32 func() bool
+
+	mu       sync.Mutex
+	counters map[string]*monotonicCounter
+}
+
+// newMonotonicCounters returns nil when monotonic accumulation is not enabled.
+func newMonotonicCounters() *MonotonicCounters {
+	if !envBool("DEX_MONOTONIC_COUNTERS", false) {
+		return nil
+	}
+
+	return &MonotonicCounters{
+		ttl:      envDuration("DEX_MONOTONIC_COUNTERS_TTL", 24*time.Hour),
+		counters: map[string]*monotonicCounter{},
+	}
+}
+
+// value returns the accumulated value of a counter. It is safe to call on a
+// nil receiver, in which case the raw value is returned.
+func (m *MonotonicCounters) value(cName, metric string, raw float64) float64 {
+	if m == nil {
+		return raw
+	}
+	wraps32 := m.wraps32 != nil && m.wraps32()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := cName + "\x00" + metric
+	counter, ok := m.counters[key]
+	if !ok {
+		counter = &monotonicCounter{}
+		m.counters[key] = counter
+	}
+
+	if wraps32 && counterWrapped32(uint64(counter.last), uint64(raw)) {
+		// the counter overflowed on a 32-bit host, continue across the wrap
+		counter.offset += 1 << 32
+	} else if raw < counter.last {
+		// the counter was reset by a restart, continue from the last total
+		counter.offset += counter.last
+	}
+	counter.last = raw
+	counter.lastSeen = time.Now()
+
+	return counter.offset + raw
+}
+
+// prune forgets counters of containers not seen for longer than the TTL.
+func (m *MonotonicCounters) prune(now time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, counter := range m.counters {
+		if now.Sub(counter.lastSeen) > m.ttl {
+			delete(m.counters, key)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonotonicCounters(t *testing.T) {
+	m := &MonotonicCounters{ttl: time.Hour, counters: map[string]*monotonicCounter{}}
+
+	assert.Equal(t, 100.0, m.value("web", "network_rx", 100))
+	assert.Equal(t, 150.0, m.value("web", "network_rx", 150))
+	assert.Equal(t, 160.0, m.value("web", "network_rx", 10), "Total should continue after a reset")
+	assert.Equal(t, 200.0, m.value("web", "network_rx", 50))
+	assert.Equal(t, 5.0, m.value("web", "network_tx", 5), "Counters should be tracked separately")
+	assert.Equal(t, 7.0, m.value("db", "network_rx", 7), "Containers should be tracked separately")
+
+	m.prune(time.Now().Add(2 * time.Hour))
+	assert.Empty(t, m.counters, "Stale counters should be pruned")
+
+	var disabled *MonotonicCounters
+	assert.Equal(t, 10.0, disabled.value("web", "network_rx", 10), "Disabled accumulation should return raw values")
+}
+
+func TestMonotonicCountersWrap32(t *testing.T) {
+	wraps32 := true
+	m := &MonotonicCounters{ttl: time.Hour, counters: map[string]*monotonicCounter{}, wraps32: func() bool { return wraps32 }}
+
+	assert.Equal(t, 4294967000.0, m.value("web", "network_rx", 4294967000))
+	assert.Equal(t, 4294967396.0, m.value("web", "network_rx", 100), "Total should continue across a 32-bit wrap")
+	assert.Equal(t, 4294967496.0, m.value("web", "network_rx", 200))
+
+	// the same drop on a 64-bit host is a reset
+	wraps32 = false
+	assert.Equal(t, 3000000000.0, m.value("db", "network_rx", 3000000000))
+	assert.Equal(t, 3000000100.0, m.value("db", "network_rx", 100), "Total should continue after a reset")
+}
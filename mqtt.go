@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var mqttInvalidIDChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+type mqttContainerState struct {
+	State         string   `json:"state"`
+	Health        string   `json:"health"`
+	Running       string   `json:"running"`
+	CPUPercent    *float64 `json:"cpu_percent,omitempty"`
+	MemoryPercent *float64 `json:"memory_percent,omitempty"`
+	MemoryMiB     *float64 `json:"memory_mib,omitempty"`
+	Restarts      float64  `json:"restarts"`
+}
+
+type mqttSensor struct {
+	key         string
+	component   string
+	name        string
+	template    string
+	unit        string
+	deviceClass string
+}
+
+var mqttSensors = []mqttSensor{
+	{"state", "sensor", "State", "{{ value_json.state }}", "", ""},
+	{"health", "sensor", "Health", "{{ value_json.health }}", "", ""},
+	{"running", "binary_sensor", "Running", "{{ value_json.running }}", "", "running"},
+	{"cpu", "sensor", "CPU", "{{ value_json.cpu_percent | default(0) }}", "%", ""},
+	{"memory", "sensor", "Memory", "{{ value_json.memory_percent | default(0) }}", "%", ""},
+	{"memory_usage", "sensor", "Memory usage", "{{ value_json.memory_mib | default(0) }}", "MiB", "data_size"},
+	{"restarts", "sensor", "Restarts", "{{ value_json.restarts }}", "", ""},
+}
+
+// MQTTPublisher periodically publishes per-container state to an MQTT broker,
+// announcing the containers through Home Assistant MQTT discovery.
+type MQTTPublisher struct {
+	gatherer        prometheus.Gatherer
+	client          mqtt.Client
+	nodeID          string
+	topicPrefix     string
+	discoveryPrefix string
+	interval        time.Duration
+
+	announced map[string]bool
+}
+
+// newMQTTPublisher returns nil when no broker is configured.
+func newMQTTPublisher(gatherer prometheus.Gatherer) *MQTTPublisher {
+	broker := envString("DEX_MQTT_BROKER", "")
+	if broker == "" {
+		return nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "dex"
+	}
+	nodeID := mqttInvalidIDChars.ReplaceAllString(hostname, "_")
+
+	interval := envDuration("DEX_MQTT_INTERVAL", 30*time.Second)
+	if interval <= 0 {
+		log.Errorf("invalid DEX_MQTT_INTERVAL '%s', using 30s", interval)
+		interval = 30 * time.Second
+		configErrors.add(configKey("DEX_MQTT_INTERVAL"))
+	}
+
+	p := &MQTTPublisher{
+		gatherer:        gatherer,
+		nodeID:          nodeID,
+		topicPrefix:     envString("DEX_MQTT_TOPIC_PREFIX", "dex/"+nodeID),
+		discoveryPrefix: envString("DEX_MQTT_DISCOVERY_PREFIX", "homeassistant"),
+		interval:        interval,
+		announced:       map[string]bool{},
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(envString("DEX_MQTT_CLIENT_ID", "dex-"+nodeID)).
+		SetUsername(envString("DEX_MQTT_USERNAME", "")).
+		SetPassword(envString("DEX_MQTT_PASSWORD", "")).
+		SetAutoReconnect(true).
+		SetWill(p.availabilityTopic(), "offline", 1, true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			c.Publish(p.availabilityTopic(), 1, true, "online")
+		})
+	p.client = mqtt.NewClient(opts)
+
+	return p
+}
+
+func (p *MQTTPublisher) availabilityTopic() string {
+	return p.topicPrefix + "/status"
+}
+
+func (p *MQTTPublisher) stateTopic(objectID string) string {
+	return p.topicPrefix + "/" + objectID + "/state"
+}
+
+func (p *MQTTPublisher) Run(ctx context.Context) {
+	if token := p.client.Connect(); token.Wait() && token.Error() != nil {
+		log.Error("can't connect to mqtt broker: ", token.Error())
+	}
+	defer func() {
+		p.client.Publish(p.availabilityTopic(), 1, true, "offline").WaitTimeout(time.Second)
+		p.client.Disconnect(250)
+	}()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if p.client.IsConnected() {
+			p.publish()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *MQTTPublisher) publish() {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		log.Error("can't gather metrics for mqtt: ", err)
+	}
+
+	seen := map[string]bool{}
+	for _, status := range containerStatuses(families) {
+		name := status.Name
+		if status.Host != "" {
+			// containers of different hosts may share a name
+			name = status.Host + " " + status.Name
+		}
+		objectID := mqttInvalidIDChars.ReplaceAllString(name, "_")
+		seen[objectID] = true
+
+		if !p.announced[objectID] && p.discoveryPrefix != "" {
+			for topic, payload := range p.discoveryConfigs(name, objectID) {
+				p.client.Publish(topic, 1, true, payload)
+			}
+			p.announced[objectID] = true
+		}
+
+		payload, err := json.Marshal(newMQTTContainerState(status))
+		if err != nil {
+			log.Error("can't marshal mqtt state: ", err)
+			continue
+		}
+		p.client.Publish(p.stateTopic(objectID), 0, true, payload)
+	}
+
+	// remove entities of containers which are gone
+	for objectID := range p.announced {
+		if seen[objectID] {
+			continue
+		}
+		for topic := range p.discoveryConfigs(objectID, objectID) {
+			p.client.Publish(topic, 1, true, "")
+		}
+		p.client.Publish(p.stateTopic(objectID), 0, true, "")
+		delete(p.announced, objectID)
+	}
+}
+
+func newMQTTContainerState(status *containerStatus) mqttContainerState {
+	state := mqttContainerState{
+		State:    status.State,
+		Health:   status.Health,
+		Running:  "OFF",
+		Restarts: status.Restarts,
+	}
+	if status.State == "running" {
+		state.Running = "ON"
+	}
+	if status.HasCPU {
+		state.CPUPercent = &status.CPUPercent
+	}
+	if status.HasMemoryPercent {
+		state.MemoryPercent = &status.MemoryPercent
+	}
+	if status.HasMemory {
+		state.MemoryMiB = &status.MemoryMiB
+	}
+	return state
+}
+
+// discoveryConfigs returns Home Assistant discovery payloads by topic.
+func (p *MQTTPublisher) discoveryConfigs(containerName, objectID string) map[string][]byte {
+	uniquePrefix := fmt.Sprintf("dex_%s_%s", p.nodeID, objectID)
+	device := map[string]any{
+		"identifiers":  []string{uniquePrefix},
+		"name":         containerName,
+		"manufacturer": "Docker",
+		"model":        "Container",
+		"via_device":   "dex_" + p.nodeID,
+	}
+
+	configs := map[string][]byte{}
+	for _, sensor := range mqttSensors {
+		config := map[string]any{
+			"name":               sensor.name,
+			"unique_id":          uniquePrefix + "_" + sensor.key,
+			"object_id":          uniquePrefix + "_" + sensor.key,
+			"state_topic":        p.stateTopic(objectID),
+			"value_template":     sensor.template,
+			"availability_topic": p.availabilityTopic(),
+			"device":             device,
+		}
+		if sensor.unit != "" {
+			config["unit_of_measurement"] = sensor.unit
+		}
+		if sensor.deviceClass != "" {
+			config["device_class"] = sensor.deviceClass
+		}
+		if sensor.component == "sensor" && sensor.unit != "" {
+			config["state_class"] = "measurement"
+		}
+
+		payload, err := json.Marshal(config)
+		if err != nil {
+			log.Error("can't marshal mqtt discovery config: ", err)
+			continue
+		}
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", p.discoveryPrefix, sensor.component, uniquePrefix, sensor.key)
+		configs[topic] = payload
+	}
+
+	return configs
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMQTTDiscoveryConfigs(t *testing.T) {
+	p := &MQTTPublisher{nodeID: "host1", topicPrefix: "dex/host1", discoveryPrefix: "homeassistant"}
+
+	configs := p.discoveryConfigs("my.app", "my_app")
+	require.Len(t, configs, len(mqttSensors), "Expected one discovery config per sensor")
+
+	payload, ok := configs["homeassistant/sensor/dex_host1_my_app/cpu/config"]
+	require.True(t, ok, "CPU sensor config not found")
+
+	var config map[string]any
+	require.NoError(t, json.Unmarshal(payload, &config))
+	assert.Equal(t, "dex_host1_my_app_cpu", config["unique_id"])
+	assert.Equal(t, "dex/host1/my_app/state", config["state_topic"])
+	assert.Equal(t, "dex/host1/status", config["availability_topic"])
+	assert.Equal(t, "%", config["unit_of_measurement"])
+	assert.Equal(t, "my.app", config["device"].(map[string]any)["name"])
+
+	_, ok = configs["homeassistant/binary_sensor/dex_host1_my_app/running/config"]
+	assert.True(t, ok, "Running binary sensor config not found")
+}
+
+func TestMQTTContainerState(t *testing.T) {
+	running := newMQTTContainerState(&containerStatus{State: "running", Health: "healthy", HasCPU: true, CPUPercent: 5})
+	assert.Equal(t, "ON", running.Running)
+	require.NotNil(t, running.CPUPercent)
+	assert.Equal(t, 5.0, *running.CPUPercent)
+	assert.Nil(t, running.MemoryMiB, "Memory should be omitted without its metric")
+
+	exited := newMQTTContainerState(&containerStatus{State: "exited", Health: "-"})
+	assert.Equal(t, "OFF", exited.Running)
+	assert.Nil(t, exited.CPUPercent, "Stats should be omitted for stopped containers")
+}
+
+func TestMQTTPublisherInvalidInterval(t *testing.T) {
+	t.Setenv("DEX_MQTT_BROKER", "tcp://localhost:1883")
+	t.Setenv("DEX_MQTT_INTERVAL", "0")
+	saved := configErrors
+	configErrors = &ConfigErrors{}
+	t.Cleanup(func() { configErrors = saved })
+
+	p := newMQTTPublisher(prometheus.NewRegistry())
+	require.NotNil(t, p)
+	assert.Equal(t, 30*time.Second, p.interval)
+	assert.Equal(t, 1, testutil.CollectAndCount(configErrors))
+}
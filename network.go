@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/netip"
+	"strconv"
+
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// NetworkCollector exports docker networks with their containers and the
+// utilization of their address pools.
+type NetworkCollector struct {
+	cli *client.Client
+	api *DockerAPIMetrics
+}
+
+// newNetworkCollector returns nil when network metrics are not enabled.
+func newNetworkCollector(cli *client.Client, api *DockerAPIMetrics) *NetworkCollector {
+	if !envBool("DEX_NETWORK_METRICS", false) {
+		return nil
+	}
+	return &NetworkCollector{cli: cli, api: api}
+}
+
+func (n *NetworkCollector) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (n *NetworkCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+
+	var networks []network.Summary
+	err := n.api.observe(ctx, "network_list", func() error {
+		var err error
+		networks, err = n.cli.NetworkList(ctx, network.ListOptions{})
+		return err
+	})
+	if err != nil {
+		log.Error("can't list networks: ", err)
+		return
+	}
+
+	for _, summary := range networks {
+		// the list doesn't include the containers of the networks
+		var inspect network.Inspect
+		err := n.api.observe(ctx, "network_inspect", func() error {
+			var err error
+			inspect, err = n.cli.NetworkInspect(ctx, summary.ID, network.InspectOptions{})
+			return err
+		})
+		if err != nil {
+			log.Errorf("can't inspect network '%s': %v", summary.Name, err)
+			continue
+		}
+
+		networkMetrics(ch, inspect)
+	}
+}
+
+func networkMetrics(ch chan<- prometheus.Metric, inspect network.Inspect) {
+	ch <- prometheus.MustNewConstMetric(newDesc(
+		"dex_network_info",
+		[]string{"network", "driver", "scope", "internal"},
+	), prometheus.GaugeValue, 1, inspect.Name, inspect.Driver, inspect.Scope, strconv.FormatBool(inspect.Internal))
+
+	ch <- prometheus.MustNewConstMetric(newDesc(
+		"dex_network_containers",
+		[]string{"network"},
+	), prometheus.GaugeValue, float64(len(inspect.Containers)), inspect.Name)
+
+	for _, ipam := range inspect.IPAM.Config {
+		pool, err := netip.ParsePrefix(ipam.Subnet)
+		if err != nil {
+			continue
+		}
+		if ipRange, err := netip.ParsePrefix(ipam.IPRange); err == nil {
+			pool = ipRange
+		}
+		pool = pool.Masked()
+
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_network_subnet_addresses",
+			[]string{"network", "subnet"},
+		), prometheus.GaugeValue, poolSize(pool), inspect.Name, ipam.Subnet)
+
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_network_subnet_allocated_addresses",
+			[]string{"network", "subnet"},
+		), prometheus.GaugeValue, allocatedAddresses(pool, ipam, inspect.Containers), inspect.Name, ipam.Subnet)
+	}
+}
+
+// poolSize returns the number of usable addresses, IPv4 pools lose the
+// network and broadcast addresses.
+func poolSize(pool netip.Prefix) float64 {
+	hostBits := pool.Addr().BitLen() - pool.Bits()
+	size := math.Pow(2, float64(hostBits))
+	if pool.Addr().Is4() && hostBits > 1 {
+		size -= 2
+	}
+	return size
+}
+
+func allocatedAddresses(pool netip.Prefix, ipam network.IPAMConfig, containers map[string]network.EndpointResource) float64 {
+	var allocated float64
+	inPool := func(s string) {
+		if addr, err := netip.ParseAddr(s); err == nil && pool.Contains(addr) {
+			allocated++
+		}
+	}
+
+	inPool(ipam.Gateway)
+	for _, aux := range ipam.AuxAddress {
+		inPool(aux)
+	}
+	for _, endpoint := range containers {
+		for _, address := range []string{endpoint.IPv4Address, endpoint.IPv6Address} {
+			if prefix, err := netip.ParsePrefix(address); err == nil {
+				inPool(prefix.Addr().String())
+			}
+		}
+	}
+	return allocated
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/network"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type networkMetricsCollector network.Inspect
+
+func (n networkMetricsCollector) Describe(_ chan<- *prometheus.Desc) {}
+
+func (n networkMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	networkMetrics(ch, network.Inspect(n))
+}
+
+func TestDockerNetworkMetrics(t *testing.T) {
+	inspect := network.Inspect{
+		Name:   "backend",
+		Driver: "bridge",
+		Scope:  "local",
+		IPAM: network.IPAM{Config: []network.IPAMConfig{
+			{Subnet: "172.20.0.0/28", Gateway: "172.20.0.1"},
+			{Subnet: "fd00::/64"},
+		}},
+		Containers: map[string]network.EndpointResource{
+			"a": {IPv4Address: "172.20.0.2/28", IPv6Address: "fd00::2/64"},
+			"b": {IPv4Address: "172.20.0.3/28"},
+		},
+	}
+
+	expected := `
+# HELP dex_network_containers Number of containers connected to the docker network
+# TYPE dex_network_containers gauge
+dex_network_containers{network="backend"} 2
+# HELP dex_network_info Information about the docker network
+# TYPE dex_network_info gauge
+dex_network_info{driver="bridge",internal="false",network="backend",scope="local"} 1
+# HELP dex_network_subnet_addresses Number of addresses containers can be assigned in the subnet
+# TYPE dex_network_subnet_addresses gauge
+dex_network_subnet_addresses{network="backend",subnet="172.20.0.0/28"} 14
+dex_network_subnet_addresses{network="backend",subnet="fd00::/64"} 1.8446744073709552e+19
+# HELP dex_network_subnet_allocated_addresses Number of allocated addresses in the subnet, including the gateway
+# TYPE dex_network_subnet_allocated_addresses gauge
+dex_network_subnet_allocated_addresses{network="backend",subnet="172.20.0.0/28"} 3
+dex_network_subnet_allocated_addresses{network="backend",subnet="fd00::/64"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(networkMetricsCollector(inspect), strings.NewReader(expected)))
+}
+
+func TestPoolSizeIPRange(t *testing.T) {
+	ipam := network.IPAMConfig{Subnet: "10.0.0.0/16", IPRange: "10.0.1.0/24", Gateway: "10.0.0.1"}
+	pool := netip.MustParsePrefix(ipam.IPRange)
+
+	assert.Equal(t, 254.0, poolSize(pool))
+	assert.Equal(t, 1.0, allocatedAddresses(pool, ipam, map[string]network.EndpointResource{
+		"a": {IPv4Address: "10.0.1.5/16"},
+	}), "Gateway outside of the range shouldn't be counted")
+}
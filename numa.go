@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type numaMemory struct {
+	node    string
+	memType string
+	bytes   float64
+}
+
+// numaMemoryTypes are exported from numa_stat, they exist in cgroup v1 and v2.
+var numaMemoryTypes = map[string]bool{"anon": true, "file": true}
+
+// readNUMAMemory returns the anonymous and file memory of the cgroup of pid
+// by NUMA node, read from memory.numa_stat.
+func readNUMAMemory(procPath, cgroupPath string, pid int) ([]numaMemory, error) {
+	cgroup, err := processCgroup(procPath, cgroupPath, pid, "memory")
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(cgroup, "memory.numa_stat"))
+	if err != nil {
+		return nil, err
+	}
+	return parseNUMAStat(string(data), float64(os.Getpagesize()))
+}
+
+// parseNUMAStat parses memory.numa_stat. Lines of cgroup v2 have the form
+// "anon N0=4096 N1=0" in bytes, lines of cgroup v1 "anon=1 N0=1 N1=0" in pages.
+func parseNUMAStat(data string, pageSize float64) ([]numaMemory, error) {
+	var memory []numaMemory
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		memType, scale := fields[0], 1.0
+		nodes := fields[1:]
+		if name, _, ok := strings.Cut(fields[0], "="); ok {
+			memType, scale = name, pageSize
+		}
+		if !numaMemoryTypes[memType] {
+			continue
+		}
+
+		for _, field := range nodes {
+			node, value, ok := strings.Cut(field, "=")
+			if !ok || !strings.HasPrefix(node, "N") {
+				return nil, fmt.Errorf("unexpected numa_stat line '%s'", line)
+			}
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected numa_stat line '%s'", line)
+			}
+			memory = append(memory, numaMemory{node: node[1:], memType: memType, bytes: v * scale})
+		}
+	}
+	return memory, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNUMAStatV2(t *testing.T) {
+	memory, err := parseNUMAStat(`anon N0=1048576 N1=4096
+file N0=8192 N1=0
+kernel_stack N0=16384 N1=0
+`, 4096)
+	require.NoError(t, err)
+	assert.Equal(t, []numaMemory{
+		{node: "0", memType: "anon", bytes: 1048576},
+		{node: "1", memType: "anon", bytes: 4096},
+		{node: "0", memType: "file", bytes: 8192},
+		{node: "1", memType: "file", bytes: 0},
+	}, memory)
+}
+
+func TestParseNUMAStatV1(t *testing.T) {
+	memory, err := parseNUMAStat(`total=3 N0=2 N1=1
+file=1 N0=1 N1=0
+anon=2 N0=1 N1=1
+unevictable=0 N0=0 N1=0
+hierarchical_total=3 N0=2 N1=1
+`, 4096)
+	require.NoError(t, err)
+	assert.Equal(t, []numaMemory{
+		{node: "0", memType: "file", bytes: 4096},
+		{node: "1", memType: "file", bytes: 0},
+		{node: "0", memType: "anon", bytes: 4096},
+		{node: "1", memType: "anon", bytes: 4096},
+	}, memory)
+}
+
+func TestReadNUMAMemory(t *testing.T) {
+	proc, cgroup := t.TempDir(), t.TempDir()
+	writeFiles(t, proc, map[string]string{
+		"100/cgroup": "0::/system.slice/docker-abc.scope\n",
+	})
+	writeFiles(t, cgroup, map[string]string{
+		"system.slice/docker-abc.scope/memory.numa_stat": "anon N0=4096\nfile N0=0\n",
+	})
+
+	memory, err := readNUMAMemory(proc, cgroup, 100)
+	require.NoError(t, err)
+	assert.Len(t, memory, 2)
+
+	_, err = parseNUMAStat("anon N0=abc", 4096)
+	assert.Error(t, err)
+}
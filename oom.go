@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// kernel log line of an OOM kill, e.g.
+// oom-kill:constraint=CONSTRAINT_MEMCG,...,task_memcg=/system.slice/docker-<id>.scope,task=python3,pid=1234,uid=0
+var oomKillRe = regexp.MustCompile(`oom-kill:.*task_memcg=[^,]*([0-9a-f]{64})[^,]*,task=([^,]*),pid=`)
+
+// how long an OOM event waits for the kernel log to name the victim
+const oomVictimWait = time.Second
+
+// OOMKills counts the OOM kills inside containers. The killed process is read
+// from the kernel log when it is accessible.
+type OOMKills struct {
+	filter   *containerFilter
+	kmsgPath string
+
+	kills *prometheus.CounterVec
+
+	mu sync.Mutex
+	// victims reported by the kernel log by container ID
+	victims map[string][]string
+}
+
+// newOOMKills returns nil when OOM kill counting is not enabled.
+func newOOMKills(filter *containerFilter) *OOMKills {
+	if !envBool("DEX_OOM_KILLS_ENABLED", false) {
+		return nil
+	}
+
+	return &OOMKills{
+		filter:   filter,
+		kmsgPath: envString("DEX_KMSG_PATH", "/dev/kmsg"),
+		kills: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dex_oom_kills_total",
+			Help: metricHelp("dex_oom_kills_total"),
+		}, append(append([]string{"container_name"}, filter.labelNames...), "process")),
+		victims: map[string][]string{},
+	}
+}
+
+func (o *OOMKills) Describe(ch chan<- *prometheus.Desc) {
+	o.kills.Describe(ch)
+}
+
+func (o *OOMKills) Collect(ch chan<- prometheus.Metric) {
+	o.kills.Collect(ch)
+}
+
+// Run follows the kernel log for the names of killed processes.
+func (o *OOMKills) Run(ctx context.Context) {
+	if o.kmsgPath == "" {
+		return
+	}
+
+	kmsg, err := os.Open(o.kmsgPath)
+	if err != nil {
+		log.Warnf("can't read kernel log, OOM kills won't have process names: %v", err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		kmsg.Close()
+	}()
+
+	// only new messages are interesting
+	if _, err := kmsg.Seek(0, io.SeekEnd); err != nil {
+		log.Debug("can't seek kernel log: ", err)
+	}
+
+	reader := bufio.NewReader(kmsg)
+	for {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, syscall.EPIPE) {
+			// messages were overwritten before being read
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error("can't read kernel log: ", err)
+			}
+			return
+		}
+
+		o.parseKernelLog(line)
+	}
+}
+
+func (o *OOMKills) parseKernelLog(line string) {
+	match := oomKillRe.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.victims[match[1]] = append(o.victims[match[1]], match[2])
+}
+
+// victim returns the next process killed in the container according to the kernel log.
+func (o *OOMKills) victim(id string) (string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	victims := o.victims[id]
+	if len(victims) == 0 {
+		return "", false
+	}
+	if len(victims) == 1 {
+		delete(o.victims, id)
+	} else {
+		o.victims[id] = victims[1:]
+	}
+	return victims[0], true
+}
+
+// handleOOM counts the OOM event of a container. The kernel log may be
+// read after the event, so it waits briefly for the victim.
+func (o *OOMKills) handleOOM(ctx context.Context, msg events.Message) {
+	cl, ok := o.filter.match(strings.TrimPrefix(msg.Actor.Attributes["name"], "/"))
+	if !ok {
+		return
+	}
+
+	go func() {
+		process, ok := o.victim(msg.Actor.ID)
+		if !ok && o.kmsgPath != "" {
+			select {
+			case <-ctx.Done():
+			case <-time.After(oomVictimWait):
+			}
+			process, _ = o.victim(msg.Actor.ID)
+		}
+
+		o.kills.WithLabelValues(append(cl.values, process)...).Inc()
+	}()
+}
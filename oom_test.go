@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const oomContainerID = "3f1e2d4c5b6a79880123456789abcdef3f1e2d4c5b6a79880123456789abcdef"
+
+func TestOOMKillsParseKernelLog(t *testing.T) {
+	t.Setenv("DEX_OOM_KILLS_ENABLED", "true")
+	o := newOOMKills(matchAllFilter())
+	require.NotNil(t, o)
+
+	o.parseKernelLog("6,1234,5678,-;oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=docker-" + oomContainerID + ".scope,mems_allowed=0,oom_memcg=/system.slice/docker-" + oomContainerID + ".scope,task_memcg=/system.slice/docker-" + oomContainerID + ".scope,task=python3,pid=4321,uid=0\n")
+	o.parseKernelLog("6,1235,5679,-;Memory cgroup out of memory: Killed process 4321 (python3)\n")
+
+	process, ok := o.victim(oomContainerID)
+	assert.True(t, ok)
+	assert.Equal(t, "python3", process)
+
+	_, ok = o.victim(oomContainerID)
+	assert.False(t, ok, "Victims should be consumed")
+}
+
+func TestOOMKillsHandleOOM(t *testing.T) {
+	t.Setenv("DEX_OOM_KILLS_ENABLED", "true")
+	t.Setenv("DEX_KMSG_PATH", "")
+	o := newOOMKills(matchAllFilter())
+
+	o.parseKernelLog("oom-kill:constraint=CONSTRAINT_MEMCG,task_memcg=/docker/" + oomContainerID + ",task=java,pid=1,uid=0")
+
+	msg := events.Message{Action: events.ActionOOM, Actor: events.Actor{ID: oomContainerID, Attributes: map[string]string{"name": "app"}}}
+	o.handleOOM(context.Background(), msg)
+	o.handleOOM(context.Background(), msg)
+
+	expected := `
+# HELP dex_oom_kills_total Number of processes killed by the OOM killer in the container
+# TYPE dex_oom_kills_total counter
+dex_oom_kills_total{container_name="app",process=""} 1
+dex_oom_kills_total{container_name="app",process="java"} 1
+`
+	assert.Eventually(t, func() bool {
+		return testutil.CollectAndCompare(o, strings.NewReader(expected)) == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestOOMKillsDisabled(t *testing.T) {
+	assert.Nil(t, newOOMKills(matchAllFilter()))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PauseEvents counts the pause and unpause events of containers, e.g. to find
+// backup jobs freezing containers for too long.
+type PauseEvents struct {
+	filter *containerFilter
+
+	pauses   *prometheus.CounterVec
+	unpauses *prometheus.CounterVec
+}
+
+// newPauseEvents returns nil when pause event counting is not enabled.
+func newPauseEvents(filter *containerFilter) *PauseEvents {
+	if !envBool("DEX_PAUSE_EVENTS_ENABLED", false) {
+		return nil
+	}
+
+	labels := append([]string{"container_name"}, filter.labelNames...)
+	return &PauseEvents{
+		filter: filter,
+		pauses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dex_container_pauses_total",
+			Help: metricHelp("dex_container_pauses_total"),
+		}, labels),
+		unpauses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dex_container_unpauses_total",
+			Help: metricHelp("dex_container_unpauses_total"),
+		}, labels),
+	}
+}
+
+func (p *PauseEvents) Describe(ch chan<- *prometheus.Desc) {
+	p.pauses.Describe(ch)
+	p.unpauses.Describe(ch)
+}
+
+func (p *PauseEvents) Collect(ch chan<- prometheus.Metric) {
+	p.pauses.Collect(ch)
+	p.unpauses.Collect(ch)
+}
+
+func (p *PauseEvents) handlePause(_ context.Context, msg events.Message) {
+	if cl, ok := p.filter.match(strings.TrimPrefix(msg.Actor.Attributes["name"], "/")); ok {
+		p.pauses.WithLabelValues(cl.values...).Inc()
+	}
+}
+
+func (p *PauseEvents) handleUnpause(_ context.Context, msg events.Message) {
+	if cl, ok := p.filter.match(strings.TrimPrefix(msg.Actor.Attributes["name"], "/")); ok {
+		p.unpauses.WithLabelValues(cl.values...).Inc()
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPauseEvents(t *testing.T) {
+	t.Setenv("DEX_PAUSE_EVENTS_ENABLED", "true")
+	p := newPauseEvents(matchAllFilter())
+	require.NotNil(t, p)
+
+	msg := events.Message{Actor: events.Actor{Attributes: map[string]string{"name": "db"}}}
+	p.handlePause(context.Background(), msg)
+	p.handleUnpause(context.Background(), msg)
+	p.handlePause(context.Background(), msg)
+
+	expected := `
+# HELP dex_container_pauses_total Number of times the container was paused
+# TYPE dex_container_pauses_total counter
+dex_container_pauses_total{container_name="db"} 2
+# HELP dex_container_unpauses_total Number of times the container was unpaused
+# TYPE dex_container_unpauses_total counter
+dex_container_unpauses_total{container_name="db"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(p, strings.NewReader(expected)))
+}
+
+func TestPauseEventsDisabled(t *testing.T) {
+	assert.Nil(t, newPauseEvents(matchAllFilter()))
+}
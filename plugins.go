@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// CollectorFactory creates the collector of a plugin with the client of
+// DEX_DOCKER_HOST. It returns nil when the plugin is not configured, like the
+// constructors of the built-in collectors.
+type CollectorFactory func(cli *client.Client) prometheus.Collector
+
+var collectorPlugins = map[string]CollectorFactory{}
+
+// registerCollectorPlugin adds a collector to every build of DEX. Site-specific
+// collectors live in their own file calling it in init, e.g.
+// plugin_licenses.go, so they don't conflict with changes of DEX.
+func registerCollectorPlugin(name string, factory CollectorFactory) {
+	if _, ok := collectorPlugins[name]; ok {
+		panic(fmt.Sprintf("collector plugin %s registered twice", name))
+	}
+	collectorPlugins[name] = factory
+}
+
+// newCollectorPlugins creates the configured collectors of the registered
+// plugins, ordered by name.
+func newCollectorPlugins(cli *client.Client) []prometheus.Collector {
+	names := make([]string, 0, len(collectorPlugins))
+	for name := range collectorPlugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var collectors []prometheus.Collector
+	for _, name := range names {
+		if c := collectorPlugins[name](cli); c != nil {
+			log.Infof("collector plugin %s enabled", name)
+			collectors = append(collectors, c)
+		}
+	}
+	return collectors
+}
+
+// ExecPlugins runs the executables of a directory on every scrape and exports
+// the metrics they print in the Prometheus text format, like the textfile
+// collector of node_exporter but always current.
+type ExecPlugins struct {
+	dir     string
+	timeout time.Duration
+	// names of the labels added by the registerer, which printed labels can't have
+	reserved []string
+}
+
+// newExecPlugins returns nil when no plugin directory is configured.
+// labels are the labels the plugins are registered with.
+func newExecPlugins(labels prometheus.Labels) *ExecPlugins {
+	dir := envString("DEX_PLUGIN_DIR", "")
+	if dir == "" {
+		return nil
+	}
+
+	return &ExecPlugins{
+		dir:      dir,
+		timeout:  envDuration("DEX_PLUGIN_TIMEOUT", 5*time.Second),
+		reserved: slices.Sorted(maps.Keys(labels)),
+	}
+}
+
+func (p *ExecPlugins) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (p *ExecPlugins) Collect(ch chan<- prometheus.Metric) {
+	// the directory is read on every scrape, so plugins can be added without a restart
+	plugins, err := executables(p.dir)
+	if err != nil {
+		log.Error("can't list plugins: ", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	// the plugins run at once, so a slow one only delays the scrape by the timeout
+	results := make([]map[string]*dto.MetricFamily, len(plugins))
+	durations := make([]time.Duration, len(plugins))
+	var wg sync.WaitGroup
+	for i, plugin := range plugins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			families, err := runPlugin(ctx, filepath.Join(p.dir, plugin), nil)
+			durations[i] = time.Since(start)
+			if err != nil {
+				log.Warnf("plugin %s failed: %v", plugin, err)
+				return
+			}
+			results[i] = families
+		}()
+	}
+	wg.Wait()
+
+	// the first plugin exporting a metric decides its help and type, the
+	// registry fails the whole scrape on inconsistent families and on series
+	// exported twice
+	seen := map[string]*dto.MetricFamily{}
+	exported := map[string]bool{}
+	for i, plugin := range plugins {
+		up := 0.0
+		if results[i] != nil {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_plugin_up",
+			[]string{"plugin"},
+		), prometheus.GaugeValue, up, plugin)
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_plugin_duration_seconds",
+			[]string{"plugin"},
+		), prometheus.GaugeValue, durations[i].Seconds(), plugin)
+
+		names := make([]string, 0, len(results[i]))
+		for name := range results[i] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			family := results[i][name]
+			if strings.HasPrefix(name, "dex_") {
+				log.Debugf("skipping metric %s of plugin %s, the dex_ prefix is reserved", name, plugin)
+				continue
+			}
+			if first, ok := seen[name]; ok && first.GetType() != family.GetType() {
+				log.Debugf("skipping metric %s of plugin %s, its type differs from other plugins", name, plugin)
+				continue
+			} else if !ok {
+				seen[name] = family
+			}
+
+			for _, m := range family.Metric {
+				// the plugin label keeps the series of the plugins apart,
+				// printed labels named like it or a reserved label are renamed
+				// like in collectFamily
+				labelNames := []string{"plugin"}
+				labelValues := []string{plugin}
+				for _, pair := range m.Label {
+					labelName := pair.GetName()
+					for slices.Contains(labelNames, labelName) || slices.Contains(p.reserved, labelName) {
+						labelName = "exported_" + labelName
+					}
+					labelNames = append(labelNames, labelName)
+					labelValues = append(labelValues, pair.GetValue())
+				}
+
+				key := seriesKey(name, labelNames, labelValues)
+				if exported[key] {
+					log.Debugf("skipping metric %s of plugin %s, the series was printed twice", name, plugin)
+					continue
+				}
+				desc := prometheus.NewDesc(name, seen[name].GetHelp(), labelNames, nil)
+
+				metric, err := constMetric(desc, family.GetType(), m, labelValues...)
+				if err != nil {
+					log.Debugf("skipping metric %s of plugin %s: %v", name, plugin, err)
+					continue
+				}
+				exported[key] = true
+				ch <- metric
+			}
+		}
+	}
+}
+
+// seriesKey identifies the series of the metric name with the labels
+// regardless of their order.
+func seriesKey(name string, labelNames, labelValues []string) string {
+	pairs := make([]string, len(labelNames))
+	for i := range labelNames {
+		pairs[i] = labelNames[i] + "\xff" + labelValues[i]
+	}
+	sort.Strings(pairs)
+	return name + "\xfe" + strings.Join(pairs, "\xfe")
+}
+
+// executables returns the names of the executable files of the directory,
+// hidden files are skipped.
+func executables(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// runPlugin runs the executable with the environment of DEX and env, and
+// parses its output.
+func runPlugin(ctx context.Context, path string, env []string) (map[string]*dto.MetricFamily, error) {
+	cmd := exec.CommandContext(ctx, path)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	// children of the plugin may keep the output open after it was killed
+	cmd.WaitDelay = time.Second
+
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("%v: %s", err, exitErr.Stderr)
+		}
+		return nil, err
+	}
+
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(bytes.NewReader(out))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePlugin(t *testing.T, dir, name, script string, mode os.FileMode) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), mode))
+}
+
+func TestCollectorPlugins(t *testing.T) {
+	defer func() { collectorPlugins = map[string]CollectorFactory{} }()
+
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "site_licenses_used", Help: "Used licenses"})
+	registerCollectorPlugin("licenses", func(*client.Client) prometheus.Collector { return gauge })
+	registerCollectorPlugin("disabled", func(*client.Client) prometheus.Collector { return nil })
+
+	assert.Equal(t, []prometheus.Collector{gauge}, newCollectorPlugins(nil))
+	assert.Panics(t, func() {
+		registerCollectorPlugin("licenses", func(*client.Client) prometheus.Collector { return nil })
+	})
+}
+
+func TestExecPluginsDisabled(t *testing.T) {
+	assert.Nil(t, newExecPlugins(nil))
+}
+
+func TestExecPlugins(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "licenses", `
+echo '# HELP site_licenses_used Used licenses'
+echo '# TYPE site_licenses_used gauge'
+echo 'site_licenses_used{product="db"} 3'
+`, 0o755)
+	writePlugin(t, dir, "queue", `
+echo '# TYPE site_licenses_used counter'
+echo 'site_licenses_used 1'
+echo 'site_queue_length 7'
+`, 0o755)
+	writePlugin(t, dir, "broken", "echo 'not metrics {'\n", 0o755)
+	writePlugin(t, dir, "failing", "echo 'no license server' >&2; exit 1\n", 0o755)
+	writePlugin(t, dir, "notes.txt", "exit 1\n", 0o644)
+	writePlugin(t, dir, ".hidden", "exit 1\n", 0o755)
+
+	t.Setenv("DEX_PLUGIN_DIR", dir)
+	plugins := newExecPlugins(nil)
+
+	// the type of the first plugin by name wins, hidden and not executable files are skipped
+	expected := `
+# HELP dex_plugin_up 1 if the plugin ran and its output was parsed, 0 otherwise
+# TYPE dex_plugin_up gauge
+dex_plugin_up{plugin="broken"} 0
+dex_plugin_up{plugin="failing"} 0
+dex_plugin_up{plugin="licenses"} 1
+dex_plugin_up{plugin="queue"} 1
+# HELP site_licenses_used Used licenses
+# TYPE site_licenses_used gauge
+site_licenses_used{plugin="licenses",product="db"} 3
+# HELP site_queue_length
+# TYPE site_queue_length untyped
+site_queue_length{plugin="queue"} 7
+`
+	assert.NoError(t, testutil.CollectAndCompare(plugins, strings.NewReader(expected),
+		"dex_plugin_up", "site_licenses_used", "site_queue_length"))
+	assert.Equal(t, 4, testutil.CollectAndCount(plugins, "dex_plugin_duration_seconds"))
+
+	// the exported metrics must be consistent for the registry
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(plugins)
+	_, err := reg.Gather()
+	assert.NoError(t, err)
+}
+
+func TestExecPluginsTimeout(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "slow", "sleep 10\n", 0o755)
+
+	t.Setenv("DEX_PLUGIN_DIR", dir)
+	t.Setenv("DEX_PLUGIN_TIMEOUT", "100ms")
+	plugins := newExecPlugins(nil)
+
+	start := time.Now()
+	assert.Equal(t, 2, testutil.CollectAndCount(plugins))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestExecPluginsSameSeries(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		writePlugin(t, dir, name, `
+echo 'site_licenses 3'
+echo 'site_licenses 4'
+echo 'site_users{hostname="db",plugin="x"} 2'
+echo 'dex_container_running 1'
+`, 0o755)
+	}
+
+	t.Setenv("DEX_PLUGIN_DIR", dir)
+	plugins := newExecPlugins(prometheus.Labels{"hostname": "node1"})
+
+	// series printed by several plugins are kept apart, printed twice by one
+	// plugin only the first is exported, dex_ metrics are dropped
+	expected := `
+# HELP site_licenses
+# TYPE site_licenses untyped
+site_licenses{plugin="a"} 3
+site_licenses{plugin="b"} 3
+# HELP site_users
+# TYPE site_users untyped
+site_users{exported_hostname="db",exported_plugin="x",plugin="a"} 2
+site_users{exported_hostname="db",exported_plugin="x",plugin="b"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(plugins, strings.NewReader(expected), "site_licenses", "site_users"))
+	assert.Equal(t, 0, testutil.CollectAndCount(plugins, "dex_container_running"))
+
+	reg := prometheus.NewRegistry()
+	prometheus.WrapRegistererWith(prometheus.Labels{"hostname": "node1"}, reg).MustRegister(plugins)
+	_, err := reg.Gather()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// capabilities kept for the features reading the host, numbered like in
+// linux/capability.h
+const (
+	capDacReadSearch = 2
+	capSysPtrace     = 19
+	capSyslog        = 34
+)
+
+// privilegedFeature is a feature needing more than read access to the Docker
+// API and the metrics of the host.
+type privilegedFeature struct {
+	name    string
+	enabled func() bool
+	// write is set for features changing state or running other programs,
+	// which DEX_READ_ONLY refuses
+	write bool
+	// capabilities the feature keeps when the others are dropped
+	capabilities []int
+}
+
+var privilegedFeatures = []privilegedFeature{
+	{
+		name:    "container_exec",
+		enabled: func() bool { return envDuration("DEX_TIME_OFFSET_INTERVAL", 0) > 0 },
+		write:   true,
+	},
+	{
+		name:    "vulnerability_scan",
+		enabled: func() bool { return envBool("DEX_TRIVY_ENABLED", false) },
+		write:   true,
+	},
+	{
+		name:    "filter_admin",
+		enabled: func() bool { return envString("DEX_ADMIN_TOKEN", "") != "" },
+		write:   true,
+	},
+	{
+		name: "exec_plugins",
+		enabled: func() bool {
+			return envString("DEX_PLUGIN_DIR", "") != "" || envString("DEX_CONTAINER_SCRIPT_DIR", "") != ""
+		},
+		write: true,
+	},
+	{
+		name: "kernel_log",
+		enabled: func() bool {
+			return envBool("DEX_OOM_KILLS_ENABLED", false) && envString("DEX_KMSG_PATH", "/dev/kmsg") != ""
+		},
+		capabilities: []int{capSyslog},
+	},
+	{
+		name: "host_procfs",
+		enabled: func() bool {
+			return envBool("DEX_PROCESS_METRICS", false) || envBool("DEX_TMPFS_METRICS", false) ||
+				envBool("DEX_NUMA_METRICS", false) || envBool("DEX_ROOTFS_INODES", false)
+		},
+		capabilities: []int{capDacReadSearch, capSysPtrace},
+	},
+	{
+		name:    "debug_endpoints",
+		enabled: func() bool { return envBool("DEX_DEBUG_ENDPOINTS", false) },
+	},
+}
+
+// PrivilegedFeatures exports which privileged features are enabled, so audits
+// can find instances running with more access than they need.
+type PrivilegedFeatures struct {
+	enabled map[string]bool
+}
+
+func newPrivilegedFeatures() *PrivilegedFeatures {
+	p := &PrivilegedFeatures{enabled: map[string]bool{}}
+	for _, feature := range privilegedFeatures {
+		p.enabled[feature.name] = feature.enabled()
+	}
+	return p
+}
+
+// checkReadOnly returns an error naming the enabled features which need more
+// than read access.
+func (p *PrivilegedFeatures) checkReadOnly() error {
+	var names []string
+	for _, feature := range privilegedFeatures {
+		if feature.write && p.enabled[feature.name] {
+			names = append(names, feature.name)
+		}
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("features needing more than read access are enabled: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// capabilities returns the capabilities needed by the enabled features.
+func (p *PrivilegedFeatures) capabilities() []int {
+	var capabilities []int
+	for _, feature := range privilegedFeatures {
+		if p.enabled[feature.name] {
+			capabilities = append(capabilities, feature.capabilities...)
+		}
+	}
+	return capabilities
+}
+
+// dropPrivileges switches to DEX_UID and DEX_GID if set and, with
+// DEX_READ_ONLY, drops the capabilities the enabled features don't need and
+// forbids gaining privileges. It is called once the ports are bound.
+func (p *PrivilegedFeatures) dropPrivileges() error {
+	readOnly := envBool("DEX_READ_ONLY", false)
+	uid, gid := envInt("DEX_UID", -1), envInt("DEX_GID", -1)
+	if !readOnly && uid < 0 && gid < 0 {
+		return nil
+	}
+
+	keep := p.capabilities()
+	if err := dropPrivileges(uid, gid, readOnly, keep); err != nil {
+		return err
+	}
+	if uid > 0 && len(keep) > 0 {
+		log.Warnf("running as uid %d without capabilities, the kernel log and host procfs may not be readable", uid)
+	}
+	log.Infof("dropped privileges, running as uid %d and gid %d", currentUID(), currentGID())
+	return nil
+}
+
+func (p *PrivilegedFeatures) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (p *PrivilegedFeatures) Collect(ch chan<- prometheus.Metric) {
+	for _, feature := range privilegedFeatures {
+		var enabled float64
+		if p.enabled[feature.name] {
+			enabled = 1
+		}
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_privileged_features_enabled",
+			[]string{"feature"},
+		), prometheus.GaugeValue, enabled, feature.name)
+	}
+}
@@ -0,0 +1,141 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prCapbsetDrop   = 24
+	prSetNoNewPrivs = 38
+	capSetpcap      = 8
+	lastCapability  = 40
+	capabilityV3    = 0x20080522
+)
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// dropPrivileges drops the capabilities except keep from the bounding set,
+// switches to uid and gid if not negative, then with readOnly drops the other
+// capabilities and sets no_new_privs. The changes apply to all threads.
+func dropPrivileges(uid, gid int, readOnly bool, keep []int) error {
+	if readOnly {
+		if err := dropBoundingSet(keep); err != nil {
+			return err
+		}
+	}
+
+	if gid >= 0 {
+		if err := syscall.Setgroups([]int{}); err != nil {
+			return fmt.Errorf("can't drop supplementary groups: %w", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("can't switch to gid %d: %w", gid, err)
+		}
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("can't switch to uid %d: %w", uid, err)
+		}
+	}
+
+	if !readOnly {
+		return nil
+	}
+	if err := setCapabilities(keep); err != nil {
+		return err
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("can't set no_new_privs: %w", allThreadsError(errno))
+	}
+	return nil
+}
+
+// dropBoundingSet removes the capabilities except keep from the bounding set,
+// so not even an executed program can regain them. Without CAP_SETPCAP, e.g.
+// when already running as an unprivileged user, there is nothing to drop.
+func dropBoundingSet(keep []int) error {
+	data, err := getCapabilities()
+	if err != nil {
+		return err
+	}
+	if data[0].effective&(1<<capSetpcap) == 0 {
+		return nil
+	}
+
+	for capability := 0; capability <= lastCapability; capability++ {
+		if slices.Contains(keep, capability) {
+			continue
+		}
+		_, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prCapbsetDrop, uintptr(capability), 0)
+		// EINVAL for capabilities unknown to the running kernel
+		if errno != 0 && errno != syscall.EINVAL {
+			return fmt.Errorf("can't drop capability %d from the bounding set: %w", capability, allThreadsError(errno))
+		}
+	}
+	return nil
+}
+
+func getCapabilities() ([2]capData, error) {
+	header := capHeader{version: capabilityV3}
+	var data [2]capData
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return data, fmt.Errorf("can't get capabilities: %w", errno)
+	}
+	return data, nil
+}
+
+// setCapabilities limits the effective and permitted capabilities to keep,
+// as far as they are still permitted.
+func setCapabilities(keep []int) error {
+	current, err := getCapabilities()
+	if err != nil {
+		return err
+	}
+
+	var data [2]capData
+	for _, capability := range keep {
+		data[capability/32].permitted |= 1 << (capability % 32)
+	}
+	for i := range data {
+		data[i].permitted &= current[i].permitted
+		data[i].effective = data[i].permitted
+	}
+
+	header := capHeader{version: capabilityV3}
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("can't drop capabilities: %w", allThreadsError(errno))
+	}
+	return nil
+}
+
+// allThreadsError explains the ENOTSUP returned by syscall.AllThreadsSyscall
+// in binaries built with cgo.
+func allThreadsError(errno syscall.Errno) error {
+	if errno == syscall.ENOTSUP {
+		return errors.New("can't drop privileges of all threads in a binary built with cgo")
+	}
+	return errno
+}
+
+func currentUID() int {
+	return syscall.Getuid()
+}
+
+func currentGID() int {
+	return syscall.Getgid()
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// dropPrivileges is only supported on linux, where dex runs next to the
+// Docker daemon.
+func dropPrivileges(_, _ int, _ bool, _ []int) error {
+	return errors.New("dropping privileges is only supported on linux")
+}
+
+func currentUID() int {
+	return os.Getuid()
+}
+
+func currentGID() int {
+	return os.Getgid()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrivilegedFeatures(t *testing.T) {
+	t.Setenv("DEX_TRIVY_ENABLED", "true")
+	t.Setenv("DEX_NUMA_METRICS", "true")
+
+	privileges := newPrivilegedFeatures()
+
+	expected := `
+# HELP dex_privileged_features_enabled 1 if the feature needing more than read access to the Docker API is enabled, 0 otherwise
+# TYPE dex_privileged_features_enabled gauge
+dex_privileged_features_enabled{feature="container_exec"} 0
+dex_privileged_features_enabled{feature="debug_endpoints"} 0
+dex_privileged_features_enabled{feature="exec_plugins"} 0
+dex_privileged_features_enabled{feature="filter_admin"} 0
+dex_privileged_features_enabled{feature="host_procfs"} 1
+dex_privileged_features_enabled{feature="kernel_log"} 0
+dex_privileged_features_enabled{feature="vulnerability_scan"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(privileges, strings.NewReader(expected)))
+	assert.ElementsMatch(t, []int{capDacReadSearch, capSysPtrace}, privileges.capabilities())
+}
+
+func TestPrivilegedFeaturesCheckReadOnly(t *testing.T) {
+	t.Setenv("DEX_DEBUG_ENDPOINTS", "true")
+	t.Setenv("DEX_OOM_KILLS_ENABLED", "true")
+	assert.NoError(t, newPrivilegedFeatures().checkReadOnly())
+
+	t.Setenv("DEX_TIME_OFFSET_INTERVAL", "1m")
+	t.Setenv("DEX_ADMIN_TOKEN", "secret")
+	err := newPrivilegedFeatures().checkReadOnly()
+	assert.EqualError(t, err, "features needing more than read access are enabled: container_exec, filter_admin")
+}
+
+func TestPrivilegedFeaturesDropPrivilegesDisabled(t *testing.T) {
+	assert.NoError(t, newPrivilegedFeatures().dropPrivileges())
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// processCounts are read from procfs for all processes of a container.
+type processCounts struct {
+	zombies float64
+	threads float64
+}
+
+// readProcessCounts counts the zombie processes and threads in the cgroup of
+// pid. The cgroup is read from procPath/<pid>/cgroup and its processes from
+// cgroup.procs under cgroupPath.
+func readProcessCounts(procPath, cgroupPath string, pid int) (processCounts, error) {
+	cgroup, err := processCgroup(procPath, cgroupPath, pid, "pids")
+	if err != nil {
+		return processCounts{}, err
+	}
+
+	f, err := os.Open(filepath.Join(cgroup, "cgroup.procs"))
+	if err != nil {
+		return processCounts{}, err
+	}
+	defer f.Close()
+
+	var counts processCounts
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		state, threads, err := processStat(procPath, scanner.Text())
+		if errors.Is(err, os.ErrNotExist) {
+			// the process exited meanwhile
+			continue
+		}
+		if err != nil {
+			return processCounts{}, err
+		}
+
+		if state == "Z" {
+			counts.zombies++
+		}
+		counts.threads += threads
+	}
+	return counts, scanner.Err()
+}
+
+// processCgroup returns the directory of the cgroup of pid, of the given
+// controller with cgroup v1.
+func processCgroup(procPath, cgroupPath string, pid int, controller string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", err
+	}
+
+	// lines have the form hierarchy-ID:controllers:path, v2 has a single
+	// line with ID 0 and no controllers, which hybrid v1 hosts have too
+	unified := ""
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			unified = filepath.Join(cgroupPath, parts[2])
+			continue
+		}
+		for _, c := range strings.Split(parts[1], ",") {
+			if c == controller {
+				return filepath.Join(cgroupPath, controller, parts[2]), nil
+			}
+		}
+	}
+	if unified != "" {
+		return unified, nil
+	}
+	return "", fmt.Errorf("no %s cgroup of process %d", controller, pid)
+}
+
+// processStat returns the state and the number of threads of a process.
+func processStat(procPath, pid string) (string, float64, error) {
+	data, err := os.ReadFile(filepath.Join(procPath, pid, "stat"))
+	if err != nil {
+		return "", 0, err
+	}
+
+	// the command in parentheses may contain spaces, the fields after it
+	// start with the state (3) and include num_threads (20)
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 18 {
+		return "", 0, fmt.Errorf("unexpected stat of process %s", pid)
+	}
+
+	threads, err := strconv.ParseFloat(fields[17], 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("unexpected stat of process %s: %v", pid, err)
+	}
+	return fields[0], threads, nil
+}
+
+// processStartTime returns the start time of a process in clock ticks since
+// boot, which tells a process from a later one with the same PID.
+func processStartTime(procPath string, pid int) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+
+	// starttime is field 22, the 20th after the command
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("unexpected stat of process %d", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
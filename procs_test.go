@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFiles creates the files under root with the given contents.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestReadProcessCountsCgroupV2(t *testing.T) {
+	proc, cgroup := t.TempDir(), t.TempDir()
+	writeFiles(t, proc, map[string]string{
+		"100/cgroup": "0::/system.slice/docker-abc.scope\n",
+		"100/stat":   "100 (app server) S 1 100 100 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 8 0 1000 0 0\n",
+		"101/stat":   "101 (worker) Z 100 100 100 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 1000 0 0\n",
+		"102/stat":   "102 (worker) Z 100 100 100 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 1000 0 0\n",
+	})
+	writeFiles(t, cgroup, map[string]string{
+		// 103 exited after the list was read
+		"system.slice/docker-abc.scope/cgroup.procs": "100\n101\n102\n103\n",
+	})
+
+	counts, err := readProcessCounts(proc, cgroup, 100)
+	require.NoError(t, err)
+	assert.Equal(t, processCounts{zombies: 2, threads: 10}, counts)
+}
+
+func TestReadProcessCountsCgroupV1(t *testing.T) {
+	proc, cgroup := t.TempDir(), t.TempDir()
+	writeFiles(t, proc, map[string]string{
+		// hybrid hosts have the unified hierarchy too
+		"100/cgroup": "0::/docker/abc\n12:memory:/docker/abc\n5:pids:/docker/abc\n1:name=systemd:/docker/abc\n",
+		"100/stat":   "100 (sh) S 1 100 100 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 3 0 1000 0 0\n",
+	})
+	writeFiles(t, cgroup, map[string]string{
+		"pids/docker/abc/cgroup.procs": "100\n",
+	})
+
+	counts, err := readProcessCounts(proc, cgroup, 100)
+	require.NoError(t, err)
+	assert.Equal(t, processCounts{zombies: 0, threads: 3}, counts)
+}
+
+func TestReadProcessCountsErrors(t *testing.T) {
+	proc := t.TempDir()
+	_, err := readProcessCounts(proc, t.TempDir(), 100)
+	assert.Error(t, err, "missing process")
+
+	writeFiles(t, proc, map[string]string{"100/cgroup": "3:cpu:/docker/abc\n"})
+	_, err = readProcessCounts(proc, t.TempDir(), 100)
+	assert.Error(t, err, "no pids cgroup")
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// container labels selecting and configuring the scraped containers, named
+// like the pod annotations of the Prometheus Kubernetes examples
+const (
+	proxyScrapeLabel  = "prometheus.io/scrape"
+	proxyPortLabel    = "prometheus.io/port"
+	proxyPathLabel    = "prometheus.io/path"
+	proxySchemeLabel  = "prometheus.io/scheme"
+	proxyNetworkLabel = "prometheus.io/network"
+)
+
+// scrapeTarget is a container serving metrics.
+type scrapeTarget struct {
+	cl  containerLabels
+	url string
+}
+
+// MetricsProxy scrapes the metrics of containers labeled with
+// prometheus.io/scrape=true and exports them with the labels of the container,
+// a poor man's federation for hosts where Prometheus can't reach the
+// containers.
+type MetricsProxy struct {
+	cli    *client.Client
+	api    *DockerAPIMetrics
+	filter *containerFilter
+
+	httpClient *http.Client
+	timeout    time.Duration
+	// prefix of the scraped metric names
+	prefix string
+	// host of the published ports, the container addresses are used if empty
+	publishedHost string
+	// names of the labels added by the registerer, which scraped labels can't have
+	reserved []string
+}
+
+// newMetricsProxy returns nil when the proxy is not enabled. labels are the
+// labels the proxy is registered with.
+func newMetricsProxy(cli *client.Client, api *DockerAPIMetrics, filter *containerFilter, labels prometheus.Labels) *MetricsProxy {
+	if !envBool("DEX_PROXY_ENABLED", false) {
+		return nil
+	}
+
+	timeout := envDuration("DEX_PROXY_TIMEOUT", 5*time.Second)
+	return &MetricsProxy{
+		cli:           cli,
+		api:           api,
+		filter:        filter,
+		httpClient:    &http.Client{Timeout: timeout},
+		timeout:       timeout,
+		prefix:        envString("DEX_PROXY_PREFIX", ""),
+		publishedHost: envString("DEX_PROXY_PUBLISHED_HOST", ""),
+		reserved:      slices.Sorted(maps.Keys(labels)),
+	}
+}
+
+func (p *MetricsProxy) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (p *MetricsProxy) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	var containers []container.Summary
+	err := p.api.observe(ctx, "list", func() error {
+		var err error
+		containers, err = p.cli.ContainerList(ctx, container.ListOptions{
+			Filters: filters.NewArgs(filters.Arg("label", proxyScrapeLabel+"=true")),
+		})
+		return err
+	})
+	if err != nil {
+		log.Error("can't list containers to scrape: ", err)
+		return
+	}
+
+	var targets []scrapeTarget
+	for _, cont := range containers {
+		cl, ok := p.filter.match(strings.TrimPrefix(strings.Join(cont.Names, ";"), "/"))
+		if !ok {
+			continue
+		}
+		url, err := p.targetURL(cont)
+		if err != nil {
+			log.Warnf("can't scrape container '%s': %v", cl.values[0], err)
+			continue
+		}
+		targets = append(targets, scrapeTarget{cl: cl, url: url})
+	}
+
+	// the containers are scraped at once, so a slow one only delays the scrape by the timeout
+	results := make([]map[string]*dto.MetricFamily, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			families, err := p.scrape(ctx, target.url)
+			if err != nil {
+				log.Debugf("can't scrape container '%s' at %s: %v", target.cl.values[0], target.url, err)
+				return
+			}
+			results[i] = families
+		}()
+	}
+	wg.Wait()
+
+	// the first container exporting a metric decides its help and type, the
+	// registry fails the whole scrape on inconsistent families
+	seen := map[string]*dto.MetricFamily{}
+	for i, target := range targets {
+		up := 0.0
+		if results[i] != nil {
+			up = 1
+		}
+		ch <- target.cl.metric(descs.get(
+			"dex_proxy_up",
+			target.cl.names,
+		), prometheus.GaugeValue, up)
+
+		names := make([]string, 0, len(results[i]))
+		for name := range results[i] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			family := results[i][name]
+			if first, ok := seen[name]; ok && first.GetType() != family.GetType() {
+				log.Debugf("skipping metric %s of container '%s', its type differs from other containers", name, target.cl.values[0])
+				continue
+			} else if !ok {
+				seen[name] = family
+			}
+			collectFamily(ch, p.prefix+name, seen[name], family, target.cl, p.reserved)
+		}
+	}
+}
+
+// targetURL returns the metrics URL of a container from its labels.
+func (p *MetricsProxy) targetURL(cont container.Summary) (string, error) {
+	port, err := p.targetPort(cont)
+	if err != nil {
+		return "", err
+	}
+
+	host := ""
+	if p.publishedHost != "" {
+		for _, published := range cont.Ports {
+			if published.PrivatePort == port && published.PublicPort != 0 && published.Type == "tcp" {
+				host, port = p.publishedHost, published.PublicPort
+				break
+			}
+		}
+		if host == "" {
+			return "", fmt.Errorf("port %d is not published", port)
+		}
+	} else {
+		host, err = containerAddress(cont)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	scheme := cont.Labels[proxySchemeLabel]
+	if scheme == "" {
+		scheme = "http"
+	}
+	path := cont.Labels[proxyPathLabel]
+	if path == "" {
+		path = "/metrics"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, strconv.Itoa(int(port))), path), nil
+}
+
+// targetPort returns the port of the prometheus.io/port label, or the only
+// exposed port of the container.
+func (p *MetricsProxy) targetPort(cont container.Summary) (uint16, error) {
+	if v, ok := cont.Labels[proxyPortLabel]; ok {
+		port, err := strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s '%s'", proxyPortLabel, v)
+		}
+		return uint16(port), nil
+	}
+
+	ports := map[uint16]bool{}
+	for _, exposed := range cont.Ports {
+		if exposed.Type == "tcp" {
+			ports[exposed.PrivatePort] = true
+		}
+	}
+	if len(ports) != 1 {
+		return 0, fmt.Errorf("missing %s label", proxyPortLabel)
+	}
+	for port := range ports {
+		return port, nil
+	}
+	return 0, nil
+}
+
+// containerAddress returns the address of a container on the network of the
+// prometheus.io/network label, or on the first of its networks by name.
+func containerAddress(cont container.Summary) (string, error) {
+	if cont.NetworkSettings == nil || len(cont.NetworkSettings.Networks) == 0 {
+		return "", fmt.Errorf("no networks")
+	}
+
+	if name, ok := cont.Labels[proxyNetworkLabel]; ok {
+		network, ok := cont.NetworkSettings.Networks[name]
+		if !ok || network.IPAddress == "" {
+			return "", fmt.Errorf("no address on network '%s'", name)
+		}
+		return network.IPAddress, nil
+	}
+
+	names := make([]string, 0, len(cont.NetworkSettings.Networks))
+	for name := range cont.NetworkSettings.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if address := cont.NetworkSettings.Networks[name].IPAddress; address != "" {
+			return address, nil
+		}
+	}
+	return "", fmt.Errorf("no address, e.g. with network mode host, publish the port and set DEX_PROXY_PUBLISHED_HOST")
+}
+
+func (p *MetricsProxy) scrape(ctx context.Context, url string) (map[string]*dto.MetricFamily, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", string(expfmt.NewFormat(expfmt.TypeTextPlain)))
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(resp.Body)
+}
+
+// collectFamily exports the metrics of a scraped family as name with the
+// labels of the container. Scraped labels named like a container or reserved
+// label are renamed with the exported_ prefix, like Prometheus does without
+// honor_labels.
+func collectFamily(ch chan<- prometheus.Metric, name string, first, family *dto.MetricFamily, cl containerLabels, reserved []string) {
+	for _, m := range family.Metric {
+		labelNames := append([]string(nil), cl.names...)
+		labelValues := append([]string(nil), cl.values...)
+		for _, pair := range m.Label {
+			labelName := pair.GetName()
+			for slices.Contains(labelNames, labelName) || slices.Contains(reserved, labelName) {
+				labelName = "exported_" + labelName
+			}
+			labelNames = append(labelNames, labelName)
+			labelValues = append(labelValues, pair.GetValue())
+		}
+		desc := prometheus.NewDesc(name, first.GetHelp(), labelNames, nil)
+
+		metric, err := constMetric(desc, family.GetType(), m, labelValues...)
+		if err != nil {
+			log.Debugf("skipping metric %s of container '%s': %v", name, cl.values[0], err)
+			continue
+		}
+		ch <- metric
+	}
+}
+
+// constMetric converts a parsed metric to a const metric of the type.
+func constMetric(desc *prometheus.Desc, metricType dto.MetricType, m *dto.Metric, labelValues ...string) (prometheus.Metric, error) {
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, m.GetCounter().GetValue(), labelValues...)
+	case dto.MetricType_GAUGE:
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.GetGauge().GetValue(), labelValues...)
+	case dto.MetricType_UNTYPED:
+		return prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.GetUntyped().GetValue(), labelValues...)
+	case dto.MetricType_SUMMARY:
+		quantiles := map[float64]float64{}
+		for _, q := range m.GetSummary().GetQuantile() {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+		return prometheus.NewConstSummary(desc, m.GetSummary().GetSampleCount(), m.GetSummary().GetSampleSum(), quantiles, labelValues...)
+	case dto.MetricType_HISTOGRAM:
+		// the +Inf bucket is implicit in const histograms
+		buckets := map[float64]uint64{}
+		for _, b := range m.GetHistogram().GetBucket() {
+			if !math.IsInf(b.GetUpperBound(), +1) {
+				buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+			}
+		}
+		return prometheus.NewConstHistogram(desc, m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum(), buckets, labelValues...)
+	default:
+		return nil, fmt.Errorf("unsupported type %s", metricType)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsProxy(t *testing.T) {
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/custom" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `# HELP http_requests_total Requests served
+# TYPE http_requests_total counter
+http_requests_total{code="200",hostname="app"} 42
+# HELP request_seconds Request latency
+# TYPE request_seconds histogram
+request_seconds_bucket{le="0.1"} 3
+request_seconds_bucket{le="1"} 5
+request_seconds_bucket{le="+Inf"} 6
+request_seconds_sum 4.5
+request_seconds_count 6
+`)
+	}))
+	defer app.Close()
+	appURL, err := url.Parse(app.URL)
+	require.NoError(t, err)
+
+	networks := &container.NetworkSettingsSummary{Networks: map[string]*network.EndpointSettings{
+		"bridge": {IPAddress: appURL.Hostname()},
+	}}
+	cli := fakeDaemon(t, []container.Summary{
+		{ID: "aaa", Names: []string{"/app"}, NetworkSettings: networks, Labels: map[string]string{
+			proxyScrapeLabel: "true",
+			proxyPortLabel:   appURL.Port(),
+			proxyPathLabel:   "/custom",
+		}},
+		{ID: "bbb", Names: []string{"/broken"}, NetworkSettings: networks, Labels: map[string]string{
+			proxyScrapeLabel: "true",
+			proxyPortLabel:   appURL.Port(),
+		}},
+		{ID: "ccc", Names: []string{"/other"}, NetworkSettings: networks, Labels: map[string]string{
+			proxyScrapeLabel: "true",
+			proxyPortLabel:   appURL.Port(),
+			proxyPathLabel:   "/custom",
+		}},
+	})
+
+	filter, err := newContainerFilter([]*FilterRule{{Match: `^other$`, Drop: true}, {Match: ".*"}})
+	require.NoError(t, err)
+	p := &MetricsProxy{
+		cli:        cli,
+		api:        newDockerAPIMetrics(),
+		filter:     filter,
+		httpClient: &http.Client{Timeout: time.Second},
+		timeout:    time.Second,
+		prefix:     "app_",
+		reserved:   []string{"hostname"},
+	}
+
+	expected := `
+# HELP app_http_requests_total Requests served
+# TYPE app_http_requests_total counter
+app_http_requests_total{code="200",container_name="app",exported_hostname="app"} 42
+# HELP app_request_seconds Request latency
+# TYPE app_request_seconds histogram
+app_request_seconds_bucket{container_name="app",le="0.1"} 3
+app_request_seconds_bucket{container_name="app",le="1"} 5
+app_request_seconds_bucket{container_name="app",le="+Inf"} 6
+app_request_seconds_sum{container_name="app"} 4.5
+app_request_seconds_count{container_name="app"} 6
+# HELP dex_proxy_up 1 if the metrics of the container were scraped, 0 otherwise
+# TYPE dex_proxy_up gauge
+dex_proxy_up{container_name="app"} 1
+dex_proxy_up{container_name="broken"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(p, strings.NewReader(expected)))
+}
+
+func TestMetricsProxyTargetURL(t *testing.T) {
+	cont := container.Summary{
+		Labels: map[string]string{proxyNetworkLabel: "backend"},
+		Ports:  []container.Port{{PrivatePort: 9100, PublicPort: 19100, Type: "tcp"}},
+		NetworkSettings: &container.NetworkSettingsSummary{Networks: map[string]*network.EndpointSettings{
+			"bridge":  {IPAddress: "172.17.0.2"},
+			"backend": {IPAddress: "10.0.1.5"},
+		}},
+	}
+
+	p := &MetricsProxy{}
+	u, err := p.targetURL(cont)
+	require.NoError(t, err)
+	assert.Equal(t, "http://10.0.1.5:9100/metrics", u, "The only exposed port is scraped")
+
+	p.publishedHost = "192.168.1.10"
+	u, err = p.targetURL(cont)
+	require.NoError(t, err)
+	assert.Equal(t, "http://192.168.1.10:19100/metrics", u)
+
+	cont.Ports = append(cont.Ports, container.Port{PrivatePort: 8080, Type: "tcp"})
+	_, err = p.targetURL(cont)
+	assert.ErrorContains(t, err, "missing prometheus.io/port label")
+
+	cont.Labels[proxyPortLabel] = "8080"
+	_, err = p.targetURL(cont)
+	assert.ErrorContains(t, err, "port 8080 is not published")
+}
+
+func TestMetricsProxyDisabled(t *testing.T) {
+	assert.Nil(t, newMetricsProxy(nil, nil, nil, prometheus.Labels{}))
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// staleNaN is the value Prometheus sends when a series ends.
+const staleNaN = 0x7ff0000000000002
+
+// metric types of the remote write metadata
+const (
+	remoteWriteCounter = 1
+	remoteWriteGauge   = 2
+)
+
+// RemoteWriteReceiver accepts remote write requests of agents inside the
+// containers on /receive and exports the last sample of every series with the
+// labels of the sending container, which is found by its address. On edge
+// hosts the agents then push through DEX instead of a second agent.
+type RemoteWriteReceiver struct {
+	cli    *client.Client
+	api    *DockerAPIMetrics
+	filter *containerFilter
+
+	// series not received within ttl are dropped
+	ttl       time.Duration
+	maxSeries int
+	// names of the labels added by the registerer, which received labels can't have
+	reserved []string
+
+	mu       sync.Mutex
+	series   map[string]*receivedSeries
+	metadata map[string]receivedMetadata
+	requests map[int]float64
+	dropped  float64
+
+	// containers by address, refreshed when a sender isn't known
+	addresses   map[string]container.Summary
+	addressesAt time.Time
+}
+
+type receivedSeries struct {
+	name      string
+	cl        containerLabels
+	value     float64
+	timestamp int64
+	received  time.Time
+}
+
+type receivedMetadata struct {
+	metricType uint64
+	help       string
+}
+
+// writeSeries is a time series of a remote write request.
+type writeSeries struct {
+	labels  [][2]string
+	samples []writeSample
+}
+
+type writeSample struct {
+	value     float64
+	timestamp int64
+}
+
+// newRemoteWriteReceiver returns nil when the receiver is not enabled. labels
+// are the labels the receiver is registered with.
+func newRemoteWriteReceiver(cli *client.Client, api *DockerAPIMetrics, filter *containerFilter, labels prometheus.Labels) *RemoteWriteReceiver {
+	if !envBool("DEX_RECEIVE_ENABLED", false) {
+		return nil
+	}
+
+	return &RemoteWriteReceiver{
+		cli:       cli,
+		api:       api,
+		filter:    filter,
+		ttl:       envDuration("DEX_RECEIVE_TTL", 5*time.Minute),
+		maxSeries: envInt("DEX_RECEIVE_MAX_SERIES", 10000),
+		reserved:  slices.Sorted(maps.Keys(labels)),
+		series:    map[string]*receivedSeries{},
+		metadata:  map[string]receivedMetadata{},
+		requests:  map[int]float64{},
+	}
+}
+
+func (rw *RemoteWriteReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	code, err := rw.receive(r)
+	rw.mu.Lock()
+	rw.requests[code]++
+	rw.mu.Unlock()
+
+	if err != nil {
+		log.Debugf("rejected remote write from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+	w.WriteHeader(code)
+}
+
+// receive stores the series of a request and returns the status code of the
+// response.
+func (rw *RemoteWriteReceiver) receive(r *http.Request) (int, error) {
+	if r.Method != http.MethodPost {
+		return http.StatusMethodNotAllowed, errors.New("method not allowed")
+	}
+	if encoding := r.Header.Get("Content-Encoding"); encoding != "snappy" {
+		return http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content encoding '%s'", encoding)
+	}
+	// only remote write 1.0, the senders fall back to it
+	if strings.Contains(r.Header.Get("Content-Type"), "io.prometheus.write.v2") {
+		return http.StatusUnsupportedMediaType, errors.New("remote write 2.0 is not supported")
+	}
+
+	compressed, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, 32<<20))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	series, metadata, err := parseWriteRequest(data)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	cont, err := rw.sender(r.Context(), r.RemoteAddr)
+	if err != nil {
+		return http.StatusForbidden, err
+	}
+	cl, ok := rw.filter.match(strings.TrimPrefix(strings.Join(cont.Names, ";"), "/"))
+	if !ok {
+		return http.StatusForbidden, errors.New("the container is filtered out")
+	}
+
+	rw.store(cl, series, metadata, time.Now())
+	return http.StatusNoContent, nil
+}
+
+// sender returns the container with the address of the client.
+func (rw *RemoteWriteReceiver) sender(ctx context.Context, remoteAddr string) (container.Summary, error) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return container.Summary{}, fmt.Errorf("unknown sender %s", remoteAddr)
+	}
+	address := ip.String()
+
+	rw.mu.Lock()
+	cont, ok := rw.addresses[address]
+	// addresses are reused by new containers, unknown senders must not list
+	// the containers on every request
+	refresh := time.Since(rw.addressesAt) > 30*time.Second || (!ok && time.Since(rw.addressesAt) > time.Second)
+	rw.mu.Unlock()
+
+	if refresh {
+		addresses, err := rw.listAddresses(ctx)
+		if err != nil {
+			return container.Summary{}, fmt.Errorf("can't list containers: %w", err)
+		}
+		rw.mu.Lock()
+		rw.addresses, rw.addressesAt = addresses, time.Now()
+		cont, ok = addresses[address]
+		rw.mu.Unlock()
+	}
+
+	if !ok {
+		return container.Summary{}, fmt.Errorf("no container has the address %s", address)
+	}
+	return cont, nil
+}
+
+// listAddresses returns the running containers by their addresses on all
+// their networks.
+func (rw *RemoteWriteReceiver) listAddresses(ctx context.Context) (map[string]container.Summary, error) {
+	var containers []container.Summary
+	err := rw.api.observe(ctx, "list", func() error {
+		var err error
+		containers, err = rw.cli.ContainerList(ctx, container.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := map[string]container.Summary{}
+	for _, cont := range containers {
+		if cont.NetworkSettings == nil {
+			continue
+		}
+		for _, network := range cont.NetworkSettings.Networks {
+			for _, address := range []string{network.IPAddress, network.GlobalIPv6Address} {
+				if ip := net.ParseIP(address); ip != nil {
+					addresses[ip.String()] = cont
+				}
+			}
+		}
+	}
+	return addresses, nil
+}
+
+// store keeps the last sample of every series with the labels of the
+// container. Received labels named like a container or reserved label are
+// renamed with the exported_ prefix.
+func (rw *RemoteWriteReceiver) store(cl containerLabels, series []writeSeries, metadata map[string]receivedMetadata, now time.Time) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	for name, m := range metadata {
+		if _, ok := rw.metadata[name]; ok || len(rw.metadata) < rw.maxSeries {
+			rw.metadata[name] = m
+		}
+	}
+
+	for _, ts := range series {
+		if len(ts.samples) == 0 {
+			continue
+		}
+		last := ts.samples[0]
+		for _, sample := range ts.samples[1:] {
+			if sample.timestamp >= last.timestamp {
+				last = sample
+			}
+		}
+
+		name := ""
+		scl := cl
+		valid := true
+		for _, label := range ts.labels {
+			if label[0] == "__name__" {
+				name = label[1]
+				continue
+			}
+			// an invalid label would fail the whole scrape
+			if !labelNameRe.MatchString(label[0]) || !utf8.ValidString(label[1]) {
+				valid = false
+				break
+			}
+			labelName := label[0]
+			for slices.Contains(scl.names, labelName) || slices.Contains(rw.reserved, labelName) {
+				labelName = "exported_" + labelName
+			}
+			scl = scl.with(labelName, label[1])
+		}
+		// the metrics of DEX can't be overwritten
+		if !valid || !metricNameRe.MatchString(name) || strings.HasPrefix(name, "dex_") {
+			continue
+		}
+
+		key := name + "\xff" + strings.Join(scl.names, "\x00") + "\xff" + scl.key()
+		if math.Float64bits(last.value) == staleNaN {
+			delete(rw.series, key)
+			continue
+		}
+
+		s, ok := rw.series[key]
+		if !ok {
+			if len(rw.series) >= rw.maxSeries {
+				rw.dropped++
+				continue
+			}
+			s = &receivedSeries{name: name, cl: scl}
+			rw.series[key] = s
+		}
+		// out of order samples of agents pushing from several queues
+		if last.timestamp >= s.timestamp {
+			s.value, s.timestamp = last.value, last.timestamp
+		}
+		s.received = now
+	}
+}
+
+func (rw *RemoteWriteReceiver) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (rw *RemoteWriteReceiver) Collect(ch chan<- prometheus.Metric) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	keys := make([]string, 0, len(rw.series))
+	for key, s := range rw.series {
+		if time.Since(s.received) > rw.ttl {
+			delete(rw.series, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := rw.series[key]
+		m := rw.metadata[s.name]
+		valueType := prometheus.UntypedValue
+		switch m.metricType {
+		case remoteWriteCounter:
+			valueType = prometheus.CounterValue
+		case remoteWriteGauge:
+			valueType = prometheus.GaugeValue
+		}
+		help := m.help
+		if help == "" {
+			help = "Received by remote write from the container"
+		}
+		// not cached in descs, the names are chosen by the senders
+		ch <- s.cl.metric(prometheus.NewDesc(s.name, help, s.cl.names, nil), valueType, s.value)
+	}
+
+	codes := make([]int, 0, len(rw.requests))
+	for code := range rw.requests {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_receive_requests_total",
+			[]string{"code"},
+		), prometheus.CounterValue, rw.requests[code], strconv.Itoa(code))
+	}
+
+	ch <- prometheus.MustNewConstMetric(newDesc("dex_receive_series", nil), prometheus.GaugeValue, float64(len(keys)))
+
+	ch <- prometheus.MustNewConstMetric(newDesc("dex_receive_series_dropped_total", nil), prometheus.CounterValue, rw.dropped)
+}
+
+// parseWriteRequest decodes the series and metadata of a remote write 1.0
+// request. Exemplars and native histograms are skipped.
+func parseWriteRequest(data []byte) ([]writeSeries, map[string]receivedMetadata, error) {
+	var series []writeSeries
+	metadata := map[string]receivedMetadata{}
+
+	err := parseMessage(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			ts, err := parseTimeSeries(value)
+			if err != nil {
+				return err
+			}
+			series = append(series, ts)
+		case num == 3 && typ == protowire.BytesType:
+			var name string
+			var m receivedMetadata
+			err := parseMessage(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				switch {
+				case num == 1 && typ == protowire.VarintType:
+					m.metricType, _ = protowire.ConsumeVarint(value)
+				case num == 2 && typ == protowire.BytesType:
+					name = string(value)
+				case num == 4 && typ == protowire.BytesType:
+					m.help = string(value)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			metadata[name] = m
+		}
+		return nil
+	})
+	return series, metadata, err
+}
+
+func parseTimeSeries(data []byte) (writeSeries, error) {
+	var ts writeSeries
+	err := parseMessage(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			var label [2]string
+			err := parseMessage(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				if (num == 1 || num == 2) && typ == protowire.BytesType {
+					label[num-1] = string(value)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.labels = append(ts.labels, label)
+		case num == 2 && typ == protowire.BytesType:
+			var sample writeSample
+			err := parseMessage(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					bits, _ := protowire.ConsumeFixed64(value)
+					sample.value = math.Float64frombits(bits)
+				case num == 2 && typ == protowire.VarintType:
+					v, _ := protowire.ConsumeVarint(value)
+					sample.timestamp = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.samples = append(ts.samples, sample)
+		}
+		return nil
+	})
+	return ts, err
+}
+
+// parseMessage calls field with every field of a protobuf message. value is
+// the content of length-delimited fields and the encoded value of the others.
+func parseMessage(data []byte, field func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var value []byte
+		if typ == protowire.BytesType {
+			value, n = protowire.ConsumeBytes(data)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n >= 0 {
+				value = data[:n]
+			}
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := field(num, typ, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// encodeWriteRequest encodes a remote write 1.0 request with a sample per
+// series and counter metadata of the first series.
+func encodeWriteRequest(series []writeSeries) []byte {
+	var req []byte
+	for _, ts := range series {
+		var b []byte
+		for _, label := range ts.labels {
+			var l []byte
+			l = protowire.AppendTag(l, 1, protowire.BytesType)
+			l = protowire.AppendString(l, label[0])
+			l = protowire.AppendTag(l, 2, protowire.BytesType)
+			l = protowire.AppendString(l, label[1])
+			b = protowire.AppendTag(b, 1, protowire.BytesType)
+			b = protowire.AppendBytes(b, l)
+		}
+		for _, sample := range ts.samples {
+			var s []byte
+			s = protowire.AppendTag(s, 1, protowire.Fixed64Type)
+			s = protowire.AppendFixed64(s, math.Float64bits(sample.value))
+			s = protowire.AppendTag(s, 2, protowire.VarintType)
+			s = protowire.AppendVarint(s, uint64(sample.timestamp))
+			b = protowire.AppendTag(b, 2, protowire.BytesType)
+			b = protowire.AppendBytes(b, s)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, b)
+	}
+
+	var m []byte
+	m = protowire.AppendTag(m, 1, protowire.VarintType)
+	m = protowire.AppendVarint(m, remoteWriteCounter)
+	m = protowire.AppendTag(m, 2, protowire.BytesType)
+	m = protowire.AppendString(m, "http_requests_total")
+	m = protowire.AppendTag(m, 4, protowire.BytesType)
+	m = protowire.AppendString(m, "Requests served")
+	req = protowire.AppendTag(req, 3, protowire.BytesType)
+	req = protowire.AppendBytes(req, m)
+	return req
+}
+
+func postWriteRequest(t *testing.T, rw *RemoteWriteReceiver, remoteAddr string, series []writeSeries) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/receive", bytes.NewReader(snappy.Encode(nil, encodeWriteRequest(series))))
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	rw.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestParseWriteRequest(t *testing.T) {
+	series := []writeSeries{{
+		labels:  [][2]string{{"__name__", "up"}, {"job", "app"}},
+		samples: []writeSample{{value: 1, timestamp: 1700000000000}, {value: 0, timestamp: 1700000015000}},
+	}}
+
+	parsed, metadata, err := parseWriteRequest(encodeWriteRequest(series))
+	require.NoError(t, err)
+	assert.Equal(t, series, parsed)
+	assert.Equal(t, map[string]receivedMetadata{"http_requests_total": {metricType: remoteWriteCounter, help: "Requests served"}}, metadata)
+
+	_, _, err = parseWriteRequest([]byte{0x0a, 0x05, 0x01})
+	assert.Error(t, err)
+}
+
+func TestRemoteWriteReceiverDisabled(t *testing.T) {
+	assert.Nil(t, newRemoteWriteReceiver(nil, nil, nil, nil))
+}
+
+func TestRemoteWriteReceiver(t *testing.T) {
+	bridge := func(ip string) *container.NetworkSettingsSummary {
+		return &container.NetworkSettingsSummary{Networks: map[string]*network.EndpointSettings{"bridge": {IPAddress: ip}}}
+	}
+	cli := fakeDaemon(t, []container.Summary{
+		{ID: "aaa", Names: []string{"/app"}, NetworkSettings: bridge("172.17.0.2")},
+		{ID: "bbb", Names: []string{"/other"}, NetworkSettings: bridge("172.17.0.3")},
+	})
+	filter, err := newContainerFilter([]*FilterRule{{Match: `^other$`, Drop: true}, {Match: ".*"}})
+	require.NoError(t, err)
+
+	t.Setenv("DEX_RECEIVE_ENABLED", "true")
+	t.Setenv("DEX_RECEIVE_MAX_SERIES", "2")
+	rw := newRemoteWriteReceiver(cli, newDockerAPIMetrics(), filter, map[string]string{"hostname": "node1"})
+
+	now := time.Now().UnixMilli()
+	series := []writeSeries{
+		{
+			labels:  [][2]string{{"__name__", "http_requests_total"}, {"code", "200"}, {"hostname", "app"}},
+			samples: []writeSample{{value: 42, timestamp: now}, {value: 40, timestamp: now - 15000}},
+		},
+		{
+			labels:  [][2]string{{"__name__", "queue_length"}},
+			samples: []writeSample{{value: 3, timestamp: now}},
+		},
+		// over DEX_RECEIVE_MAX_SERIES
+		{
+			labels:  [][2]string{{"__name__", "workers"}},
+			samples: []writeSample{{value: 8, timestamp: now}},
+		},
+		// not exported, named like the metrics of DEX
+		{
+			labels:  [][2]string{{"__name__", "dex_container_running"}},
+			samples: []writeSample{{value: 0, timestamp: now}},
+		},
+	}
+	assert.Equal(t, http.StatusNoContent, postWriteRequest(t, rw, "172.17.0.2:40000", series))
+	assert.Equal(t, http.StatusForbidden, postWriteRequest(t, rw, "172.17.0.3:40000", series))
+	assert.Equal(t, http.StatusForbidden, postWriteRequest(t, rw, "10.0.0.1:40000", series))
+
+	expected := `
+# HELP http_requests_total Requests served
+# TYPE http_requests_total counter
+http_requests_total{code="200",container_name="app",exported_hostname="app"} 42
+# HELP queue_length Received by remote write from the container
+# TYPE queue_length untyped
+queue_length{container_name="app"} 3
+# HELP dex_receive_requests_total Number of remote write requests received by status code
+# TYPE dex_receive_requests_total counter
+dex_receive_requests_total{code="204"} 1
+dex_receive_requests_total{code="403"} 2
+# HELP dex_receive_series Number of series received by remote write and exported
+# TYPE dex_receive_series gauge
+dex_receive_series 2
+# HELP dex_receive_series_dropped_total Number of received series dropped because of DEX_RECEIVE_MAX_SERIES
+# TYPE dex_receive_series_dropped_total counter
+dex_receive_series_dropped_total 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(rw, strings.NewReader(expected)))
+
+	// a stale marker ends the series
+	stale := []writeSeries{{
+		labels:  [][2]string{{"__name__", "queue_length"}},
+		samples: []writeSample{{value: math.Float64frombits(staleNaN), timestamp: now + 15000}},
+	}}
+	assert.Equal(t, http.StatusNoContent, postWriteRequest(t, rw, "172.17.0.2:40000", stale))
+	assert.Equal(t, 0, testutil.CollectAndCount(rw, "queue_length"))
+
+	// series not received within the TTL expire
+	rw.ttl = 0
+	assert.Equal(t, 0, testutil.CollectAndCount(rw, "http_requests_total"))
+}
+
+func TestRemoteWriteReceiverRejects(t *testing.T) {
+	t.Setenv("DEX_RECEIVE_ENABLED", "true")
+	rw := newRemoteWriteReceiver(nil, newDockerAPIMetrics(), nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/receive", nil)
+	w := httptest.NewRecorder()
+	rw.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/receive", strings.NewReader("up 1"))
+	w = httptest.NewRecorder()
+	rw.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/receive", strings.NewReader("not snappy"))
+	req.Header.Set("Content-Encoding", "snappy")
+	w = httptest.NewRecorder()
+	rw.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// updater is implemented by the collectors reading data in the background.
+type updater interface {
+	update(ctx context.Context)
+}
+
+// RefreshHandler serves POST /-/refresh, which re-reads the data cached by
+// the background modes immediately instead of at their next interval, e.g.
+// right after a deployment. With the container parameter only the stats of
+// that container are sampled again.
+type RefreshHandler struct {
+	// samplers returns the stats samplers, which change in multi-host mode
+	samplers func() []*StatsSampler
+	updaters []updater
+
+	// concurrent refreshes would only load the daemon
+	mu sync.Mutex
+}
+
+func newRefreshHandler(samplers func() []*StatsSampler) *RefreshHandler {
+	return &RefreshHandler{samplers: samplers}
+}
+
+// add registers a background collector refreshed by requests without a container.
+func (h *RefreshHandler) add(u updater) {
+	h.updaters = append(h.updaters, u)
+}
+
+func (h *RefreshHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	name := r.URL.Query().Get("container")
+
+	var samplers []*StatsSampler
+	for _, s := range h.samplers() {
+		if s != nil {
+			samplers = append(samplers, s)
+		}
+	}
+	if name != "" && len(samplers) == 0 {
+		http.Error(w, "container stats are not sampled in the background, see DEX_SAMPLE_INTERVAL", http.StatusBadRequest)
+		return
+	}
+
+	refreshed := 0
+	for _, s := range samplers {
+		n, err := s.refresh(r.Context(), name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("can't refresh container stats: %v", err), http.StatusBadGateway)
+			return
+		}
+		refreshed += n
+	}
+	if name != "" && refreshed == 0 {
+		http.Error(w, fmt.Sprintf("container '%s' not found", name), http.StatusNotFound)
+		return
+	}
+
+	if name == "" {
+		for _, u := range h.updaters {
+			u.update(r.Context())
+		}
+	}
+
+	fmt.Fprintf(w, "refreshed %d containers\n", refreshed)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingUpdater struct {
+	updates int
+}
+
+func (u *countingUpdater) update(context.Context) {
+	u.updates++
+}
+
+// fakeDaemon serves the container list, inspect and stats endpoints of the docker API.
+func fakeDaemon(t *testing.T, containers []container.Summary) *client.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			json.NewEncoder(w).Encode(containers)
+		case strings.HasSuffix(r.URL.Path, "/stats"):
+			json.NewEncoder(w).Encode(sampleStats(100, 200))
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			ref := path.Base(path.Dir(r.URL.Path))
+			for _, cont := range containers {
+				if cont.ID == ref || slices.Contains(cont.Names, "/"+ref) {
+					json.NewEncoder(w).Encode(container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{ID: cont.ID}})
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "No such container: " + ref})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.45"))
+	require.NoError(t, err)
+	return cli
+}
+
+func TestRefreshHandler(t *testing.T) {
+	cli := fakeDaemon(t, []container.Summary{
+		{ID: "aaa", Names: []string{"/web"}},
+		{ID: "bbb", Names: []string{"/web-worker"}},
+	})
+	sampler := &StatsSampler{cli: cli, api: newDockerAPIMetrics(), filter: matchAllFilter(), samples: map[string]*container.StatsResponse{}}
+	updater := &countingUpdater{}
+
+	h := newRefreshHandler(func() []*StatsSampler { return []*StatsSampler{sampler} })
+	h.add(updater)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/-/refresh", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/-/refresh?container=web", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "refreshed 1 containers\n", w.Body.String())
+	_, ok := sampler.get("aaa")
+	assert.True(t, ok)
+	_, ok = sampler.get("bbb")
+	assert.False(t, ok, "the name must match exactly")
+	assert.Equal(t, 0, updater.updates, "background collectors are refreshed only without a container")
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/-/refresh?container=db", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/-/refresh", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "refreshed 2 containers\n", w.Body.String())
+	assert.Equal(t, 1, updater.updates)
+}
+
+func TestRefreshHandlerWithoutSampler(t *testing.T) {
+	h := newRefreshHandler(func() []*StatsSampler { return []*StatsSampler{nil} })
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/-/refresh?container=web", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/-/refresh", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
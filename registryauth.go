@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// the key of Docker Hub in the docker config
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// dockerConfig is the part of the docker CLI config.json with registry credentials.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+type registryCredentials struct {
+	Username string
+	Password string
+}
+
+// RegistryAuth resolves registry credentials from the docker CLI config
+// including credential helpers like ecr-login or gcloud, so private
+// registries work without duplicating secrets in the dex config.
+type RegistryAuth struct {
+	path string
+}
+
+func newRegistryAuth() *RegistryAuth {
+	dir := envString("DEX_DOCKER_CONFIG", os.Getenv("DOCKER_CONFIG"))
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return &RegistryAuth{}
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	return &RegistryAuth{path: filepath.Join(dir, "config.json")}
+}
+
+// imageRegistry returns the registry of an image reference like the docker CLI.
+func imageRegistry(image string) string {
+	first, _, found := strings.Cut(image, "/")
+	if !found || (!strings.ContainsAny(first, ".:") && first != "localhost") {
+		return "docker.io"
+	}
+	return first
+}
+
+// credentials returns the credentials for the registry of the image, or
+// false if there are none. The config is read on every call, so changes and
+// refreshed tokens are picked up. It is safe to call on a nil receiver.
+func (a *RegistryAuth) credentials(ctx context.Context, image string) (registryCredentials, bool, error) {
+	if a == nil || a.path == "" {
+		return registryCredentials{}, false, nil
+	}
+
+	data, err := os.ReadFile(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return registryCredentials{}, false, nil
+	}
+	if err != nil {
+		return registryCredentials{}, false, err
+	}
+
+	var cfg dockerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return registryCredentials{}, false, fmt.Errorf("can't parse %s: %v", a.path, err)
+	}
+
+	registry := imageRegistry(image)
+	server := registry
+	if registry == "docker.io" {
+		server = dockerHubServer
+	}
+
+	if helper, ok := cfg.CredHelpers[registry]; ok {
+		return credentialHelper(ctx, helper, server)
+	}
+	if cfg.CredsStore != "" {
+		return credentialHelper(ctx, cfg.CredsStore, server)
+	}
+
+	for key, auth := range cfg.Auths {
+		if key != server && strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://") != server {
+			continue
+		}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return registryCredentials{}, false, fmt.Errorf("invalid auth of %s: %v", key, err)
+			}
+			username, password, _ := strings.Cut(string(decoded), ":")
+			return registryCredentials{Username: username, Password: password}, true, nil
+		}
+		return registryCredentials{Username: auth.Username, Password: auth.Password}, auth.Username != "", nil
+	}
+
+	return registryCredentials{}, false, nil
+}
+
+// credentialHelper runs docker-credential-<helper> get.
+func credentialHelper(ctx context.Context, helper, server string) (registryCredentials, bool, error) {
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// helpers report missing credentials on stdout
+		if strings.Contains(string(out)+stderr.String(), "credentials not found") {
+			return registryCredentials{}, false, nil
+		}
+		return registryCredentials{}, false, fmt.Errorf("docker-credential-%s: %v: %s", helper, err, strings.TrimSpace(string(out)+stderr.String()))
+	}
+
+	var response struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &response); err != nil {
+		return registryCredentials{}, false, fmt.Errorf("docker-credential-%s: %v", helper, err)
+	}
+	return registryCredentials{Username: response.Username, Password: response.Secret}, true, nil
+}
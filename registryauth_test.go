@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageRegistry(t *testing.T) {
+	tests := map[string]string{
+		"nginx":                                 "docker.io",
+		"library/nginx:1.25":                    "docker.io",
+		"ghcr.io/org/app:v1":                    "ghcr.io",
+		"localhost/app":                         "localhost",
+		"registry.local:5000/app":               "registry.local:5000",
+		"123.dkr.ecr.us-east-1.amazonaws.com/x": "123.dkr.ecr.us-east-1.amazonaws.com",
+	}
+	for image, expected := range tests {
+		assert.Equal(t, expected, imageRegistry(image), image)
+	}
+}
+
+func writeDockerConfig(t *testing.T, content string) *RegistryAuth {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(content), 0600))
+	t.Setenv("DEX_DOCKER_CONFIG", dir)
+	return newRegistryAuth()
+}
+
+func TestRegistryAuthAuths(t *testing.T) {
+	auth := writeDockerConfig(t, `{"auths": {
+		"https://index.docker.io/v1/": {"auth": "aHViOnNlY3JldA=="},
+		"registry.local:5000": {"username": "ci", "password": "pass"}
+	}}`)
+
+	creds, found, err := auth.credentials(context.Background(), "nginx:latest")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, registryCredentials{Username: "hub", Password: "secret"}, creds)
+
+	creds, found, err = auth.credentials(context.Background(), "registry.local:5000/app")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, registryCredentials{Username: "ci", Password: "pass"}, creds)
+
+	_, found, err = auth.credentials(context.Background(), "ghcr.io/org/app")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestRegistryAuthCredentialHelper(t *testing.T) {
+	bin := t.TempDir()
+	helper := `#!/bin/sh
+read server
+if [ "$server" = "123.dkr.ecr.us-east-1.amazonaws.com" ]; then
+	echo '{"ServerURL":"'$server'","Username":"AWS","Secret":"token"}'
+else
+	echo "credentials not found in native keychain"
+	exit 1
+fi
+`
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "docker-credential-fake"), []byte(helper), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	auth := writeDockerConfig(t, `{"credHelpers": {
+		"123.dkr.ecr.us-east-1.amazonaws.com": "fake",
+		"other.example.com": "fake"
+	}}`)
+
+	creds, found, err := auth.credentials(context.Background(), "123.dkr.ecr.us-east-1.amazonaws.com/app:1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, registryCredentials{Username: "AWS", Password: "token"}, creds)
+
+	_, found, err = auth.credentials(context.Background(), "other.example.com/app")
+	require.NoError(t, err)
+	assert.False(t, found, "Missing credentials shouldn't be an error")
+}
+
+func TestRegistryAuthMissingConfig(t *testing.T) {
+	t.Setenv("DEX_DOCKER_CONFIG", t.TempDir())
+
+	_, found, err := newRegistryAuth().credentials(context.Background(), "nginx")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
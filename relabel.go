@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// RelabelConfig is a Prometheus metric_relabel_configs entry. The actions
+// replace, keep and drop are supported, the metric name is the __name__ label.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,flow"`
+	Separator    string   `yaml:"separator"`
+	Regex        string   `yaml:"regex"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Replacement  string   `yaml:"replacement"`
+	Action       string   `yaml:"action"`
+
+	re *regexp.Regexp
+}
+
+// UnmarshalYAML applies the Prometheus defaults for omitted fields.
+func (c *RelabelConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain RelabelConfig
+	*c = RelabelConfig{Separator: ";", Regex: "(.*)", Replacement: "$1", Action: "replace"}
+	return value.Decode((*plain)(c))
+}
+
+func (c *RelabelConfig) compile() error {
+	re, err := regexp.Compile("^(?:" + c.Regex + ")$")
+	if err != nil {
+		return err
+	}
+	c.re = re
+
+	switch c.Action {
+	case "replace":
+		if c.TargetLabel != "__name__" && !labelNameRe.MatchString(c.TargetLabel) {
+			return fmt.Errorf("invalid target_label '%s'", c.TargetLabel)
+		}
+	case "keep", "drop":
+	default:
+		return fmt.Errorf("unsupported action '%s'", c.Action)
+	}
+	return nil
+}
+
+// apply relabels the labels in place and returns false if the metric is dropped.
+func (c *RelabelConfig) apply(labels map[string]string) bool {
+	values := make([]string, len(c.SourceLabels))
+	for i, name := range c.SourceLabels {
+		values[i] = labels[name]
+	}
+	value := strings.Join(values, c.Separator)
+
+	switch c.Action {
+	case "keep":
+		return c.re.MatchString(value)
+	case "drop":
+		return !c.re.MatchString(value)
+	}
+
+	submatches := c.re.FindStringSubmatchIndex(value)
+	if submatches == nil {
+		return true
+	}
+	if target := string(c.re.ExpandString(nil, c.Replacement, value, submatches)); target != "" {
+		labels[c.TargetLabel] = target
+	} else {
+		delete(labels, c.TargetLabel)
+	}
+	return true
+}
+
+// compileRelabelConfigs validates the rules, it's used by check-config too.
+func compileRelabelConfigs(rules []*RelabelConfig) error {
+	for i, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("rule %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// MetricRelabeler applies metric_relabel_configs to gathered metrics before
+// exposition, so cardinality can be cut at the source.
+type MetricRelabeler struct {
+	gatherer prometheus.Gatherer
+	rules    []*RelabelConfig
+}
+
+// newMetricRelabeler returns the gatherer unchanged when no rules are configured.
+func newMetricRelabeler(gatherer prometheus.Gatherer, rules []*RelabelConfig) prometheus.Gatherer {
+	if len(rules) == 0 {
+		return gatherer
+	}
+
+	if err := compileRelabelConfigs(rules); err != nil {
+		log.Errorf("invalid metric_relabel_configs, exporting all metrics: %v", err)
+		configErrors.add("metric_relabel_configs")
+		return gatherer
+	}
+
+	return &MetricRelabeler{gatherer: gatherer, rules: rules}
+}
+
+func (r *MetricRelabeler) Gather() ([]*dto.MetricFamily, error) {
+	// relabel what was gathered even on partial errors
+	families, err := r.gatherer.Gather()
+
+	var result []*dto.MetricFamily
+	byName := map[string]*dto.MetricFamily{}
+
+	for _, family := range families {
+		for _, m := range family.Metric {
+			labels := map[string]string{"__name__": family.GetName()}
+			for _, lp := range m.Label {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+
+			if !r.relabel(labels) {
+				continue
+			}
+
+			name := labels["__name__"]
+			out, found := byName[name]
+			if !found {
+				out = &dto.MetricFamily{Name: proto.String(name), Help: family.Help, Type: family.Type, Unit: family.Unit}
+				byName[name] = out
+				result = append(result, out)
+			} else if out.GetType() != family.GetType() {
+				log.Debugf("dropping %s renamed to %s of a different type", family.GetName(), name)
+				continue
+			}
+
+			m.Label = labelPairs(labels)
+			out.Metric = append(out.Metric, m)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetName() < result[j].GetName()
+	})
+	for _, family := range result {
+		family.Metric = sortedMetrics(family.GetName(), family.Metric)
+	}
+	return result, err
+}
+
+// sortedMetrics sorts the metrics of a family by their labels like the
+// registry does, relabeled metrics may not be in order anymore. Of metrics
+// relabeled to the same labels only the first is kept, as scrapes with
+// duplicate series are rejected.
+func sortedMetrics(family string, metrics []*dto.Metric) []*dto.Metric {
+	keys := make(map[*dto.Metric]string, len(metrics))
+	for _, m := range metrics {
+		var key strings.Builder
+		for _, lp := range m.Label {
+			key.WriteString(lp.GetName())
+			key.WriteByte(0)
+			key.WriteString(lp.GetValue())
+			key.WriteByte(0)
+		}
+		keys[m] = key.String()
+	}
+
+	sort.SliceStable(metrics, func(i, j int) bool {
+		return keys[metrics[i]] < keys[metrics[j]]
+	})
+	return slices.CompactFunc(metrics, func(a, b *dto.Metric) bool {
+		if keys[a] != keys[b] {
+			return false
+		}
+		log.Debugf("dropping duplicate %s series after relabeling", family)
+		return true
+	})
+}
+
+func (r *MetricRelabeler) relabel(labels map[string]string) bool {
+	for _, rule := range r.rules {
+		if !rule.apply(labels) {
+			return false
+		}
+	}
+	return labels["__name__"] != ""
+}
+
+// labelPairs returns the sorted labels without internal labels starting with __.
+func labelPairs(labels map[string]string) []*dto.LabelPair {
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for name, value := range labels {
+		if strings.HasPrefix(name, "__") {
+			continue
+		}
+		pairs = append(pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].GetName() < pairs[j].GetName()
+	})
+	return pairs
+}
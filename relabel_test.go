@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func relabelRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+
+	memory := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "dex_memory_usage_bytes", Help: "memory"}, []string{"container_name"})
+	memory.WithLabelValues("web-1").Set(100)
+	memory.WithLabelValues("ci-runner-42").Set(200)
+
+	network := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dex_network_rx_bytes", Help: "network"}, []string{"container_name"})
+	network.WithLabelValues("web-1").Add(10)
+
+	reg.MustRegister(memory, network)
+	return reg
+}
+
+func parseRelabelConfigs(t *testing.T, content string) []*RelabelConfig {
+	var rules []*RelabelConfig
+	require.NoError(t, yaml.Unmarshal([]byte(content), &rules))
+	return rules
+}
+
+func TestMetricRelabeler(t *testing.T) {
+	rules := parseRelabelConfigs(t, `
+- source_labels: [__name__]
+  regex: dex_network_.*
+  action: drop
+- source_labels: [container_name]
+  regex: ci-runner-.*
+  action: drop
+- source_labels: [container_name]
+  regex: (.+)-\d+
+  target_label: service
+- source_labels: [__name__]
+  regex: dex_(.*)
+  target_label: __name__
+  replacement: docker_$1
+`)
+
+	gatherer := newMetricRelabeler(relabelRegistry(), rules)
+	require.IsType(t, &MetricRelabeler{}, gatherer)
+
+	expected := `
+# HELP docker_memory_usage_bytes memory
+# TYPE docker_memory_usage_bytes gauge
+docker_memory_usage_bytes{container_name="web-1",service="web"} 100
+`
+	assert.NoError(t, testutil.GatherAndCompare(gatherer, strings.NewReader(expected)))
+}
+
+func TestMetricRelabelerKeep(t *testing.T) {
+	rules := parseRelabelConfigs(t, `
+- source_labels: [__name__, container_name]
+  regex: dex_memory_usage_bytes;web-.*
+  action: keep
+`)
+
+	expected := `
+# HELP dex_memory_usage_bytes memory
+# TYPE dex_memory_usage_bytes gauge
+dex_memory_usage_bytes{container_name="web-1"} 100
+`
+	assert.NoError(t, testutil.GatherAndCompare(newMetricRelabeler(relabelRegistry(), rules), strings.NewReader(expected)))
+}
+
+func TestMetricRelabelerDeleteLabel(t *testing.T) {
+	rules := parseRelabelConfigs(t, `
+- target_label: container_name
+  replacement: ""
+`)
+
+	families, err := newMetricRelabeler(relabelRegistry(), rules).Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		for _, m := range family.Metric {
+			assert.Empty(t, m.Label, "Empty replacement should remove the label")
+		}
+	}
+}
+
+func TestMetricRelabelerInvalid(t *testing.T) {
+	saved := configErrors
+	configErrors = &ConfigErrors{}
+	t.Cleanup(func() { configErrors = saved })
+
+	reg := relabelRegistry()
+	rules := parseRelabelConfigs(t, `
+- action: labelmap
+`)
+
+	assert.Same(t, reg, newMetricRelabeler(reg, rules), "Invalid rules should export all metrics")
+	assert.Equal(t, []string{"metric_relabel_configs"}, configErrors.options)
+}
+
+func TestMetricRelabelerSortsMetrics(t *testing.T) {
+	rules := parseRelabelConfigs(t, `
+- source_labels: [container_name]
+  regex: ci-runner-.*
+  target_label: container_name
+  replacement: zz-runner
+`)
+
+	families, err := newMetricRelabeler(relabelRegistry(), rules).Gather()
+	require.NoError(t, err)
+	require.Equal(t, "dex_memory_usage_bytes", families[0].GetName())
+
+	var names []string
+	for _, m := range families[0].Metric {
+		names = append(names, m.Label[0].GetValue())
+	}
+	assert.Equal(t, []string{"web-1", "zz-runner"}, names, "Metrics should be sorted after relabeling")
+}
+
+func TestMetricRelabelerDropsDuplicates(t *testing.T) {
+	rules := parseRelabelConfigs(t, `
+- target_label: container_name
+  replacement: all
+`)
+
+	families, err := newMetricRelabeler(relabelRegistry(), rules).Gather()
+	require.NoError(t, err)
+	require.Equal(t, "dex_memory_usage_bytes", families[0].GetName())
+	require.Len(t, families[0].Metric, 1, "Series with the same labels should be dropped")
+	assert.Equal(t, 200.0, families[0].Metric[0].GetGauge().GetValue(), "The first series should be kept")
+}
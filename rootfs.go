@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+)
+
+// filesystemUsage is the usage of a filesystem reported by statfs.
+type filesystemUsage struct {
+	size       float64
+	free       float64
+	freeInodes float64
+}
+
+// rootfsInodes returns the number of inodes used by the writable layer of a
+// container at upperDir and the number of free inodes of its filesystem. The
+// filesystem is shared by all containers, so the used inodes are counted by
+// walking the layer.
+func rootfsInodes(upperDir string) (used, free float64, err error) {
+	st, err := statfs(upperDir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = filepath.WalkDir(upperDir, func(_ string, _ fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			// removed by the container meanwhile
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		used++
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return used, st.freeInodes, nil
+}
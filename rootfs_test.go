@@ -0,0 +1,28 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRootfsInodes(t *testing.T) {
+	upperDir := t.TempDir()
+	writeFiles(t, upperDir, map[string]string{
+		"etc/hosts":     "",
+		"tmp/a":         "",
+		"tmp/b":         "",
+		"var/log/app.1": "",
+	})
+
+	used, free, err := rootfsInodes(upperDir)
+	require.NoError(t, err)
+	// the root, 4 directories and 4 files
+	assert.Equal(t, 9.0, used)
+	assert.Positive(t, free)
+
+	_, _, err = rootfsInodes(filepath.Join(upperDir, "missing"))
+	assert.Error(t, err)
+}
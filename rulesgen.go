@@ -0,0 +1,210 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// promRule is a Prometheus recording or alerting rule.
+type promRule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type promRuleGroup struct {
+	Name  string     `yaml:"name"`
+	Rules []promRule `yaml:"rules"`
+}
+
+type generatedRule struct {
+	rule promRule
+	// enabled reports whether the metrics of the rule are exported with the
+	// current options, the rule is always generated if nil
+	enabled func() bool
+}
+
+// generatedAlerts lists the alerts generate-rules knows about. Metric names
+// use the dex_ prefix, which is replaced by the -prefix flag.
+var generatedAlerts = []generatedRule{
+	{promRule{
+		Alert:       "DockerContainerFailed",
+		Expr:        "dex_container_failed == 1",
+		For:         "1m",
+		Labels:      map[string]string{"severity": "critical"},
+		Annotations: map[string]string{"summary": "Container {{ $labels.container_name }} exited with an error"},
+	}, listsExitedContainers},
+	{promRule{
+		Alert:       "DockerContainerRemoved",
+		Expr:        "dex_container_absent == 1",
+		For:         "1m",
+		Labels:      map[string]string{"severity": "warning"},
+		Annotations: map[string]string{"summary": "Container {{ $labels.container_name }} was removed"},
+	}, func() bool { return envDuration("DEX_ABSENT_CONTAINERS_TTL", 0) > 0 }},
+	{promRule{
+		Alert:       "DockerExpectedContainerMissing",
+		Expr:        "dex_expected_container_missing == 1",
+		For:         "2m",
+		Labels:      map[string]string{"severity": "critical"},
+		Annotations: map[string]string{"summary": "Expected container {{ $labels.name }} doesn't exist"},
+	}, func() bool { return len(config.ExpectedContainers) > 0 }},
+	{promRule{
+		Alert:       "DockerContainerRestartLoop",
+		Expr:        "increase(dex_container_restarts_total[15m]) > 3",
+		Labels:      map[string]string{"severity": "critical"},
+		Annotations: map[string]string{"summary": "Container {{ $labels.container_name }} restarted {{ $value | humanize }} times in 15 minutes"},
+	}, nil},
+	{promRule{
+		Alert:       "DockerContainerUnhealthy",
+		Expr:        "dex_container_healthy == 0",
+		For:         "5m",
+		Labels:      map[string]string{"severity": "warning"},
+		Annotations: map[string]string{"summary": "Container {{ $labels.container_name }} is unhealthy"},
+	}, nil},
+	{promRule{
+		Alert:       "DockerContainerHighMemory",
+		Expr:        "dex_memory_utilization_percent > 90",
+		For:         "5m",
+		Labels:      map[string]string{"severity": "warning"},
+		Annotations: map[string]string{"summary": "Container {{ $labels.container_name }} uses {{ $value | humanize }}% of its memory limit"},
+	}, nil},
+	{promRule{
+		Alert:       "DockerContainerOOMKilled",
+		Expr:        "increase(dex_oom_kills_total[15m]) > 0",
+		Labels:      map[string]string{"severity": "warning"},
+		Annotations: map[string]string{"summary": "Process {{ $labels.process }} of container {{ $labels.container_name }} was OOM killed"},
+	}, func() bool { return envBool("DEX_OOM_KILLS_ENABLED", false) }},
+	{promRule{
+		Alert:       "DockerComposeProjectUnhealthy",
+		Expr:        "dex_compose_project_healthy == 0",
+		For:         "5m",
+		Labels:      map[string]string{"severity": "warning"},
+		Annotations: map[string]string{"summary": "Compose project {{ $labels.compose_project }} has containers down or unhealthy"},
+	}, func() bool { return envBool("DEX_COMPOSE_AGGREGATES", false) }},
+	{promRule{
+		Alert:       "DockerImageCriticalVulnerabilities",
+		Expr:        `dex_image_vulnerabilities{severity="CRITICAL"} > 0`,
+		Labels:      map[string]string{"severity": "warning"},
+		Annotations: map[string]string{"summary": "Image {{ $labels.image }} has {{ $value }} critical vulnerabilities"},
+	}, func() bool { return envBool("DEX_TRIVY_ENABLED", false) }},
+	{promRule{
+		Alert:       "DexConfigError",
+		Expr:        "dex_config_error == 1",
+		Labels:      map[string]string{"severity": "warning"},
+		Annotations: map[string]string{"summary": "Option {{ $labels.option }} of dex is invalid, its default is used"},
+	}, nil},
+}
+
+// listsExitedContainers reports whether exited containers are exported.
+func listsExitedContainers() bool {
+	states := splitList(envString("DEX_CONTAINER_STATES", ""))
+	return len(states) == 0 || slices.Contains(states, "exited")
+}
+
+// multiHost reports whether the metrics have the docker_host label.
+func multiHost() bool {
+	return envString("DEX_DOCKER_HOSTS", "") != "" || envString("DEX_DOCKER_HOSTS_DNS", "") != ""
+}
+
+// generatedRecordings returns the recording rules of the per-host totals.
+func generatedRecordings() []promRule {
+	level, by := "instance", "instance"
+	if multiHost() {
+		level, by = "docker_host", "instance, docker_host"
+	}
+
+	metrics := []string{"dex_container_running", "dex_memory_usage_bytes"}
+	// a histogram can't be summed like the gauge
+	if !envBool("DEX_CPU_HISTOGRAM", false) {
+		metrics = append(metrics, "dex_cpu_utilization_percent")
+	}
+
+	var rules []promRule
+	for _, metric := range metrics {
+		rules = append(rules, promRule{
+			Record: fmt.Sprintf("%s:%s:sum", level, metric),
+			Expr:   fmt.Sprintf("sum by (%s) (%s)", by, metric),
+		})
+	}
+	return rules
+}
+
+// generateRules implements `dex generate-rules [-prefix dex_] [file]`. It
+// prints Prometheus recording and alerting rules for the metrics exported
+// with the options of the file, DEX_CONFIG and the environment and returns
+// the exit code.
+func generateRules(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("generate-rules", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	prefix := flags.String("prefix", "dex_", "prefix of the metric names, e.g. when renamed by metric_relabel_configs")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: dex generate-rules [-prefix dex_] [file]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	path := os.Getenv("DEX_CONFIG")
+	if flags.NArg() == 1 {
+		path = flags.Arg(0)
+	}
+	if path != "" {
+		cfg, err := loadConfig(path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		config = cfg
+	}
+
+	rename := func(s string) string {
+		return strings.ReplaceAll(s, "dex_", *prefix)
+	}
+
+	recordings := generatedRecordings()
+	for i := range recordings {
+		recordings[i].Record = rename(recordings[i].Record)
+		recordings[i].Expr = rename(recordings[i].Expr)
+	}
+
+	var alerts []promRule
+	for _, generated := range generatedAlerts {
+		if generated.enabled != nil && !generated.enabled() {
+			continue
+		}
+		rule := generated.rule
+		rule.Expr = rename(rule.Expr)
+		alerts = append(alerts, rule)
+	}
+
+	rules := map[string][]promRuleGroup{"groups": {
+		{Name: "dex-recording", Rules: recordings},
+		{Name: "dex-alerts", Rules: alerts},
+	}}
+
+	encoder := yaml.NewEncoder(stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(rules); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if err := encoder.Close(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
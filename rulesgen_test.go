@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func generatedRuleNames(t *testing.T, args ...string) (alerts, records, exprs []string) {
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, 0, generateRules(args, &stdout, &stderr), stderr.String())
+
+	var rules struct {
+		Groups []promRuleGroup `yaml:"groups"`
+	}
+	require.NoError(t, yaml.Unmarshal(stdout.Bytes(), &rules))
+	for _, group := range rules.Groups {
+		for _, rule := range group.Rules {
+			if rule.Alert != "" {
+				alerts = append(alerts, rule.Alert)
+			} else {
+				records = append(records, rule.Record)
+			}
+			exprs = append(exprs, rule.Expr)
+		}
+	}
+	return alerts, records, exprs
+}
+
+func TestGenerateRules(t *testing.T) {
+	t.Setenv("DEX_CONFIG", "")
+	alerts, records, _ := generatedRuleNames(t)
+
+	assert.Equal(t, []string{
+		"DockerContainerFailed",
+		"DockerContainerRestartLoop",
+		"DockerContainerUnhealthy",
+		"DockerContainerHighMemory",
+		"DexConfigError",
+	}, alerts)
+	assert.Equal(t, []string{
+		"instance:dex_container_running:sum",
+		"instance:dex_memory_usage_bytes:sum",
+		"instance:dex_cpu_utilization_percent:sum",
+	}, records)
+}
+
+func TestGenerateRulesForOptions(t *testing.T) {
+	path := writeConfig(t, `
+container_states: running
+oom_kills_enabled: true
+compose_aggregates: true
+cpu_histogram: true
+docker_hosts: tcp://10.0.0.2:2375
+expected_containers:
+  - name: traefik
+`)
+
+	alerts, records, exprs := generatedRuleNames(t, "-prefix", "docker_", path)
+
+	assert.NotContains(t, alerts, "DockerContainerFailed", "Exited containers aren't listed")
+	assert.Contains(t, alerts, "DockerContainerOOMKilled")
+	assert.Contains(t, alerts, "DockerComposeProjectUnhealthy")
+	assert.Contains(t, alerts, "DockerExpectedContainerMissing")
+	assert.NotContains(t, alerts, "DockerImageCriticalVulnerabilities")
+
+	assert.Equal(t, []string{
+		"docker_host:docker_container_running:sum",
+		"docker_host:docker_memory_usage_bytes:sum",
+	}, records)
+	for _, expr := range exprs {
+		assert.NotContains(t, expr, "dex_")
+	}
+	assert.Contains(t, exprs, "increase(docker_oom_kills_total[15m]) > 0")
+}
+
+func TestGenerateRulesUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, generateRules([]string{"a.yml", "b.yml"}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "usage: dex generate-rules")
+
+	stderr.Reset()
+	assert.Equal(t, 1, generateRules([]string{"/nonexistent.yml"}, &stdout, &stderr))
+}
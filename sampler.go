@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// sampleIntervalLabel is the container label setting the minimum time between
+// the samples of a container, e.g. dex.interval=5m for a noisy but unimportant
+// one. Containers without it are sampled every interval of the sampler.
+const sampleIntervalLabel = "dex.interval"
+
+// StatsSampler reads the stats of running containers in the background, so
+// scrapes are served from the cache instead of waiting for the daemon.
+type StatsSampler struct {
+	cli      *client.Client
+	api      *DockerAPIMetrics
+	filter   *containerFilter
+	interval time.Duration
+	// stream two samples instead of a single one, see readContainerStats
+	streamStats bool
+	// wraps32 reports whether the counters of the daemon wrap at 32 bits
+	wraps32 func() bool
+	// shared with the scrapes of the collector, see DockerCollector.slots
+	slots chan struct{}
+
+	mu sync.RWMutex
+	// whether all containers were sampled once, the top-N can't be ranked before
+	sampled bool
+	// last stats of the running containers by ID
+	samples map[string]*container.StatsResponse
+	// time the containers with the dex.interval label were last sampled by ID
+	sampledAt map[string]time.Time
+	// CPU utilization of all samples by container ID, nil unless DEX_CPU_HISTOGRAM is set
+	cpu map[string]prometheus.Histogram
+	// network throughput between the last two samples by container ID, nil
+	// unless DEX_NETWORK_RATES is set
+	networkRates map[string]networkRate
+}
+
+// networkRate is the throughput of eth0 in bytes per second.
+type networkRate struct {
+	rx, tx float64
+}
+
+// cpuBuckets are the classic buckets of the CPU utilization histogram,
+// 100% per CPU.
+var cpuBuckets = []float64{5, 10, 25, 50, 75, 100, 150, 200, 300, 400, 800, 1600}
+
+// newStatsSampler returns nil when background sampling is disabled. It is
+// enabled by DEX_SAMPLE_INTERVAL or implicitly by the top-N mode.
+func newStatsSampler(cli *client.Client, api *DockerAPIMetrics, filter *containerFilter, topN int) *StatsSampler {
+	interval := envDuration("DEX_SAMPLE_INTERVAL", 0)
+	if interval <= 0 && topN > 0 {
+		interval = 15 * time.Second
+	}
+	if interval <= 0 {
+		return nil
+	}
+
+	return &StatsSampler{
+		cli:      cli,
+		api:      api,
+		filter:   filter,
+		interval: interval,
+		samples:  map[string]*container.StatsResponse{},
+	}
+}
+
+func (s *StatsSampler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.sample(ctx, false); err != nil {
+			log.Error("can't list containers for sampling: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sample replaces the cache with the stats of the running containers. The
+// stats of containers whose dex.interval isn't over are carried over unless
+// force is set.
+func (s *StatsSampler) sample(ctx context.Context, force bool) error {
+	ctx, span := tracer.Start(ctx, "sample")
+	defer span.End()
+
+	var containers []container.Summary
+	err := s.api.observe(ctx, "list", func() error {
+		var err error
+		containers, err = s.cli.ContainerList(ctx, container.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	samples := map[string]*container.StatsResponse{}
+	// whether the containers sampled in this run have a dex.interval
+	labeled := map[string]bool{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, cont := range containers {
+		if _, ok := s.filter.match(strings.TrimPrefix(strings.Join(cont.Names, ";"), "/")); !ok {
+			continue
+		}
+
+		interval, hasInterval := s.containerInterval(cont)
+		if hasInterval && !force {
+			if stats, ok := s.carried(cont.ID, interval, now); ok {
+				samples[cont.ID] = stats
+				continue
+			}
+		}
+
+		if s.slots != nil {
+			s.slots <- struct{}{}
+		}
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if s.slots != nil {
+				defer func() { <-s.slots }()
+			}
+
+			stats, err := readContainerStats(ctx, s.cli, s.api, id, s.streamStats)
+			if err != nil {
+				log.Debugf("can't sample stats of container '%s': %v", shortID(id), err)
+				return
+			}
+			normalizeStats(&stats)
+
+			mu.Lock()
+			samples[id] = &stats
+			labeled[id] = hasInterval
+			mu.Unlock()
+		}(cont.ID)
+	}
+	wg.Wait()
+
+	// read before locking, the first call asks the daemon
+	wraps32 := s.wraps32 != nil && s.wraps32()
+
+	s.mu.Lock()
+	previous := s.samples
+	s.samples = samples
+	s.sampled = true
+	s.updateSampledAt(labeled, now)
+	s.observeCPU(previous)
+	s.updateNetworkRates(previous, wraps32)
+	s.mu.Unlock()
+	return nil
+}
+
+// containerInterval returns the dex.interval of a container, false if it has
+// none or it is invalid.
+func (s *StatsSampler) containerInterval(cont container.Summary) (time.Duration, bool) {
+	v, ok := cont.Labels[sampleIntervalLabel]
+	if !ok {
+		return 0, false
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil || interval <= 0 {
+		log.Debugf("invalid %s '%s' of container '%s', sampling it every %s", sampleIntervalLabel, v, shortID(cont.ID), s.interval)
+		return 0, false
+	}
+	return interval, true
+}
+
+// carried returns the last stats of a container if its interval isn't over.
+// Half a sampler interval is tolerated, so the ticks of the sampler don't
+// delay the next sample by a whole interval.
+func (s *StatsSampler) carried(id string, interval time.Duration, now time.Time) (*container.StatsResponse, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sampledAt, ok := s.sampledAt[id]
+	if !ok || now.Sub(sampledAt)+s.interval/2 >= interval {
+		return nil, false
+	}
+	stats, ok := s.samples[id]
+	return stats, ok
+}
+
+// updateSampledAt records the time the labeled containers were sampled and
+// forgets the containers which aren't running anymore. s.mu must be held.
+func (s *StatsSampler) updateSampledAt(labeled map[string]bool, now time.Time) {
+	if s.sampledAt == nil {
+		s.sampledAt = map[string]time.Time{}
+	}
+	for id, ok := range labeled {
+		if ok {
+			s.sampledAt[id] = now
+		} else {
+			delete(s.sampledAt, id)
+		}
+	}
+	for id := range s.sampledAt {
+		if _, ok := s.samples[id]; !ok {
+			delete(s.sampledAt, id)
+		}
+	}
+}
+
+// updateNetworkRates computes the network throughput of the containers
+// between the previous and the current samples. Containers without a previous
+// sample or with reset counters, e.g. after a restart, get no rate. Counters
+// of 32-bit hosts may wrap if wraps32 is set. s.mu must be held.
+func (s *StatsSampler) updateNetworkRates(previous map[string]*container.StatsResponse, wraps32 bool) {
+	if s.networkRates == nil {
+		return
+	}
+
+	rates := make(map[string]networkRate, len(s.samples))
+	for id, stats := range s.samples {
+		prev, ok := previous[id]
+		if !ok {
+			continue
+		}
+		// carried over until the dex.interval of the container is over
+		if stats == prev {
+			if rate, ok := s.networkRates[id]; ok {
+				rates[id] = rate
+			}
+			continue
+		}
+		seconds := stats.Read.Sub(prev.Read).Seconds()
+		cur, last := stats.Networks["eth0"], prev.Networks["eth0"]
+		rx, rxOK := counterDelta(last.RxBytes, cur.RxBytes, wraps32)
+		tx, txOK := counterDelta(last.TxBytes, cur.TxBytes, wraps32)
+		if seconds <= 0 || !rxOK || !txOK {
+			continue
+		}
+		rates[id] = networkRate{
+			rx: float64(rx) / seconds,
+			tx: float64(tx) / seconds,
+		}
+	}
+	s.networkRates = rates
+}
+
+// networkRate returns the network throughput of a container. It is safe to
+// call on a nil receiver.
+func (s *StatsSampler) networkRate(id string) (networkRate, bool) {
+	if s == nil {
+		return networkRate{}, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rate, ok := s.networkRates[id]
+	return rate, ok
+}
+
+// observeCPU adds the CPU utilization of the samples to the histograms and
+// drops those of the containers which aren't running anymore. Samples carried
+// over from previous aren't observed again. s.mu must be held.
+func (s *StatsSampler) observeCPU(previous map[string]*container.StatsResponse) {
+	if s.cpu == nil {
+		return
+	}
+
+	for id, stats := range s.samples {
+		if previous[id] == stats {
+			continue
+		}
+		percent, ok := cpuPercent(stats)
+		if !ok {
+			continue
+		}
+		h, ok := s.cpu[id]
+		if !ok {
+			h = prometheus.NewHistogram(withNativeHistogram(prometheus.HistogramOpts{
+				Name:    "dex_cpu_utilization_percent",
+				Help:    metricHelp("dex_cpu_utilization_percent"),
+				Buckets: cpuBuckets,
+			}))
+			s.cpu[id] = h
+		}
+		h.Observe(percent)
+	}
+
+	for id := range s.cpu {
+		if _, ok := s.samples[id]; !ok {
+			delete(s.cpu, id)
+		}
+	}
+}
+
+// cpuHistogram returns the CPU utilization histogram of a container. It is
+// safe to call on a nil receiver.
+func (s *StatsSampler) cpuHistogram(id string) (prometheus.Histogram, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.cpu[id]
+	return h, ok
+}
+
+// refresh samples the container with the given name, or all containers if
+// it is empty, and returns the number of sampled containers.
+func (s *StatsSampler) refresh(ctx context.Context, name string) (int, error) {
+	if name == "" {
+		if err := s.sample(ctx, true); err != nil {
+			return 0, err
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return len(s.samples), nil
+	}
+
+	var containers []container.Summary
+	err := s.api.observe(ctx, "list", func() error {
+		var err error
+		// the name filter matches substrings
+		containers, err = s.cli.ContainerList(ctx, container.ListOptions{Filters: filters.NewArgs(filters.Arg("name", name))})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	refreshed := 0
+	for _, cont := range containers {
+		if !slices.Contains(cont.Names, "/"+name) {
+			continue
+		}
+		if _, ok := s.filter.match(strings.TrimPrefix(strings.Join(cont.Names, ";"), "/")); !ok {
+			continue
+		}
+
+		stats, err := readContainerStats(ctx, s.cli, s.api, cont.ID, s.streamStats)
+		if err != nil {
+			return refreshed, err
+		}
+		normalizeStats(&stats)
+
+		s.mu.Lock()
+		s.samples[cont.ID] = &stats
+		s.mu.Unlock()
+		refreshed++
+	}
+	return refreshed, nil
+}
+
+// get returns the cached stats of a container. It is safe to call on a nil
+// receiver, in which case nothing is cached.
+func (s *StatsSampler) get(id string) (*container.StatsResponse, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats, ok := s.samples[id]
+	return stats, ok
+}
+
+// top returns the IDs of the n sampled containers using the most CPU or
+// memory. It returns nil until all containers were sampled once, so the first
+// scrapes export all containers instead of none.
+func (s *StatsSampler) top(n int, by string) map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.sampled {
+		return nil
+	}
+
+	type usage struct {
+		id    string
+		value float64
+	}
+	usages := make([]usage, 0, len(s.samples))
+	for id, stats := range s.samples {
+		var value float64
+		if by == "memory" {
+			value = float64(memoryUsageBytes(stats))
+		} else {
+			value, _ = cpuPercent(stats)
+		}
+		usages = append(usages, usage{id, value})
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].value != usages[j].value {
+			return usages[i].value > usages[j].value
+		}
+		return usages[i].id < usages[j].id
+	})
+
+	top := map[string]bool{}
+	for i := 0; i < n && i < len(usages); i++ {
+		top[usages[i].id] = true
+	}
+	return top
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sampleStats(cpuDelta, memory uint64) *container.StatsResponse {
+	stats := &container.StatsResponse{}
+	stats.PreCPUStats.SystemUsage = 1000
+	stats.CPUStats.SystemUsage = 2000
+	stats.CPUStats.CPUUsage.TotalUsage = cpuDelta
+	stats.CPUStats.OnlineCPUs = 1
+	stats.MemoryStats.Usage = memory
+	return stats
+}
+
+func TestStatsSamplerTop(t *testing.T) {
+	s := &StatsSampler{samples: map[string]*container.StatsResponse{
+		"a": sampleStats(100, 300),
+		"b": sampleStats(500, 100),
+		"c": sampleStats(300, 200),
+	}}
+	assert.Nil(t, s.top(2, "cpu"), "All containers should be exported until they were sampled")
+
+	s.sampled = true
+	assert.Equal(t, map[string]bool{"b": true, "c": true}, s.top(2, "cpu"))
+	assert.Equal(t, map[string]bool{"a": true, "c": true}, s.top(2, "memory"))
+	assert.Len(t, s.top(10, "cpu"), 3, "All containers should be included when there are fewer than N")
+}
+
+func TestStatsSamplerDisabled(t *testing.T) {
+	assert.Nil(t, newStatsSampler(nil, nil, matchAllFilter(), 0))
+
+	var s *StatsSampler
+	_, ok := s.get("a")
+	assert.False(t, ok, "Nil sampler should have no cached stats")
+}
+
+func TestStatsSamplerEnabledByTopN(t *testing.T) {
+	s := newStatsSampler(nil, nil, matchAllFilter(), 5)
+	require.NotNil(t, s)
+	assert.Positive(t, s.interval)
+}
+
+func TestProcessContainerOutsideTopN(t *testing.T) {
+	c := &DockerCollector{filter: matchAllFilter()}
+	projects := newProjectAggregates(true)
+
+	ch := make(chan prometheus.Metric, 10)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	c.processContainer(context.Background(), container.Summary{
+		ID:     "3f1e2d4c5b6a79880123456789abcdef",
+		Names:  []string{"/ci-job"},
+		State:  "running",
+		Labels: map[string]string{"com.docker.compose.project": "ci"},
+	}, ch, projects, nil, false, &wg)
+	close(ch)
+
+	var names []string
+	for m := range ch {
+		names = append(names, m.Desc().String())
+	}
+
+	require.Len(t, names, 6, "Only state metrics should be exported outside the top-N")
+	for _, name := range names {
+		assert.Regexp(t, `dex_container_(running|restarting|exited|paused|last_seen_timestamp_seconds|info)"`, name)
+	}
+	assert.Equal(t, 1.0, projects.projects["ci"].states["running"], "Aggregates should still count the container")
+}
+
+func TestStatsSamplerCPUHistogram(t *testing.T) {
+	s := &StatsSampler{cpu: map[string]prometheus.Histogram{}}
+	for _, delta := range []uint64{100, 900} {
+		stats := sampleStats(delta, 0)
+		stats.ID = "a"
+		s.samples = map[string]*container.StatsResponse{"a": stats, "b": sampleStats(200, 0)}
+		s.observeCPU(nil)
+	}
+
+	c := &DockerCollector{sampler: s, cpuHistogram: true}
+	ch := make(chan prometheus.Metric, 2)
+	c.CPUMetrics(ch, s.samples["a"], newContainerLabels("web"))
+	close(ch)
+
+	var histogram *dto.Histogram
+	for m := range ch {
+		var pb dto.Metric
+		require.NoError(t, m.Write(&pb))
+		if pb.Histogram != nil {
+			histogram = pb.Histogram
+			assert.Equal(t, "web", pb.Label[0].GetValue())
+		}
+	}
+	require.NotNil(t, histogram, "the CPU utilization must be exported as histogram")
+	assert.Equal(t, uint64(2), histogram.GetSampleCount(), "every sample is observed")
+	assert.InDelta(t, 100.0, histogram.GetSampleSum(), 0.001)
+
+	// b stopped running
+	s.samples = map[string]*container.StatsResponse{"a": s.samples["a"]}
+	s.observeCPU(nil)
+	_, ok := s.cpuHistogram("b")
+	assert.False(t, ok)
+}
+
+func TestStatsSamplerNetworkRates(t *testing.T) {
+	now := time.Now()
+	sample := func(read time.Time, rx, tx uint64) *container.StatsResponse {
+		stats := &container.StatsResponse{Networks: map[string]container.NetworkStats{"eth0": {RxBytes: rx, TxBytes: tx}}}
+		stats.Read = read
+		return stats
+	}
+
+	s := &StatsSampler{networkRates: map[string]networkRate{}}
+	previous := map[string]*container.StatsResponse{
+		"a": sample(now, 1000, 500),
+		"b": sample(now, 5000, 5000),
+	}
+	s.samples = map[string]*container.StatsResponse{
+		"a": sample(now.Add(10*time.Second), 21000, 1500),
+		// restarted, the counters were reset
+		"b": sample(now.Add(10*time.Second), 100, 100),
+		"c": sample(now.Add(10*time.Second), 100, 100),
+	}
+	s.updateNetworkRates(previous, false)
+
+	rate, ok := s.networkRate("a")
+	require.True(t, ok)
+	assert.Equal(t, networkRate{rx: 2000, tx: 100}, rate)
+	_, ok = s.networkRate("b")
+	assert.False(t, ok, "reset counters have no rate")
+	_, ok = s.networkRate("c")
+	assert.False(t, ok, "new containers have no rate")
+
+	var disabled *StatsSampler
+	_, ok = disabled.networkRate("a")
+	assert.False(t, ok)
+
+	// the counters of 32-bit hosts wrap at 4 GiB
+	previous = map[string]*container.StatsResponse{"a": sample(now, 4294962296, 500)}
+	s.samples = map[string]*container.StatsResponse{"a": sample(now.Add(10*time.Second), 15000, 1500)}
+	s.updateNetworkRates(previous, true)
+	rate, ok = s.networkRate("a")
+	require.True(t, ok)
+	assert.Equal(t, networkRate{rx: 2000, tx: 100}, rate)
+}
+
+func TestStatsSamplerContainerInterval(t *testing.T) {
+	cli := fakeDaemon(t, []container.Summary{
+		{ID: "aaa", Names: []string{"/db"}},
+		{ID: "bbb", Names: []string{"/noisy"}, Labels: map[string]string{sampleIntervalLabel: "5m"}},
+		{ID: "ccc", Names: []string{"/invalid"}, Labels: map[string]string{sampleIntervalLabel: "often"}},
+	})
+	s := &StatsSampler{cli: cli, api: newDockerAPIMetrics(), filter: matchAllFilter(), interval: 15 * time.Second, samples: map[string]*container.StatsResponse{}}
+
+	require.NoError(t, s.sample(context.Background(), false))
+	first := maps.Clone(s.samples)
+	require.Len(t, first, 3)
+
+	require.NoError(t, s.sample(context.Background(), false))
+	assert.NotSame(t, first["aaa"], s.samples["aaa"], "containers without the label are sampled every interval")
+	assert.Same(t, first["bbb"], s.samples["bbb"], "the stats are carried over until the interval of the label is over")
+	assert.NotSame(t, first["ccc"], s.samples["ccc"], "invalid intervals are ignored")
+
+	// the interval is over
+	s.sampledAt["bbb"] = time.Now().Add(-5 * time.Minute)
+	require.NoError(t, s.sample(context.Background(), false))
+	assert.NotSame(t, first["bbb"], s.samples["bbb"])
+
+	carried := s.samples["bbb"]
+	require.NoError(t, s.sample(context.Background(), true))
+	assert.NotSame(t, carried, s.samples["bbb"], "refreshes sample all containers")
+}
+
+func TestStatsSamplerSlots(t *testing.T) {
+	cli := fakeDaemon(t, []container.Summary{
+		{ID: "aaa", Names: []string{"/db"}},
+		{ID: "bbb", Names: []string{"/web"}},
+	})
+	slots := make(chan struct{}, 1)
+	s := &StatsSampler{cli: cli, api: newDockerAPIMetrics(), filter: matchAllFilter(), interval: 15 * time.Second, samples: map[string]*container.StatsResponse{}, slots: slots}
+
+	// a scrape holds the only slot
+	slots <- struct{}{}
+	done := make(chan error)
+	go func() { done <- s.sample(context.Background(), false) }()
+
+	select {
+	case <-done:
+		t.Fatal("Sampling should wait for a slot held by a scrape")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	<-slots
+	require.NoError(t, <-done)
+	assert.Len(t, s.samples, 2)
+	assert.Empty(t, slots, "All slots should be released after sampling")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// minLabelMaxLength leaves room for some characters besides the hash suffix.
+const minLabelMaxLength = 16
+
+// labelSanitizer keeps the label values taken from container names and
+// labels valid and short, whatever names the containers are generated with.
+type labelSanitizer struct {
+	// invalid matches the characters replaced with _, nil to keep all
+	invalid *regexp.Regexp
+	// maxLength is the maximum number of characters, unlimited if 0
+	maxLength int
+}
+
+func newLabelSanitizer() *labelSanitizer {
+	s := &labelSanitizer{maxLength: envInt("DEX_LABEL_MAX_LENGTH", 0)}
+	if err := validateLabelMaxLength(strconv.Itoa(s.maxLength)); err != nil {
+		log.Errorf("invalid DEX_LABEL_MAX_LENGTH, not limiting label values: %v", err)
+		s.maxLength = 0
+		configErrors.add(configKey("DEX_LABEL_MAX_LENGTH"))
+	}
+
+	if expr := envString("DEX_LABEL_INVALID_CHARS", ""); expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			log.Errorf("invalid DEX_LABEL_INVALID_CHARS regexp '%s', keeping all characters: %v", expr, err)
+			configErrors.add(configKey("DEX_LABEL_INVALID_CHARS"))
+		} else {
+			s.invalid = re
+		}
+	}
+	return s
+}
+
+// sanitize replaces the invalid characters of v and shortens it to the
+// maximum length. Shortened values end with a hash of the original value, so
+// they stay distinct. Invalid UTF-8 is always replaced, even on a nil receiver.
+func (s *labelSanitizer) sanitize(v string) string {
+	sanitized := strings.ToValidUTF8(v, "_")
+	if s == nil {
+		return sanitized
+	}
+
+	if s.invalid != nil {
+		sanitized = s.invalid.ReplaceAllString(sanitized, "_")
+	}
+	if s.maxLength > 0 && utf8.RuneCountInString(sanitized) > s.maxLength {
+		sum := sha256.Sum256([]byte(v))
+		suffix := "-" + hex.EncodeToString(sum[:4])
+		sanitized = string([]rune(sanitized)[:s.maxLength-len(suffix)]) + suffix
+	}
+	return sanitized
+}
+
+func validateLabelMaxLength(v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	if n != 0 && n < minLabelMaxLength {
+		return fmt.Errorf("must be 0 or at least %d", minLabelMaxLength)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelSanitizer(t *testing.T) {
+	t.Setenv("DEX_LABEL_INVALID_CHARS", `[^a-zA-Z0-9_.-]`)
+	t.Setenv("DEX_LABEL_MAX_LENGTH", "20")
+	s := newLabelSanitizer()
+
+	assert.Equal(t, "web", s.sanitize("web"))
+	assert.Equal(t, "my_project_", s.sanitize("my project!"))
+
+	long := s.sanitize(strings.Repeat("a", 30) + "1")
+	assert.Equal(t, 20, utf8.RuneCountInString(long))
+	assert.True(t, strings.HasPrefix(long, "aaaaaaaaaaa-"), long)
+	assert.NotEqual(t, long, s.sanitize(strings.Repeat("a", 30)+"2"), "Shortened values should stay distinct")
+}
+
+func TestLabelSanitizerDefaults(t *testing.T) {
+	s := newLabelSanitizer()
+	assert.Equal(t, "my project!", s.sanitize("my project!"))
+	assert.Equal(t, "bad_utf8", s.sanitize("bad\xffutf8"), "Invalid UTF-8 should always be replaced")
+
+	var nilSanitizer *labelSanitizer
+	assert.Equal(t, "bad_utf8", nilSanitizer.sanitize("bad\xffutf8"))
+}
+
+func TestLabelSanitizerInvalidOptions(t *testing.T) {
+	t.Setenv("DEX_LABEL_INVALID_CHARS", "[")
+	t.Setenv("DEX_LABEL_MAX_LENGTH", "4")
+	saved := configErrors
+	configErrors = &ConfigErrors{}
+	t.Cleanup(func() { configErrors = saved })
+
+	s := newLabelSanitizer()
+	assert.Nil(t, s.invalid)
+	assert.Zero(t, s.maxLength)
+	assert.Equal(t, []string{"label_max_length", "label_invalid_chars"}, configErrors.options)
+}
+
+func TestContainerFilterSanitizesLabels(t *testing.T) {
+	t.Setenv("DEX_LABEL_INVALID_CHARS", `[^a-z]`)
+	f, err := newContainerFilter([]*FilterRule{{Match: `^(.*)$`, Labels: map[string]string{"team": "Team A"}}})
+	require.NoError(t, err)
+	f.sanitizer = newLabelSanitizer()
+
+	cl, ok := f.match("web.1")
+	require.True(t, ok)
+	assert.Equal(t, []string{"web__", "_eam__"}, cl.values)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// overlapModes are the values of DEX_SCRAPE_OVERLAP.
+var overlapModes = []string{"queue", "reject", "cache"}
+
+// ScrapeGuard serializes the collections of the container collector, so
+// scrapes taking longer than the scrape interval don't multiply the load on
+// the Docker daemon. A scrape arriving during a collection waits for it in
+// queue mode, gets 503 in reject mode, or is served the metrics of the last
+// collection in cache mode.
+type ScrapeGuard struct {
+	collector prometheus.Collector
+	mode      string
+
+	// held during a collection
+	mu      sync.Mutex
+	running atomic.Bool
+
+	cacheMu sync.Mutex
+	cached  []prometheus.Metric
+
+	overlaps prometheus.Counter
+}
+
+// newScrapeGuard returns nil when overlapping scrapes are allowed.
+func newScrapeGuard(collector prometheus.Collector) *ScrapeGuard {
+	mode := envString("DEX_SCRAPE_OVERLAP", "")
+	if mode == "" {
+		return nil
+	}
+	if err := validateOverlapMode(mode); err != nil {
+		log.Errorf("invalid DEX_SCRAPE_OVERLAP, queueing overlapping scrapes: %v", err)
+		mode = "queue"
+		configErrors.add(configKey("DEX_SCRAPE_OVERLAP"))
+	}
+
+	return &ScrapeGuard{
+		collector: collector,
+		mode:      mode,
+		overlaps: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "dex_scrape_overlaps_total",
+			Help:        metricHelp("dex_scrape_overlaps_total"),
+			ConstLabels: prometheus.Labels{"mode": mode},
+		}),
+	}
+}
+
+func (g *ScrapeGuard) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (g *ScrapeGuard) Collect(ch chan<- prometheus.Metric) {
+	defer func() { ch <- g.overlaps }()
+
+	if !g.mu.TryLock() {
+		// the rejected scrapes are counted by Wrap, the other gatherers queue
+		if g.mode != "reject" {
+			g.overlaps.Inc()
+		}
+		if g.mode == "cache" && g.serveCached(ch) {
+			return
+		}
+		g.mu.Lock()
+	}
+	defer g.mu.Unlock()
+
+	g.running.Store(true)
+	defer g.running.Store(false)
+
+	if g.mode != "cache" {
+		g.collector.Collect(ch)
+		return
+	}
+
+	collected := make(chan prometheus.Metric)
+	go func() {
+		g.collector.Collect(collected)
+		close(collected)
+	}()
+
+	var metrics []prometheus.Metric
+	for m := range collected {
+		metrics = append(metrics, m)
+		ch <- m
+	}
+
+	g.cacheMu.Lock()
+	g.cached = metrics
+	g.cacheMu.Unlock()
+}
+
+// serveCached sends the metrics of the last collection, it returns false if
+// there was none yet.
+func (g *ScrapeGuard) serveCached(ch chan<- prometheus.Metric) bool {
+	g.cacheMu.Lock()
+	defer g.cacheMu.Unlock()
+
+	if g.cached == nil {
+		return false
+	}
+	for _, m := range g.cached {
+		ch <- m
+	}
+	return true
+}
+
+// Wrap returns a handler rejecting scrapes with 503 while a collection is
+// running in reject mode. It is safe to call on a nil receiver.
+func (g *ScrapeGuard) Wrap(next http.Handler) http.Handler {
+	if g == nil || g.mode != "reject" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.running.Load() {
+			g.overlaps.Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "a collection is already running", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validateOverlapMode(v string) error {
+	if v != "" && !slices.Contains(overlapModes, v) {
+		return fmt.Errorf("must be one of %s", strings.Join(overlapModes, ", "))
+	}
+	return nil
+}
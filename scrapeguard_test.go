@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingCollector collects a single metric once release is closed.
+type blockingCollector struct {
+	started chan struct{}
+	release chan struct{}
+
+	running     atomic.Int32
+	concurrent  atomic.Int32
+	collections atomic.Int32
+}
+
+func newBlockingCollector() *blockingCollector {
+	return &blockingCollector{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (c *blockingCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *blockingCollector) Collect(ch chan<- prometheus.Metric) {
+	if n := c.running.Add(1); n > 1 {
+		c.concurrent.Store(n)
+	}
+	defer c.running.Add(-1)
+
+	c.started <- struct{}{}
+	<-c.release
+	n := c.collections.Add(1)
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("dex_test_collections", "collections", nil, nil), prometheus.GaugeValue, float64(n))
+}
+
+func guardWithMode(t *testing.T, c prometheus.Collector, mode string) *ScrapeGuard {
+	t.Setenv("DEX_SCRAPE_OVERLAP", mode)
+	g := newScrapeGuard(c)
+	require.NotNil(t, g)
+	return g
+}
+
+// collectValues collects the guard and returns the values of the test metric.
+func collectValues(g *ScrapeGuard) []float64 {
+	ch := make(chan prometheus.Metric, 10)
+	g.Collect(ch)
+	close(ch)
+
+	var values []float64
+	for m := range ch {
+		if m.Desc() != g.overlaps.Desc() {
+			pb := &dto.Metric{}
+			m.Write(pb)
+			values = append(values, pb.GetGauge().GetValue())
+		}
+	}
+	return values
+}
+
+func TestScrapeGuardQueue(t *testing.T) {
+	c := newBlockingCollector()
+	g := guardWithMode(t, c, "queue")
+
+	var wg sync.WaitGroup
+	results := make([][]float64, 2)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0] = collectValues(g)
+	}()
+	<-c.started
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[1] = collectValues(g)
+	}()
+	require.Eventually(t, func() bool { return testutil.ToFloat64(g.overlaps) == 1 }, time.Second, time.Millisecond)
+
+	close(c.release)
+	wg.Wait()
+
+	assert.ElementsMatch(t, [][]float64{{1}, {2}}, results, "The overlapping scrape should wait for its own collection")
+	assert.Zero(t, c.concurrent.Load())
+}
+
+func TestScrapeGuardCache(t *testing.T) {
+	c := newBlockingCollector()
+	g := guardWithMode(t, c, "cache")
+
+	close(c.release)
+	assert.Equal(t, []float64{1}, collectValues(g))
+
+	// a collection blocked until the overlapping scrape is served
+	c.release = make(chan struct{})
+	done := make(chan []float64)
+	go func() { done <- collectValues(g) }()
+	<-c.started
+	<-c.started
+
+	assert.Equal(t, []float64{1}, collectValues(g), "The overlapping scrape should get the last collection")
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.overlaps))
+
+	close(c.release)
+	assert.Equal(t, []float64{2}, <-done)
+}
+
+func TestScrapeGuardReject(t *testing.T) {
+	c := newBlockingCollector()
+	g := guardWithMode(t, c, "reject")
+	handler := g.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	done := make(chan struct{})
+	go func() {
+		collectValues(g)
+		close(done)
+	}()
+	<-c.started
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.overlaps))
+
+	close(c.release)
+	<-done
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestScrapeGuardDisabled(t *testing.T) {
+	assert.Nil(t, newScrapeGuard(newBlockingCollector()))
+
+	// a nil guard serves all scrapes
+	var g *ScrapeGuard
+	w := httptest.NewRecorder()
+	g.Wrap(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// ContainerScripts runs the executables of a directory for every running
+// container on every scrape and exports the metrics they print with the labels
+// of the container, custom metrics of the containers without Go code.
+type ContainerScripts struct {
+	cli    *client.Client
+	api    *DockerAPIMetrics
+	filter *containerFilter
+
+	dir         string
+	timeout     time.Duration
+	concurrency int
+	procPath    string
+	// names of the labels added by the registerer, which printed labels can't have
+	reserved []string
+
+	// processes of the containers by ID, so they are inspected only once
+	// per start
+	mu        sync.Mutex
+	processes map[string]containerProcess
+}
+
+// containerProcess is the main process of a container. The start time tells
+// it from a later process with the same PID after the container restarted.
+type containerProcess struct {
+	pid     int
+	started uint64
+}
+
+// scriptTarget is a running container the scripts run for.
+type scriptTarget struct {
+	cl   containerLabels
+	id   string
+	name string
+}
+
+// newContainerScripts returns nil when no script directory is configured.
+// labels are the labels the scripts are registered with.
+func newContainerScripts(cli *client.Client, api *DockerAPIMetrics, filter *containerFilter, labels prometheus.Labels) *ContainerScripts {
+	dir := envString("DEX_CONTAINER_SCRIPT_DIR", "")
+	if dir == "" {
+		return nil
+	}
+
+	return &ContainerScripts{
+		cli:         cli,
+		api:         api,
+		filter:      filter,
+		dir:         dir,
+		timeout:     envDuration("DEX_CONTAINER_SCRIPT_TIMEOUT", 5*time.Second),
+		concurrency: max(envInt("DEX_CONTAINER_SCRIPT_CONCURRENCY", 10), 1),
+		procPath:    envString("DEX_PROC_PATH", "/proc"),
+		reserved:    slices.Sorted(maps.Keys(labels)),
+		processes:   map[string]containerProcess{},
+	}
+}
+
+func (s *ContainerScripts) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (s *ContainerScripts) Collect(ch chan<- prometheus.Metric) {
+	// the directory is read on every scrape, so scripts can be added without a restart
+	scripts, err := executables(s.dir)
+	if err != nil {
+		log.Error("can't list container scripts: ", err)
+		return
+	}
+	if len(scripts) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	var containers []container.Summary
+	err = s.api.observe(ctx, "list", func() error {
+		var err error
+		containers, err = s.cli.ContainerList(ctx, container.ListOptions{})
+		return err
+	})
+	if err != nil {
+		log.Error("can't list containers for the scripts: ", err)
+		return
+	}
+
+	var targets []scriptTarget
+	ids := map[string]bool{}
+	for _, cont := range containers {
+		cl, ok := s.filter.match(strings.TrimPrefix(strings.Join(cont.Names, ";"), "/"))
+		if !ok {
+			continue
+		}
+		targets = append(targets, scriptTarget{cl: cl, id: cont.ID, name: strings.TrimPrefix(cont.Names[0], "/")})
+		ids[cont.ID] = true
+	}
+	s.forgetProcesses(ids)
+
+	// the scripts of the containers run at once up to
+	// DEX_CONTAINER_SCRIPT_CONCURRENCY, so a slow one only delays the scrape
+	// by the timeout
+	slots := make(chan struct{}, s.concurrency)
+	acquire := func() bool {
+		select {
+		case slots <- struct{}{}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	results := make([][]map[string]*dto.MetricFamily, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		results[i] = make([]map[string]*dto.MetricFamily, len(scripts))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !acquire() {
+				return
+			}
+			pid := s.containerPID(ctx, target)
+			<-slots
+			env := []string{
+				"DEX_CONTAINER_ID=" + target.id,
+				"DEX_CONTAINER_NAME=" + target.name,
+				"DEX_CONTAINER_PID=" + pid,
+			}
+
+			var scriptsWG sync.WaitGroup
+			for j, script := range scripts {
+				scriptsWG.Add(1)
+				go func() {
+					defer scriptsWG.Done()
+					if !acquire() {
+						return
+					}
+					defer func() { <-slots }()
+					families, err := runPlugin(ctx, filepath.Join(s.dir, script), env)
+					if err != nil {
+						log.Debugf("script %s failed for container '%s': %v", script, target.cl.values[0], err)
+						return
+					}
+					results[i][j] = families
+				}()
+			}
+			scriptsWG.Wait()
+		}()
+	}
+	wg.Wait()
+
+	// the first container and script exporting a metric decide its help and
+	// type, the registry fails the whole scrape on inconsistent families
+	seen := map[string]*dto.MetricFamily{}
+	for i, target := range targets {
+		for j, script := range scripts {
+			up := 0.0
+			if results[i][j] != nil {
+				up = 1
+			}
+			scl := target.cl.with("script", script)
+			ch <- scl.metric(descs.get(
+				"dex_container_script_up",
+				scl.names,
+			), prometheus.GaugeValue, up)
+
+			names := make([]string, 0, len(results[i][j]))
+			for name := range results[i][j] {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				family := results[i][j][name]
+				if first, ok := seen[name]; ok && first.GetType() != family.GetType() {
+					log.Debugf("skipping metric %s of script %s for container '%s', its type differs from other scripts", name, script, target.cl.values[0])
+					continue
+				} else if !ok {
+					seen[name] = family
+				}
+				collectFamily(ch, name, seen[name], family, target.cl, s.reserved)
+			}
+		}
+	}
+}
+
+// containerPID returns the PID of the main process of the container on the
+// host, empty if it can't be inspected. The container is inspected again only
+// when the process is gone, if procfs isn't readable on every call.
+func (s *ContainerScripts) containerPID(ctx context.Context, target scriptTarget) string {
+	s.mu.Lock()
+	process, ok := s.processes[target.id]
+	s.mu.Unlock()
+	if ok {
+		if started, err := processStartTime(s.procPath, process.pid); err == nil && started == process.started {
+			return strconv.Itoa(process.pid)
+		}
+	}
+
+	var inspect container.InspectResponse
+	err := s.api.observe(ctx, "inspect", func() error {
+		var err error
+		inspect, err = s.cli.ContainerInspect(ctx, target.id)
+		return err
+	})
+	if err != nil {
+		log.Debugf("can't inspect container '%s' for the scripts: %v", target.cl.values[0], err)
+		return ""
+	}
+	if inspect.State == nil || inspect.State.Pid == 0 {
+		return ""
+	}
+
+	pid := inspect.State.Pid
+	if started, err := processStartTime(s.procPath, pid); err == nil {
+		s.mu.Lock()
+		s.processes[target.id] = containerProcess{pid: pid, started: started}
+		s.mu.Unlock()
+	}
+	return strconv.Itoa(pid)
+}
+
+// forgetProcesses removes the processes of the containers not running anymore.
+func (s *ContainerScripts) forgetProcesses(running map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id := range s.processes {
+		if !running[id] {
+			delete(s.processes, id)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContainerScriptsDisabled(t *testing.T) {
+	assert.Nil(t, newContainerScripts(nil, nil, nil, nil))
+}
+
+func TestContainerScripts(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "sessions", `
+[ "$DEX_CONTAINER_NAME" = db ] || exit 1
+echo '# HELP app_sessions Open sessions'
+echo '# TYPE app_sessions gauge'
+echo "app_sessions{id=\"$DEX_CONTAINER_ID\",hostname=\"db\"} 5"
+`, 0o755)
+
+	cli := fakeDaemon(t, []container.Summary{
+		{ID: "aaa", Names: []string{"/db"}},
+		{ID: "bbb", Names: []string{"/web"}},
+		{ID: "ccc", Names: []string{"/other"}},
+	})
+	filter, err := newContainerFilter([]*FilterRule{{Match: `^other$`, Drop: true}, {Match: ".*"}})
+	require.NoError(t, err)
+
+	t.Setenv("DEX_CONTAINER_SCRIPT_DIR", dir)
+	s := newContainerScripts(cli, newDockerAPIMetrics(), filter, map[string]string{"hostname": "node1"})
+
+	expected := `
+# HELP app_sessions Open sessions
+# TYPE app_sessions gauge
+app_sessions{container_name="db",exported_hostname="db",id="aaa"} 5
+# HELP dex_container_script_up 1 if the script ran for the container and its output was parsed, 0 otherwise
+# TYPE dex_container_script_up gauge
+dex_container_script_up{container_name="db",script="sessions"} 1
+dex_container_script_up{container_name="web",script="sessions"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(s, strings.NewReader(expected)))
+}
+
+func TestContainerScriptsEmptyDir(t *testing.T) {
+	t.Setenv("DEX_CONTAINER_SCRIPT_DIR", t.TempDir())
+	s := newContainerScripts(nil, newDockerAPIMetrics(), nil, nil)
+
+	// the daemon isn't asked without scripts
+	assert.Equal(t, 0, testutil.CollectAndCount(s))
+}
+
+func TestContainerScriptsConcurrency(t *testing.T) {
+	dir := t.TempDir()
+	running := filepath.Join(t.TempDir(), "running")
+	require.NoError(t, os.Mkdir(running, 0o755))
+	// every run records the number of runs at once
+	writePlugin(t, dir, "count", `
+touch "`+running+`/$$"
+ls "`+running+`" | wc -l >> "`+running+`.log"
+sleep 0.1
+rm "`+running+`/$$"
+`, 0o755)
+
+	var containers []container.Summary
+	for i := range 6 {
+		containers = append(containers, container.Summary{ID: strconv.Itoa(i), Names: []string{"/c" + strconv.Itoa(i)}})
+	}
+	cli := fakeDaemon(t, containers)
+
+	t.Setenv("DEX_CONTAINER_SCRIPT_DIR", dir)
+	t.Setenv("DEX_CONTAINER_SCRIPT_CONCURRENCY", "2")
+	s := newContainerScripts(cli, newDockerAPIMetrics(), matchAllFilter(), nil)
+	assert.Equal(t, 6, testutil.CollectAndCount(s, "dex_container_script_up"))
+
+	runs, err := os.ReadFile(running + ".log")
+	require.NoError(t, err)
+	counts := strings.Fields(string(runs))
+	assert.Len(t, counts, 6)
+	for _, count := range counts {
+		n, err := strconv.Atoi(count)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, n, 2, "No more scripts than DEX_CONTAINER_SCRIPT_CONCURRENCY should run at once")
+	}
+}
+
+func TestContainerScriptsPID(t *testing.T) {
+	inspects := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			json.NewEncoder(w).Encode([]container.Summary{{ID: "aaa", Names: []string{"/db"}}})
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			inspects++
+			// the test process stands in for the main process of the container
+			json.NewEncoder(w).Encode(container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{
+				ID:    "aaa",
+				State: &container.State{Pid: os.Getpid()},
+			}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.45"))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	writePlugin(t, dir, "pid", `echo "pid $DEX_CONTAINER_PID"`+"\n", 0o755)
+	t.Setenv("DEX_CONTAINER_SCRIPT_DIR", dir)
+	s := newContainerScripts(cli, newDockerAPIMetrics(), matchAllFilter(), nil)
+
+	expected := `
+# HELP pid
+# TYPE pid untyped
+pid{container_name="db"} ` + strconv.Itoa(os.Getpid()) + `
+`
+	assert.NoError(t, testutil.CollectAndCompare(s, strings.NewReader(expected), "pid"))
+	assert.NoError(t, testutil.CollectAndCompare(s, strings.NewReader(expected), "pid"))
+	assert.Equal(t, 1, inspects, "The container should be inspected once per start of its process")
+
+	s.forgetProcesses(map[string]bool{})
+	assert.Empty(t, s.processes)
+}
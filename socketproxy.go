@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// the Docker API endpoints probed behind a socket proxy, named like the
+// sections of docker-socket-proxy
+const (
+	endpointContainers = "containers"
+	endpointInfo       = "info"
+	endpointEvents     = "events"
+)
+
+// eventsProbeTimeout is how long the event stream must stay open to be
+// permitted, proxies deny it right away.
+const eventsProbeTimeout = time.Second
+
+// SocketProxyCheck probes at startup which Docker API endpoints a socket
+// proxy like docker-socket-proxy permits, so the collectors needing a denied
+// endpoint are disabled instead of failing every scrape. Only 403 responses
+// disable a collector, other errors, e.g. a daemon which isn't up yet, are
+// left to the collectors.
+type SocketProxyCheck struct {
+	forbidden []string
+
+	mu         sync.Mutex
+	collectors map[string]bool
+}
+
+// newSocketProxyCheck returns nil when dex isn't running behind a socket
+// proxy.
+func newSocketProxyCheck(ctx context.Context, cli *client.Client, api *DockerAPIMetrics) *SocketProxyCheck {
+	if !envBool("DEX_SOCKET_PROXY", false) {
+		return nil
+	}
+
+	c := &SocketProxyCheck{collectors: map[string]bool{}}
+	c.probe(ctx, cli, api)
+	if len(c.forbidden) > 0 {
+		log.Warnf("the socket proxy denies the %v endpoints, disabling the collectors using them", c.forbidden)
+	}
+	return c
+}
+
+func (c *SocketProxyCheck) probe(ctx context.Context, cli *client.Client, api *DockerAPIMetrics) {
+	probes := map[string]func(context.Context) error{
+		endpointContainers: func(ctx context.Context) error {
+			return api.observe(ctx, "list", func() error {
+				_, err := cli.ContainerList(ctx, container.ListOptions{Limit: 1})
+				return err
+			})
+		},
+		endpointInfo: func(ctx context.Context) error {
+			return api.observe(ctx, "info", func() error {
+				_, err := cli.Info(ctx)
+				return err
+			})
+		},
+		endpointEvents: func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, eventsProbeTimeout)
+			defer cancel()
+
+			_, errs := cli.Events(ctx, events.ListOptions{
+				Filters: filters.NewArgs(filters.Arg("type", string(events.ContainerEventType))),
+			})
+			if err := <-errs; !errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
+			return nil
+		},
+	}
+
+	for endpoint, probe := range probes {
+		if err := probe(ctx); errdefs.IsForbidden(err) {
+			c.forbidden = append(c.forbidden, endpoint)
+		}
+	}
+	sort.Strings(c.forbidden)
+}
+
+// require records whether the collector is enabled, that is none of the
+// endpoints it uses is denied, and returns it. It returns true on a nil
+// receiver.
+func (c *SocketProxyCheck) require(collector string, endpoints ...string) bool {
+	if c == nil {
+		return true
+	}
+
+	enabled := true
+	for _, endpoint := range endpoints {
+		if slices.Contains(c.forbidden, endpoint) {
+			enabled = false
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.collectors[collector] = enabled
+	return enabled
+}
+
+func (c *SocketProxyCheck) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (c *SocketProxyCheck) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for collector, enabled := range c.collectors {
+		var value float64
+		if enabled {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_collector_enabled",
+			[]string{"collector"},
+		), prometheus.GaugeValue, value, collector)
+	}
+}
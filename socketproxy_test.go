@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSocketProxy answers 403 for the denied endpoints like docker-socket-proxy.
+func fakeSocketProxy(t *testing.T, denied ...string) *client.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, endpoint := range denied {
+			if strings.Contains(r.URL.Path, "/"+endpoint) {
+				http.Error(w, "<html><body><h1>403 Forbidden</h1></body></html>", http.StatusForbidden)
+				return
+			}
+		}
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			json.NewEncoder(w).Encode([]container.Summary{})
+		case strings.HasSuffix(r.URL.Path, "/info"):
+			json.NewEncoder(w).Encode(system.Info{Name: "host"})
+		case strings.HasSuffix(r.URL.Path, "/events"):
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.45"))
+	require.NoError(t, err)
+	return cli
+}
+
+func TestSocketProxyCheckDisabled(t *testing.T) {
+	check := newSocketProxyCheck(context.Background(), fakeSocketProxy(t), newDockerAPIMetrics())
+	assert.Nil(t, check)
+	assert.True(t, check.require("containers", endpointContainers))
+}
+
+func TestSocketProxyCheck(t *testing.T) {
+	t.Setenv("DEX_SOCKET_PROXY", "true")
+
+	check := newSocketProxyCheck(context.Background(), fakeSocketProxy(t, "info", "events"), newDockerAPIMetrics())
+	assert.Equal(t, []string{endpointEvents, endpointInfo}, check.forbidden)
+
+	assert.True(t, check.require("containers", endpointContainers))
+	assert.False(t, check.require("daemon", endpointInfo))
+	assert.False(t, check.require("start_durations", endpointContainers, endpointEvents))
+
+	expected := `
+# HELP dex_collector_enabled 1 if the collector is enabled, 0 if it is disabled because the socket proxy denies an endpoint it uses
+# TYPE dex_collector_enabled gauge
+dex_collector_enabled{collector="containers"} 1
+dex_collector_enabled{collector="daemon"} 0
+dex_collector_enabled{collector="start_durations"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(check, strings.NewReader(expected)))
+}
+
+func TestSocketProxyCheckAllPermitted(t *testing.T) {
+	t.Setenv("DEX_SOCKET_PROXY", "true")
+
+	check := newSocketProxyCheck(context.Background(), fakeSocketProxy(t), newDockerAPIMetrics())
+	assert.Empty(t, check.forbidden)
+	assert.True(t, check.require("lifetimes", endpointContainers, endpointEvents))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// StartDurations exports the time containers take from being created to
+// running, e.g. to catch regressions caused by bloated images.
+type StartDurations struct {
+	cli    *client.Client
+	api    *DockerAPIMetrics
+	filter *containerFilter
+
+	durations *prometheus.HistogramVec
+}
+
+// newStartDurations returns nil when start durations are not enabled.
+func newStartDurations(cli *client.Client, api *DockerAPIMetrics, filter *containerFilter) *StartDurations {
+	if !envBool("DEX_START_DURATION_ENABLED", false) {
+		return nil
+	}
+
+	return &StartDurations{
+		cli:    cli,
+		api:    api,
+		filter: filter,
+		durations: prometheus.NewHistogramVec(withNativeHistogram(prometheus.HistogramOpts{
+			Name:    "dex_container_start_duration_seconds",
+			Help:    metricHelp("dex_container_start_duration_seconds"),
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		}), []string{"image"}),
+	}
+}
+
+func (s *StartDurations) Describe(ch chan<- *prometheus.Desc) {
+	s.durations.Describe(ch)
+}
+
+func (s *StartDurations) Collect(ch chan<- prometheus.Metric) {
+	s.durations.Collect(ch)
+}
+
+// handleStart observes the start duration of a container on its start event.
+func (s *StartDurations) handleStart(ctx context.Context, msg events.Message) {
+	if _, ok := s.filter.match(msg.Actor.Attributes["name"]); !ok {
+		return
+	}
+
+	var inspect container.InspectResponse
+	err := s.api.observe(ctx, "inspect", func() error {
+		var err error
+		inspect, err = s.cli.ContainerInspect(ctx, msg.Actor.ID)
+		return err
+	})
+	if err != nil {
+		log.Errorf("can't inspect started container '%s': %v", shortID(msg.Actor.ID), err)
+		return
+	}
+
+	if duration, ok := startDuration(inspect); ok {
+		image := msg.Actor.Attributes["image"]
+		if image == "" && inspect.Config != nil {
+			image = inspect.Config.Image
+		}
+		s.durations.WithLabelValues(image).Observe(duration.Seconds())
+	}
+}
+
+// startDuration returns the time from creation to the first start of a
+// container. Later starts are skipped as they include the time it was stopped.
+func startDuration(inspect container.InspectResponse) (time.Duration, bool) {
+	if inspect.ContainerJSONBase == nil || inspect.State == nil {
+		return 0, false
+	}
+
+	finished, err := time.Parse(time.RFC3339Nano, inspect.State.FinishedAt)
+	if err == nil && !finished.IsZero() {
+		return 0, false
+	}
+
+	created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+	if err != nil {
+		return 0, false
+	}
+	started, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+	if err != nil || started.Before(created) {
+		return 0, false
+	}
+
+	return started.Sub(created), true
+}
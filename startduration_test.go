@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func inspectWithTimes(created, started, finished string) container.InspectResponse {
+	return container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{
+		Created: created,
+		State:   &container.State{StartedAt: started, FinishedAt: finished},
+	}}
+}
+
+func TestStartDuration(t *testing.T) {
+	duration, ok := startDuration(inspectWithTimes("2024-05-01T10:00:00.000000000Z", "2024-05-01T10:00:01.500000000Z", "0001-01-01T00:00:00Z"))
+	assert.True(t, ok)
+	assert.Equal(t, 1500*time.Millisecond, duration)
+}
+
+func TestStartDurationSkipsRestarts(t *testing.T) {
+	_, ok := startDuration(inspectWithTimes("2024-05-01T10:00:00Z", "2024-05-02T10:00:00Z", "2024-05-01T12:00:00Z"))
+	assert.False(t, ok, "Restarted containers should be skipped")
+}
+
+func TestStartDurationInvalid(t *testing.T) {
+	_, ok := startDuration(container.InspectResponse{})
+	assert.False(t, ok)
+
+	_, ok = startDuration(inspectWithTimes("2024-05-01T10:00:00Z", "invalid", ""))
+	assert.False(t, ok)
+
+	_, ok = startDuration(inspectWithTimes("2024-05-01T10:00:00Z", "2024-05-01T09:00:00Z", ""))
+	assert.False(t, ok, "Start before creation should be skipped")
+}
@@ -0,0 +1,18 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// statfs returns the usage of the filesystem containing path.
+func statfs(path string) (filesystemUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return filesystemUsage{}, err
+	}
+	return filesystemUsage{
+		size:       float64(st.Blocks) * float64(st.Bsize),
+		free:       float64(st.Bfree) * float64(st.Bsize),
+		freeInodes: float64(st.Ffree),
+	}, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// statfs is only supported on linux, where the container filesystems are
+// reachable.
+func statfs(_ string) (filesystemUsage, error) {
+	return filesystemUsage{}, errors.New("filesystem usage is only supported on linux")
+}
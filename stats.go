@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"slices"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// normalizeStats fills the fields the metrics are read from when the daemon
+// reports them under another name, so the metrics don't drop to zero when the
+// daemon is upgraded or the host moves to another cgroup version. The shape
+// is detected from the fields present rather than the API version, because
+// the same daemon reports differently on cgroup v1 and v2:
+//
+//   - cgroup v1 reports the page cache as "cache", hierarchical setups only
+//     as "total_cache"; cgroup v2 reports it as "file"
+//   - daemons before API 1.27 don't report the online CPUs, cgroup v2 doesn't
+//     report the per-CPU usage either
+func normalizeStats(stats *container.StatsResponse) {
+	memory := stats.MemoryStats.Stats
+	if _, ok := memory["cache"]; !ok && memory != nil {
+		if cache, ok := memory["total_cache"]; ok {
+			memory["cache"] = cache
+		} else if file, ok := memory["file"]; ok {
+			memory["cache"] = file
+		}
+	}
+
+	for _, cpu := range []*container.CPUStats{&stats.CPUStats, &stats.PreCPUStats} {
+		// Windows daemons count the CPU time in 100ns ticks, it must only be
+		// converted once per sample
+		if isWindowsStats(stats) {
+			cpu.CPUUsage.TotalUsage *= 100
+			cpu.CPUUsage.UsageInKernelmode *= 100
+			cpu.CPUUsage.UsageInUsermode *= 100
+			cpu.OnlineCPUs = stats.NumProcs
+		}
+		if cpu.OnlineCPUs == 0 {
+			cpu.OnlineCPUs = uint32(len(cpu.CPUUsage.PercpuUsage))
+		}
+	}
+}
+
+// isWindowsStats reports whether the stats are of a Windows container. Only
+// Windows daemons report the number of processors instead of the host CPU
+// time.
+func isWindowsStats(stats *container.StatsResponse) bool {
+	return stats.NumProcs > 0
+}
+
+// hasMemoryStats reports whether the daemon could read the memory cgroup of
+// the container. Rootless daemons without the memory controller delegated
+// return empty memory stats, which must not be exported as zero usage.
+func hasMemoryStats(stats *container.StatsResponse) bool {
+	return stats.MemoryStats.Usage != 0 || stats.MemoryStats.Limit != 0 || len(stats.MemoryStats.Stats) != 0
+}
+
+// arch32 are the architectures of 32-bit docker hosts as reported by the
+// daemon.
+var arch32 = []string{"armv6l", "armv7l", "i386", "i686"}
+
+// counterWrapped32 reports whether a counter dropped from last to cur because
+// it overflowed 32 bits rather than because it was reset. Daemons on 32-bit
+// ARM kernels report some counters, e.g. the network bytes, from unsigned
+// longs wrapping at 4 GiB. Only drops from the upper half of the 32-bit range
+// are taken as wraps, a reset by a restart usually starts from a lower value.
+func counterWrapped32(last, cur uint64) bool {
+	return cur < last && last >= 1<<31 && last <= math.MaxUint32
+}
+
+// counterDelta returns the increase of a counter from last to cur, counting
+// 32-bit wraps if the counter can wrap. It returns false if the counter was
+// reset.
+func counterDelta(last, cur uint64, wraps32 bool) (uint64, bool) {
+	if cur >= last {
+		return cur - last, true
+	}
+	if wraps32 && counterWrapped32(last, cur) {
+		return cur + (1 << 32) - last, true
+	}
+	return 0, false
+}
+
+// unlimitedMemory reports whether a memory limit is the value cgroup v1
+// reports without a limit, the page counter maximum of 64-bit kernels or of
+// 32-bit kernels, which is far below the 64-bit one but still above any host
+// memory a 32-bit kernel can address.
+func unlimitedMemory(limit uint64) bool {
+	return limit >= 1<<62 || limit == (1<<31-1)*4096
+}
+
+// isArch32 reports whether a daemon architecture is 32-bit.
+func isArch32(arch string) bool {
+	return slices.Contains(arch32, arch)
+}
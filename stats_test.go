@@ -0,0 +1,217 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// statsMetricValues returns the values of the stats metrics of a sample by name.
+func statsMetricValues(t *testing.T, stats *container.StatsResponse) map[string]float64 {
+	c := &DockerCollector{}
+	cl := newContainerLabels("app")
+
+	ch := make(chan prometheus.Metric, 16)
+	c.blockIoMetrics(ch, stats, cl)
+	c.memoryMetrics(ch, stats, cl)
+	c.CPUMetrics(ch, stats, cl)
+	c.pidsMetrics(ch, stats, cl)
+	close(ch)
+
+	values := map[string]float64{}
+	for m := range ch {
+		var pb dto.Metric
+		require.NoError(t, m.Write(&pb))
+		name := strings.Split(strings.Split(m.Desc().String(), `fqName: "`)[1], `"`)[0]
+		switch {
+		case pb.Gauge != nil:
+			values[name] = pb.Gauge.GetValue()
+		case pb.Counter != nil:
+			values[name] = pb.Counter.GetValue()
+		}
+	}
+	return values
+}
+
+func TestNormalizeStatsFixtures(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		expected map[string]float64
+		missing  []string
+	}{
+		{
+			// no online_cpus yet
+			fixture: "docker-17.03-cgroupv1.json",
+			expected: map[string]float64{
+				"dex_memory_usage_bytes":         83886080,
+				"dex_cpu_utilization_percent":    200,
+				"dex_block_io_read_bytes_total":  1048576,
+				"dex_block_io_write_bytes_total": 524288,
+				"dex_pids_current":               3,
+			},
+		},
+		{
+			fixture: "docker-20.10-cgroupv1.json",
+			expected: map[string]float64{
+				"dex_memory_usage_bytes":         262144000,
+				"dex_cpu_utilization_percent":    100,
+				"dex_block_io_read_bytes_total":  8388608,
+				"dex_block_io_write_bytes_total": 2097152,
+				"dex_pids_current":               24,
+			},
+		},
+		{
+			// the page cache is reported as "file", the ops in lower case
+			fixture: "docker-20.10-cgroupv2.json",
+			expected: map[string]float64{
+				"dex_memory_usage_bytes":         262144000,
+				"dex_cpu_utilization_percent":    100,
+				"dex_block_io_read_bytes_total":  8388608,
+				"dex_block_io_write_bytes_total": 2097152,
+				"dex_pids_current":               24,
+			},
+		},
+		{
+			// a Raspberry Pi without a memory limit, the 32-bit kernel reports
+			// its page counter maximum as limit
+			fixture: "docker-20.10-armv7-cgroupv1.json",
+			expected: map[string]float64{
+				"dex_memory_usage_bytes":         104857600,
+				"dex_memory_limit_set":           0,
+				"dex_cpu_utilization_percent":    50,
+				"dex_block_io_read_bytes_total":  3145728,
+				"dex_block_io_write_bytes_total": 1048576,
+				"dex_pids_current":               9,
+			},
+			missing: []string{"dex_memory_utilization_percent", "dex_memory_headroom_bytes"},
+		},
+		{
+			// without the memory controller delegated
+			fixture: "docker-27-rootless-cgroupv2.json",
+			expected: map[string]float64{
+				"dex_cpu_utilization_percent":    50,
+				"dex_block_io_read_bytes_total":  0,
+				"dex_block_io_write_bytes_total": 0,
+				"dex_pids_current":               6,
+			},
+			missing: []string{"dex_memory_usage_bytes", "dex_memory_total_bytes", "dex_memory_limit_set"},
+		},
+		{
+			// CPU time in 100ns ticks and the number of processors instead of
+			// the host CPU time, no cgroup memory or block I/O stats
+			fixture: "docker-24-windows-ltsc2022.json",
+			expected: map[string]float64{
+				"dex_cpu_utilization_percent":       50,
+				"dex_cpu_utilization_seconds_total": 15.5025,
+			},
+			missing: []string{"dex_memory_usage_bytes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			stats := loadStatsFixture(t, tt.fixture)
+			normalizeStats(stats)
+
+			values := statsMetricValues(t, stats)
+			for name, expected := range tt.expected {
+				if assert.Contains(t, values, name) {
+					assert.InDelta(t, expected, values[name], 0.001, name)
+				}
+			}
+			for _, name := range tt.missing {
+				assert.NotContains(t, values, name)
+			}
+		})
+	}
+}
+
+func TestNormalizeStatsWindows(t *testing.T) {
+	stats := loadStatsFixture(t, "docker-24-windows-ltsc2022.json")
+	normalizeStats(stats)
+
+	assert.Equal(t, uint32(4), stats.CPUStats.OnlineCPUs)
+	assert.Equal(t, uint64(15502500000), stats.CPUStats.CPUUsage.TotalUsage)
+	assert.Equal(t, uint64(12000000000), stats.CPUStats.CPUUsage.UsageInUsermode)
+	assert.Equal(t, uint64(15000000000), stats.PreCPUStats.CPUUsage.TotalUsage)
+}
+
+func TestCPUPercentWindows(t *testing.T) {
+	stats := loadStatsFixture(t, "docker-24-windows-ltsc2022.json")
+	normalizeStats(stats)
+
+	// 4 processors busy for the whole interval
+	stats.CPUStats.CPUUsage.TotalUsage = stats.PreCPUStats.CPUUsage.TotalUsage + 4*uint64(stats.Read.Sub(stats.PreRead))
+	percent, ok := cpuPercent(stats)
+	assert.True(t, ok)
+	assert.InDelta(t, 400, percent, 0.001)
+
+	// the first sample of a stream has no previous read
+	stats.PreRead = time.Time{}
+	_, ok = cpuPercent(stats)
+	assert.False(t, ok)
+}
+
+func TestNormalizeStatsKeepsReportedFields(t *testing.T) {
+	stats := &container.StatsResponse{
+		CPUStats: container.CPUStats{
+			CPUUsage:   container.CPUUsage{PercpuUsage: []uint64{1, 2}},
+			OnlineCPUs: 4,
+		},
+		MemoryStats: container.MemoryStats{
+			Stats: map[string]uint64{"cache": 100, "total_cache": 300},
+		},
+	}
+	normalizeStats(stats)
+
+	assert.Equal(t, uint32(4), stats.CPUStats.OnlineCPUs)
+	assert.Equal(t, uint64(100), stats.MemoryStats.Stats["cache"])
+
+	normalizeStats(&container.StatsResponse{})
+}
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name       string
+		last, cur  uint64
+		wraps32    bool
+		expected   uint64
+		expectedOk bool
+	}{
+		{"increase", 100, 150, false, 50, true},
+		{"reset", 1000, 10, false, 0, false},
+		{"wrap on 32-bit", 4294967000, 100, true, 396, true},
+		{"wrap on 64-bit is a reset", 4294967000, 100, false, 0, false},
+		{"reset from the lower half on 32-bit", 1000000, 10, true, 0, false},
+		{"reset above 32 bits", 1 << 40, 10, true, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta, ok := counterDelta(tt.last, tt.cur, tt.wraps32)
+			assert.Equal(t, tt.expectedOk, ok)
+			assert.Equal(t, tt.expected, delta)
+		})
+	}
+}
+
+func TestUnlimitedMemory(t *testing.T) {
+	assert.True(t, unlimitedMemory(9223372036854771712), "64-bit cgroup v1 without a limit")
+	assert.True(t, unlimitedMemory(8796093018112), "32-bit cgroup v1 without a limit")
+	assert.False(t, unlimitedMemory(1073741824))
+	assert.False(t, unlimitedMemory(0))
+}
+
+func TestIsArch32(t *testing.T) {
+	assert.True(t, isArch32("armv7l"))
+	assert.True(t, isArch32("armv6l"))
+	assert.True(t, isArch32("i686"))
+	assert.False(t, isArch32("aarch64"))
+	assert.False(t, isArch32("x86_64"))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// newSwarmNodeLabels returns the labels identifying the swarm node of the
+// local daemon, to tell the instances of a global service apart. It returns
+// nil when swarm labels are disabled or the daemon isn't part of a swarm.
+func newSwarmNodeLabels(cli *client.Client) prometheus.Labels {
+	if !envBool("DEX_SWARM_NODE_LABELS", false) {
+		return nil
+	}
+
+	info, err := cli.Info(context.Background())
+	if err != nil {
+		log.Error("can't get docker info, swarm node labels are not added: ", err)
+		return nil
+	}
+	return swarmNodeLabels(info)
+}
+
+func swarmNodeLabels(info system.Info) prometheus.Labels {
+	if info.Swarm.LocalNodeState != swarm.LocalNodeStateActive {
+		log.Warn("docker daemon is not part of a swarm, swarm node labels are not added")
+		return nil
+	}
+
+	role := "worker"
+	if info.Swarm.ControlAvailable {
+		role = "manager"
+	}
+	return prometheus.Labels{
+		"swarm_node_id":   info.Swarm.NodeID,
+		"swarm_node":      info.Name,
+		"swarm_node_role": role,
+	}
+}
+
+// SwarmCollector exports the services and nodes of the swarm cluster. Every
+// manager can read them, but only the raft leader exports them, so a dex
+// global service doesn't export the same series from every manager.
+type SwarmCollector struct {
+	cli *client.Client
+	api *DockerAPIMetrics
+}
+
+// newSwarmCollector returns nil when swarm cluster metrics are not enabled.
+func newSwarmCollector(cli *client.Client, api *DockerAPIMetrics) *SwarmCollector {
+	if !envBool("DEX_SWARM_CLUSTER_METRICS", false) {
+		return nil
+	}
+	return &SwarmCollector{cli: cli, api: api}
+}
+
+func (s *SwarmCollector) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (s *SwarmCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+
+	if leader, err := s.isLeader(ctx); err != nil {
+		log.Error("can't check the swarm leader: ", err)
+		return
+	} else if !leader {
+		return
+	}
+
+	var services []swarm.Service
+	err := s.api.observe(ctx, "service_list", func() error {
+		var err error
+		services, err = s.cli.ServiceList(ctx, types.ServiceListOptions{Status: true})
+		return err
+	})
+	if err != nil {
+		log.Error("can't list swarm services: ", err)
+	} else {
+		swarmServiceMetrics(ch, services)
+	}
+
+	var nodes []swarm.Node
+	err = s.api.observe(ctx, "node_list", func() error {
+		var err error
+		nodes, err = s.cli.NodeList(ctx, types.NodeListOptions{})
+		return err
+	})
+	if err != nil {
+		log.Error("can't list swarm nodes: ", err)
+	} else {
+		swarmNodeMetrics(ch, nodes)
+	}
+}
+
+// isLeader reports whether the local daemon is the leader of the swarm managers.
+func (s *SwarmCollector) isLeader(ctx context.Context) (bool, error) {
+	var info system.Info
+	err := s.api.observe(ctx, "info", func() error {
+		var err error
+		info, err = s.cli.Info(ctx)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if !info.Swarm.ControlAvailable {
+		return false, nil
+	}
+
+	var node swarm.Node
+	err = s.api.observe(ctx, "node_inspect", func() error {
+		var err error
+		node, _, err = s.cli.NodeInspectWithRaw(ctx, info.Swarm.NodeID)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return node.ManagerStatus != nil && node.ManagerStatus.Leader, nil
+}
+
+func serviceMode(mode swarm.ServiceMode) string {
+	switch {
+	case mode.Replicated != nil:
+		return "replicated"
+	case mode.Global != nil:
+		return "global"
+	case mode.ReplicatedJob != nil:
+		return "replicated-job"
+	case mode.GlobalJob != nil:
+		return "global-job"
+	}
+	return ""
+}
+
+func swarmServiceMetrics(ch chan<- prometheus.Metric, services []swarm.Service) {
+	labels := []string{"service", "mode"}
+	for _, service := range services {
+		if service.ServiceStatus == nil {
+			continue
+		}
+		values := []string{service.Spec.Name, serviceMode(service.Spec.Mode)}
+
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_swarm_service_desired_tasks",
+			labels,
+		), prometheus.GaugeValue, float64(service.ServiceStatus.DesiredTasks), values...)
+
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_swarm_service_running_tasks",
+			labels,
+		), prometheus.GaugeValue, float64(service.ServiceStatus.RunningTasks), values...)
+	}
+}
+
+func swarmNodeMetrics(ch chan<- prometheus.Metric, nodes []swarm.Node) {
+	for _, node := range nodes {
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_swarm_node_info",
+			[]string{"swarm_node_id", "swarm_node", "swarm_node_role", "availability", "state"},
+		), prometheus.GaugeValue, 1,
+			node.ID, node.Description.Hostname, string(node.Spec.Role), string(node.Spec.Availability), string(node.Status.State))
+	}
+}
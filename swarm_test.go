@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwarmNodeLabels(t *testing.T) {
+	info := system.Info{Name: "node-1", Swarm: swarm.Info{
+		NodeID:           "abc123",
+		LocalNodeState:   swarm.LocalNodeStateActive,
+		ControlAvailable: true,
+	}}
+	assert.Equal(t, prometheus.Labels{
+		"swarm_node_id":   "abc123",
+		"swarm_node":      "node-1",
+		"swarm_node_role": "manager",
+	}, swarmNodeLabels(info))
+
+	info.Swarm.ControlAvailable = false
+	assert.Equal(t, "worker", swarmNodeLabels(info)["swarm_node_role"])
+
+	info.Swarm.LocalNodeState = swarm.LocalNodeStateInactive
+	assert.Nil(t, swarmNodeLabels(info))
+}
+
+func TestSwarmServiceMetrics(t *testing.T) {
+	replicas := uint64(3)
+	services := []swarm.Service{
+		{
+			Spec:          swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "web"}, Mode: swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}}},
+			ServiceStatus: &swarm.ServiceStatus{DesiredTasks: 3, RunningTasks: 2},
+		},
+		{
+			Spec:          swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "agent"}, Mode: swarm.ServiceMode{Global: &swarm.GlobalService{}}},
+			ServiceStatus: &swarm.ServiceStatus{DesiredTasks: 5, RunningTasks: 5},
+		},
+		{
+			// older daemons don't report the status
+			Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "old"}},
+		},
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	swarmServiceMetrics(ch, services)
+	assert.ElementsMatch(t, []map[string]string{
+		{"service": "web", "mode": "replicated", "value": "3"},
+		{"service": "web", "mode": "replicated", "value": "2"},
+		{"service": "agent", "mode": "global", "value": "5"},
+		{"service": "agent", "mode": "global", "value": "5"},
+	}, collectLabels(t, ch))
+}
+
+func TestSwarmNodeMetrics(t *testing.T) {
+	nodes := []swarm.Node{
+		{
+			ID:          "abc123",
+			Description: swarm.NodeDescription{Hostname: "node-1"},
+			Spec:        swarm.NodeSpec{Role: swarm.NodeRoleManager, Availability: swarm.NodeAvailabilityActive},
+			Status:      swarm.NodeStatus{State: swarm.NodeStateReady},
+		},
+		{
+			ID:          "def456",
+			Description: swarm.NodeDescription{Hostname: "node-2"},
+			Spec:        swarm.NodeSpec{Role: swarm.NodeRoleWorker, Availability: swarm.NodeAvailabilityDrain},
+			Status:      swarm.NodeStatus{State: swarm.NodeStateDown},
+		},
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	swarmNodeMetrics(ch, nodes)
+	assert.ElementsMatch(t, []map[string]string{
+		{"swarm_node_id": "abc123", "swarm_node": "node-1", "swarm_node_role": "manager", "availability": "active", "state": "ready", "value": "1"},
+		{"swarm_node_id": "def456", "swarm_node": "node-2", "swarm_node_role": "worker", "availability": "drain", "state": "down", "value": "1"},
+	}, collectLabels(t, ch))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sdNotify sends a state to the systemd notification socket. It does nothing
+// when not running under systemd with Type=notify.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	addr := &net.UnixAddr{Name: socket, Net: "unixgram"}
+	if socket[0] == '@' {
+		// abstract socket
+		addr.Name = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns the watchdog timeout configured by systemd, or 0
+// if the watchdog is not enabled for this process.
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// runSystemdNotify reports readiness once the Docker daemon is reachable and
+// then answers the watchdog for as long as collections keep succeeding.
+func runSystemdNotify(ctx context.Context, c *DockerCollector) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+
+	for !c.ping(ctx, 5*time.Second) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		log.Error("can't notify systemd: ", err)
+	}
+
+	timeout := sdWatchdogInterval()
+	if timeout == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			_ = sdNotify("STOPPING=1")
+			return
+		case <-ticker.C:
+		}
+
+		if c.healthy(ctx, timeout) {
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				log.Error("can't notify systemd watchdog: ", err)
+			}
+		} else {
+			log.Warn("collections are failing, not answering systemd watchdog")
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSdNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	require.NoError(t, sdNotify("READY=1"))
+
+	buf := make([]byte, 64)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+	assert.Equal(t, 30*time.Second, sdWatchdogInterval())
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(1))
+	assert.Equal(t, time.Duration(0), sdWatchdogInterval(), "Watchdog of another process should be ignored")
+}
+
+func TestCollectionStatus(t *testing.T) {
+	var s collectionStatus
+	now := time.Now()
+
+	id := s.begin()
+	assert.False(t, s.stalled(now, time.Minute), "Fresh collection should not be stalled")
+	assert.True(t, s.stalled(now.Add(2*time.Minute), time.Minute), "Long running collection should be stalled")
+
+	s.end(id, true)
+	assert.False(t, s.stalled(now.Add(2*time.Minute), time.Minute), "Finished collection should not be stalled")
+	assert.True(t, s.succeededSince(now.Add(-time.Second)))
+
+	s.end(s.begin(), false)
+	assert.False(t, s.succeededSince(time.Now()), "Failed collection should not count as success")
+}
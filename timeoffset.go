@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// the command printing the clock and the UTC offset of a container,
+// busybox date doesn't support %N and prints it literally
+var timeOffsetCmd = []string{"date", "+%s.%N %z"}
+
+// how long a single exec may take
+const timeOffsetTimeout = 5 * time.Second
+
+type timeOffset struct {
+	cl        containerLabels
+	offset    float64
+	utcOffset float64
+}
+
+// TimeOffsetCollector periodically execs date in the running containers and
+// compares their clock with the clock of dex, to find containers with a
+// broken view of the time, e.g. a faked clock or a wrong timezone.
+type TimeOffsetCollector struct {
+	cli      *client.Client
+	api      *DockerAPIMetrics
+	filter   *containerFilter
+	interval time.Duration
+
+	mu      sync.Mutex
+	offsets []timeOffset
+}
+
+// newTimeOffsetCollector returns nil when time offset metrics are not enabled.
+func newTimeOffsetCollector(cli *client.Client, api *DockerAPIMetrics, filter *containerFilter) *TimeOffsetCollector {
+	interval := envDuration("DEX_TIME_OFFSET_INTERVAL", 0)
+	if interval <= 0 {
+		return nil
+	}
+
+	return &TimeOffsetCollector{
+		cli:      cli,
+		api:      api,
+		filter:   filter,
+		interval: interval,
+	}
+}
+
+func (t *TimeOffsetCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		t.update(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *TimeOffsetCollector) update(ctx context.Context) {
+	var containers []container.Summary
+	err := t.api.observe(ctx, "list", func() error {
+		var err error
+		containers, err = t.cli.ContainerList(ctx, container.ListOptions{})
+		return err
+	})
+	if err != nil {
+		log.Error("can't list containers for time offsets: ", err)
+		return
+	}
+
+	var offsets []timeOffset
+	for _, cont := range containers {
+		cl, ok := t.filter.match(strings.TrimPrefix(cont.Names[0], "/"))
+		if !ok {
+			continue
+		}
+
+		offset, utcOffset, err := t.measure(ctx, cont.ID)
+		if err != nil {
+			// e.g. distroless images have no date
+			log.Debugf("can't read the clock of container %s: %v", cont.Names[0], err)
+			continue
+		}
+		offsets = append(offsets, timeOffset{cl: cl, offset: offset, utcOffset: utcOffset})
+	}
+
+	t.mu.Lock()
+	t.offsets = offsets
+	t.mu.Unlock()
+}
+
+// measure returns the offset of the container clock from the local clock at
+// the middle of the exec, and the UTC offset of the container timezone.
+func (t *TimeOffsetCollector) measure(ctx context.Context, id string) (float64, float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeOffsetTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	var start, end time.Time
+	err := t.api.observe(ctx, "exec", func() error {
+		exec, err := t.cli.ContainerExecCreate(ctx, id, container.ExecOptions{
+			Cmd:          timeOffsetCmd,
+			AttachStdout: true,
+			AttachStderr: true,
+		})
+		if err != nil {
+			return err
+		}
+
+		start = time.Now()
+		resp, err := t.cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+		if err != nil {
+			return err
+		}
+		defer resp.Close()
+
+		_, err = stdcopy.StdCopy(&stdout, &stderr, resp.Reader)
+		end = time.Now()
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	containerTime, utcOffset, err := parseDateOutput(stdout.String())
+	if err != nil {
+		return 0, 0, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	local := float64(start.Add(end.Sub(start)/2).UnixNano()) / 1e9
+	return containerTime - local, utcOffset, nil
+}
+
+// parseDateOutput parses the output of timeOffsetCmd, e.g.
+// "1700000000.123456789 +0200".
+func parseDateOutput(output string) (float64, float64, error) {
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected date output '%s'", strings.TrimSpace(output))
+	}
+
+	seconds, nanos, _ := strings.Cut(fields[0], ".")
+	if _, err := strconv.Atoi(nanos); err != nil {
+		// no nanosecond support
+		nanos = "0"
+	}
+	containerTime, err := strconv.ParseFloat(seconds+"."+nanos, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected date output '%s'", strings.TrimSpace(output))
+	}
+
+	zone, err := time.Parse("-0700", fields[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected timezone '%s'", fields[1])
+	}
+	_, utcOffset := zone.Zone()
+
+	return containerTime, float64(utcOffset), nil
+}
+
+func (t *TimeOffsetCollector) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (t *TimeOffsetCollector) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, o := range t.offsets {
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_container_time_offset_seconds",
+			o.cl.names,
+		), prometheus.GaugeValue, o.offset, o.cl.values...)
+
+		ch <- prometheus.MustNewConstMetric(newDesc(
+			"dex_container_utc_offset_seconds",
+			o.cl.names,
+		), prometheus.GaugeValue, o.utcOffset, o.cl.values...)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDateOutput(t *testing.T) {
+	for _, tc := range []struct {
+		output    string
+		time      float64
+		utcOffset float64
+	}{
+		{"1700000000.250000000 +0000\n", 1700000000.25, 0},
+		{"1700000000.5 +0200", 1700000000.5, 7200},
+		{"1700000000.5 -0330", 1700000000.5, -12600},
+		// busybox
+		{"1700000000.%N +0100", 1700000000, 3600},
+	} {
+		containerTime, utcOffset, err := parseDateOutput(tc.output)
+		require.NoError(t, err, tc.output)
+		assert.InDelta(t, tc.time, containerTime, 1e-6, tc.output)
+		assert.Equal(t, tc.utcOffset, utcOffset, tc.output)
+	}
+
+	for _, output := range []string{"", "date: not found", "abc +0000", "1700000000 UTC"} {
+		_, _, err := parseDateOutput(output)
+		assert.Error(t, err, output)
+	}
+}
+
+func TestTimeOffsetCollector(t *testing.T) {
+	c := &TimeOffsetCollector{offsets: []timeOffset{
+		{cl: newContainerLabels("web"), offset: -0.5, utcOffset: 3600},
+	}}
+
+	expected := `
+# HELP dex_container_time_offset_seconds Offset of the container clock from the clock of dex, accurate to the duration of an exec
+# TYPE dex_container_time_offset_seconds gauge
+dex_container_time_offset_seconds{container_name="web"} -0.5
+# HELP dex_container_utc_offset_seconds UTC offset of the timezone of the container
+# TYPE dex_container_utc_offset_seconds gauge
+dex_container_utc_offset_seconds{container_name="web"} 3600
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected)))
+}
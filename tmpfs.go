@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type tmpfsUsage struct {
+	mountpoint string
+	used       float64
+	size       float64
+}
+
+// readTmpfsUsage returns the usage of the tmpfs mounts of the process pid,
+// e.g. /dev/shm. The mounts are read from its mountinfo and measured through
+// its root directory in procfs. The masks docker mounts over /proc and /sys
+// are skipped.
+func readTmpfsUsage(procPath string, pid int) ([]tmpfsUsage, error) {
+	dir := filepath.Join(procPath, strconv.Itoa(pid))
+	mountpoints, err := tmpfsMountpoints(filepath.Join(dir, "mountinfo"))
+	if err != nil {
+		return nil, err
+	}
+
+	var usages []tmpfsUsage
+	for _, mountpoint := range mountpoints {
+		st, err := statfs(filepath.Join(dir, "root", mountpoint))
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, tmpfsUsage{
+			mountpoint: mountpoint,
+			used:       st.size - st.free,
+			size:       st.size,
+		})
+	}
+	return usages, nil
+}
+
+// tmpfsMountpoints parses a mountinfo file, whose lines have the form
+// 36 35 98:0 /root /mountpoint rw,noatime master:1 - tmpfs shm rw,size=65536k
+func tmpfsMountpoints(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mountpoints []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields, fsFields, ok := strings.Cut(scanner.Text(), " - ")
+		if !ok {
+			continue
+		}
+		mountFields := strings.Fields(fields)
+		fsType := strings.Fields(fsFields)
+		if len(mountFields) < 5 || len(fsType) == 0 || fsType[0] != "tmpfs" {
+			continue
+		}
+
+		mountpoint := unescapeMountpoint(mountFields[4])
+		if mountpoint == "/proc" || strings.HasPrefix(mountpoint, "/proc/") ||
+			mountpoint == "/sys" || strings.HasPrefix(mountpoint, "/sys/") {
+			continue
+		}
+		mountpoints = append(mountpoints, mountpoint)
+	}
+	return mountpoints, scanner.Err()
+}
+
+// unescapeMountpoint decodes the octal escapes of spaces, tabs, newlines and
+// backslashes in mountinfo.
+func unescapeMountpoint(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadTmpfsUsage(t *testing.T) {
+	proc := t.TempDir()
+	writeFiles(t, proc, map[string]string{
+		"100/mountinfo": `812 700 0:56 / / rw,relatime master:300 - overlay overlay rw,lowerdir=/l,upperdir=/u,workdir=/w
+813 812 0:59 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw
+814 812 0:60 / /dev rw,nosuid - tmpfs tmpfs rw,size=65536k,mode=755
+818 814 0:58 / /dev/shm rw,nosuid,nodev,noexec,relatime - tmpfs shm rw,size=65536k
+820 812 0:61 / /run/my\040cache rw,relatime - tmpfs tmpfs rw,size=1024k
+705 813 0:62 / /proc/acpi ro,relatime - tmpfs tmpfs ro
+706 812 0:63 / /sys/firmware ro,relatime - tmpfs tmpfs ro
+`,
+	})
+	for _, dir := range []string{"dev/shm", "run/my cache"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(proc, "100", "root", dir), 0755))
+	}
+
+	usages, err := readTmpfsUsage(proc, 100)
+	require.NoError(t, err)
+
+	var mountpoints []string
+	for _, usage := range usages {
+		mountpoints = append(mountpoints, usage.mountpoint)
+		assert.Positive(t, usage.size)
+		assert.LessOrEqual(t, usage.used, usage.size)
+	}
+	assert.Equal(t, []string{"/dev", "/dev/shm", "/run/my cache"}, mountpoints)
+}
+
+func TestReadTmpfsUsageMissingProcess(t *testing.T) {
+	_, err := readTmpfsUsage(t.TempDir(), 100)
+	assert.Error(t, err)
+}
+
+func TestUnescapeMountpoint(t *testing.T) {
+	assert.Equal(t, "/a b\tc\\d", unescapeMountpoint(`/a\040b\011c\134d`))
+	assert.Equal(t, `/end\04`, unescapeMountpoint(`/end\04`))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tracer creates the spans of the collection pipeline. Requests to the
+// Docker API get child spans from the instrumented client.
+var tracer = otel.Tracer("dex")
+
+// newTracerProvider returns the provider exporting spans over OTLP/HTTP to
+// DEX_OTLP_ENDPOINT, or nil if tracing is disabled. The standard OTEL_*
+// variables configure the exporter and sampler further.
+func newTracerProvider(ctx context.Context) *sdktrace.TracerProvider {
+	endpoint := envString("DEX_OTLP_ENDPOINT", "")
+	if endpoint == "" {
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		log.Fatalf("can't create OTLP exporter: %v", err)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", envString("DEX_OTLP_SERVICE_NAME", "dex")))),
+	)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestCollectTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	c := &DockerCollector{
+		cli:      fakeDaemon(t, []container.Summary{{ID: "aaa", Names: []string{"/web"}, State: "running"}}),
+		api:      newDockerAPIMetrics(),
+		counters: newMonotonicCounters(),
+		filter:   matchAllFilter(),
+	}
+
+	ch := make(chan prometheus.Metric, 1000)
+	c.Collect(ch)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	collect, ok := spans["collect"]
+	require.True(t, ok)
+	assert.Contains(t, collect.Attributes(), attribute.Int("containers", 1))
+
+	cont, ok := spans["container"]
+	require.True(t, ok)
+	assert.Equal(t, collect.SpanContext().SpanID(), cont.Parent().SpanID())
+	assert.Contains(t, cont.Attributes(), attribute.String("container.name", "web"))
+
+	// the requests of the container are traced by the docker client
+	inspect, ok := spans["GET /v1.45/containers/aaa/json"]
+	require.True(t, ok)
+	assert.Equal(t, cont.SpanContext().SpanID(), inspect.Parent().SpanID())
+	_, ok = spans["GET /v1.45/containers/aaa/stats"]
+	assert.True(t, ok)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var trivySeverities = []string{"CRITICAL", "HIGH", "MEDIUM", "LOW", "UNKNOWN"}
+
+// VulnerabilityScanner periodically scans the images of running containers
+// with trivy and exports the number of findings per severity.
+type VulnerabilityScanner struct {
+	cli      *client.Client
+	api      *DockerAPIMetrics
+	auth     *RegistryAuth
+	trivyBin string
+	server   string
+	interval time.Duration
+	timeout  time.Duration
+
+	mu         sync.Mutex
+	results    map[string]map[string]int
+	scanErrors float64
+}
+
+type trivyReport struct {
+	Results []struct {
+		Vulnerabilities []struct {
+			VulnerabilityID string `json:"VulnerabilityID"`
+			Severity        string `json:"Severity"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+// newVulnerabilityScanner returns nil when scanning is not enabled.
+func newVulnerabilityScanner(cli *client.Client, api *DockerAPIMetrics) *VulnerabilityScanner {
+	if !envBool("DEX_TRIVY_ENABLED", false) {
+		return nil
+	}
+
+	interval := envDuration("DEX_TRIVY_INTERVAL", 6*time.Hour)
+	if interval <= 0 {
+		log.Errorf("invalid DEX_TRIVY_INTERVAL '%s', using 6h", interval)
+		interval = 6 * time.Hour
+		configErrors.add(configKey("DEX_TRIVY_INTERVAL"))
+	}
+
+	return &VulnerabilityScanner{
+		cli:      cli,
+		api:      api,
+		auth:     newRegistryAuth(),
+		trivyBin: envString("DEX_TRIVY_BIN", "trivy"),
+		server:   envString("DEX_TRIVY_SERVER", ""),
+		interval: interval,
+		timeout:  envDuration("DEX_TRIVY_TIMEOUT", 10*time.Minute),
+		results:  map[string]map[string]int{},
+	}
+}
+
+func (s *VulnerabilityScanner) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.scanAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *VulnerabilityScanner) scanAll(ctx context.Context) {
+	var containers []container.Summary
+	err := s.api.observe(ctx, "list", func() error {
+		var err error
+		containers, err = s.cli.ContainerList(ctx, container.ListOptions{})
+		return err
+	})
+	if err != nil {
+		log.Error("can't list containers for vulnerability scan: ", err)
+		return
+	}
+
+	images := map[string]bool{}
+	for _, cont := range containers {
+		images[cont.Image] = true
+	}
+
+	results := map[string]map[string]int{}
+	for image := range images {
+		counts, err := s.scanImage(ctx, image)
+		if err != nil {
+			log.Errorf("can't scan image '%s': %v", image, err)
+			s.mu.Lock()
+			s.scanErrors++
+			// keep the previous result rather than dropping the image from dashboards
+			if prev, ok := s.results[image]; ok {
+				results[image] = prev
+			}
+			s.mu.Unlock()
+			continue
+		}
+		results[image] = counts
+	}
+
+	s.mu.Lock()
+	s.results = results
+	s.mu.Unlock()
+}
+
+func (s *VulnerabilityScanner) scanImage(ctx context.Context, image string) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	args := []string{"image", "--quiet", "--format", "json", "--scanners", "vuln"}
+	if s.server != "" {
+		args = append(args, "--server", s.server)
+	}
+	args = append(args, image)
+
+	cmd := exec.CommandContext(ctx, s.trivyBin, args...)
+
+	creds, found, err := s.auth.credentials(ctx, image)
+	if err != nil {
+		log.Warnf("can't get registry credentials of image '%s': %v", image, err)
+	}
+	if found {
+		cmd.Env = append(os.Environ(), "TRIVY_USERNAME="+creds.Username, "TRIVY_PASSWORD="+creds.Password)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("%v: %s", err, exitErr.Stderr)
+		}
+		return nil, err
+	}
+
+	return parseTrivyReport(out)
+}
+
+// parseTrivyReport counts unique vulnerabilities per severity in a trivy JSON report.
+func parseTrivyReport(data []byte) (map[string]int, error) {
+	var report trivyReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, severity := range trivySeverities {
+		counts[severity] = 0
+	}
+
+	seen := map[string]bool{}
+	for _, result := range report.Results {
+		for _, vuln := range result.Vulnerabilities {
+			if seen[vuln.VulnerabilityID] {
+				continue
+			}
+			seen[vuln.VulnerabilityID] = true
+
+			if _, known := counts[vuln.Severity]; known {
+				counts[vuln.Severity]++
+			} else {
+				counts["UNKNOWN"]++
+			}
+		}
+	}
+
+	return counts, nil
+}
+
+func (s *VulnerabilityScanner) Describe(_ chan<- *prometheus.Desc) {
+
+}
+
+func (s *VulnerabilityScanner) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for image, counts := range s.results {
+		for severity, count := range counts {
+			ch <- prometheus.MustNewConstMetric(newDesc(
+				"dex_image_vulnerabilities",
+				[]string{"image", "severity"},
+			), prometheus.GaugeValue, float64(count), image, severity)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(newDesc("dex_image_vulnerability_scan_errors_total", nil), prometheus.CounterValue, s.scanErrors)
+}
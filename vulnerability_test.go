@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrivyReport(t *testing.T) {
+	report := []byte(`{
+		"SchemaVersion": 2,
+		"ArtifactName": "alpine:3.18",
+		"Results": [
+			{
+				"Target": "alpine:3.18 (alpine 3.18.0)",
+				"Vulnerabilities": [
+					{"VulnerabilityID": "CVE-2023-0001", "Severity": "CRITICAL"},
+					{"VulnerabilityID": "CVE-2023-0002", "Severity": "HIGH"},
+					{"VulnerabilityID": "CVE-2023-0003", "Severity": "HIGH"}
+				]
+			},
+			{
+				"Target": "usr/local/bin/app",
+				"Vulnerabilities": [
+					{"VulnerabilityID": "CVE-2023-0002", "Severity": "HIGH"},
+					{"VulnerabilityID": "CVE-2023-0004", "Severity": "NEGLIGIBLE"}
+				]
+			},
+			{
+				"Target": "requirements.txt"
+			}
+		]
+	}`)
+
+	counts, err := parseTrivyReport(report)
+	require.NoError(t, err, "Failed to parse trivy report")
+
+	assert.Equal(t, 1, counts["CRITICAL"], "Unexpected CRITICAL count")
+	assert.Equal(t, 2, counts["HIGH"], "Duplicate vulnerability IDs should be counted once")
+	assert.Equal(t, 0, counts["MEDIUM"], "Missing severities should be reported as 0")
+	assert.Equal(t, 0, counts["LOW"], "Missing severities should be reported as 0")
+	assert.Equal(t, 1, counts["UNKNOWN"], "Unrecognised severities should be reported as UNKNOWN")
+}
+
+func TestParseTrivyReportInvalid(t *testing.T) {
+	_, err := parseTrivyReport([]byte("not json"))
+	assert.Error(t, err, "Expected error for invalid report")
+}
+
+func TestVulnerabilityScannerInvalidInterval(t *testing.T) {
+	t.Setenv("DEX_TRIVY_ENABLED", "true")
+	t.Setenv("DEX_TRIVY_INTERVAL", "0")
+	saved := configErrors
+	configErrors = &ConfigErrors{}
+	t.Cleanup(func() { configErrors = saved })
+
+	s := newVulnerabilityScanner(nil, nil)
+	require.NotNil(t, s)
+	assert.Equal(t, 6*time.Hour, s.interval)
+	assert.Equal(t, 1, testutil.CollectAndCount(configErrors))
+}
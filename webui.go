@@ -0,0 +1,208 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+var statusPageTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>DEX - Docker EXporter</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 12px; border-bottom: 1px solid #ddd; text-align: left; }
+td.num { text-align: right; }
+.running, .healthy { color: #2e7d32; }
+.restarting, .paused, .starting { color: #ef6c00; }
+.exited, .unhealthy { color: #c62828; }
+</style>
+</head>
+<body>
+<h1>DEX - Docker EXporter</h1>
+<p>{{if .Updated.IsZero}}Not scraped yet.{{else}}{{len .Containers}} containers, updated {{.Updated.Format "2006-01-02 15:04:05"}}.{{end}} <a href="/metrics">Metrics</a></p>
+<table>
+<tr>{{if .MultiHost}}<th>Host</th>{{end}}<th>Container</th><th>State</th><th>Health</th><th>CPU %</th><th>Memory %</th><th>Memory</th><th>Restarts</th></tr>
+{{range .Containers}}<tr>
+{{if $.MultiHost}}<td>{{.Host}}</td>{{end}}<td>{{.Name}}</td>
+<td class="{{.State}}">{{.State}}</td>
+<td class="{{.Health}}">{{.Health}}</td>
+<td class="num">{{if .HasCPU}}{{printf "%.1f" .CPUPercent}}{{end}}</td>
+<td class="num">{{if .HasMemoryPercent}}{{printf "%.1f" .MemoryPercent}}{{end}}</td>
+<td class="num">{{if .HasMemory}}{{printf "%.1f MiB" .MemoryMiB}}{{end}}</td>
+<td class="num">{{printf "%.0f" .Restarts}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type containerStatus struct {
+	Host             string
+	Name             string
+	State            string
+	Health           string
+	HasCPU           bool
+	CPUPercent       float64
+	HasMemoryPercent bool
+	MemoryPercent    float64
+	HasMemory        bool
+	MemoryMiB        float64
+	Restarts         float64
+}
+
+type statusPage struct {
+	Refresh    int
+	Updated    time.Time
+	MultiHost  bool
+	Containers []*containerStatus
+}
+
+// StatusRecorder keeps the container statuses of the last scrape of the
+// gatherer, so the status page doesn't run a collection of its own.
+type StatusRecorder struct {
+	gatherer prometheus.Gatherer
+
+	mu         sync.Mutex
+	containers []*containerStatus
+	updated    time.Time
+}
+
+func newStatusRecorder(gatherer prometheus.Gatherer) *StatusRecorder {
+	return &StatusRecorder{gatherer: gatherer}
+}
+
+// Gather gathers the metrics and records the container statuses before the
+// metrics are relabeled.
+func (s *StatusRecorder) Gather() ([]*dto.MetricFamily, error) {
+	families, err := s.gatherer.Gather()
+	containers := containerStatuses(families)
+
+	s.mu.Lock()
+	s.containers = containers
+	s.updated = time.Now()
+	s.mu.Unlock()
+	return families, err
+}
+
+// statusHandler serves a small HTML overview of all containers as of the last
+// scrape recorded by the recorder.
+func statusHandler(recorder *StatusRecorder) http.Handler {
+	refresh := envDuration("DEX_UI_REFRESH", 10*time.Second)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		recorder.mu.Lock()
+		page := statusPage{
+			Refresh:    int(refresh.Seconds()),
+			Updated:    recorder.updated,
+			Containers: recorder.containers,
+		}
+		recorder.mu.Unlock()
+		for _, status := range page.Containers {
+			if status.Host != "" {
+				page.MultiHost = true
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusPageTemplate.Execute(w, page); err != nil {
+			log.Error("can't render status page: ", err)
+		}
+	})
+}
+
+func containerStatuses(families []*dto.MetricFamily) []*containerStatus {
+	// containers of different hosts may share a name
+	type containerKey struct{ host, name string }
+	byKey := map[containerKey]*containerStatus{}
+	get := func(m *dto.Metric) *containerStatus {
+		var key containerKey
+		for _, lp := range m.GetLabel() {
+			switch lp.GetName() {
+			case "docker_host":
+				key.host = lp.GetValue()
+			case "container_name":
+				key.name = lp.GetValue()
+			}
+		}
+		status, ok := byKey[key]
+		if !ok {
+			status = &containerStatus{Host: key.host, Name: key.name, State: "unknown", Health: "-"}
+			byKey[key] = status
+		}
+		return status
+	}
+
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			value := metricValue(m)
+
+			switch mf.GetName() {
+			case "dex_container_running":
+				if value == 1 {
+					get(m).State = "running"
+				}
+			case "dex_container_restarting":
+				if value == 1 {
+					get(m).State = "restarting"
+				}
+			case "dex_container_exited":
+				if value == 1 {
+					get(m).State = "exited"
+				}
+			case "dex_container_paused":
+				if value == 1 {
+					get(m).State = "paused"
+				}
+			case "dex_container_healthy":
+				if value == 1 {
+					get(m).Health = "healthy"
+				} else {
+					get(m).Health = "unhealthy"
+				}
+			case "dex_container_restarts_total":
+				get(m).Restarts = value
+			case "dex_cpu_utilization_percent":
+				status := get(m)
+				status.HasCPU = true
+				status.CPUPercent = value
+			case "dex_memory_utilization_percent":
+				status := get(m)
+				status.HasMemoryPercent = true
+				status.MemoryPercent = value
+			case "dex_memory_usage_bytes":
+				status := get(m)
+				status.HasMemory = true
+				status.MemoryMiB = value / (1024 * 1024)
+			}
+		}
+	}
+
+	statuses := make([]*containerStatus, 0, len(byKey))
+	for _, status := range byKey {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Host != statuses[j].Host {
+			return statuses[i].Host < statuses[j].Host
+		}
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func gaugeFamily(name, container string, value float64) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name: proto.String(name),
+		Type: dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{
+			Label: []*dto.LabelPair{{Name: proto.String("container_name"), Value: proto.String(container)}},
+			Gauge: &dto.Gauge{Value: proto.Float64(value)},
+		}},
+	}
+}
+
+func TestContainerStatuses(t *testing.T) {
+	families := []*dto.MetricFamily{
+		gaugeFamily("dex_container_running", "web", 1),
+		gaugeFamily("dex_container_exited", "batch", 1),
+		gaugeFamily("dex_container_healthy", "web", 0),
+		gaugeFamily("dex_cpu_utilization_percent", "web", 12.5),
+		gaugeFamily("dex_memory_utilization_percent", "web", 40),
+		gaugeFamily("dex_memory_usage_bytes", "web", 64*1024*1024),
+	}
+
+	statuses := containerStatuses(families)
+	require.Len(t, statuses, 2, "Expected 2 containers")
+
+	assert.Equal(t, "batch", statuses[0].Name, "Containers should be sorted by name")
+	assert.Equal(t, "exited", statuses[0].State)
+	assert.Equal(t, "-", statuses[0].Health, "Containers without healthcheck have no health")
+	assert.False(t, statuses[0].HasCPU, "Exited containers have no stats")
+	assert.False(t, statuses[0].HasMemory, "Exited containers have no stats")
+
+	assert.Equal(t, "web", statuses[1].Name)
+	assert.Equal(t, "running", statuses[1].State)
+	assert.Equal(t, "unhealthy", statuses[1].Health)
+	assert.True(t, statuses[1].HasCPU)
+	assert.True(t, statuses[1].HasMemoryPercent)
+	assert.True(t, statuses[1].HasMemory)
+	assert.Equal(t, 12.5, statuses[1].CPUPercent)
+	assert.Equal(t, 40.0, statuses[1].MemoryPercent)
+	assert.Equal(t, 64.0, statuses[1].MemoryMiB)
+}
+
+func TestContainerStatusesPerHost(t *testing.T) {
+	withHost := func(mf *dto.MetricFamily, host string) *dto.MetricFamily {
+		m := mf.Metric[0]
+		m.Label = append(m.Label, &dto.LabelPair{Name: proto.String("docker_host"), Value: proto.String(host)})
+		return mf
+	}
+	families := []*dto.MetricFamily{
+		withHost(gaugeFamily("dex_container_running", "web", 1), "tcp://b:2375"),
+		withHost(gaugeFamily("dex_container_paused", "web", 1), "tcp://a:2375"),
+		withHost(gaugeFamily("dex_memory_usage_bytes", "web", 32*1024*1024), "tcp://a:2375"),
+	}
+
+	statuses := containerStatuses(families)
+	require.Len(t, statuses, 2, "Containers of different hosts with the same name should be kept apart")
+
+	assert.Equal(t, "tcp://a:2375", statuses[0].Host, "Containers should be sorted by host")
+	assert.Equal(t, "paused", statuses[0].State)
+	assert.False(t, statuses[0].HasCPU, "CPU should be unavailable without its metric")
+	assert.False(t, statuses[0].HasMemoryPercent)
+	assert.True(t, statuses[0].HasMemory, "Memory should be available without the CPU metric")
+	assert.Equal(t, 32.0, statuses[0].MemoryMiB)
+
+	assert.Equal(t, "tcp://b:2375", statuses[1].Host)
+	assert.Equal(t, "running", statuses[1].State)
+}
+
+func TestStatusHandler(t *testing.T) {
+	collected := 0
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "dex_container_running",
+		Help:        metricHelp("dex_container_running"),
+		ConstLabels: prometheus.Labels{"container_name": "web"},
+	}, func() float64 {
+		collected++
+		return 1
+	}))
+
+	recorder := newStatusRecorder(reg)
+	handler := statusHandler(recorder)
+	render := func() string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	assert.Contains(t, render(), "Not scraped yet")
+
+	_, err := recorder.Gather()
+	require.NoError(t, err)
+	page := render()
+	assert.Contains(t, page, "1 containers")
+	assert.Contains(t, page, "<td>web</td>")
+	assert.Equal(t, 1, collected, "The page should be rendered from the last scrape")
+}
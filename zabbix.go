@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+var zabbixHeader = []byte("ZBXD\x01")
+
+type zabbixItem struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+type zabbixRequest struct {
+	Request string       `json:"request"`
+	Data    []zabbixItem `json:"data"`
+	Clock   int64        `json:"clock"`
+}
+
+type zabbixResponse struct {
+	Response string `json:"response"`
+	Info     string `json:"info"`
+}
+
+// zabbixTemplateData is passed to the host and key templates.
+type zabbixTemplateData struct {
+	Hostname  string
+	Metric    string
+	Container string
+	Labels    map[string]string
+	// Params are the label values formatted as zabbix key parameters
+	Params string
+}
+
+// ZabbixSender periodically pushes the collected metrics to a Zabbix server
+// or proxy using the sender protocol.
+type ZabbixSender struct {
+	gatherer     prometheus.Gatherer
+	server       string
+	hostname     string
+	hostTemplate *template.Template
+	keyTemplate  *template.Template
+	discoveryKey string
+	interval     time.Duration
+	timeout      time.Duration
+}
+
+// newZabbixSender returns nil when no Zabbix server is configured.
+func newZabbixSender(gatherer prometheus.Gatherer) *ZabbixSender {
+	server := envString("DEX_ZABBIX_SERVER", "")
+	if server == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "10051")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("can't get hostname: %v", err)
+	}
+
+	hostTemplate, err := template.New("host").Parse(envString("DEX_ZABBIX_HOST", "{{.Hostname}}"))
+	if err != nil {
+		log.Fatalf("invalid zabbix host template: %v", err)
+	}
+	keyTemplate, err := template.New("key").Parse(envString("DEX_ZABBIX_KEY", "dex.{{.Metric}}{{if .Params}}[{{.Params}}]{{end}}"))
+	if err != nil {
+		log.Fatalf("invalid zabbix key template: %v", err)
+	}
+
+	interval := envDuration("DEX_ZABBIX_INTERVAL", time.Minute)
+	if interval <= 0 {
+		log.Errorf("invalid DEX_ZABBIX_INTERVAL '%s', using 1m", interval)
+		interval = time.Minute
+		configErrors.add(configKey("DEX_ZABBIX_INTERVAL"))
+	}
+
+	return &ZabbixSender{
+		gatherer:     gatherer,
+		server:       server,
+		hostname:     hostname,
+		hostTemplate: hostTemplate,
+		keyTemplate:  keyTemplate,
+		discoveryKey: envString("DEX_ZABBIX_DISCOVERY_KEY", "dex.containers.discovery"),
+		interval:     interval,
+		timeout:      10 * time.Second,
+	}
+}
+
+func (z *ZabbixSender) Run(ctx context.Context) {
+	ticker := time.NewTicker(z.interval)
+	defer ticker.Stop()
+
+	for {
+		families, err := z.gatherer.Gather()
+		if err != nil {
+			log.Error("can't gather metrics for zabbix: ", err)
+		}
+
+		items, err := z.items(families, time.Now())
+		if err != nil {
+			log.Error("can't map metrics to zabbix items: ", err)
+		} else if err := z.send(ctx, items); err != nil {
+			log.Error("can't send metrics to zabbix: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (z *ZabbixSender) items(families []*dto.MetricFamily, now time.Time) ([]zabbixItem, error) {
+	var items []zabbixItem
+	containers := map[string]map[string]bool{}
+
+	for _, mf := range families {
+		if !strings.HasPrefix(mf.GetName(), "dex_") {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			data := zabbixTemplateData{
+				Hostname: z.hostname,
+				Metric:   strings.TrimPrefix(mf.GetName(), "dex_"),
+				Labels:   map[string]string{},
+			}
+
+			var params []string
+			for _, lp := range m.GetLabel() {
+				data.Labels[lp.GetName()] = lp.GetValue()
+				if lp.GetName() == "container_name" {
+					data.Container = lp.GetValue()
+					// container is always the first parameter
+					params = append([]string{zabbixQuoteParam(lp.GetValue())}, params...)
+				} else {
+					params = append(params, zabbixQuoteParam(lp.GetValue()))
+				}
+			}
+			data.Params = strings.Join(params, ",")
+
+			host, err := executeTemplate(z.hostTemplate, data)
+			if err != nil {
+				return nil, err
+			}
+			key, err := executeTemplate(z.keyTemplate, data)
+			if err != nil {
+				return nil, err
+			}
+
+			if data.Container != "" {
+				if containers[host] == nil {
+					containers[host] = map[string]bool{}
+				}
+				containers[host][data.Container] = true
+			}
+
+			items = append(items, zabbixItem{
+				Host:  host,
+				Key:   key,
+				Value: strconv.FormatFloat(metricValue(m), 'f', -1, 64),
+				Clock: now.Unix(),
+			})
+		}
+	}
+
+	if z.discoveryKey == "" {
+		return items, nil
+	}
+
+	// low-level discovery of containers, so item prototypes can be used in Zabbix
+	for host, names := range containers {
+		var discovery []map[string]string
+		for name := range names {
+			discovery = append(discovery, map[string]string{"{#CONTAINER}": name})
+		}
+		sort.Slice(discovery, func(i, j int) bool {
+			return discovery[i]["{#CONTAINER}"] < discovery[j]["{#CONTAINER}"]
+		})
+
+		value, err := json.Marshal(map[string]any{"data": discovery})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, zabbixItem{Host: host, Key: z.discoveryKey, Value: string(value), Clock: now.Unix()})
+	}
+
+	return items, nil
+}
+
+func (z *ZabbixSender) send(ctx context.Context, items []zabbixItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(zabbixRequest{Request: "sender data", Data: items, Clock: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+
+	dialer := net.Dialer{Timeout: z.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", z.server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(z.timeout)); err != nil {
+		return err
+	}
+
+	if _, err := conn.Write(zabbixPacket(payload)); err != nil {
+		return err
+	}
+
+	response, err := readZabbixPacket(conn)
+	if err != nil {
+		return err
+	}
+
+	var resp zabbixResponse
+	if err := json.Unmarshal(response, &resp); err != nil {
+		return err
+	}
+	if resp.Response != "success" {
+		return fmt.Errorf("zabbix server responded '%s': %s", resp.Response, resp.Info)
+	}
+	log.Debug("zabbix: ", resp.Info)
+
+	return nil
+}
+
+func zabbixPacket(payload []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(zabbixHeader)
+	_ = binary.Write(&buf, binary.LittleEndian, uint64(len(payload)))
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func readZabbixPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, len(zabbixHeader)+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(zabbixHeader)], zabbixHeader) {
+		return nil, fmt.Errorf("invalid zabbix response header")
+	}
+
+	length := binary.LittleEndian.Uint64(header[len(zabbixHeader):])
+	if length > 16*1024*1024 {
+		return nil, fmt.Errorf("zabbix response too large: %d bytes", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// zabbixQuoteParam quotes an item key parameter when required by the key syntax.
+func zabbixQuoteParam(param string) string {
+	if !strings.ContainsAny(param, `,[]" `) {
+		return param
+	}
+	return `"` + strings.ReplaceAll(param, `"`, `\"`) + `"`
+}
+
+func executeTemplate(t *template.Template, data any) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
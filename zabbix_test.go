@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestZabbixSender(server string) *ZabbixSender {
+	return &ZabbixSender{
+		server:       server,
+		hostname:     "docker01",
+		hostTemplate: template.Must(template.New("host").Parse("{{.Hostname}}")),
+		keyTemplate:  template.Must(template.New("key").Parse("dex.{{.Metric}}{{if .Params}}[{{.Params}}]{{end}}")),
+		discoveryKey: "dex.containers.discovery",
+		timeout:      time.Second,
+	}
+}
+
+func TestZabbixItems(t *testing.T) {
+	z := newTestZabbixSender("")
+	now := time.Unix(1700000000, 0)
+
+	items, err := z.items([]*dto.MetricFamily{
+		gaugeFamily("dex_cpu_utilization_percent", "web", 12.5),
+		gaugeFamily("dex_memory_usage_bytes", "my app", 1024),
+		gaugeFamily("go_goroutines", "web", 10),
+	}, now)
+	require.NoError(t, err)
+	require.Len(t, items, 3, "Expected two metric items and one discovery item")
+
+	assert.Equal(t, zabbixItem{Host: "docker01", Key: "dex.cpu_utilization_percent[web]", Value: "12.5", Clock: 1700000000}, items[0])
+	assert.Equal(t, `dex.memory_usage_bytes["my app"]`, items[1].Key, "Parameters with spaces should be quoted")
+
+	assert.Equal(t, "dex.containers.discovery", items[2].Key)
+	assert.JSONEq(t, `{"data":[{"{#CONTAINER}":"my app"},{"{#CONTAINER}":"web"}]}`, items[2].Value)
+}
+
+func TestZabbixSend(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan zabbixRequest, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		payload, err := readZabbixPacket(conn)
+		if err != nil {
+			return
+		}
+		var req zabbixRequest
+		_ = json.Unmarshal(payload, &req)
+		received <- req
+
+		_, _ = conn.Write(zabbixPacket([]byte(`{"response":"success","info":"processed: 1; failed: 0; total: 1"}`)))
+	}()
+
+	z := newTestZabbixSender(listener.Addr().String())
+	err = z.send(context.Background(), []zabbixItem{{Host: "docker01", Key: "dex.pids_current[web]", Value: "3", Clock: 1}})
+	require.NoError(t, err, "Send should succeed")
+
+	req := <-received
+	assert.Equal(t, "sender data", req.Request)
+	require.Len(t, req.Data, 1)
+	assert.Equal(t, "dex.pids_current[web]", req.Data[0].Key)
+}
+
+func TestZabbixSenderInvalidInterval(t *testing.T) {
+	t.Setenv("DEX_ZABBIX_SERVER", "zabbix")
+	t.Setenv("DEX_ZABBIX_INTERVAL", "-1m")
+	saved := configErrors
+	configErrors = &ConfigErrors{}
+	t.Cleanup(func() { configErrors = saved })
+
+	z := newZabbixSender(prometheus.NewRegistry())
+	require.NotNil(t, z)
+	assert.Equal(t, "zabbix:10051", z.server)
+	assert.Equal(t, time.Minute, z.interval)
+	assert.Equal(t, 1, testutil.CollectAndCount(configErrors))
+}